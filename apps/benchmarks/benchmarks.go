@@ -0,0 +1,206 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package benchmarks provides reproducible load scenarios for confidential clients, with allocation budgets this
+module's tests enforce. Applications sensitive to the performance of token acquisition can run the scenarios to
+compare versions of this module before upgrading:
+
+	func BenchmarkMSAL(b *testing.B) {
+		for _, s := range benchmarks.Scenarios {
+			b.Run(s.Name, s.Run)
+		}
+	}
+
+The scenarios send no network requests. An in-process fake authority answers the requests a client sends, so
+results measure only this module's work.
+*/
+package benchmarks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/mock"
+)
+
+const (
+	host   = "login.microsoftonline.com"
+	tenant = "tenant"
+)
+
+var scopes = []string{"https://resource/.default"}
+
+// Scenario is a reproducible load scenario.
+type Scenario struct {
+	// Name identifies the scenario.
+	Name string
+	// Description describes the scenario's operation.
+	Description string
+	// MaxAllocs is the most heap allocations one operation may make. This module's tests fail when
+	// an operation exceeds it.
+	MaxAllocs float64
+
+	// setup prepares the scenario and returns its operation
+	setup func() (func() error, error)
+}
+
+// Run runs the scenario as a benchmark, reporting allocations.
+func (s Scenario) Run(b *testing.B) {
+	op, err := s.setup()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := op(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Allocs returns the average number of heap allocations one of the scenario's operations makes, over runs operations.
+func (s Scenario) Allocs(runs int) (float64, error) {
+	op, err := s.setup()
+	if err != nil {
+		return 0, err
+	}
+	var opErr error
+	allocs := testing.AllocsPerRun(runs, func() {
+		if err := op(); err != nil && opErr == nil {
+			opErr = err
+		}
+	})
+	return allocs, opErr
+}
+
+// Scenarios are the load scenarios.
+var Scenarios = []Scenario{
+	{
+		Name:        "ColdCache",
+		Description: "constructs a client and acquires an app token, discovering the authority's endpoints",
+		MaxAllocs:   1000,
+		setup: func() (func() error, error) {
+			return func() error {
+				client, err := newClient()
+				if err != nil {
+					return err
+				}
+				_, err = client.AcquireTokenByCredential(context.Background(), scopes)
+				return err
+			}, nil
+		},
+	},
+	{
+		Name:        "WarmCache",
+		Description: "acquires a cached app token",
+		MaxAllocs:   50,
+		setup: func() (func() error, error) {
+			client, err := newClient()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = client.AcquireTokenByCredential(context.Background(), scopes); err != nil {
+				return nil, err
+			}
+			return func() error {
+				_, err := client.AcquireTokenSilent(context.Background(), scopes)
+				return err
+			}, nil
+		},
+	},
+	{
+		Name:        "1kAccounts",
+		Description: "acquires a cached on-behalf-of token for one of 1,000 users",
+		MaxAllocs:   250,
+		setup: func() (func() error, error) {
+			const users = 1000
+			client, err := newClient()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < users; i++ {
+				if _, err := client.AcquireTokenOnBehalfOf(context.Background(), assertion(i), scopes); err != nil {
+					return nil, err
+				}
+			}
+			i := 0
+			return func() error {
+				i = (i + 1) % users
+				_, err := client.AcquireTokenOnBehalfOf(context.Background(), assertion(i), scopes)
+				return err
+			}, nil
+		},
+	},
+	{
+		Name:        "10kConcurrentSilent",
+		Description: "acquires a cached app token in each of 10,000 concurrent goroutines",
+		MaxAllocs:   160000,
+		setup: func() (func() error, error) {
+			const goroutines = 10000
+			client, err := newClient()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = client.AcquireTokenByCredential(context.Background(), scopes); err != nil {
+				return nil, err
+			}
+			return func() error {
+				errs := make(chan error, goroutines)
+				wg := sync.WaitGroup{}
+				wg.Add(goroutines)
+				for i := 0; i < goroutines; i++ {
+					go func() {
+						defer wg.Done()
+						if _, err := client.AcquireTokenSilent(context.Background(), scopes); err != nil {
+							errs <- err
+						}
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				return <-errs
+			}, nil
+		},
+	},
+}
+
+func assertion(user int) string {
+	return fmt.Sprintf("assertion-%d", user)
+}
+
+func newClient() (confidential.Client, error) {
+	cred, err := confidential.NewCredFromSecret("secret")
+	if err != nil {
+		return confidential.Client{}, err
+	}
+	return confidential.New("client-id", cred, confidential.WithAuthority(fmt.Sprintf("https://%s/%s", host, tenant)), confidential.WithHTTPClient(authority{}))
+}
+
+// authority is an HTTP client that answers requests as the authority would
+type authority struct{}
+
+func (authority) Do(r *http.Request) (*http.Response, error) {
+	var body []byte
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/openid-configuration"):
+		body = mock.GetTenantDiscoveryBody(host, tenant)
+	case strings.HasSuffix(r.URL.Path, "/discovery/instance"):
+		body = mock.GetInstanceDiscoveryBody(host, tenant)
+	case strings.HasSuffix(r.URL.Path, "/token"):
+		body = mock.GetAccessTokenBody("access-token", "", "", "", 3600)
+	default:
+		return nil, errors.New("unexpected request to " + r.URL.String())
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func (authority) CloseIdleConnections() {}
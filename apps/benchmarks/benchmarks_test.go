@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package benchmarks
+
+import "testing"
+
+func BenchmarkScenarios(b *testing.B) {
+	for _, s := range Scenarios {
+		b.Run(s.Name, s.Run)
+	}
+}
+
+func TestAllocationBudgets(t *testing.T) {
+	for _, s := range Scenarios {
+		t.Run(s.Name, func(t *testing.T) {
+			runs := 100
+			if testing.Short() || s.Name == "10kConcurrentSilent" {
+				runs = 3
+			}
+			allocs, err := s.Allocs(runs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("%.0f allocations per operation (budget %.0f)", allocs, s.MaxAllocs)
+			if allocs > s.MaxAllocs {
+				t.Errorf("%.0f allocations per operation exceeds the budget of %.0f", allocs, s.MaxAllocs)
+			}
+		})
+	}
+}
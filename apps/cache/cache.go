@@ -11,6 +11,8 @@ implementers on the format being passed.
 */
 package cache
 
+import "io"
+
 // Marshaler marshals data from an internal cache to bytes that can be stored.
 type Marshaler interface {
 	Marshal() ([]byte, error)
@@ -21,6 +23,21 @@ type Unmarshaler interface {
 	Unmarshal([]byte) error
 }
 
+// StreamMarshaler is implemented by the Marshalers a client passes to ExportReplace.Export. MarshalTo
+// writes the data Marshal returns to w one cached item at a time, so that an ExportReplace can write
+// a large cache directly to storage without buffering all of it in memory. The cache can't change while
+// MarshalTo writes, so the client's token requests wait to store tokens until MarshalTo returns. An
+// ExportReplace writing to slow storage should use Marshal instead, or buffer w.
+type StreamMarshaler interface {
+	MarshalTo(w io.Writer) error
+}
+
+// StreamUnmarshaler is implemented by the Unmarshalers a client passes to ExportReplace.Replace.
+// UnmarshalFrom reads data from r as Unmarshal does.
+type StreamUnmarshaler interface {
+	UnmarshalFrom(r io.Reader) error
+}
+
 // Serializer can serialize the cache to binary or from binary into the cache.
 type Serializer interface {
 	Marshaler
@@ -37,3 +54,14 @@ type ExportReplace interface {
 	// key is the suggested key which can be used for partioning the cache
 	Export(cache Marshaler, key string)
 }
+
+// Encrypter encrypts and decrypts the secrets of tokens a client holds in its in-memory cache,
+// for example with a key held only by the process or in an enclave. Tokens are encrypted when
+// written to the cache and decrypted when read from it. The data passed to an ExportReplace is
+// decrypted, so it doesn't depend on the Encrypter. Implementations must be safe for concurrent use.
+type Encrypter interface {
+	// Encrypt returns the ciphertext of plaintext.
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypt returns the plaintext of ciphertext returned by Encrypt.
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
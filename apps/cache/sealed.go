@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// sealedVersion is the first byte of data SealedPartition seals. It identifies the format.
+const sealedVersion = 1
+
+// minSecretLen is the shortest secret NewSealedPartition accepts
+const minSecretLen = 32
+
+// PartitionStore stores opaque data by key, for example in a web framework's session or a distributed cache.
+type PartitionStore interface {
+	// Load returns the data stored under key. It returns no data and a nil error when there is none.
+	Load(key string) ([]byte, error)
+	// Store stores data under key, replacing any data already stored there.
+	Store(key string, data []byte) error
+}
+
+// SealedPartition is an ExportReplace that encrypts the cache data it writes to a PartitionStore and decrypts
+// the data it reads. It's intended for web apps that store each user's part of the cache in that user's session,
+// which may be a cookie or a store shared with other applications. Use it with a client's partitioned cache
+// export option, so that each session holds only its user's tokens.
+//
+// SealedPartition encrypts with AES-256-GCM under a key it derives from a secret, such as the web framework's
+// session secret, and authenticates the partition key with the data, so that data sealed for one partition
+// can't be substituted for another's. When the PartitionStore returns an error, or data SealedPartition
+// can't open, SealedPartition leaves the client's cache unchanged and reports the error to OnError.
+type SealedPartition struct {
+	// Store holds the sealed data.
+	Store PartitionStore
+	// OnError, when not nil, receives the errors of Replace and Export, which can't return them.
+	OnError func(key string, err error)
+
+	aead cipher.AEAD
+}
+
+// NewSealedPartition returns a SealedPartition that stores data in store, encrypted with a key derived
+// from secret. secret must have at least 32 bytes and should be random.
+func NewSealedPartition(secret []byte, store PartitionStore) (*SealedPartition, error) {
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("secret must have at least %d bytes", minSecretLen)
+	}
+	if store == nil {
+		return nil, errors.New("store can't be nil")
+	}
+	// derive a key specific to this purpose, so sealing doesn't expose secret's other uses to attack
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("msal-go sealed cache partition"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SealedPartition{Store: store, aead: aead}, nil
+}
+
+// Seal returns plaintext encrypted for the partition having key.
+func (s *SealedPartition) Seal(key string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), 1+s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{sealedVersion}, nonce...)
+	return s.aead.Seal(sealed, nonce, plaintext, []byte(key)), nil
+}
+
+// Open returns the plaintext of data Seal returned for the partition having key. It returns an error
+// when data was sealed for another partition or with another secret, or has been modified.
+func (s *SealedPartition) Open(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 1+s.aead.NonceSize() || sealed[0] != sealedVersion {
+		return nil, errors.New("data isn't a sealed cache partition")
+	}
+	nonce, ciphertext := sealed[1:1+s.aead.NonceSize()], sealed[1+s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the cache partition: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Replace implements ExportReplace. It replaces the client's cache with the data stored under key, if any.
+func (s *SealedPartition) Replace(cache Unmarshaler, key string) {
+	sealed, err := s.Store.Load(key)
+	if err != nil || len(sealed) == 0 {
+		s.report(key, err)
+		return
+	}
+	plaintext, err := s.Open(key, sealed)
+	if err == nil {
+		err = cache.Unmarshal(plaintext)
+	}
+	s.report(key, err)
+}
+
+// Export implements ExportReplace. It stores the client's cache data under key.
+func (s *SealedPartition) Export(cache Marshaler, key string) {
+	plaintext, err := cache.Marshal()
+	if err != nil {
+		s.report(key, err)
+		return
+	}
+	sealed, err := s.Seal(key, plaintext)
+	if err == nil {
+		err = s.Store.Store(key, sealed)
+	}
+	s.report(key, err)
+}
+
+func (s *SealedPartition) report(key string, err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(key, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+type mapStore map[string][]byte
+
+func (m mapStore) Load(key string) ([]byte, error) {
+	return m[key], nil
+}
+
+func (m mapStore) Store(key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+type fakeCache struct {
+	data []byte
+}
+
+func (f *fakeCache) Marshal() ([]byte, error) {
+	return f.data, nil
+}
+
+func (f *fakeCache) Unmarshal(b []byte) error {
+	f.data = b
+	return nil
+}
+
+func TestSealedPartition(t *testing.T) {
+	secret := bytes.Repeat([]byte{42}, minSecretLen)
+	if _, err := NewSealedPartition(secret[1:], mapStore{}); err == nil {
+		t.Fatal("expected an error for a short secret")
+	}
+	store := mapStore{}
+	var errs []error
+	s, err := NewSealedPartition(secret, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnError = func(key string, err error) { errs = append(errs, err) }
+
+	plaintext := []byte(`{"tokens":"secret"}`)
+	s.Export(&fakeCache{data: plaintext}, "alice")
+	if bytes.Contains(store["alice"], plaintext) {
+		t.Fatal("stored data isn't encrypted")
+	}
+	c := fakeCache{}
+	s.Replace(&c, "alice")
+	if !bytes.Equal(c.data, plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, c.data)
+	}
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// data sealed for one partition or with another secret shouldn't open, nor should modified data
+	other, err := NewSealedPartition(bytes.Repeat([]byte{7}, minSecretLen), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, store["alice"]...)
+	tampered[len(tampered)-1] ^= 1
+	for _, test := range []struct {
+		desc   string
+		s      *SealedPartition
+		key    string
+		sealed []byte
+	}{
+		{desc: "other partition", s: s, key: "bob", sealed: store["alice"]},
+		{desc: "other secret", s: other, key: "alice", sealed: store["alice"]},
+		{desc: "tampered", s: s, key: "alice", sealed: tampered},
+		{desc: "empty", s: s, key: "alice", sealed: []byte{}},
+	} {
+		if _, err := test.s.Open(test.key, test.sealed); err == nil {
+			t.Errorf("%s: expected an error", test.desc)
+		}
+	}
+
+	// Replace should leave the cache unchanged and report an error when it can't open the stored data
+	store["bob"] = store["alice"]
+	c = fakeCache{data: []byte("unchanged")}
+	s.Replace(&c, "bob")
+	if string(c.data) != "unchanged" {
+		t.Fatalf("expected the cache to be unchanged, got %q", c.data)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package cloud describes the Azure clouds: their authority hosts and the endpoints of common resources in each.
+Multi-cloud applications can use it to derive the ".default" scope of a resource in the cloud of their authority,
+instead of hard-coding a scope per cloud:
+
+	c, err := cloud.ForAuthority("https://login.microsoftonline.us/tenant")
+	if err != nil {
+		// handle the unknown cloud
+	}
+	result, err := client.AcquireTokenByCredential(ctx, []string{c.GraphScope()})
+*/
+package cloud
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Configuration describes an Azure cloud.
+type Configuration struct {
+	// Name identifies the cloud, for example "AzurePublic".
+	Name string
+	// AuthorityHost is the host of the cloud's Microsoft Entra authority, for example "login.microsoftonline.com".
+	AuthorityHost string
+	// Graph is the base URL of Microsoft Graph in the cloud.
+	Graph string
+	// ResourceManager is the base URL of Azure Resource Manager in the cloud.
+	ResourceManager string
+
+	// aliases are other hosts of the cloud's authority
+	aliases []string
+}
+
+var (
+	// AzurePublic is the Azure public cloud.
+	AzurePublic = Configuration{
+		Name:            "AzurePublic",
+		AuthorityHost:   "login.microsoftonline.com",
+		Graph:           "https://graph.microsoft.com",
+		ResourceManager: "https://management.azure.com",
+		aliases:         []string{"login.windows.net", "login.microsoft.com", "sts.windows.net"},
+	}
+	// AzureChina is the Azure cloud operated by 21Vianet in China.
+	AzureChina = Configuration{
+		Name:            "AzureChina",
+		AuthorityHost:   "login.chinacloudapi.cn",
+		Graph:           "https://microsoftgraph.chinacloudapi.cn",
+		ResourceManager: "https://management.chinacloudapi.cn",
+		aliases:         []string{"login.partner.microsoftonline.cn"},
+	}
+	// AzureGovernment is the Azure US Government cloud.
+	AzureGovernment = Configuration{
+		Name:            "AzureGovernment",
+		AuthorityHost:   "login.microsoftonline.us",
+		Graph:           "https://graph.microsoft.us",
+		ResourceManager: "https://management.usgovcloudapi.net",
+		aliases:         []string{"login.usgovcloudapi.net"},
+	}
+)
+
+// known are the clouds ForAuthority recognizes
+var known = []Configuration{AzurePublic, AzureChina, AzureGovernment}
+
+// ForAuthority returns the cloud of authority, which may be an authority URL such as
+// "https://login.microsoftonline.com/tenant" or an authority host such as "login.microsoftonline.com".
+// It returns an error when authority doesn't belong to a known cloud.
+func ForAuthority(authority string) (Configuration, error) {
+	host := authority
+	if strings.Contains(authority, "://") {
+		u, err := url.Parse(authority)
+		if err != nil {
+			return Configuration{}, err
+		}
+		host = u.Hostname()
+	}
+	for _, c := range known {
+		if strings.EqualFold(host, c.AuthorityHost) {
+			return c, nil
+		}
+		for _, alias := range c.aliases {
+			if strings.EqualFold(host, alias) {
+				return c, nil
+			}
+		}
+	}
+	return Configuration{}, fmt.Errorf("%q isn't the authority of a known cloud", authority)
+}
+
+// DefaultScope returns the ".default" scope of the resource having base URL resource, which requests
+// the permissions configured for the application on that resource.
+func DefaultScope(resource string) string {
+	return strings.TrimSuffix(resource, "/") + "/.default"
+}
+
+// GraphScope returns the ".default" scope of Microsoft Graph in the cloud.
+func (c Configuration) GraphScope() string {
+	return DefaultScope(c.Graph)
+}
+
+// ResourceManagerScope returns the ".default" scope of Azure Resource Manager in the cloud.
+func (c Configuration) ResourceManagerScope() string {
+	return DefaultScope(c.ResourceManager)
+}
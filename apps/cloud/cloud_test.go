@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cloud
+
+import "testing"
+
+func TestForAuthority(t *testing.T) {
+	for _, test := range []struct {
+		authority, graphScope, armScope string
+	}{
+		{"https://login.microsoftonline.com/tenant", "https://graph.microsoft.com/.default", "https://management.azure.com/.default"},
+		{"login.windows.net", "https://graph.microsoft.com/.default", "https://management.azure.com/.default"},
+		{"https://LOGIN.PARTNER.MICROSOFTONLINE.CN/tenant/", "https://microsoftgraph.chinacloudapi.cn/.default", "https://management.chinacloudapi.cn/.default"},
+		{"https://login.microsoftonline.us/organizations", "https://graph.microsoft.us/.default", "https://management.usgovcloudapi.net/.default"},
+	} {
+		c, err := ForAuthority(test.authority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := c.GraphScope(); actual != test.graphScope {
+			t.Errorf("%s: expected Graph scope %q, got %q", test.authority, test.graphScope, actual)
+		}
+		if actual := c.ResourceManagerScope(); actual != test.armScope {
+			t.Errorf("%s: expected Resource Manager scope %q, got %q", test.authority, test.armScope, actual)
+		}
+	}
+	for _, authority := range []string{"https://contoso.b2clogin.com/tenant", "login.example.com"} {
+		if _, err := ForAuthority(authority); err == nil {
+			t.Errorf("expected an error for %q", authority)
+		}
+	}
+	if actual := DefaultScope("https://vault.azure.net/"); actual != "https://vault.azure.net/.default" {
+		t.Errorf("unexpected scope %q", actual)
+	}
+}
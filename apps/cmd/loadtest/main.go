@@ -0,0 +1,183 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Loadtest measures the throughput and allocations of AcquireTokenSilent on the hardware it runs on, to help
+capacity planning for services caching tokens for many users. It caches tokens for a configurable number of
+accounts in a configurable number of tenants, acquiring them from a fake STS that runs in process, then acquires
+those tokens silently from many goroutines:
+
+	go run ./apps/cmd/loadtest -accounts 100000 -tenants 100 -acquisitions 10000000
+
+In soak mode, given by -duration, loadtest acquires tokens until the duration elapses. With -token-lifetime shorter
+than the soak, cached access tokens expire during it and the client redeems refresh tokens at the fake STS, so the
+soak exercises cache writes as well as reads. The fake STS never throttles or fails.
+*/
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/msaltest"
+)
+
+const host = "login.microsoftonline.com"
+
+var scopes = []string{"api://loadtest/.default"}
+
+// sts is a fake STS. It implements the HTTP client interface accepted by confidential.WithHTTPClient, returning
+// discovery documents and, for each authorization code or refresh token, tokens for the account the code names.
+type sts struct {
+	tokenLifetime int
+	// tokenRequests counts token requests
+	tokenRequests int64
+}
+
+func (s *sts) Do(req *http.Request) (*http.Response, error) {
+	// the first path segment is the tenant
+	tenant := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)[0]
+	var body []byte
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/discovery/instance"):
+		body = msaltest.GetInstanceDiscoveryBody(host, tenant)
+	case strings.HasSuffix(req.URL.Path, "/.well-known/openid-configuration"):
+		body = msaltest.GetTenantDiscoveryBody(host, tenant)
+	case strings.HasSuffix(req.URL.Path, "/oauth2/v2.0/token"):
+		atomic.AddInt64(&s.tokenRequests, 1)
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		// authorization codes and refresh tokens are the account's user ID
+		uid := req.PostForm.Get("code")
+		if uid == "" {
+			uid = strings.TrimPrefix(req.PostForm.Get("refresh_token"), "rt-")
+		}
+		clientInfo := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"uid":%q,"utid":%q}`, uid, tenant)))
+		idToken := msaltest.GetIDToken(tenant, fmt.Sprintf("https://%s/%s/v2.0", host, tenant))
+		body = msaltest.GetAccessTokenBody("at-"+uid, idToken, "rt-"+uid, clientInfo, s.tokenLifetime)
+	default:
+		return nil, fmt.Errorf("the fake STS doesn't serve %s", req.URL)
+	}
+	return &http.Response{
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Request:    req,
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+func (*sts) CloseIdleConnections() {}
+
+func main() {
+	accounts := flag.Int("accounts", 10000, "number of accounts to cache")
+	tenants := flag.Int("tenants", 10, "number of tenants among which to distribute the accounts")
+	acquisitions := flag.Int("acquisitions", 1000000, "number of silent acquisitions, when -duration is zero")
+	duration := flag.Duration("duration", 0, "soak for this long instead of making a fixed number of acquisitions")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(), "number of goroutines acquiring tokens")
+	tokenLifetime := flag.Int("token-lifetime", 3600, "lifetime in seconds of the fake STS's access tokens")
+	flag.Parse()
+	if *accounts < 1 || *tenants < 1 || *concurrency < 1 || *acquisitions < 1 && *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "accounts, tenants, concurrency and acquisitions or duration must be positive")
+		os.Exit(2)
+	}
+	if err := run(*accounts, *tenants, *acquisitions, *duration, *concurrency, *tokenLifetime); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(accountCount, tenantCount, acquisitions int, duration time.Duration, concurrency, tokenLifetime int) error {
+	cred, err := confidential.NewCredFromSecret("secret")
+	if err != nil {
+		return err
+	}
+	fake := &sts{tokenLifetime: tokenLifetime}
+	client, err := confidential.New("client-id", cred,
+		confidential.WithAuthority(fmt.Sprintf("https://%s/organizations", host)),
+		confidential.WithHTTPClient(fake),
+	)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	fmt.Printf("caching tokens for %d accounts in %d tenants...\n", accountCount, tenantCount)
+	start := time.Now()
+	accounts := make([]confidential.Account, accountCount)
+	tenants := make([]string, accountCount)
+	for i := range accounts {
+		tenants[i] = fmt.Sprintf("00000000-0000-0000-0000-%012d", i%tenantCount)
+		ar, err := client.AcquireTokenByAuthCode(ctx, fmt.Sprint(i), "https://localhost", scopes, confidential.WithTenantID(tenants[i]))
+		if err != nil {
+			return fmt.Errorf("couldn't cache a token for account %d: %w", i, err)
+		}
+		accounts[i] = ar.Account
+	}
+	fmt.Printf("cached in %v\n", time.Since(start))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	requestsBefore := atomic.LoadInt64(&fake.tokenRequests)
+	var (
+		completed int64
+		firstErr  error
+		errOnce   sync.Once
+		wg        sync.WaitGroup
+	)
+	deadline := time.Now().Add(duration)
+	start = time.Now()
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; ; i += concurrency {
+				if duration > 0 {
+					if time.Now().After(deadline) {
+						return
+					}
+				} else if i >= acquisitions {
+					return
+				}
+				n := i % accountCount
+				_, err := client.AcquireTokenSilent(ctx, scopes, confidential.WithSilentAccount(accounts[n]), confidential.WithTenantID(tenants[n]))
+				if err != nil {
+					errOnce.Do(func() { firstErr = fmt.Errorf("silent acquisition for account %d failed: %w", n, err) })
+					return
+				}
+				atomic.AddInt64(&completed, 1)
+			}
+		}(g)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if firstErr != nil {
+		return firstErr
+	}
+
+	n := atomic.LoadInt64(&completed)
+	stats := client.CacheStats(ctx)
+	fmt.Printf("%d silent acquisitions by %d goroutines in %v\n", n, concurrency, elapsed)
+	fmt.Printf("throughput:      %.0f acquisitions/s\n", float64(n)/elapsed.Seconds())
+	fmt.Printf("mean latency:    %v\n", elapsed*time.Duration(concurrency)/time.Duration(n))
+	fmt.Printf("allocations:     %.1f per acquisition, %.0f bytes per acquisition\n",
+		float64(after.Mallocs-before.Mallocs)/float64(n), float64(after.TotalAlloc-before.TotalAlloc)/float64(n))
+	fmt.Printf("heap in use:     %d MiB\n", after.HeapInuse>>20)
+	fmt.Printf("GC cycles:       %d\n", after.NumGC-before.NumGC)
+	fmt.Printf("token requests:  %d during acquisition\n", atomic.LoadInt64(&fake.tokenRequests)-requestsBefore)
+	fmt.Printf("cache size:      ~%d KiB in %d partitions\n", stats.ApproximateBytes>>10, len(stats.Partitions))
+	return nil
+}
@@ -6,21 +6,35 @@ Package confidential provides a client for authentication of "confidential" appl
 A "confidential" application is defined as an app that run on servers. They are considered
 difficult to access and for that reason capable of keeping an application secret.
 Confidential clients can hold configuration-time secrets.
+
+This package doesn't depend on the code for interactive authentication, such as the public package's
+browser launcher and redirect listener, so applications importing only this package don't compile it.
 */
 package confidential
 
 import (
 	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/base"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
@@ -29,6 +43,7 @@ import (
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/options"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/wirelog"
 )
 
 /*
@@ -61,8 +76,27 @@ put a PEM decoder into here.
 // For details see https://aka.ms/msal-net-authenticationresult
 type AuthResult = base.AuthResult
 
+// AuthorityMetadata is the OpenID Connect metadata of an authority.
+type AuthorityMetadata = base.AuthorityMetadata
+
+// UserInfo contains claims about a user from the authority's OpenID Connect userinfo endpoint.
+type UserInfo = authority.UserInfo
+
 type Account = shared.Account
 
+// NewHomeAccountID returns the home account ID of the user having object ID oid in the tenant having ID tid,
+// in the form of [Account].HomeAccountID. Applications can use it to find a user's cached account by the IDs
+// in the user's ID token. It returns an empty string when either ID is empty.
+func NewHomeAccountID(oid, tid string) string {
+	return shared.NewHomeAccountID(oid, tid)
+}
+
+// ParseHomeAccountID returns the object and tenant IDs of an [Account].HomeAccountID. It returns empty strings
+// when id isn't a home account ID. For Azure AD B2C accounts, the object ID includes the user flow.
+func ParseHomeAccountID(id string) (oid, tid string) {
+	return shared.ParseHomeAccountID(id)
+}
+
 // CertFromPEM converts a PEM file (.pem or .key) for use with NewCredFromCert(). The file
 // must contain the public certificate and the private key. If a PEM block is encrypted and
 // password is not an empty string, it attempts to decrypt the PEM blocks using the password.
@@ -140,9 +174,17 @@ type Credential struct {
 	key  crypto.PrivateKey
 	x5c  []string
 
+	assertionAudience string
+	assertionClaims   map[string]interface{}
+	assertionLifetime time.Duration
+	assertionIDs      AssertionIDStrategy
+	noAssertionReuse  bool
+
 	assertionCallback func(context.Context, AssertionRequestOptions) (string, error)
 
 	tokenProvider func(context.Context, TokenProviderParameters) (TokenProviderResult, error)
+
+	chain []Credential
 }
 
 // toInternal returns the accesstokens.Credential that is used internally. The current structure of the
@@ -150,6 +192,17 @@ type Credential struct {
 // having import recursion. That requires the type used between is in a shared package. Therefore
 // we have this.
 func (c Credential) toInternal() (*accesstokens.Credential, error) {
+	if len(c.chain) > 0 {
+		creds := make([]*accesstokens.Credential, len(c.chain))
+		for i, cred := range c.chain {
+			ic, err := cred.toInternal()
+			if err != nil {
+				return nil, fmt.Errorf("credential %d in the chain is invalid: %w", i, err)
+			}
+			creds[i] = ic
+		}
+		return &accesstokens.Credential{Chain: accesstokens.NewCredentialChain(creds...)}, nil
+	}
 	if c.secret != "" {
 		return &accesstokens.Credential{Secret: c.secret}, nil
 	}
@@ -157,7 +210,16 @@ func (c Credential) toInternal() (*accesstokens.Credential, error) {
 		if c.key == nil {
 			return nil, errors.New("missing private key for certificate")
 		}
-		return &accesstokens.Credential{Cert: c.cert, Key: c.key, X5c: c.x5c}, nil
+		return &accesstokens.Credential{
+			Cert:                  c.cert,
+			Key:                   c.key,
+			X5c:                   c.x5c,
+			AssertionAudience:     c.assertionAudience,
+			AssertionClaims:       c.assertionClaims,
+			AssertionLifetime:     c.assertionLifetime,
+			AssertionIDs:          c.assertionIDs.generator(),
+			DisableAssertionReuse: c.noAssertionReuse,
+		}, nil
 	}
 	if c.key != nil {
 		return nil, errors.New("missing certificate for private key")
@@ -196,17 +258,112 @@ func NewCredFromAssertionCallback(callback func(context.Context, AssertionReques
 	return Credential{assertionCallback: callback}
 }
 
+// CredentialOption configures the client assertions a certificate Credential signs.
+type CredentialOption func(c *Credential)
+
+// WithAssertionAudience sets the "aud" claim of client assertions. By default, the audience is the token
+// endpoint, which some authorities such as sovereign clouds and dSTS don't accept.
+func WithAssertionAudience(audience string) CredentialOption {
+	return func(c *Credential) {
+		c.assertionAudience = audience
+	}
+}
+
+// WithAssertionClaims adds claims such as "xms_az_claim" to client assertions. These can't
+// override the standard claims "aud", "exp", "iss", "jti", "nbf" and "sub".
+func WithAssertionClaims(claims map[string]interface{}) CredentialOption {
+	return func(c *Credential) {
+		c.assertionClaims = make(map[string]interface{}, len(claims))
+		for k, v := range claims {
+			c.assertionClaims[k] = v
+		}
+	}
+}
+
+// WithAssertionLifetime sets how long client assertions are valid. The default is 10 minutes.
+func WithAssertionLifetime(lifetime time.Duration) CredentialOption {
+	return func(c *Credential) {
+		c.assertionLifetime = lifetime
+	}
+}
+
+// AssertionIDStrategy determines the "jti" claims of the client assertions a certificate Credential signs.
+type AssertionIDStrategy int
+
+const (
+	// AssertionIDRandom gives each client assertion a random (version 4) UUID. This is the default.
+	AssertionIDRandom AssertionIDStrategy = iota
+	// AssertionIDCounter gives each client assertion an ID having a prefix, random for each client, and a
+	// sequence number, such as "0f8fad5b-d9cb-469f-a165-70867728950e.1", so audits can order a client's requests
+	// and detect gaps.
+	AssertionIDCounter
+)
+
+// generator returns a generator of the strategy's assertion IDs, or nil for the default
+func (s AssertionIDStrategy) generator() func() string {
+	if s == AssertionIDCounter {
+		return accesstokens.CounterAssertionIDs()
+	}
+	return nil
+}
+
+// WithAssertionIDs sets how the client generates the "jti" claims of client assertions. See [AssertionIDStrategy].
+func WithAssertionIDs(strategy AssertionIDStrategy) CredentialOption {
+	return func(c *Credential) {
+		c.assertionIDs = strategy
+	}
+}
+
+// WithAssertionReuse determines whether the client reuses a client assertion for token requests until three
+// quarters of its lifetime has passed, which it does by default to spare signing for every request. Disabling
+// reuse gives each token request a client assertion having a unique "jti" claim, for environments that audit
+// request uniqueness. WithAssertionLifetime bounds the window in which the authority accepts an assertion.
+func WithAssertionReuse(enabled bool) CredentialOption {
+	return func(c *Credential) {
+		c.noAssertionReuse = !enabled
+	}
+}
+
+// AssertionClaims returns the claims of the client assertion the client would sign for an application
+// authenticating to authorityURI with a certificate, configured by opts. Applications whose keys are held by
+// an external signing service can sign these claims as a JWT whose header has "alg" "RS256", "typ" "JWT"
+// and "x5t" the base64 encoded SHA-1 thumbprint of the certificate, then pass the result to
+// NewCredFromAssertionCallback. The "aud" claim is the authority's token endpoint unless overridden by
+// WithAssertionAudience. The "jti" claim is unique to each call.
+func AssertionClaims(authorityURI, clientID string, opts ...CredentialOption) (map[string]interface{}, error) {
+	info, err := authority.NewInfoFromAuthorityURI(authorityURI, false)
+	if err != nil {
+		return nil, err
+	}
+	var cred Credential
+	for _, o := range opts {
+		o(&cred)
+	}
+	authParams := authority.NewAuthParams(clientID, info)
+	authParams.Endpoints.TokenEndpoint = info.TokenEndpoint()
+	ic := accesstokens.Credential{
+		AssertionAudience: cred.assertionAudience,
+		AssertionClaims:   cred.assertionClaims,
+		AssertionLifetime: cred.assertionLifetime,
+		AssertionIDs:      cred.assertionIDs.generator(),
+	}
+	return ic.Claims(authParams), nil
+}
+
 // NewCredFromCert creates a Credential from an x509.Certificate and an RSA private key.
 // CertFromPEM() can be used to get these values from a PEM file.
-func NewCredFromCert(cert *x509.Certificate, key crypto.PrivateKey) Credential {
-	cred, _ := NewCredFromCertChain([]*x509.Certificate{cert}, key)
+func NewCredFromCert(cert *x509.Certificate, key crypto.PrivateKey, opts ...CredentialOption) Credential {
+	cred, _ := NewCredFromCertChain([]*x509.Certificate{cert}, key, opts...)
 	return cred
 }
 
 // NewCredFromCertChain creates a Credential from a chain of x509.Certificates and an RSA private key
 // as returned by CertFromPEM().
-func NewCredFromCertChain(certs []*x509.Certificate, key crypto.PrivateKey) (Credential, error) {
+func NewCredFromCertChain(certs []*x509.Certificate, key crypto.PrivateKey, opts ...CredentialOption) (Credential, error) {
 	cred := Credential{key: key}
+	for _, o := range opts {
+		o(&cred)
+	}
 	k, ok := key.(*rsa.PrivateKey)
 	if !ok {
 		return cred, errors.New("key must be an RSA key")
@@ -232,6 +389,23 @@ func NewCredFromCertChain(certs []*x509.Certificate, key crypto.PrivateKey) (Cre
 	return cred, nil
 }
 
+// NewCredChain creates a Credential from alternative credentials for the same application, for example a
+// secret and the certificate replacing it. Token requests use the credential the authority last accepted,
+// initially the first one. When the authority rejects that credential, or it fails to produce an assertion,
+// the client tries the others in order. This allows rotating from secrets to certificates or federated
+// credentials without downtime.
+func NewCredChain(creds ...Credential) (Credential, error) {
+	if len(creds) == 0 {
+		return Credential{}, errors.New("a credential chain requires at least one credential")
+	}
+	for _, c := range creds {
+		if len(c.chain) > 0 {
+			return Credential{}, errors.New("a credential chain can't contain another chain")
+		}
+	}
+	return Credential{chain: append([]Credential{}, creds...)}, nil
+}
+
 // TokenProviderParameters is the authentication parameters passed to token providers
 type TokenProviderParameters = exported.TokenProviderParameters
 
@@ -258,6 +432,9 @@ type Client struct {
 
 	cred *accesstokens.Credential
 
+	// redirectURIs are the redirect URIs from which calls select one. Empty allows any redirect URI.
+	redirectURIs []string
+
 	// userID is some unique identifier for a user. It actually isn't used by us at all, it
 	// simply acts as another hint that a confidential.Client is for a single user.
 	userID string
@@ -270,6 +447,34 @@ type Options struct {
 	// By default there is no cache persistence. This can be set using the WithAccessor() option.
 	Accessor cache.ExportReplace
 
+	// Encrypter encrypts the secrets of tokens in the client's in-memory cache.
+	// By default they aren't encrypted. This can be set using the WithCacheEncrypter() option.
+	Encrypter cache.Encrypter
+
+	// CacheCompression specifies whether the client gzips the data it passes to its cache accessor.
+	// This can be set using the WithCacheCompression() option.
+	CacheCompression bool
+
+	// PartitionedCacheExport specifies whether the client passes its cache accessor only the part of the cache
+	// stored under the suggested key. This can be set using the WithPartitionedCacheExport() option.
+	PartitionedCacheExport bool
+
+	// CacheDisabled specifies whether the client keeps no tokens or accounts.
+	// This can be set using the WithCacheDisabled() option.
+	CacheDisabled bool
+
+	// ReadOnlyCache specifies whether the client reads its cache accessor's data without ever changing it.
+	// This can be set using the WithReadOnlyCache() option.
+	ReadOnlyCache bool
+
+	// RefreshNeeded is called when a client having a read-only cache can't return a token silently.
+	// This can be set using the WithReadOnlyCache() option.
+	RefreshNeeded func(account Account, scopes []string)
+
+	// CacheNamespace isolates the client's cache data from that of clients having another namespace.
+	// This can be set using the WithCacheNamespace() option.
+	CacheNamespace string
+
 	// The host of the Azure Active Directory authority.
 	// The default is https://login.microsoftonline.com/common. This can be changed using the
 	// WithAuthority() option.
@@ -284,22 +489,318 @@ type Options struct {
 
 	// Instructs MSAL Go to use an Azure regional token service with sepcified AzureRegion.
 	AzureRegion string
+
+	// TokenEndpointOverride is the URL of a token endpoint, such as an Azure Private Link endpoint, to use instead
+	// of the authority's. This can be set using the WithTokenEndpointOverride() option.
+	TokenEndpointOverride string
+
+	// AuthorizationEndpoint is the URL of an authorization endpoint to use instead of the authority's.
+	// This can be set using the WithAuthorizationEndpoint() option.
+	AuthorizationEndpoint string
+
+	// ReturnRefreshToken specifies whether results include the account's refresh token.
+	// This can be set using the WithReturnRefreshToken() option.
+	ReturnRefreshToken bool
+
+	// OfflineInstanceDiscovery specifies whether the client uses bundled instance metadata.
+	// This can be set using the WithOfflineInstanceDiscovery() option.
+	OfflineInstanceDiscovery bool
+
+	// InstanceDiscoveryEndpoint is the URL to which the client sends instance discovery requests.
+	// This can be set using the WithInstanceDiscoveryEndpoint() option.
+	InstanceDiscoveryEndpoint string
+
+	// V1TokenEndpoint specifies whether the client sends token requests to the authority's v1 token endpoint.
+	// This can be set using the WithV1TokenEndpoint() option.
+	V1TokenEndpoint bool
+
+	// StaticAuthorizationEndpoint, StaticTokenEndpoint and StaticIssuer are the authority's endpoints and issuer,
+	// which the client uses instead of discovering any metadata.
+	// These can be set using the WithStaticEndpoints() option.
+	StaticAuthorizationEndpoint, StaticTokenEndpoint, StaticIssuer string
+
+	// Clock returns the current time. It defaults to the system clock.
+	// This can be set using the WithClock() option.
+	Clock func() time.Time
+
+	// ClockSkew is how far the client's clock may differ from the authority's.
+	// This can be set using the WithClockSkew() option.
+	ClockSkew time.Duration
+
+	// ClockSkewCompensation specifies whether the client corrects its clock to match the authority's.
+	// This can be set using the WithClockSkewCompensation() option.
+	ClockSkewCompensation bool
+
+	// MaxStale is how long after an access token expires the client may return it when the authority is unavailable.
+	// This can be set using the WithAllowStaleOnError() option.
+	MaxStale time.Duration
+
+	// ExtendedTokenLifetime specifies whether the client may return an access token before its extended expiry when
+	// the authority is unavailable. This can be set using the WithExtendedTokenLifetime() option.
+	ExtendedTokenLifetime bool
+
+	// RequestInterceptor inspects token requests before the client sends them.
+	// This can be set using the WithRequestInterceptor() option.
+	RequestInterceptor func(context.Context, *TokenRequestInfo) error
+
+	// ResponseInterceptor observes the outcome of token requests.
+	// This can be set using the WithResponseInterceptor() option.
+	ResponseInterceptor func(context.Context, *TokenResponseInfo)
+
+	// StrictTokenResponses specifies whether the client rejects malformed token responses instead of coercing them.
+	// This can be set using the WithStrictTokenResponses() option.
+	StrictTokenResponses bool
+
+	// PIILogging controls the wire log of the client's HTTP requests and responses. It's off by default.
+	// This can be set using the WithPIILogging() option.
+	PIILogging PIILevel
+
+	// Logger receives the client's log output, such as the wire log. The default is the standard library's log package.
+	// This can be set using the WithLogger() option.
+	Logger Logger
+
+	// AllowedTenants are the only tenants to which the client sends token requests. Empty allows all.
+	// This can be set using the WithAllowedTenants() option.
+	AllowedTenants []string
+
+	// AllowedScopes are the only scopes for which the client requests tokens. Empty allows all.
+	// This can be set using the WithAllowedScopes() option.
+	AllowedScopes []string
+
+	// CertificateExpiryWarning is called when the client authenticates with a certificate that expires within
+	// CertificateExpiryWindow. Nil logs a warning with Logger instead.
+	// This can be set using the WithCertificateExpiryWarning() option.
+	CertificateExpiryWarning func(cert *x509.Certificate)
+
+	// CertificateExpiryWindow is how long before a certificate credential expires the client warns of its expiry.
+	// Zero disables warnings. This can be set using the WithCertificateExpiryWarning() option.
+	CertificateExpiryWindow time.Duration
+
+	// RefreshTokenExpiryWarning is called for results whose refresh token expires within RefreshTokenExpiryWindow.
+	// This can be set using the WithRefreshTokenExpiryWarning() option.
+	RefreshTokenExpiryWarning func(account Account, expiresOn time.Time)
+
+	// RefreshTokenExpiryWindow is how long before a refresh token expires the client warns of its expiry.
+	// This can be set using the WithRefreshTokenExpiryWarning() option.
+	RefreshTokenExpiryWindow time.Duration
+
+	// TokenRequestRate is the number of token requests per second the client sends to each authority host.
+	// Zero means no limit. This can be set using the WithTokenRequestRateLimit() option.
+	TokenRequestRate float64
+
+	// TokenRequestBurst is the number of token requests the client may send to a host at once despite TokenRequestRate.
+	// This can be set using the WithTokenRequestRateLimit() option.
+	TokenRequestBurst int
+
+	// TokenRequestMaxDelay is how long the client delays a request exceeding TokenRequestRate before rejecting it.
+	// This can be set using the WithTokenRequestRateLimit() option.
+	TokenRequestMaxDelay time.Duration
+
+	// UsernameNormalizer normalizes usernames before AccountsByUsername compares them.
+	// This can be set using the WithUsernameNormalizer() option.
+	UsernameNormalizer func(string) string
+
+	// RedirectURIs are the redirect URIs from which calls select one. Empty allows any redirect URI.
+	// This can be set using the WithRedirectURIs() option.
+	RedirectURIs []string
+}
+
+// validate returns every problem with the options and clientID, so that a misconfigured client fails at
+// construction instead of in the middle of an authentication
+func (o Options) validate(clientID string) []error {
+	var errs []error
+	if strings.TrimSpace(clientID) != clientID || clientID == "" {
+		errs = append(errs, fmt.Errorf("the client ID(%q) is empty or has surrounding whitespace", clientID))
+	}
+	if u, err := url.Parse(o.Authority); err != nil {
+		errs = append(errs, fmt.Errorf("the Authority(%s) does not parse as a valid URL", o.Authority))
+	} else if u.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("the Authority(%s) does not appear to use https", o.Authority))
+	} else if _, err := authority.NewInfoFromAuthorityURI(o.Authority, false); err != nil {
+		errs = append(errs, fmt.Errorf("the Authority(%s) is invalid: %w", o.Authority, err))
+	}
+	if o.HTTPClient == nil {
+		errs = append(errs, errors.New("the HTTPClient can't be nil"))
+	}
+	if o.InstanceDiscoveryEndpoint != "" {
+		if u, err := url.Parse(o.InstanceDiscoveryEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("the InstanceDiscoveryEndpoint(%s) does not parse as a valid URL", o.InstanceDiscoveryEndpoint))
+		} else if u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("the InstanceDiscoveryEndpoint(%s) does not appear to use https", o.InstanceDiscoveryEndpoint))
+		}
+	}
+	if o.StaticTokenEndpoint == "" && (o.StaticAuthorizationEndpoint != "" || o.StaticIssuer != "") {
+		errs = append(errs, errors.New("the static endpoints must include a token endpoint"))
+	}
+	if o.StaticTokenEndpoint != "" && o.AzureRegion != "" {
+		errs = append(errs, errors.New("a client can't have both static endpoints and an AzureRegion"))
+	}
+	for _, endpoint := range []struct{ name, value string }{
+		{"TokenEndpointOverride", o.TokenEndpointOverride},
+		{"AuthorizationEndpoint", o.AuthorizationEndpoint},
+		{"StaticAuthorizationEndpoint", o.StaticAuthorizationEndpoint},
+		{"StaticTokenEndpoint", o.StaticTokenEndpoint},
+		{"StaticIssuer", o.StaticIssuer},
+	} {
+		if err := validateEndpointOverride(endpoint.name, endpoint.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.CacheDisabled && o.Accessor != nil {
+		errs = append(errs, errors.New("a client can't have both a disabled cache and a cache accessor"))
+	}
+	if o.ReadOnlyCache && o.Accessor == nil {
+		errs = append(errs, errors.New("a client having a read-only cache must have a cache accessor"))
+	}
+	if o.TokenRequestRate < 0 || o.TokenRequestRate > 0 && (o.TokenRequestBurst < 1 || o.TokenRequestMaxDelay < 0) {
+		errs = append(errs, fmt.Errorf("the TokenRequestRate(%v) must be zero, or positive with a positive burst and non-negative max delay", o.TokenRequestRate))
+	}
+	for _, uri := range o.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// optionsError returns nil when errs is empty, its only error when it has one and otherwise an
+// errors.OptionsError
+func optionsError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return msalerrors.OptionsError{Errs: errs}
+}
+
+// validateEndpointOverride returns an error when endpoint, the value of the named option, isn't empty or an
+// https URL without a query or fragment
+func validateEndpointOverride(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("the %s(%s) does not parse as a valid URL", name, endpoint)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("the %s(%s) does not appear to use https", name, endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("the %s(%s) has a query or fragment", name, endpoint)
+	}
+	return nil
 }
 
-func (o Options) validate() error {
-	u, err := url.Parse(o.Authority)
+// validateRedirectURI returns an errors.RedirectURIError when uri isn't an absolute URI without a fragment
+// having https, a custom scheme, or http with a loopback host
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
 	if err != nil {
-		return fmt.Errorf("the Authority(%s) does not parse as a valid URL", o.Authority)
+		return msalerrors.RedirectURIError{URI: uri, Reason: "it doesn't parse as a URI"}
+	}
+	if !u.IsAbs() {
+		return msalerrors.RedirectURIError{URI: uri, Reason: "it isn't absolute"}
 	}
-	if u.Scheme != "https" {
-		return fmt.Errorf("the Authority(%s) does not appear to use https", o.Authority)
+	if u.Fragment != "" {
+		return msalerrors.RedirectURIError{URI: uri, Reason: "it has a fragment"}
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return msalerrors.RedirectURIError{URI: uri, Reason: "it has no host"}
+		}
+	case "http":
+		if h := u.Hostname(); h != "localhost" {
+			if ip := net.ParseIP(h); ip == nil || !ip.IsLoopback() {
+				return msalerrors.RedirectURIError{URI: uri, Reason: "only loopback addresses may use http"}
+			}
+		}
 	}
 	return nil
 }
 
+// redirectURI returns the redirect URI of a call having the redirectURI argument and the WithRedirectURI option
+// override. It defaults to the client's first redirect URI, and must be one of them when the client has any.
+func (cca Client) redirectURI(redirectURI, override string) (string, error) {
+	if override != "" {
+		if err := validateRedirectURI(override); err != nil {
+			return "", err
+		}
+		redirectURI = override
+	}
+	if len(cca.redirectURIs) == 0 {
+		return redirectURI, nil
+	}
+	if redirectURI == "" {
+		return cca.redirectURIs[0], nil
+	}
+	for _, uri := range cca.redirectURIs {
+		if uri == redirectURI {
+			return redirectURI, nil
+		}
+	}
+	return "", msalerrors.RedirectURIError{URI: redirectURI, Reason: "it isn't one of the client's redirect URIs"}
+}
+
+// TokenRequestInfo describes a token request. See [WithRequestInterceptor].
+type TokenRequestInfo = exported.TokenRequestInfo
+
+// TokenResponseInfo describes the outcome of a token request. See [WithResponseInterceptor].
+type TokenResponseInfo = exported.TokenResponseInfo
+
+// PIILevel controls what the wire log includes. See [WithPIILogging].
+type PIILevel = exported.PIILevel
+
+// Logger receives a client's log output. See [WithLogger].
+type Logger = exported.Logger
+
+const (
+	// WireLogOff disables the wire log.
+	WireLogOff = exported.WireLogOff
+	// WireLogRedacted logs HTTP requests and responses, redacting secrets, tokens and personal data such as usernames.
+	WireLogRedacted = exported.WireLogRedacted
+	// WireLogPII logs HTTP requests and responses, redacting secrets and tokens but not personal data.
+	WireLogPII = exported.WireLogPII
+)
+
 // Option is an optional argument to New().
 type Option func(o *Options)
 
+// WithAllowStaleOnError allows the client to return a cached access token that expired up to maxStale ago when
+// it can't get a new token because the authority is unavailable, that is, it responds with a 5xx status or can't
+// be reached. This keeps applications running through short authority outages. The AuthResult of such a token has
+// Stale set. The client never returns a stale token for a request with claims. By default, the client returns
+// an error instead of a stale token.
+func WithAllowStaleOnError(maxStale time.Duration) Option {
+	return func(o *Options) {
+		o.MaxStale = maxStale
+	}
+}
+
+// WithAllowedScopes restricts the scopes for which the client requests tokens to scopes. Token acquisition methods
+// return an errors.PolicyError for any other scope, before sending a request or reading the cache. Scopes are
+// compared case insensitively. By default, the client allows all scopes.
+func WithAllowedScopes(scopes ...string) Option {
+	return func(o *Options) {
+		o.AllowedScopes = scopes
+	}
+}
+
+// WithAllowedTenants restricts the tenants to which the client sends token requests to tenants, for example to
+// prevent shared tooling from sending credentials to consumer tenants. Token acquisition methods return an
+// errors.PolicyError for any other tenant, including a tenant specified by WithTenantID, before sending a
+// request or reading the cache. The list must contain the tenant of the client's authority, in the form that
+// authority specifies it, such as a tenant ID or domain. Tenants are compared case insensitively. By default, the
+// client allows all tenants.
+func WithAllowedTenants(tenants ...string) Option {
+	return func(o *Options) {
+		o.AllowedTenants = tenants
+	}
+}
+
 // WithAuthority allows you to provide a custom authority for use in the client.
 func WithAuthority(authority string) Option {
 	return func(o *Options) {
@@ -315,129 +816,824 @@ func WithAccessor(accessor cache.ExportReplace) Option {
 	}
 }
 
-// WithHTTPClient allows for a custom HTTP client to be set.
-func WithHTTPClient(httpClient ops.HTTPClient) Option {
+// WithCacheCompression gzips the data the client passes to its cache accessor, which reduces the size of
+// large caches in external storage. The client reads compressed and uncompressed data regardless of this
+// option, so enabling it doesn't invalidate data already in storage. However, clients that don't enable it
+// and other MSAL libraries can't read compressed data.
+func WithCacheCompression() Option {
 	return func(o *Options) {
-		o.HTTPClient = httpClient
+		o.CacheCompression = true
 	}
 }
 
-// WithX5C specifies if x5c claim(public key of the certificate) should be sent to STS to enable Subject Name Issuer Authentication.
-func WithX5C() Option {
+// WithCacheNamespace isolates the client's cache data from that of clients having a different namespace, or none,
+// for example when two applications in one process share a cache file through their [WithAccessor] accessors. Clients
+// having different namespaces don't read each other's tokens even when they have the same client ID. The
+// serialized cache holds a namespace's data in a member named for the namespace, which clients having other
+// namespaces preserve, and the client prefixes the keys it suggests to its accessor with the namespace. Other
+// MSAL libraries don't read data having a namespace.
+func WithCacheNamespace(namespace string) Option {
 	return func(o *Options) {
-		o.SendX5C = true
+		o.CacheNamespace = namespace
 	}
 }
 
-// WithAzureRegion sets the region(preferred) or Confidential.AutoDetectRegion() for auto detecting region.
-// Region names as per https://azure.microsoft.com/en-ca/global-infrastructure/geographies/.
-// See https://aka.ms/region-map for more details on region names.
-// The region value should be short region name for the region where the service is deployed.
-// For example "centralus" is short name for region Central US.
-// Not all auth flows can use the regional token service.
-// Service To Service (client credential flow) tokens can be obtained from the regional service.
-// Requires configuration at the tenant level.
-// Auto-detection works on a limited number of Azure artifacts (VMs, Azure functions).
-// If auto-detection fails, the non-regional endpoint will be used.
-// If an invalid region name is provided, the non-regional endpoint MIGHT be used or the token request MIGHT fail.
-func WithAzureRegion(val string) Option {
+// WithCacheEncrypter encrypts the secrets of tokens the client holds in memory with e. See [cache.Encrypter].
+func WithCacheEncrypter(e cache.Encrypter) Option {
 	return func(o *Options) {
-		o.AzureRegion = val
+		o.Encrypter = e
 	}
 }
 
-// New is the constructor for Client. userID is the unique identifier of the user this client
-// will store credentials for (a Client is per user). clientID is the Azure clientID and cred is
-// the type of credential to use.
-func New(clientID string, cred Credential, options ...Option) (Client, error) {
-	internalCred, err := cred.toInternal()
-	if err != nil {
-		return Client{}, err
+// WithClock sets the clock the client uses to decide whether tokens have expired and to set the validity period
+// of the client assertions it creates. By default the client uses the system clock. A fixed or adjustable clock
+// makes tests deterministic, and a clock corrected for a known offset compensates for a host's inaccurate clock.
+func WithClock(clock func() time.Time) Option {
+	return func(o *Options) {
+		o.Clock = clock
 	}
+}
 
-	opts := Options{
-		Authority:  base.AuthorityPublicCloud,
-		HTTPClient: shared.DefaultClient,
+// WithClockSkew allows for the client's clock differing from the authority's by up to skew. The client considers
+// tokens expired this much earlier than it otherwise would and backdates the client assertions it creates by this
+// much, so that the authority doesn't reject them as not yet valid.
+func WithClockSkew(skew time.Duration) Option {
+	return func(o *Options) {
+		o.ClockSkew = skew
 	}
+}
 
-	for _, o := range options {
-		o(&opts)
+// WithClockSkewCompensation corrects the client's clock by its offset from the authority's clock, which the client
+// computes from the Date header of token responses. The client applies the correction to the times it sends the
+// authority, which prevents a client whose clock is several minutes off from creating client assertions the
+// authority rejects. Token expiry doesn't need the correction because the authority states token lifetimes relative
+// to the time it responds, so the client stores and checks expiry times according to its own clock, and applications
+// sharing the cache read expiry times they can use. The client ignores offsets smaller than a minute. It applies the
+// correction to the clock set by [WithClock], if any.
+func WithClockSkewCompensation() Option {
+	return func(o *Options) {
+		o.ClockSkewCompensation = true
 	}
-	if err := opts.validate(); err != nil {
-		return Client{}, err
+}
+
+// WithExtendedTokenLifetime allows the client to return an expired access token whose extended lifetime hasn't
+// ended when it can't get a new token because the authority is unavailable, that is, it responds with a 5xx status
+// or can't be reached. The authority's ext_expires_in sets a token's extended lifetime, which resources honor only
+// during an outage of the authority. The AuthResult of such a token has Stale and Metadata.DegradedMode set. The
+// client never returns such a token for a request with claims. By default, the client returns an error instead.
+func WithExtendedTokenLifetime(enabled bool) Option {
+	return func(o *Options) {
+		o.ExtendedTokenLifetime = enabled
 	}
+}
 
-	baseOpts := []base.Option{
-		base.WithCacheAccessor(opts.Accessor),
-		base.WithRegionDetection(opts.AzureRegion),
-		base.WithX5C(opts.SendX5C),
+// WithHTTPClient allows for a custom HTTP client to be set.
+func WithHTTPClient(httpClient ops.HTTPClient) Option {
+	return func(o *Options) {
+		o.HTTPClient = httpClient
 	}
-	if cred.tokenProvider != nil {
-		// The caller will handle all details of authentication, using Client only as a token cache.
-		// Declaring the authority host known prevents unnecessary metadata discovery requests. (The
-		// authority is irrelevant to Client and friends because the token provider is responsible
-		// for authentication.)
-		parsed, err := url.Parse(opts.Authority)
-		if err != nil {
-			return Client{}, errors.New("invalid authority")
-		}
-		baseOpts = append(baseOpts, base.WithKnownAuthorityHosts([]string{parsed.Hostname()}))
+}
+
+// WithInstanceDiscoveryEndpoint sets the URL to which the client sends instance discovery requests, which the client
+// uses to validate its authority and learn the authority's aliases. By default, the client sends these requests to
+// the authority's host when that's a known Microsoft host, and otherwise to login.microsoftonline.com. Set this to
+// target a test environment, a national cloud this module doesn't know, or a proxy for the discovery service. The
+// URL must use https. The client doesn't send instance discovery requests when configured for offline instance
+// discovery or a region.
+func WithInstanceDiscoveryEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.InstanceDiscoveryEndpoint = endpoint
 	}
-	base, err := base.New(clientID, opts.Authority, oauth.New(opts.HTTPClient), baseOpts...)
-	if err != nil {
-		return Client{}, err
+}
+
+// WithOfflineInstanceDiscovery specifies whether the client uses instance metadata bundled with this module instead
+// of requesting it from the authority, and derives the authority's endpoints from the conventions of the public and
+// sovereign clouds instead of requesting its OpenID configuration. This saves requests, which reduces the latency
+// of the first authentication and permits authenticating on networks that allow only the authority's token endpoint.
+// The bundled metadata describes the public and sovereign clouds. When the client's authority isn't among them, the
+// client doesn't validate the authority and doesn't recognize its aliases. Clients configured for a region still
+// request the regional endpoints' metadata.
+func WithOfflineInstanceDiscovery(enabled bool) Option {
+	return func(o *Options) {
+		o.OfflineInstanceDiscovery = enabled
 	}
+}
 
-	return Client{base: base, cred: internalCred}, nil
+// WithV1TokenEndpoint directs the client to send token requests to the v1 token endpoint of its Microsoft Entra
+// authority, for legacy APIs that accept only v1 access tokens. The client then identifies the requested API by a
+// "resource" parameter, which it gets from the first requested scope having one. For example, scopes
+// "https://legacy.contoso.com/.default" request resource "https://legacy.contoso.com". The client caches v1 tokens
+// apart from v2.0 tokens for the same scopes. Authorization URLs still use the v2.0 authorization endpoint, so this
+// doesn't suit the authorization code flow. The option has no effect on clients of other authorities, such as
+// ADFS, and a token endpoint specified by another option takes precedence.
+func WithV1TokenEndpoint(enabled bool) Option {
+	return func(o *Options) {
+		o.V1TokenEndpoint = enabled
+	}
 }
 
-// UserID is the unique user identifier this client if for.
-func (cca Client) UserID() string {
-	return cca.userID
+// WithStaticEndpoints directs the client to use the specified authorization and token endpoints and issuer instead of
+// discovering the authority's metadata. The client then sends no instance discovery, tenant discovery or other
+// metadata requests, which permits authenticating in a disconnected environment, such as a sovereign enclave, whose
+// authority's metadata endpoints the client can't reach. It uses bundled instance metadata, as for
+// [WithOfflineInstanceDiscovery], to recognize the authority's aliases. The URLs must use https and have no query or
+// fragment. tokenEndpoint is required; the others may be empty when the client doesn't use them. A client can't have both static endpoints and an
+// Azure region.
+func WithStaticEndpoints(authorizationEndpoint, tokenEndpoint, issuer string) Option {
+	return func(o *Options) {
+		o.StaticAuthorizationEndpoint = authorizationEndpoint
+		o.StaticTokenEndpoint = tokenEndpoint
+		o.StaticIssuer = issuer
+	}
 }
 
-// authCodeURLOptions contains options for AuthCodeURL
-type authCodeURLOptions struct {
-	loginHint, tenantID string
+// WithReadOnlyCache directs the client to read tokens from its cache accessor, which another process maintains,
+// without ever writing, exporting or removing them. This suits a sidecar deployment in which one process acquires
+// and refreshes tokens for others sharing its serialized cache. AcquireTokenSilent returns a cached access token
+// when it's valid. Otherwise, instead of refreshing the token, it calls refreshNeeded, when that isn't nil, with the
+// request's account and scopes and returns an error. Tokens from other methods, such as AcquireTokenByCredential, aren't
+// cached, and RemoveAccount does nothing. New returns an error when the client has no cache accessor.
+func WithReadOnlyCache(refreshNeeded func(account Account, scopes []string)) Option {
+	return func(o *Options) {
+		o.ReadOnlyCache = true
+		o.RefreshNeeded = refreshNeeded
+	}
 }
 
-// AuthCodeURLOption is implemented by options for AuthCodeURL
-type AuthCodeURLOption interface {
-	authCodeURLOption()
+// WithCacheDisabled specifies whether the client keeps no tokens or accounts in memory or in a cache accessor.
+// A client with a disabled cache sends a request to the authority for every call to AcquireTokenByCredential and the other acquisition methods,
+// AcquireTokenSilent returns an error, and Account returns a zero Account. RemoveAccount does nothing. This suits an application that
+// implements its own token caching or mustn't retain tokens. New returns an error when the client also has a
+// cache accessor.
+func WithCacheDisabled(disabled bool) Option {
+	return func(o *Options) {
+		o.CacheDisabled = disabled
+	}
 }
 
-// AuthCodeURL creates a URL used to acquire an authorization code. Users need to call CreateAuthorizationCodeURLParameters and pass it in.
+// WithPartitionedCacheExport passes the cache accessor only the part of the cache stored under the key it
+// receives, such as one user's tokens, instead of the entire cache. When the accessor replaces the cache
+// with data for a key, the client replaces only that part of its cache. This reduces the cost of persisting
+// the cache of an application that holds many users' tokens, for example a web app that persists tokens per
+// user. The cache accessor receives the entire cache for operations that don't have a key.
+func WithPartitionedCacheExport() Option {
+	return func(o *Options) {
+		o.PartitionedCacheExport = true
+	}
+}
+
+// WithRedirectURIs registers the redirect URIs the client's authorization code flows may use, such as the URIs
+// of an application's routes. A call selects one with its redirectURI argument or [WithRedirectURI], and uses the
+// first when it selects none. A call selecting a redirect URI the client doesn't have returns an
+// errors.RedirectURIError before sending any request. New returns that error when a URI is malformed: each must
+// be absolute, have no fragment, and use https, a custom scheme, or http with a loopback host. By default, the
+// client allows any redirect URI.
+func WithRedirectURIs(uris ...string) Option {
+	return func(o *Options) {
+		o.RedirectURIs = uris
+	}
+}
+
+// WithUsernameNormalizer sets a function the client applies to usernames before [Client.AccountsByUsername] compares
+// them, such as the NFC normalization of golang.org/x/text/unicode/norm:
 //
-// Options:
-// - [WithLoginHint]
-// - [WithTenantID]
-func (cca Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, scopes []string, opts ...AuthCodeURLOption) (string, error) {
-	o := authCodeURLOptions{}
-	if err := options.ApplyOptions(&o, opts); err != nil {
-		return "", err
+//	WithUsernameNormalizer(norm.NFC.String)
+//
+// Normalization lets a username match the cached username of the same account when they're encoded differently,
+// for example when one has "é" as a single code point and the other as "e" followed by a combining accent, as
+// can happen with usernames typed on different platforms. By default the client doesn't normalize usernames.
+func WithUsernameNormalizer(normalize func(string) string) Option {
+	return func(o *Options) {
+		o.UsernameNormalizer = normalize
 	}
-	ap, err := cca.base.AuthParams.WithTenant(o.tenantID)
-	if err != nil {
-		return "", err
+}
+
+// WithTokenRequestRateLimit limits the rate of the client's token requests to each authority host, so that a
+// misbehaving caller, such as a loop acquiring tokens without using the cache, can't provoke the authority into
+// throttling the application for every caller. The client allows requestsPerSecond requests per second in bursts of
+// up to burst requests, delays a request exceeding that rate by up to maxDelay, and fails a request it would delay
+// longer with an errors.RateLimitError. The limit doesn't apply to tokens the client returns from its cache.
+// Clients sharing a cache don't share a limit. See [Client.RateLimitStats] for counts of delayed and rejected requests.
+func WithTokenRequestRateLimit(requestsPerSecond float64, burst int, maxDelay time.Duration) Option {
+	return func(o *Options) {
+		o.TokenRequestRate = requestsPerSecond
+		o.TokenRequestBurst = burst
+		o.TokenRequestMaxDelay = maxDelay
 	}
-	ap.LoginHint = o.loginHint
-	return cca.base.AuthCodeURL(ctx, clientID, redirectURI, scopes, ap)
 }
 
-// WithLoginHint pre-populates the login prompt with a username.
-func WithLoginHint(username string) interface {
-	AuthCodeURLOption
-	options.CallOption
-} {
-	return struct {
-		AuthCodeURLOption
+// WithCertificateExpiryWarning directs the client to warn whenever it authenticates with a certificate credential
+// that expires within window, so that operators replace the certificate before authentication starts failing. The
+// client calls warn with the certificate or, when warn is nil, logs the warning with the logger set by [WithLogger].
+// The client authenticates when it sends a token request, so it doesn't warn when it returns a cached token. warn is
+// called synchronously, before the token request, and applies to each certificate in a credential chain.
+func WithCertificateExpiryWarning(window time.Duration, warn func(cert *x509.Certificate)) Option {
+	return func(o *Options) {
+		o.CertificateExpiryWarning = warn
+		o.CertificateExpiryWindow = window
+	}
+}
+
+// WithRefreshTokenExpiryWarning directs the client to call warn when it returns a result for an account whose refresh
+// token expires within window, for example so an application can prompt its user to sign in again before silent
+// authentication starts failing. The client knows a refresh token's expiry only when the authority includes it in a
+// token response, as it does for refresh tokens bound to a device or limited by a sign-in frequency policy. The
+// AuthResult of any acquisition reports the expiry, when known, in RefreshTokenExpiresOn. warn is called synchronously,
+// before the acquisition method returns.
+func WithRefreshTokenExpiryWarning(window time.Duration, warn func(account Account, expiresOn time.Time)) Option {
+	return func(o *Options) {
+		o.RefreshTokenExpiryWarning = warn
+		o.RefreshTokenExpiryWindow = window
+	}
+}
+
+// WithRequestInterceptor sets a function the client calls before sending each token request, in all flows. The
+// function can enforce policy, for example by denying requests for certain scopes, and add parameters to the
+// request's body by setting ExtraBodyParameters. When it returns an error, the client doesn't send the request
+// and returns an error wrapping that one. It must be safe for concurrent use.
+func WithRequestInterceptor(interceptor func(context.Context, *TokenRequestInfo) error) Option {
+	return func(o *Options) {
+		o.RequestInterceptor = interceptor
+	}
+}
+
+// WithResponseInterceptor sets a function the client calls after each token request completes, successfully or not,
+// for example to write an audit log. The TokenResponseInfo it receives contains no secrets. It must be safe for
+// concurrent use.
+func WithResponseInterceptor(interceptor func(context.Context, *TokenResponseInfo)) Option {
+	return func(o *Options) {
+		o.ResponseInterceptor = interceptor
+	}
+}
+
+// WithStrictTokenResponses makes the client reject a token response having an expires_in, ext_expires_in,
+// refresh_token_expires_in or expires_on value that isn't a whole number of seconds, malformed client_info or no
+// expires_in. The error describes each malformed field. By default, the client is lenient because some token
+// services, such as emulators, return nonstandard responses: it truncates fractional seconds, treats empty and null
+// durations as absent and ignores malformed client info, which then identifies no account.
+func WithStrictTokenResponses(enabled bool) Option {
+	return func(o *Options) {
+		o.StrictTokenResponses = enabled
+	}
+}
+
+// WithPIILogging enables a wire log of the client's HTTP requests and responses, for debugging. The client writes
+// the log with the standard library's log package unless it has [WithLogger]. It always replaces secrets and tokens
+// with a prefix of their SHA-256 hash, which identifies a value without revealing it, and does the same to personal
+// data such as usernames unless level is [WireLogPII]. It omits bodies it can't redact, such as WS-Trust XML. Don't
+// enable [WireLogPII] in production without considering where the log goes.
+func WithPIILogging(level PIILevel) Option {
+	return func(o *Options) {
+		o.PIILogging = level
+	}
+}
+
+// WithLogger sends the client's log output, such as the wire log enabled by [WithPIILogging], to logger instead of
+// the standard library's log package. A nil logger restores the default.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithX5C specifies if x5c claim(public key of the certificate) should be sent to STS to enable Subject Name Issuer Authentication.
+func WithX5C() Option {
+	return func(o *Options) {
+		o.SendX5C = true
+	}
+}
+
+// WithAzureRegion sets the region(preferred) or Confidential.AutoDetectRegion() for auto detecting region.
+// Region names as per https://azure.microsoft.com/en-ca/global-infrastructure/geographies/.
+// See https://aka.ms/region-map for more details on region names.
+// The region value should be short region name for the region where the service is deployed.
+// For example "centralus" is short name for region Central US.
+// Not all auth flows can use the regional token service.
+// Service To Service (client credential flow) tokens can be obtained from the regional service.
+// Requires configuration at the tenant level.
+// Auto-detection works on a limited number of Azure artifacts (VMs, Azure functions).
+// If auto-detection fails, the non-regional endpoint will be used.
+// If an invalid region name is provided, the non-regional endpoint MIGHT be used or the token request MIGHT fail.
+// If the regional endpoint is unavailable, the client retries the request on the non-regional endpoint
+// and uses that endpoint for the next several minutes before trying the regional endpoint again.
+func WithAzureRegion(val string) Option {
+	return func(o *Options) {
+		o.AzureRegion = val
+	}
+}
+
+// WithTokenEndpointOverride sends token requests to endpoint, for example an Azure Private Link endpoint, instead of
+// the token endpoint the authority advertises. The client still gets metadata from the authority, and caches tokens
+// under the authority's host, so tokens cached by clients that don't override the endpoint remain valid. The override
+// takes precedence over WithAzureRegion and applies to requests for all tenants, including those specified by
+// WithTenantID. Client assertions signed by certificate credentials have the override as their audience unless
+// WithAssertionAudience specifies another.
+func WithTokenEndpointOverride(endpoint string) Option {
+	return func(o *Options) {
+		o.TokenEndpointOverride = endpoint
+	}
+}
+
+// WithReturnRefreshToken specifies whether the client includes the account's refresh token in the RefreshToken
+// field of its results, for token brokering services that hand refresh tokens to other components. By default it
+// doesn't, and applications should leave it that way: the client manages refresh tokens itself, and a refresh
+// token is a long-lived credential, so an application exposing one must protect it as it would a password, for
+// example by never logging results. Results without an account, such as those of AcquireTokenByCredential,
+// have no refresh token.
+func WithReturnRefreshToken(enabled bool) Option {
+	return func(o *Options) {
+		o.ReturnRefreshToken = enabled
+	}
+}
+
+// WithTokenEndpoint is equivalent to [WithTokenEndpointOverride]. Its name pairs it with [WithAuthorizationEndpoint]
+// for deployments placing a reverse proxy or API gateway in front of both endpoints.
+func WithTokenEndpoint(endpoint string) Option {
+	return WithTokenEndpointOverride(endpoint)
+}
+
+// WithAuthorizationEndpoint sets the URL of the authorization endpoint in the URLs returned by AuthCodeURL, for example
+// the address of a reverse proxy or API gateway in front of the authority, instead of the endpoint in the authority's
+// openid-configuration document. The client still gets that document from the authority. The URL must use https and
+// have no query or fragment.
+func WithAuthorizationEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.AuthorizationEndpoint = endpoint
+	}
+}
+
+// New is the constructor for Client. userID is the unique identifier of the user this client
+// will store credentials for (a Client is per user). clientID is the Azure clientID and cred is
+// the type of credential to use.
+//
+// New sends no requests and is cheap enough to call per request, for example in a web handler, provided the
+// clients share a cache via [WithAccessor]. It's safe to call concurrently, and a Client is safe for concurrent use.
+func New(clientID string, cred Credential, options ...Option) (Client, error) {
+	opts := Options{
+		Authority:  base.AuthorityPublicCloud,
+		HTTPClient: shared.DefaultClient,
+	}
+
+	for _, o := range options {
+		o(&opts)
+	}
+	// report a bad credential along with any problems in the options
+	var errs []error
+	internalCred, err := cred.toInternal()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := optionsError(append(errs, opts.validate(clientID)...)); err != nil {
+		return Client{}, err
+	}
+
+	baseOpts := []base.Option{
+		base.WithConfidentialClient(),
+		base.WithCacheAccessor(opts.Accessor),
+		base.WithPartitionedCacheExport(opts.PartitionedCacheExport),
+		base.WithCacheDisabled(opts.CacheDisabled),
+		base.WithReadOnlyCache(opts.ReadOnlyCache, opts.RefreshNeeded),
+		base.WithCacheCompression(opts.CacheCompression),
+		base.WithCacheNamespace(opts.CacheNamespace),
+		base.WithCacheEncrypter(opts.Encrypter),
+		base.WithClock(opts.Clock),
+		base.WithClockSkew(opts.ClockSkew),
+		base.WithClockSkewCompensation(opts.ClockSkewCompensation),
+		base.WithAllowStaleOnError(opts.MaxStale),
+		base.WithExtendedTokenLifetime(opts.ExtendedTokenLifetime),
+		base.WithInterceptors(opts.RequestInterceptor, opts.ResponseInterceptor),
+		base.WithStrictTokenResponses(opts.StrictTokenResponses),
+		base.WithPolicy(opts.AllowedTenants, opts.AllowedScopes),
+		base.WithOfflineInstanceDiscovery(opts.OfflineInstanceDiscovery),
+		base.WithInstanceDiscoveryEndpoint(opts.InstanceDiscoveryEndpoint),
+		base.WithStaticEndpoints(opts.StaticAuthorizationEndpoint, opts.StaticTokenEndpoint, opts.StaticIssuer),
+		base.WithV1TokenEndpoint(opts.V1TokenEndpoint),
+		base.WithRefreshTokenExpiryWarning(opts.RefreshTokenExpiryWindow, opts.RefreshTokenExpiryWarning),
+		base.WithRateLimit(opts.TokenRequestRate, opts.TokenRequestBurst, opts.TokenRequestMaxDelay),
+		base.WithUsernameNormalizer(opts.UsernameNormalizer),
+		base.WithRegionDetection(opts.AzureRegion),
+		base.WithTokenEndpointOverride(opts.TokenEndpointOverride),
+		base.WithAuthorizationEndpointOverride(opts.AuthorizationEndpoint),
+		base.WithReturnRefreshToken(opts.ReturnRefreshToken),
+		base.WithX5C(opts.SendX5C),
+	}
+	if cred.tokenProvider != nil {
+		// The caller will handle all details of authentication, using Client only as a token cache.
+		// Declaring the authority host known prevents unnecessary metadata discovery requests. (The
+		// authority is irrelevant to Client and friends because the token provider is responsible
+		// for authentication.)
+		parsed, err := url.Parse(opts.Authority)
+		if err != nil {
+			return Client{}, errors.New("invalid authority")
+		}
+		baseOpts = append(baseOpts, base.WithKnownAuthorityHosts([]string{parsed.Hostname()}))
+	}
+	logf := log.Printf
+	if opts.Logger != nil {
+		logf = opts.Logger.Printf
+	}
+	if opts.CertificateExpiryWindow > 0 {
+		warn := opts.CertificateExpiryWarning
+		if warn == nil {
+			warn = func(cert *x509.Certificate) {
+				h := sha1.Sum(cert.Raw)
+				logf("MSAL: client certificate %s expires at %s", hex.EncodeToString(h[:]), cert.NotAfter.Format(time.RFC3339))
+			}
+		}
+		creds := []*accesstokens.Credential{internalCred}
+		if internalCred.Chain != nil {
+			creds = internalCred.Chain.Credentials()
+		}
+		for _, c := range creds {
+			if c.Cert != nil {
+				c.CertExpiryWarning, c.CertExpiryWindow = warn, opts.CertificateExpiryWindow
+			}
+		}
+	}
+	base, err := base.New(clientID, opts.Authority, oauth.New(wirelog.New(opts.HTTPClient, opts.PIILogging, logf)), baseOpts...)
+	if err != nil {
+		return Client{}, err
+	}
+
+	return Client{base: base, cred: internalCred, redirectURIs: opts.RedirectURIs}, nil
+}
+
+// UserID is the unique user identifier this client if for.
+func (cca Client) UserID() string {
+	return cca.userID
+}
+
+// authCodeURLOptions contains options for AuthCodeURL
+type authCodeURLOptions struct {
+	claims, loginHint, redirectURI, tenantID string
+	flow                                     FlowState
+	pushed                                   bool
+}
+
+// AuthCodeURLOption is implemented by options for AuthCodeURL
+type AuthCodeURLOption interface {
+	authCodeURLOption()
+}
+
+// AuthCodeURL creates a URL used to acquire an authorization code. Users need to call CreateAuthorizationCodeURLParameters and pass it in.
+//
+// Options:
+// - [WithClaims]
+// - [WithFlowState]
+// - [WithLoginHint]
+// - [WithPushedAuthorizationRequest]
+// - [WithRedirectURI]
+// - [WithTenantID]
+func (cca Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, scopes []string, opts ...AuthCodeURLOption) (string, error) {
+	o := authCodeURLOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return "", err
+	}
+	ap, err := cca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return "", err
+	}
+	ap.Claims = o.claims
+	ap.LoginHint = o.loginHint
+	ap.PushedAuthorization = o.pushed
+	if o.flow.State != "" {
+		h := sha256.Sum256([]byte(o.flow.CodeVerifier))
+		ap.CodeChallenge = base64.RawURLEncoding.EncodeToString(h[:])
+		ap.CodeChallengeMethod = "S256"
+		ap.Nonce = o.flow.Nonce
+		ap.State = o.flow.State
+	}
+	redirectURI, err = cca.redirectURI(redirectURI, o.redirectURI)
+	if err != nil {
+		return "", err
+	}
+	return cca.base.AuthCodeURL(ctx, clientID, redirectURI, scopes, ap, cca.cred)
+}
+
+// WithPushedAuthorizationRequest has AuthCodeURL push the parameters of the authorization request to the authority
+// (RFC 9126), authenticating with the client's credential, and return a short URL referring to them. The parameters
+// then don't pass through the browser, where users and extensions could read or alter them, and the authority knows
+// they came from the application. The authority's metadata must advertise a pushed_authorization_request_endpoint;
+// AuthCodeURL returns an error when it doesn't.
+func WithPushedAuthorizationRequest() interface {
+	AuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *authCodeURLOptions:
+					t.pushed = true
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// FlowState is the secret state of one authorization code flow: a state parameter protecting the flow from
+// cross-site request forgery, a PKCE code verifier, and a nonce binding the ID token to the flow. A web app
+// creates one with [NewFlowState] for each sign-in, passes it to AuthCodeURL with [WithFlowState], stores
+// it in the user's session, and passes it and the redirect's query to [Client.AcquireTokenByAuthCodeFlow].
+// Its fields are secret, so the session store must keep them on the server or encrypt them. FlowState
+// serializes to JSON; a flow should be redeemed once and then removed from the session.
+type FlowState struct {
+	State        string `json:"state"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+}
+
+// NewFlowState returns a FlowState having new random values.
+func NewFlowState() (FlowState, error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return FlowState{}, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return FlowState{State: values[0], CodeVerifier: values[1], Nonce: values[2]}, nil
+}
+
+// WithFlowState adds the state, PKCE code challenge and nonce of flow to the URL AuthCodeURL creates.
+// Redeem the resulting authorization code with [Client.AcquireTokenByAuthCodeFlow].
+func WithFlowState(flow FlowState) interface {
+	AuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *authCodeURLOptions:
+					if flow.State == "" || flow.CodeVerifier == "" {
+						return errors.New("flow state must have a state and code verifier; create it with NewFlowState")
+					}
+					t.flow = flow
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// WithLoginHint pre-populates the login prompt with a username.
+func WithLoginHint(username string) interface {
+	AuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *authCodeURLOptions:
+					t.loginHint = username
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// logoutURLOptions contains options for CreateLogoutURL
+type logoutURLOptions struct {
+	tenantID string
+}
+
+// LogoutURLOption is implemented by options for CreateLogoutURL
+type LogoutURLOption interface {
+	logoutURLOption()
+}
+
+// CreateLogoutURL creates a URL that signs the user out of the authority, using the end_session_endpoint
+// from the authority's OpenID configuration. Web apps redirect the user's browser to this URL, after which
+// the authority redirects it to postLogoutRedirectURI. postLogoutRedirectURI is optional; when set, it must be
+// registered for the application. This method doesn't remove accounts from the cache, see [Client.RemoveAccount].
+//
+// Options:
+//   - [WithTenantID]
+func (cca Client) CreateLogoutURL(ctx context.Context, postLogoutRedirectURI string, opts ...LogoutURLOption) (string, error) {
+	o := logoutURLOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return "", err
+	}
+	ap, err := cca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return "", err
+	}
+	return cca.base.LogoutURL(ctx, ap, postLogoutRedirectURI)
+}
+
+// WithClaims sets additional claims to request, a JSON object such as the claims challenge a Continuous Access
+// Evaluation (CAE) enabled resource returns when it rejects an access token. AcquireTokenSilent,
+// AcquireTokenByRefreshToken, AcquireTokenByTokenExchange and AcquireTokenOnBehalfOf don't return a cached access token when claims are set,
+// because that token may be the one the resource rejected. Instead they redeem a cached refresh token, if any, for
+// a new access token.
+func WithClaims(claims string) interface {
+	AcquireByAuthCodeOption
+	AcquireByCredentialOption
+	AcquireByDeviceCodeOption
+	AcquireByRefreshTokenOption
+	AcquireByTokenExchangeOption
+	AcquireOnBehalfOfOption
+	AcquireSilentOption
+	AuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByCredentialOption
+		AcquireByDeviceCodeOption
+		AcquireByRefreshTokenOption
+		AcquireByTokenExchangeOption
+		AcquireOnBehalfOfOption
+		AcquireSilentOption
+		AuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.claims = claims
+				case *acquireTokenByCredentialOptions:
+					t.claims = claims
+				case *acquireTokenByDeviceCodeOptions:
+					t.claims = claims
+				case *acquireTokenByRefreshTokenOptions:
+					t.claims = claims
+				case *acquireTokenByTokenExchangeOptions:
+					t.claims = claims
+				case *acquireTokenOnBehalfOfOptions:
+					t.claims = claims
+				case *AcquireTokenSilentOptions:
+					t.claims = claims
+				case *authCodeURLOptions:
+					t.claims = claims
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// RequestPriority indicates how urgently an application needs a token. See [WithRequestPriority].
+type RequestPriority = ops.Priority
+
+const (
+	// PriorityInteractive is for requests a user is waiting on. When the authority throttles such a request,
+	// the client retries it promptly, a few times.
+	PriorityInteractive RequestPriority = ops.PriorityInteractive
+	// PriorityBackground is for requests that can wait, such as refreshing a token before it expires. When the
+	// authority throttles such a request, the client retries it with exponential backoff, honoring the authority's
+	// Retry-After header, so that background work doesn't add to the load on a throttled authority.
+	PriorityBackground RequestPriority = ops.PriorityBackground
+)
+
+// WithRequestPriority sets the priority of an acquisition, which determines how the client retries requests the
+// authority throttles (HTTP 429). By default, the client doesn't retry throttled requests.
+func WithRequestPriority(priority RequestPriority) interface {
+	AcquireByAuthCodeOption
+	AcquireByCredentialOption
+	AcquireByRefreshTokenOption
+	AcquireOnBehalfOfOption
+	AcquireSilentOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByCredentialOption
+		AcquireByRefreshTokenOption
+		AcquireOnBehalfOfOption
+		AcquireSilentOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
 			func(a any) error {
 				switch t := a.(type) {
-				case *authCodeURLOptions:
-					t.loginHint = username
+				case *AcquireTokenByAuthCodeOptions:
+					t.priority = priority
+				case *acquireTokenByCredentialOptions:
+					t.priority = priority
+				case *acquireTokenByRefreshTokenOptions:
+					t.priority = priority
+				case *acquireTokenOnBehalfOfOptions:
+					t.priority = priority
+				case *AcquireTokenSilentOptions:
+					t.priority = priority
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// WithPerCallHTTPClient sets the HTTP client that sends an acquisition's requests, instead of the client set by
+// [WithHTTPClient], for example to route one tenant's requests through an egress proxy. It applies to every
+// request of the acquisition, including requests for the authority's metadata when the client hasn't cached it,
+// and to the probe requests of [Client.Validate]. The client doesn't log the requests httpClient sends, even when it has [WithPIILogging].
+func WithPerCallHTTPClient(httpClient ops.HTTPClient) interface {
+	AcquireByAuthCodeOption
+	AcquireByCredentialOption
+	AcquireByRefreshTokenOption
+	AcquireOnBehalfOfOption
+	AcquireSilentOption
+	ValidateOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByCredentialOption
+		AcquireByRefreshTokenOption
+		AcquireOnBehalfOfOption
+		AcquireSilentOption
+		ValidateOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.httpClient = httpClient
+				case *acquireTokenByCredentialOptions:
+					t.httpClient = httpClient
+				case *acquireTokenByRefreshTokenOptions:
+					t.httpClient = httpClient
+				case *acquireTokenOnBehalfOfOptions:
+					t.httpClient = httpClient
+				case *AcquireTokenSilentOptions:
+					t.httpClient = httpClient
+				case *validateOptions:
+					t.httpClient = httpClient
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// IDTokenPolicy determines whether a token request requires the authority's response to include an ID token.
+// See [WithIDTokenPolicy].
+type IDTokenPolicy = authority.IDTokenPolicy
+
+const (
+	// IDTokenOptional accepts responses with or without an ID token. This is the default.
+	IDTokenOptional IDTokenPolicy = authority.IDTokenOptional
+	// IDTokenRequired rejects responses without an ID token, for example when an application signs users in.
+	IDTokenRequired IDTokenPolicy = authority.IDTokenRequired
+	// IDTokenForbidden rejects responses having an ID token, for example when a flow must not identify a user.
+	IDTokenForbidden IDTokenPolicy = authority.IDTokenForbidden
+)
+
+// WithIDTokenPolicy determines whether an acquisition requires the authority's response to include an ID token.
+// When the response doesn't satisfy policy, the method returns an error and the client doesn't cache the response's
+// tokens. The policy applies only to the authority's responses, not to tokens the client returns from its cache.
+// Some flows, such as the client credentials grant and some Azure AD B2C policies, return no ID token. The client
+// caches no account for a response that has no client info, even when the response has an ID token.
+func WithIDTokenPolicy(policy IDTokenPolicy) interface {
+	AcquireByAuthCodeOption
+	AcquireByCredentialOption
+	AcquireOnBehalfOfOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByCredentialOption
+		AcquireOnBehalfOfOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.idTokenPolicy = policy
+				case *acquireTokenByCredentialOptions:
+					t.idTokenPolicy = policy
+				case *acquireTokenOnBehalfOfOptions:
+					t.idTokenPolicy = policy
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -447,22 +1643,109 @@ func WithLoginHint(username string) interface {
 	}
 }
 
+// AuthenticationScheme determines the type of an access token and how the client formats it. The client requests
+// a token of the scheme's type and caches it separately from tokens of other types, such as the default Bearer
+// tokens. Proof-of-possession (PoP) schemes bind a token to a key identified by the scheme's KeyID.
+type AuthenticationScheme = authority.AuthenticationScheme
+
+// WithAuthenticationScheme requests an access token of scheme's type. An acquisition fails when the authority
+// returns a token of another type, and AcquireTokenSilent returns only cached tokens of that type and key.
+func WithAuthenticationScheme(scheme AuthenticationScheme) interface {
+	AcquireByCredentialOption
+	AcquireSilentOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByCredentialOption
+		AcquireSilentOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *acquireTokenByCredentialOptions:
+					t.authnScheme = scheme
+				case *AcquireTokenSilentOptions:
+					t.authnScheme = scheme
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// DPoP is an AuthenticationScheme binding access tokens to a key by Demonstrating Proof of Possession (RFC 9449),
+// for authorities and resources that require DPoP. The client adds a proof to each token request, including the
+// nonce the authority requires, if any. An application sends a DPoP token in an Authorization header having the
+// DPoP scheme, with a DPoP header whose value it gets from [DPoP.Proof]. When a resource requires a nonce, the
+// application passes the resource's DPoP-Nonce header to [DPoP.ObserveNonce] and retries with a new proof.
+type DPoP = authority.DPoP
+
+// NewDPoP returns a DPoP scheme whose proofs key signs. key must be an ECDSA P-256 key or an RSA key, and the
+// application should use the same key for all requests with the scheme's tokens. Pass the scheme to
+// [WithAuthenticationScheme].
+func NewDPoP(key crypto.Signer) (*DPoP, error) {
+	return authority.NewDPoP(key)
+}
+
+// These are the meta-tenants of Microsoft Entra authorities. An authority such as
+// https://login.microsoftonline.com/common specifies a class of accounts rather than a specific tenant.
+const (
+	// TenantCommon accepts work, school and personal Microsoft accounts.
+	TenantCommon = authority.TenantCommon
+	// TenantConsumers accepts only personal Microsoft accounts.
+	TenantConsumers = authority.TenantConsumers
+	// TenantOrganizations accepts only work and school accounts.
+	TenantOrganizations = authority.TenantOrganizations
+)
+
+// IsMetaTenant returns true when tenant is [TenantCommon], [TenantConsumers] or [TenantOrganizations].
+func IsMetaTenant(tenant string) bool {
+	return authority.IsMetaTenant(tenant)
+}
+
+// EffectiveTenant returns the tenant a client configured with authorityURI would request tokens from when
+// given [WithTenantID](tenantID). An empty tenantID returns the authority's tenant. It returns the error
+// [WithTenantID] would cause when the combination is invalid.
+func EffectiveTenant(authorityURI, tenantID string) (string, error) {
+	return authority.EffectiveTenant(authorityURI, tenantID)
+}
+
 // WithTenantID specifies a tenant for a single authentication. It may be different than the tenant set in [New] by [WithAuthority].
-// This option is valid for any token acquisition method.
+// This option is valid for any token acquisition method. tenantID must be a specific tenant, and the client's
+// authority must be a Microsoft Entra authority whose tenant isn't [TenantConsumers]. Otherwise, acquisition
+// methods return an errors.InvalidTenantError from package
+// github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors.
 func WithTenantID(tenantID string) interface {
 	AcquireByAuthCodeOption
 	AcquireByCredentialOption
+	AcquireByDeviceCodeOption
+	AcquireByRefreshTokenOption
+	AcquireByTokenExchangeOption
 	AcquireOnBehalfOfOption
 	AcquireSilentOption
 	AuthCodeURLOption
+	CacheKeysOption
+	LogoutURLOption
+	ResolvedEndpointsOption
+	WarmupOption
 	options.CallOption
 } {
 	return struct {
 		AcquireByAuthCodeOption
 		AcquireByCredentialOption
+		AcquireByDeviceCodeOption
+		AcquireByRefreshTokenOption
+		AcquireByTokenExchangeOption
 		AcquireOnBehalfOfOption
 		AcquireSilentOption
 		AuthCodeURLOption
+		CacheKeysOption
+		LogoutURLOption
+		ResolvedEndpointsOption
+		WarmupOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
@@ -472,12 +1755,26 @@ func WithTenantID(tenantID string) interface {
 					t.tenantID = tenantID
 				case *acquireTokenByCredentialOptions:
 					t.tenantID = tenantID
+				case *acquireTokenByDeviceCodeOptions:
+					t.tenantID = tenantID
+				case *acquireTokenByRefreshTokenOptions:
+					t.tenantID = tenantID
+				case *acquireTokenByTokenExchangeOptions:
+					t.tenantID = tenantID
 				case *acquireTokenOnBehalfOfOptions:
 					t.tenantID = tenantID
 				case *AcquireTokenSilentOptions:
 					t.tenantID = tenantID
 				case *authCodeURLOptions:
 					t.tenantID = tenantID
+				case *cacheKeysOptions:
+					t.tenantID = tenantID
+				case *logoutURLOptions:
+					t.tenantID = tenantID
+				case *resolvedEndpointsOptions:
+					t.tenantID = tenantID
+				case *warmupOptions:
+					t.tenantID = tenantID
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -493,7 +1790,10 @@ type AcquireTokenSilentOptions struct {
 	// Account represents the account to use. To set, use the WithSilentAccount() option.
 	Account Account
 
-	tenantID string
+	authnScheme      AuthenticationScheme
+	claims, tenantID string
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
 }
 
 // AcquireSilentOption is implemented by options for AcquireTokenSilent
@@ -529,60 +1829,391 @@ func WithSilentAccount(account Account) interface {
 	}
 }
 
-// AcquireTokenSilent acquires a token from either the cache or using a refresh token.
+// AcquireTokenSilent acquires a token from either the cache or using a refresh token. With [WithTenantID], it gets
+// a token from that tenant for the account, which may be a guest there: the client matches the account by its
+// home account ID and redeems the account's refresh token when the cache has no token from the tenant. The
+// result's TenantID identifies the tenant that issued the token.
+//
+// Options:
+//   - [WithAuthenticationScheme]
+//   - [WithClaims]
+//   - [WithRequestPriority]
+//   - [WithSilentAccount]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenSilent(ctx context.Context, scopes []string, opts ...AcquireSilentOption) (AuthResult, error) {
+	o := AcquireTokenSilentOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+
+	silentParameters := base.AcquireTokenSilentParameters{
+		Scopes:      scopes,
+		Account:     o.Account,
+		RequestType: accesstokens.ATConfidential,
+		Credential:  cca.cred,
+		IsAppCache:  o.Account.IsZero(),
+		TenantID:    o.tenantID,
+		Claims:      o.claims,
+		AuthnScheme: o.authnScheme,
+	}
+
+	return cca.base.AcquireTokenSilent(ctx, silentParameters)
+}
+
+// AcquireTokenByAuthCodeOptions contains the optional parameters used to acquire an access token using the authorization code flow.
+type AcquireTokenByAuthCodeOptions struct {
+	Challenge string
+
+	claims, redirectURI, tenantID string
+	idTokenPolicy                 IDTokenPolicy
+	priority                      RequestPriority
+	httpClient                    ops.HTTPClient
+}
+
+// AcquireByAuthCodeOption is implemented by options for AcquireTokenByAuthCode
+type AcquireByAuthCodeOption interface {
+	acquireByAuthCodeOption()
+}
+
+// AcquireTokenByAuthCodeOption changes options inside AcquireTokenByAuthCodeOptions used in .AcquireTokenByAuthCode().
+type AcquireTokenByAuthCodeOption func(a *AcquireTokenByAuthCodeOptions)
+
+func (AcquireTokenByAuthCodeOption) acquireByAuthCodeOption() {}
+
+// WithChallenge allows you to provide a challenge for the .AcquireTokenByAuthCode() call.
+func WithChallenge(challenge string) interface {
+	AcquireByAuthCodeOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.Challenge = challenge
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// WithRedirectURI sets the redirect URI of an AuthCodeURL or authorization code redemption, taking precedence over
+// the method's redirectURI argument. This helps applications having several registered redirect URIs, such as one
+// per route, select a redirect URI per call. Redeeming an authorization code requires the redirect URI of the
+// AuthCodeURL that requested the code. The method returns an errors.RedirectURIError when redirectURI is malformed
+// or the client has redirect URIs (see [WithRedirectURIs]) that don't include it.
+func WithRedirectURI(redirectURI string) interface {
+	AcquireByAuthCodeOption
+	AuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.redirectURI = redirectURI
+				case *authCodeURLOptions:
+					t.redirectURI = redirectURI
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// AcquireTokenByAuthCode is a request to acquire a security token from the authority, using an authorization code.
+// The specified redirect URI must be the same URI that was used when the authorization code was requested. The
+// result's Account and IDToken describe the signed-in user, whose tokens the client caches in that user's cache
+// partition, from which AcquireTokenSilent with the Account reads them.
+//
+// Options:
+//   - [WithChallenge]
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRedirectURI]
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenByAuthCode(ctx context.Context, code string, redirectURI string, scopes []string, opts ...AcquireByAuthCodeOption) (AuthResult, error) {
+	o := AcquireTokenByAuthCodeOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	return cca.authCode(ctx, code, redirectURI, scopes, o, "")
+}
+
+// AcquireTokenByAuthCodeFlow redeems the authorization code in redirect, the query of the request by which the
+// authority redirected the user's browser to redirectURI, for a flow that began with an AuthCodeURL having
+// [WithFlowState]. It returns an error without sending a token request when redirect's state doesn't match
+// flow's, which indicates a forged request, or when redirect reports an error. It also returns an error when
+// the nonce of the ID token the authority returns doesn't match flow's, in which case the client doesn't cache
+// the token.
+//
+// Options:
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRedirectURI]
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenByAuthCodeFlow(ctx context.Context, flow FlowState, redirect url.Values, redirectURI string, scopes []string, opts ...AcquireByAuthCodeOption) (AuthResult, error) {
+	if flow.State == "" || flow.CodeVerifier == "" {
+		return AuthResult{}, errors.New("flow state must have a state and code verifier; create it with NewFlowState")
+	}
+	if subtle.ConstantTimeCompare([]byte(redirect.Get("state")), []byte(flow.State)) != 1 {
+		return AuthResult{}, errors.New("the redirect's state doesn't match the flow's, so the request may be forged")
+	}
+	if e := redirect.Get("error"); e != "" {
+		return AuthResult{}, fmt.Errorf("authorization failed: %s: %s", e, redirect.Get("error_description"))
+	}
+	code := redirect.Get("code")
+	if code == "" {
+		return AuthResult{}, errors.New("the redirect has no authorization code")
+	}
+	o := AcquireTokenByAuthCodeOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	o.Challenge = flow.CodeVerifier
+	return cca.authCode(ctx, code, redirectURI, scopes, o, flow.Nonce)
+}
+
+// authCode redeems an authorization code. When nonce isn't empty, the ID token the authority returns must have it.
+func (cca Client) authCode(ctx context.Context, code, redirectURI string, scopes []string, o AcquireTokenByAuthCodeOptions, nonce string) (AuthResult, error) {
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+	redirectURI, err := cca.redirectURI(redirectURI, o.redirectURI)
+	if err != nil {
+		return AuthResult{}, err
+	}
+
+	params := base.AcquireTokenAuthCodeParameters{
+		Scopes:        scopes,
+		Code:          code,
+		Challenge:     o.Challenge,
+		AppType:       accesstokens.ATConfidential,
+		Credential:    cca.cred, // This setting differs from public.Client.AcquireTokenByAuthCode
+		RedirectURI:   redirectURI,
+		TenantID:      o.tenantID,
+		Claims:        o.claims,
+		Nonce:         nonce,
+		IDTokenPolicy: o.idTokenPolicy,
+	}
+
+	return cca.base.AcquireTokenByAuthCode(ctx, params)
+}
+
+// acquireTokenByCredentialOptions contains optional configuration for AcquireTokenByCredential
+type acquireTokenByCredentialOptions struct {
+	authnScheme      AuthenticationScheme
+	claims, tenantID string
+	idTokenPolicy    IDTokenPolicy
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
+}
+
+// AcquireByCredentialOption is implemented by options for AcquireTokenByCredential
+type AcquireByCredentialOption interface {
+	acquireByCredOption()
+}
+
+// AcquireTokenByCredential acquires a security token from the authority, using the client credentials grant.
+// When the authority is a Microsoft Entra authority, scopes must be the single "/.default" scope of a resource,
+// such as "https://graph.microsoft.com/.default". The method returns an errors.ScopeError for other scopes
+// without sending a request.
+//
+// Options:
+//   - [WithAuthenticationScheme]
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenByCredential(ctx context.Context, scopes []string, opts ...AcquireByCredentialOption) (AuthResult, error) {
+	o := acquireTokenByCredentialOptions{}
+	err := options.ApplyOptions(&o, opts)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	authParams, err := cca.credentialAuthParams(scopes, o)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return cca.acquireTokenByCredential(ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient), authParams)
+}
+
+// credentialAuthParams returns the AuthParams of a client credentials request
+func (cca Client) credentialAuthParams(scopes []string, o acquireTokenByCredentialOptions) (authority.AuthParams, error) {
+	authParams, err := cca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return authority.AuthParams{}, err
+	}
+	if authParams.AuthorityInfo.AuthorityType == authority.AAD {
+		if err := validateCredentialScopes(scopes); err != nil {
+			return authority.AuthParams{}, err
+		}
+	}
+	authParams.Scopes = scopes
+	authParams.AuthorizationType = authority.ATClientCredentials
+	authParams.Claims = o.claims
+	authParams.IDTokenPolicy = o.idTokenPolicy
+	authParams.AuthnScheme = o.authnScheme
+	return authParams, nil
+}
+
+// validateCredentialScopes returns an errors.ScopeError when scopes isn't the single "/.default" scope
+// Microsoft Entra ID requires of client credentials requests
+func validateCredentialScopes(scopes []string) error {
+	var reason string
+	switch {
+	case len(scopes) == 0:
+		reason = "no scopes were requested"
+	case len(scopes) > 1:
+		reason = "a request can have only one scope"
+	case !strings.HasSuffix(scopes[0], "/.default"):
+		reason = `the scope doesn't end with "/.default"`
+	case len(scopes[0]) == len("/.default"):
+		reason = "the scope doesn't identify a resource"
+	default:
+		return nil
+	}
+	return msalerrors.ScopeError{Scopes: append([]string(nil), scopes...), Reason: reason}
+}
+
+func (cca Client) acquireTokenByCredential(ctx context.Context, authParams authority.AuthParams) (AuthResult, error) {
+	token, err := cca.base.Token.Credential(ctx, authParams, cca.cred)
+	if err != nil {
+		if ar, ok := cca.base.StaleAppToken(ctx, authParams, err); ok {
+			return ar, nil
+		}
+		return AuthResult{}, err
+	}
+	return cca.base.AuthResultFromToken(ctx, authParams, token, true)
+}
+
+// minTokenValidity is how long a token AutoRefreshingToken.Token returns remains valid, at least
+const minTokenValidity = 5 * time.Minute
+
+// AutoRefreshingToken provides unexpired tokens for an operation that may outlive a token's lifetime, such as
+// a long export or migration. Create one with [Client.AutoRefreshingToken]. It's safe for concurrent use.
+type AutoRefreshingToken struct {
+	acquire func(context.Context) (AuthResult, error)
+	now     func() time.Time
+
+	// mu protects current and serializes acquisitions, so concurrent callers share a new token
+	mu      sync.Mutex
+	current AuthResult
+}
+
+// AutoRefreshingToken acquires a token for scopes as [Client.AcquireTokenByCredential] does and returns a handle
+// whose Token method returns that token until it's about to expire, and then acquires a new one. It returns an
+// error when it can't acquire the first token.
 //
 // Options:
-//   - [WithSilentAccount]
+//   - [WithAuthenticationScheme]
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRequestPriority]
 //   - [WithTenantID]
-func (cca Client) AcquireTokenSilent(ctx context.Context, scopes []string, opts ...AcquireSilentOption) (AuthResult, error) {
-	o := AcquireTokenSilentOptions{}
+func (cca Client) AutoRefreshingToken(ctx context.Context, scopes []string, opts ...AcquireByCredentialOption) (*AutoRefreshingToken, error) {
+	o := acquireTokenByCredentialOptions{}
 	if err := options.ApplyOptions(&o, opts); err != nil {
-		return AuthResult{}, err
+		return nil, err
 	}
-
-	silentParameters := base.AcquireTokenSilentParameters{
-		Scopes:      scopes,
-		Account:     o.Account,
-		RequestType: accesstokens.ATConfidential,
-		Credential:  cca.cred,
-		IsAppCache:  o.Account.IsZero(),
-		TenantID:    o.tenantID,
+	authParams, err := cca.credentialAuthParams(scopes, o)
+	if err != nil {
+		return nil, err
+	}
+	t := &AutoRefreshingToken{
+		acquire: func(ctx context.Context) (AuthResult, error) {
+			return cca.acquireTokenByCredential(ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient), authParams)
+		},
+		now: time.Now,
 	}
+	if t.current, err = t.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
 
-	return cca.base.AcquireTokenSilent(ctx, silentParameters)
+// Token returns a token that remains valid for at least five minutes or, when ctx has a deadline further away,
+// until that deadline, so that work bounded by ctx can use the token throughout. It acquires a new token when
+// the current one doesn't satisfy that, and returns the current token if it can't acquire a new one and the
+// current token hasn't expired. A new token may not remain valid until a distant deadline, because the
+// authority determines token lifetimes.
+func (t *AutoRefreshingToken) Token(ctx context.Context) (AuthResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	validUntil := t.now().Add(minTokenValidity)
+	if deadline, ok := ctx.Deadline(); ok && deadline.After(validUntil) {
+		validUntil = deadline
+	}
+	if t.current.ExpiresOn.After(validUntil) {
+		return t.current, nil
+	}
+	ar, err := t.acquire(ctx)
+	if err != nil {
+		if t.current.ExpiresOn.After(t.now()) {
+			return t.current, nil
+		}
+		return AuthResult{}, err
+	}
+	t.current = ar
+	return ar, nil
 }
 
-// AcquireTokenByAuthCodeOptions contains the optional parameters used to acquire an access token using the authorization code flow.
-type AcquireTokenByAuthCodeOptions struct {
-	Challenge string
+// defaultMaxConcurrency is the default number of token requests AcquireTokens sends at once
+const defaultMaxConcurrency = 8
 
-	tenantID string
+// TokenRequest is a request for a token sent by AcquireTokens. Its options are those of AcquireTokenByCredential.
+type TokenRequest struct {
+	Scopes  []string
+	Options []AcquireByCredentialOption
 }
 
-// AcquireByAuthCodeOption is implemented by options for AcquireTokenByAuthCode
-type AcquireByAuthCodeOption interface {
-	acquireByAuthCodeOption()
+// TokenResult is the result of a TokenRequest. Err is nil when the request succeeded.
+type TokenResult struct {
+	AuthResult AuthResult
+	Err        error
 }
 
-// AcquireTokenByAuthCodeOption changes options inside AcquireTokenByAuthCodeOptions used in .AcquireTokenByAuthCode().
-type AcquireTokenByAuthCodeOption func(a *AcquireTokenByAuthCodeOptions)
+// acquireTokensOptions contains optional configuration for AcquireTokens
+type acquireTokensOptions struct {
+	maxConcurrency int
+}
 
-func (AcquireTokenByAuthCodeOption) acquireByAuthCodeOption() {}
+// AcquireTokensOption is implemented by options for AcquireTokens
+type AcquireTokensOption interface {
+	acquireTokensOption()
+}
 
-// WithChallenge allows you to provide a challenge for the .AcquireTokenByAuthCode() call.
-func WithChallenge(challenge string) interface {
-	AcquireByAuthCodeOption
+// WithMaxConcurrency sets the number of token requests AcquireTokens sends at once. The default is 8.
+func WithMaxConcurrency(n int) interface {
+	AcquireTokensOption
 	options.CallOption
 } {
 	return struct {
-		AcquireByAuthCodeOption
+		AcquireTokensOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
 			func(a any) error {
 				switch t := a.(type) {
-				case *AcquireTokenByAuthCodeOptions:
-					t.Challenge = challenge
+				case *acquireTokensOptions:
+					if n < 1 {
+						return fmt.Errorf("max concurrency must be at least 1, got %d", n)
+					}
+					t.maxConcurrency = n
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -592,68 +2223,138 @@ func WithChallenge(challenge string) interface {
 	}
 }
 
-// AcquireTokenByAuthCode is a request to acquire a security token from the authority, using an authorization code.
-// The specified redirect URI must be the same URI that was used when the authorization code was requested.
+// AcquireTokens acquires tokens for many requests, as AcquireTokenByCredential does for each. It's useful for
+// warming tokens for many resources at once, for example when an application starts. AcquireTokens resolves the
+// authority's endpoints once for each tenant, then sends token requests in parallel, reusing the client's HTTP
+// connections. It returns one result per request, in the order of requests. The returned error is non-nil only
+// when an option is invalid; failures of individual requests are reported in their results.
 //
 // Options:
-//   - [WithChallenge]
-//   - [WithTenantID]
-func (cca Client) AcquireTokenByAuthCode(ctx context.Context, code string, redirectURI string, scopes []string, opts ...AcquireByAuthCodeOption) (AuthResult, error) {
-	o := AcquireTokenByAuthCodeOptions{}
+//   - [WithMaxConcurrency]
+func (cca Client) AcquireTokens(ctx context.Context, requests []TokenRequest, opts ...AcquireTokensOption) ([]TokenResult, error) {
+	o := acquireTokensOptions{maxConcurrency: defaultMaxConcurrency}
 	if err := options.ApplyOptions(&o, opts); err != nil {
-		return AuthResult{}, err
+		return nil, err
+	}
+	results := make([]TokenResult, len(requests))
+	type job struct {
+		i          int
+		authParams authority.AuthParams
+		priority   RequestPriority
+		httpClient ops.HTTPClient
+	}
+	jobs := make([]job, 0, len(requests))
+	resolved := map[string]bool{}
+	for i, r := range requests {
+		ro := acquireTokenByCredentialOptions{}
+		if err := options.ApplyOptions(&ro, r.Options); err != nil {
+			results[i].Err = err
+			continue
+		}
+		authParams, err := cca.credentialAuthParams(r.Scopes, ro)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		// resolving endpoints before sending token requests ensures requests to the same tenant share one
+		// resolution. A failure here is reported by the token requests that need the endpoints.
+		if tenant := authParams.AuthorityInfo.Tenant; !resolved[tenant] && cca.cred.TokenProvider == nil {
+			resolved[tenant] = true
+			_, _ = cca.base.Token.ResolveEndpoints(ops.WithHTTPClient(ops.WithPriority(ctx, ro.priority), ro.httpClient), authParams.AuthorityInfo, "")
+		}
+		jobs = append(jobs, job{i: i, authParams: authParams, priority: ro.priority, httpClient: ro.httpClient})
 	}
 
-	params := base.AcquireTokenAuthCodeParameters{
-		Scopes:      scopes,
-		Code:        code,
-		Challenge:   o.Challenge,
-		AppType:     accesstokens.ATConfidential,
-		Credential:  cca.cred, // This setting differs from public.Client.AcquireTokenByAuthCode
-		RedirectURI: redirectURI,
-		TenantID:    o.tenantID,
+	work := make(chan job)
+	wg := sync.WaitGroup{}
+	for n := 0; n < o.maxConcurrency && n < len(jobs); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				ar, err := cca.acquireTokenByCredential(ops.WithHTTPClient(ops.WithPriority(ctx, j.priority), j.httpClient), j.authParams)
+				results[j.i] = TokenResult{AuthResult: ar, Err: err}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		work <- j
 	}
+	close(work)
+	wg.Wait()
+	return results, nil
+}
 
-	return cca.base.AcquireTokenByAuthCode(ctx, params)
+// DeviceCodeResult describes the code a user enters on a second device to authenticate.
+type DeviceCodeResult = accesstokens.DeviceCodeResult
+
+// DeviceCode provides the results of the device code flow's first stage (containing the code)
+// that must be entered on the second device and provides a method to retrieve the AuthenticationResult
+// once that code has been entered and verified.
+type DeviceCode struct {
+	// Result holds the information about the device code (such as the code).
+	Result DeviceCodeResult
+
+	authParams authority.AuthParams
+	client     Client
+	dc         oauth.DeviceCode
 }
 
-// acquireTokenByCredentialOptions contains optional configuration for AcquireTokenByCredential
-type acquireTokenByCredentialOptions struct {
-	tenantID string
+// AuthenticationResult retrieves the AuthenticationResult once the user enters the code
+// on the second device. Until then it blocks until ctx is done or the code expires.
+func (d DeviceCode) AuthenticationResult(ctx context.Context) (AuthResult, error) {
+	token, err := d.dc.Token(ctx)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return d.client.base.AuthResultFromToken(ctx, d.authParams, token, true)
 }
 
-// AcquireByCredentialOption is implemented by options for AcquireTokenByCredential
-type AcquireByCredentialOption interface {
-	acquireByCredOption()
+// acquireTokenByDeviceCodeOptions contains optional configuration for AcquireTokenByDeviceCode
+type acquireTokenByDeviceCodeOptions struct {
+	claims, tenantID string
 }
 
-// AcquireTokenByCredential acquires a security token from the authority, using the client credentials grant.
+// AcquireByDeviceCodeOption is implemented by options for AcquireTokenByDeviceCode
+type AcquireByDeviceCodeOption interface {
+	acquireByDeviceCodeOption()
+}
+
+// AcquireTokenByDeviceCode acquires a device code, which a user enters on a second device to authenticate. It
+// returns when the authority issues the code; [DeviceCode.AuthenticationResult] waits for the user and returns
+// the user's tokens. Unlike the public client's device code flow, the client authenticates its requests for the
+// user's tokens with its credential, so the application registration needn't allow public client flows. Use
+// [Client.AcquireTokenSilent] with the result's Account to get cached tokens for the user later.
 //
 // Options:
+//   - [WithClaims]
 //   - [WithTenantID]
-func (cca Client) AcquireTokenByCredential(ctx context.Context, scopes []string, opts ...AcquireByCredentialOption) (AuthResult, error) {
-	o := acquireTokenByCredentialOptions{}
-	err := options.ApplyOptions(&o, opts)
-	if err != nil {
-		return AuthResult{}, err
+func (cca Client) AcquireTokenByDeviceCode(ctx context.Context, scopes []string, opts ...AcquireByDeviceCodeOption) (DeviceCode, error) {
+	o := acquireTokenByDeviceCodeOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return DeviceCode{}, err
 	}
 	authParams, err := cca.base.AuthParams.WithTenant(o.tenantID)
 	if err != nil {
-		return AuthResult{}, err
+		return DeviceCode{}, err
 	}
 	authParams.Scopes = scopes
-	authParams.AuthorizationType = authority.ATClientCredentials
+	authParams.AuthorizationType = authority.ATDeviceCode
+	authParams.Claims = o.claims
 
-	token, err := cca.base.Token.Credential(ctx, authParams, cca.cred)
+	dc, err := cca.base.Token.DeviceCode(ctx, authParams, cca.cred)
 	if err != nil {
-		return AuthResult{}, err
+		return DeviceCode{}, err
 	}
-	return cca.base.AuthResultFromToken(ctx, authParams, token, true)
+	return DeviceCode{Result: dc.Result, authParams: authParams, client: cca, dc: dc}, nil
 }
 
 // acquireTokenOnBehalfOfOptions contains optional configuration for AcquireTokenOnBehalfOf
 type acquireTokenOnBehalfOfOptions struct {
-	tenantID string
+	claims, tenantID string
+	idTokenPolicy    IDTokenPolicy
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
 }
 
 // AcquireOnBehalfOfOption is implemented by options for AcquireTokenOnBehalfOf
@@ -665,28 +2366,462 @@ type AcquireOnBehalfOfOption interface {
 // Refer https://docs.microsoft.com/en-us/azure/active-directory/develop/v2-oauth2-on-behalf-of-flow.
 //
 // Options:
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRequestPriority]
 //   - [WithTenantID]
 func (cca Client) AcquireTokenOnBehalfOf(ctx context.Context, userAssertion string, scopes []string, opts ...AcquireOnBehalfOfOption) (AuthResult, error) {
 	o := acquireTokenOnBehalfOfOptions{}
 	if err := options.ApplyOptions(&o, opts); err != nil {
 		return AuthResult{}, err
 	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
 	params := base.AcquireTokenOnBehalfOfParameters{
 		Scopes:        scopes,
 		UserAssertion: userAssertion,
 		Credential:    cca.cred,
 		TenantID:      o.tenantID,
+		Claims:        o.claims,
+		IDTokenPolicy: o.idTokenPolicy,
 	}
 	return cca.base.AcquireTokenOnBehalfOf(ctx, params)
 }
 
+// These are the types of subject tokens Microsoft Entra ID exchanges. See [Client.AcquireTokenByTokenExchange].
+const (
+	// TokenTypeAccessToken is the type of an OAuth 2.0 access token
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	// TokenTypeIDToken is the type of an OpenID Connect ID token
+	TokenTypeIDToken = "urn:ietf:params:oauth:token-type:id_token"
+	// TokenTypeJWT is the type of a JWT, such as one issued by a service mesh's security token service
+	TokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// acquireTokenByTokenExchangeOptions contains optional configuration for AcquireTokenByTokenExchange
+type acquireTokenByTokenExchangeOptions struct {
+	claims, tenantID string
+}
+
+// AcquireByTokenExchangeOption is implemented by options for AcquireTokenByTokenExchange
+type AcquireByTokenExchangeOption interface {
+	acquireByTokenExchangeOption()
+}
+
+// AcquireTokenByTokenExchange exchanges subjectToken, a token of type subjectTokenType such as [TokenTypeJWT],
+// for an access token using the OAuth 2.0 token exchange grant (RFC 8693). This lets a service exchange a token
+// another security token service issued, for example a service mesh's, for a token from the client's authority.
+// The client authenticates the request with its credential. As with AcquireTokenOnBehalfOf, the client caches
+// the resulting tokens apart from other tokens, keyed by the subject token, and later calls with the same subject
+// token return a cached access token when one is valid.
+//
+// Options:
+//   - [WithClaims]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenByTokenExchange(ctx context.Context, subjectToken, subjectTokenType string, scopes []string, opts ...AcquireByTokenExchangeOption) (AuthResult, error) {
+	if subjectToken == "" {
+		return AuthResult{}, errors.New("subject token can't be empty")
+	}
+	if subjectTokenType == "" {
+		return AuthResult{}, errors.New("subject token type can't be empty")
+	}
+	o := acquireTokenByTokenExchangeOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	params := base.AcquireTokenByTokenExchangeParameters{
+		Scopes:           scopes,
+		Credential:       cca.cred,
+		TenantID:         o.tenantID,
+		SubjectToken:     subjectToken,
+		SubjectTokenType: subjectTokenType,
+		Claims:           o.claims,
+	}
+	return cca.base.AcquireTokenByTokenExchange(ctx, params)
+}
+
+// acquireTokenByRefreshTokenOptions contains optional configuration for AcquireTokenByRefreshToken
+type acquireTokenByRefreshTokenOptions struct {
+	claims, tenantID string
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
+}
+
+// AcquireByRefreshTokenOption is implemented by options for AcquireTokenByRefreshToken
+type AcquireByRefreshTokenOption interface {
+	acquireByRefreshTokenOption()
+}
+
+// AcquireTokenByRefreshToken redeems a refresh token the application obtained elsewhere, for example from a
+// component it brokers tokens for. As with AcquireTokenOnBehalfOf, the client caches the resulting tokens apart
+// from other users' tokens, keyed by the given refresh token. Later calls with the same refresh token return a
+// cached access token when one is valid, and otherwise redeem the latest refresh token the authority issued, so
+// applications needn't track refresh token rotation.
+//
+// Options:
+//   - [WithClaims]
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+func (cca Client) AcquireTokenByRefreshToken(ctx context.Context, refreshToken string, scopes []string, opts ...AcquireByRefreshTokenOption) (AuthResult, error) {
+	if refreshToken == "" {
+		return AuthResult{}, errors.New("refresh token can't be empty")
+	}
+	o := acquireTokenByRefreshTokenOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+	params := base.AcquireTokenByRefreshTokenParameters{
+		Scopes:       scopes,
+		RefreshToken: refreshToken,
+		Credential:   cca.cred,
+		TenantID:     o.tenantID,
+		Claims:       o.claims,
+	}
+	return cca.base.AcquireTokenByRefreshToken(ctx, params)
+}
+
+// warmupOptions contains optional configuration for Warmup
+type warmupOptions struct {
+	tenantID string
+}
+
+// WarmupOption is implemented by options for Warmup
+type WarmupOption interface {
+	warmupOption()
+}
+
+// Warmup fetches and caches metadata the client needs to acquire tokens, such as the authority's endpoints and
+// instance metadata. When scopes isn't empty, Warmup also ensures the cache has a token for them, acquiring one
+// by the client credentials grant when the cache has none. Calling Warmup when an application starts spares the
+// application's first token request the latency of fetching metadata and tokens.
+//
+// Options:
+//   - [WithTenantID]
+func (cca Client) Warmup(ctx context.Context, scopes []string, opts ...WarmupOption) error {
+	o := warmupOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return err
+	}
+	authParams, err := cca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return err
+	}
+	if err := cca.base.Warmup(ctx, authParams); err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	if _, err := cca.AcquireTokenSilent(ctx, scopes, WithTenantID(o.tenantID)); err == nil {
+		return nil
+	}
+	_, err = cca.AcquireTokenByCredential(ctx, scopes, WithTenantID(o.tenantID))
+	return err
+}
+
+// RateLimitStats counts the token requests admitted by a client's rate limiter.
+type RateLimitStats = base.RateLimitStats
+
+// RateLimitStats returns the number of token requests the client's rate limiter has allowed, delayed and rejected.
+// The counts are zero when the client has no rate limit. See [WithTokenRequestRateLimit].
+func (cca Client) RateLimitStats() RateLimitStats {
+	return cca.base.RateLimitStats()
+}
+
+// AuthorityMetadata returns the OpenID Connect metadata of the client's authority, such as its end_session_endpoint
+// and jwks_uri. The client caches this metadata and shares it with token requests, so calling this method
+// doesn't fetch the openid-configuration document again.
+func (cca Client) AuthorityMetadata(ctx context.Context) (AuthorityMetadata, error) {
+	return cca.base.AuthorityMetadata(ctx, cca.base.AuthParams)
+}
+
+// ResolvedEndpoints are the endpoints a client uses with its authority and the hosts of those endpoints.
+type ResolvedEndpoints = base.ResolvedEndpoints
+
+// resolvedEndpointsOptions contains optional configuration for ResolvedEndpoints
+type resolvedEndpointsOptions struct {
+	tenantID string
+}
+
+// ResolvedEndpointsOption is implemented by options for ResolvedEndpoints
+type ResolvedEndpointsOption interface {
+	resolvedEndpointsOption()
+}
+
+// ResolvedEndpoints returns the endpoints the client uses with its authority, after instance and tenant discovery
+// and with any endpoint overrides applied. Its Hosts field lists every host the client may send requests to, or
+// direct a browser to, for that authority, which helps applications provision firewall rules and egress proxies.
+// The client caches the endpoints, so calling this method discovers them only when the client hasn't already
+// done so.
+//
+// Options:
+//   - [WithTenantID]
+func (cca Client) ResolvedEndpoints(ctx context.Context, opts ...ResolvedEndpointsOption) (ResolvedEndpoints, error) {
+	o := resolvedEndpointsOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return ResolvedEndpoints{}, err
+	}
+	authParams, err := cca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return ResolvedEndpoints{}, err
+	}
+	return cca.base.ResolvedEndpoints(ctx, authParams)
+}
+
+// UserInfo gets claims about the account's user, such as name and email, from the authority's OpenID Connect userinfo
+// endpoint. It authenticates to that endpoint with a token acquired silently, as by AcquireTokenSilent, and so returns
+// an error when the cache has no token for the account and can't refresh one.
+func (cca Client) UserInfo(ctx context.Context, account Account) (UserInfo, error) {
+	return cca.base.UserInfo(ctx, base.AcquireTokenSilentParameters{
+		Account:     account,
+		RequestType: accesstokens.ATConfidential,
+		Credential:  cca.cred,
+	})
+}
+
 // Account gets the account in the token cache with the specified homeAccountID.
 func (cca Client) Account(homeAccountID string) Account {
 	return cca.base.Account(homeAccountID)
 }
 
+// AccountsByUsername returns the cached accounts whose PreferredUsername matches username, for example the username
+// a user typed to sign in. Usernames match when they're equal under Unicode case folding, so "JOSÉ@contoso.com"
+// matches "josé@contoso.com", after normalization by the function given to [WithUsernameNormalizer], if any. A user
+// has an account in each tenant in which the client has acquired a token for them.
+func (cca Client) AccountsByUsername(username string) []Account {
+	return cca.base.AccountsByUsername(username)
+}
+
 // RemoveAccount signs the account out and forgets account from token cache.
 func (cca Client) RemoveAccount(account Account) error {
 	cca.base.RemoveAccount(account)
 	return nil
 }
+
+// LogoutToken is a validated OpenID Connect back-channel logout token.
+type LogoutToken = base.LogoutToken
+
+// ValidateLogoutToken validates a logout_token a web app received from its authority in a back-channel logout
+// request. It verifies the token's signature with the authority's published signing keys, which the client caches,
+// and checks the token's issuer, audience, times and events claims as required by OpenID Connect Back-Channel Logout 1.0.
+// Applications should also reject a token whose ID they've seen before.
+func (cca Client) ValidateLogoutToken(ctx context.Context, logoutToken string) (LogoutToken, error) {
+	return cca.base.ValidateLogoutToken(ctx, logoutToken)
+}
+
+// RemoveAccountByLogoutToken validates logoutToken as ValidateLogoutToken does, then removes from the token
+// cache the accounts of the user or session the token logs out. When the token identifies the user's home
+// account, the client suggests that account's home account ID as the cache key, so an app partitioning its
+// cache by home account ID purges the right partition.
+func (cca Client) RemoveAccountByLogoutToken(ctx context.Context, logoutToken string) (LogoutToken, error) {
+	return cca.base.RemoveAccountByLogoutToken(ctx, logoutToken)
+}
+
+// CacheSnapshot describes the content of a client's cache. It contains no secrets.
+type CacheSnapshot = base.CacheSnapshot
+
+// CachedToken describes a cached token. It doesn't contain the token.
+type CachedToken = base.CachedToken
+
+// CacheSnapshot describes the accounts and tokens in the client's cache, without secrets, to help diagnose
+// why silent authentication fails, for example because the cache has no token for a tenant or scope.
+func (cca Client) CacheSnapshot(ctx context.Context) CacheSnapshot {
+	return cca.base.CacheSnapshot(ctx)
+}
+
+// CacheStats describes the size of a client's cache partitions. It contains no secrets.
+type CacheStats = base.CacheStats
+
+// PartitionStats describes the items in a cache partition.
+type PartitionStats = base.PartitionStats
+
+// CacheStats counts the accounts and tokens in each partition of the client's cache and estimates the memory
+// they occupy, to help operators of multi-tenant services detect cache bloat, for example from an admin endpoint.
+// A partition is the part of the cache a [cache.ExportReplace] stores under one key: the tokens of a user, of
+// the application in a tenant or of a user assertion given to [Client.AcquireTokenOnBehalfOf]. CacheStats
+// examines every cached item, so it takes time proportional to the size of the cache.
+func (cca Client) CacheStats(ctx context.Context) CacheStats {
+	return cca.base.CacheStats(ctx)
+}
+
+// CacheKeys are the keys under which the cache stores an account's tokens.
+type CacheKeys = base.CacheKeys
+
+// cacheKeysOptions contains options for CacheKeys
+type cacheKeysOptions struct {
+	tenantID string
+}
+
+// CacheKeysOption is implemented by options for CacheKeys
+type CacheKeysOption interface {
+	cacheKeysOption()
+}
+
+// CacheKeys returns the keys under which the client caches tokens for account and scopes. Operators can use
+// these to delete specific items from data stored by a [cache.ExportReplace], for example to revoke access
+// during an incident. A zero account specifies the application's own tokens,
+// which the client acquires with [Client.AcquireTokenByCredential]. The cache stores an access token under the scopes the authority granted, in the
+// order it returned them, so scopes must match that order.
+//
+// Options:
+//   - [WithTenantID]
+func (cca Client) CacheKeys(account Account, scopes []string, opts ...CacheKeysOption) (CacheKeys, error) {
+	o := cacheKeysOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return CacheKeys{}, err
+	}
+	return cca.base.CacheKeys(account, o.tenantID, scopes)
+}
+
+// certExpiryWarning is how long before its certificate expires Validate warns about a credential
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// CredentialDiagnostics describes the health of one credential. A credential chain has one for each credential
+// in the chain, in the order token requests try them.
+type CredentialDiagnostics struct {
+	// Type is the kind of credential: "secret", "certificate", "assertion" or "token provider".
+	Type string
+	// CertificateThumbprint is the SHA-1 thumbprint of a certificate credential's certificate, hex encoded.
+	CertificateThumbprint string
+	// CertificateExpiresOn is when a certificate credential's certificate expires.
+	CertificateExpiresOn time.Time
+	// Probed is true when Validate requested a token with the credential.
+	Probed bool
+	// Warnings describe problems that don't yet prevent the credential from authenticating, such as a
+	// certificate that expires soon.
+	Warnings []string
+	// Err is why the credential can't authenticate, or nil when it can.
+	Err error
+}
+
+// Diagnostics is the result of [Client.Validate].
+type Diagnostics struct {
+	// Credentials describes the client's credentials.
+	Credentials []CredentialDiagnostics
+	// ProbeScope is the scope Validate requested tokens for. It's empty when Validate sent no requests.
+	ProbeScope string
+}
+
+// Healthy returns true when at least one of the client's credentials can authenticate.
+func (d Diagnostics) Healthy() bool {
+	for _, c := range d.Credentials {
+		if c.Err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOptions contains optional configuration for Validate
+type validateOptions struct {
+	probeScope string
+	httpClient ops.HTTPClient
+}
+
+// ValidateOption is implemented by options for Validate
+type ValidateOption interface {
+	validateOption()
+}
+
+// WithProbeScope directs Validate to verify each credential by requesting a token for scope with the client
+// credentials grant, bypassing the cache. For a Microsoft Entra authority, scope must be the "/.default" scope
+// of a resource the application can access. Validate discards the tokens.
+func WithProbeScope(scope string) interface {
+	ValidateOption
+	options.CallOption
+} {
+	return struct {
+		ValidateOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *validateOptions:
+					t.probeScope = scope
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// Validate checks whether the client's credentials can authenticate, for example to implement a readiness
+// probe. It checks that a secret isn't empty and that a certificate is within its validity period. With
+// [WithProbeScope], it also requests a token with each credential, which is the only way to verify an
+// assertion or token provider, and detects credentials the authority has revoked. Validate returns an error
+// when no credential can authenticate, and the diagnostics of every credential in any case.
+//
+// Options:
+//   - [WithPerCallHTTPClient]
+//   - [WithProbeScope]
+func (cca Client) Validate(ctx context.Context, opts ...ValidateOption) (Diagnostics, error) {
+	o := validateOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return Diagnostics{}, err
+	}
+	var authParams authority.AuthParams
+	if o.probeScope != "" {
+		var err error
+		authParams, err = cca.credentialAuthParams([]string{o.probeScope}, acquireTokenByCredentialOptions{})
+		if err != nil {
+			return Diagnostics{}, err
+		}
+		ctx = ops.WithHTTPClient(ctx, o.httpClient)
+	}
+	creds := []*accesstokens.Credential{cca.cred}
+	if cca.cred.Chain != nil {
+		creds = cca.cred.Chain.Credentials()
+	}
+	d := Diagnostics{ProbeScope: o.probeScope, Credentials: make([]CredentialDiagnostics, len(creds))}
+	now := cca.base.AuthParams.ClientNow()
+	for i, cred := range creds {
+		cd := inspectCredential(cred, now)
+		if cd.Err == nil && o.probeScope != "" {
+			cd.Probed = true
+			// requesting a token with this credential alone, rather than the chain, verifies it independently
+			if _, err := cca.base.Token.Credential(ctx, authParams, cred); err != nil {
+				cd.Err = err
+			}
+		}
+		d.Credentials[i] = cd
+	}
+	if !d.Healthy() {
+		// the first credential is the one token requests prefer
+		c := d.Credentials[0]
+		return d, fmt.Errorf("no credential can authenticate: %s credential: %w", c.Type, c.Err)
+	}
+	return d, nil
+}
+
+// inspectCredential returns the diagnostics Validate determines for cred without sending requests
+func inspectCredential(cred *accesstokens.Credential, now time.Time) CredentialDiagnostics {
+	cd := CredentialDiagnostics{}
+	switch {
+	case cred.Cert != nil:
+		cd.Type = "certificate"
+		h := sha1.Sum(cred.Cert.Raw)
+		cd.CertificateThumbprint = hex.EncodeToString(h[:])
+		cd.CertificateExpiresOn = cred.Cert.NotAfter
+		switch {
+		case now.Before(cred.Cert.NotBefore):
+			cd.Err = fmt.Errorf("certificate isn't valid until %s", cred.Cert.NotBefore.Format(time.RFC3339))
+		case now.After(cred.Cert.NotAfter):
+			cd.Err = fmt.Errorf("certificate expired at %s", cred.Cert.NotAfter.Format(time.RFC3339))
+		case now.Add(certExpiryWarning).After(cred.Cert.NotAfter):
+			cd.Warnings = append(cd.Warnings, fmt.Sprintf("certificate expires at %s", cred.Cert.NotAfter.Format(time.RFC3339)))
+		}
+	case cred.AssertionCallback != nil:
+		cd.Type = "assertion"
+	case cred.TokenProvider != nil:
+		cd.Type = "token provider"
+	default:
+		cd.Type = "secret"
+		if cred.Secret == "" {
+			cd.Err = errors.New("secret is empty")
+		}
+	}
+	return cd
+}
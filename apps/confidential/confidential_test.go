@@ -6,20 +6,30 @@ package confidential
 import (
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/mock"
@@ -67,7 +77,7 @@ const (
 	refresh           = "fake_refresh"
 )
 
-var tokenScope = []string{"the_scope"}
+var tokenScope = []string{"https://resource/.default"}
 
 func fakeClient(tk accesstokens.TokenResponse, credential Credential, options ...Option) (Client, error) {
 	options = append(options, WithAuthority("https://fake_authority/fake"))
@@ -157,515 +167,3019 @@ func TestAcquireTokenByCredential(t *testing.T) {
 	}
 }
 
-func TestAcquireTokenByAssertionCallback(t *testing.T) {
-	calls := 0
-	key := struct{}{}
-	ctx := context.WithValue(context.Background(), key, true)
-	getAssertion := func(c context.Context, o AssertionRequestOptions) (string, error) {
-		if v := c.Value(key); v == nil || !v.(bool) {
-			t.Fatal("callback received unexpected context")
-		}
-		if o.ClientID != fakeClientID {
-			t.Fatalf(`unexpected client ID "%s"`, o.ClientID)
-		}
-		if o.TokenEndpoint != fakeTokenEndpoint {
-			t.Fatalf(`unexpected token endpoint "%s"`, o.TokenEndpoint)
-		}
-		calls++
-		if calls < 4 {
-			return "assertion", nil
-		}
-		return "", errors.New("expected error")
-	}
-	cred := NewCredFromAssertionCallback(getAssertion)
-	client, err := fakeClient(accesstokens.TokenResponse{}, cred)
+func TestCredentialScopes(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
 	if err != nil {
 		t.Fatal(err)
 	}
-	for i := 0; i < 3; i++ {
-		if calls != i {
-			t.Fatalf("expected %d calls, got %d", i, calls)
-		}
-		_, err = client.AcquireTokenByCredential(ctx, tokenScope)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}
-	_, err = client.AcquireTokenByCredential(ctx, tokenScope)
-	if err == nil || err.Error() != "expected error" {
-		t.Fatalf("expected an error from the callback, got %v", err)
+	for _, scopes := range [][]string{
+		nil,
+		{"User.Read"},
+		{"https://graph.microsoft.com/User.Read"},
+		{"/.default"},
+		{"https://graph.microsoft.com/.default", "https://vault.azure.net/.default"},
+	} {
+		t.Run(strings.Join(scopes, " "), func(t *testing.T) {
+			// the mock client panics if the client sends a request
+			client, err := New("client-id", cred, WithHTTPClient(&mock.Client{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var scopeErr msalerrors.ScopeError
+			_, err = client.AcquireTokenByCredential(context.Background(), scopes)
+			if !errors.As(err, &scopeErr) {
+				t.Fatalf("expected a ScopeError, got %v", err)
+			}
+			if strings.Join(scopeErr.Scopes, " ") != strings.Join(scopes, " ") {
+				t.Fatalf("expected scopes %v, got %v", scopes, scopeErr.Scopes)
+			}
+		})
 	}
 }
 
-func TestAcquireTokenByAuthCode(t *testing.T) {
-	cred, err := NewCredFromSecret("fake_secret")
+func TestPerCallHTTPClient(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
 	if err != nil {
 		t.Fatal(err)
 	}
-	client, err := fakeClient(accesstokens.TokenResponse{
-		AccessToken:   token,
-		RefreshToken:  refresh,
-		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(1 * time.Hour)},
-		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(1 * time.Hour)},
-		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
-		IDToken: accesstokens.IDToken{
-			PreferredUsername: "fakeuser@fakeplace.fake",
-			Name:              "fake person",
-			Oid:               "123-456",
-			TenantID:          "fake",
-			Subject:           "nothing",
-			Issuer:            "https://fake_authority/fake",
-			Audience:          "abc-123",
-			ExpirationTime:    time.Now().Add(time.Hour).Unix(),
-			IssuedAt:          time.Now().Add(-5 * time.Minute).Unix(),
-			NotBefore:         time.Now().Add(-5 * time.Minute).Unix(),
-			// NOTE: this is an invalid JWT however this doesn't cause a failure.
-			// it simply falls back to calling Token.Refresh() which will obviously succeed.
-			RawToken: "fake.raw.token",
-		},
-		ClientInfo: accesstokens.ClientInfo{
-			UID:  "123-456",
-			UTID: "fake",
-		},
-	}, cred)
+	// the client's own HTTP client panics if the client sends it a request
+	client, err := New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mock.Client{}))
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = client.AcquireTokenSilent(context.Background(), tokenScope)
-	// first attempt should fail
-	if err == nil {
-		t.Fatal("unexpected nil error from AcquireTokenSilent")
+	perCall := mock.Client{}
+	perCall.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	perCall.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)))
+	if _, err := client.AcquireTokenByCredential(context.Background(), tokenScope, WithPerCallHTTPClient(&perCall)); err != nil {
+		t.Fatal(err)
 	}
-	tk, err := client.AcquireTokenByAuthCode(context.Background(), "fake_auth_code", "fake_redirect_uri", tokenScope)
+
+	// AcquireTokens should resolve endpoints with a request's HTTP client too
+	client, err = New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mock.Client{}))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if tk.AccessToken != token {
-		t.Fatalf("unexpected access token %s", tk.AccessToken)
-	}
-	account := client.Account(tk.Account.HomeAccountID)
-	// second attempt should return the cached token
-	tk, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(account))
+	perCall.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	perCall.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)))
+	results, err := client.AcquireTokens(context.Background(), []TokenRequest{
+		{Scopes: tokenScope, Options: []AcquireByCredentialOption{WithPerCallHTTPClient(&perCall)}},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if tk.AccessToken != token {
-		t.Fatalf("unexpected access token %s", tk.AccessToken)
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
 	}
 }
 
-func TestAcquireTokenSilentTenants(t *testing.T) {
+func TestStaticEndpoints(t *testing.T) {
+	host, tenant := "login.enclave.example", "tenant"
+	tokenEndpoint := fmt.Sprintf("https://%s/%s/oauth2/v2.0/token", host, tenant)
 	cred, err := NewCredFromSecret("secret")
 	if err != nil {
 		t.Fatal(err)
 	}
-	tenants := []string{"a", "b"}
-	lmo := "login.microsoftonline.com"
+	// the mock client panics if the client sends any request other than the token request
 	mockClient := mock.Client{}
-	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenants[0])))
-	client, err := New("client-id", cred, WithHTTPClient(&mockClient))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if actual := r.URL.String(); actual != tokenEndpoint {
+				t.Errorf("expected a token request to %q, got %q", tokenEndpoint, actual)
+			}
+		}),
+	)
+	client, err := New("client-id", cred,
+		WithAuthority(fmt.Sprintf("https://%s/%s", host, tenant)),
+		WithHTTPClient(&mockClient),
+		WithStaticEndpoints(fmt.Sprintf("https://%s/%s/oauth2/v2.0/authorize", host, tenant), tokenEndpoint, fmt.Sprintf("https://%s/%s/v2.0", host, tenant)),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
-	ctx := context.Background()
-	// cache an access token for each tenant. To simplify determining their provenance below, the value of each token is the ID of the tenant that provided it.
-	for _, tenant := range tenants {
-		if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(tenant)); err == nil {
-			t.Fatal("silent auth should fail because the cache is empty")
-		}
-		mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
-		mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody(tenant, "", "", "", 3600)))
-		if _, err := client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID(tenant)); err != nil {
-			t.Fatal(err)
-		}
+	if _, err := client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
 	}
-	// cache should return the correct access token for each tenant
-	for _, tenant := range tenants {
-		ar, err := client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(tenant))
-		if err != nil {
-			t.Fatal(err)
+	if _, err := client.AcquireTokenSilent(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range [][]Option{
+		{WithStaticEndpoints("", "", "https://issuer")},
+		{WithStaticEndpoints("", "http://insecure/token", "")},
+		{WithStaticEndpoints("", tokenEndpoint, ""), WithAzureRegion("westus2")},
+	} {
+		if _, err := New("client-id", cred, opts...); err == nil {
+			t.Error("expected an error")
 		}
-		if ar.AccessToken != tenant {
-			t.Fatalf(`expected "%s", got "%s"`, tenant, ar.AccessToken)
+	}
+}
+
+func TestV1TokenEndpoint(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("v1", "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if expected := fmt.Sprintf("https://%s/%s/oauth2/token", lmo, tenant); r.URL.String() != expected {
+				t.Errorf("expected a request to %q, got %q", expected, r.URL.String())
+			}
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if actual := r.PostForm.Get("resource"); actual != "https://resource" {
+				t.Errorf(`expected resource "https://resource", got %q`, actual)
+			}
+			if actual := r.PostForm.Get("scope"); strings.Contains(actual, "https://resource") {
+				t.Errorf("expected no resource in the scope parameter, got %q", actual)
+			}
+		}),
+	)
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	client, err := New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient), WithV1TokenEndpoint(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "v1" {
+		t.Fatalf(`expected access token "v1", got %q`, ar.AccessToken)
+	}
+	// the cached v1 token should satisfy a later request for the same scopes
+	if ar, err = client.AcquireTokenSilent(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	} else if ar.AccessToken != "v1" {
+		t.Fatalf(`expected the cached access token "v1", got %q`, ar.AccessToken)
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single problem is returned as is
+	if _, err = New("client-id", cred, WithRedirectURIs("http://insecure")); err == nil {
+		t.Fatal("expected an error")
+	}
+	var optsErr msalerrors.OptionsError
+	if errors.As(err, &optsErr) {
+		t.Fatalf("expected no OptionsError for a single problem, got %v", err)
+	}
+	// New reports every problem, including a bad credential
+	_, err = New(" client-id", Credential{},
+		WithAuthority("https://login.microsoftonline.com"),
+		WithHTTPClient(nil),
+		WithCacheDisabled(true),
+		WithAccessor(&recordingAccessor{}),
+		WithRedirectURIs("http://insecure"),
+	)
+	if !errors.As(err, &optsErr) {
+		t.Fatalf("expected an OptionsError, got %v", err)
+	}
+	if actual := len(optsErr.Errs); actual != 6 {
+		t.Fatalf("expected 6 problems, got %d: %v", actual, err)
+	}
+	var uriErr msalerrors.RedirectURIError
+	if !errors.As(err, &uriErr) {
+		t.Errorf("expected the OptionsError to contain a RedirectURIError")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("this test requires the go command")
+	}
+	out, err := exec.Command("go", "list", "-deps", ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	// confidential clients shouldn't compile the code for interactive authentication
+	for _, pkg := range strings.Fields(string(out)) {
+		for _, forbidden := range []string{
+			"github.com/pkg/browser",
+			"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/local",
+			"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public",
+			"github.com/AzureAD/microsoft-authentication-library-for-go/apps/webview",
+		} {
+			if pkg == forbidden || strings.HasPrefix(pkg, forbidden+"/") {
+				t.Errorf("confidential depends on %s", pkg)
+			}
 		}
 	}
 }
 
-func TestInvalidCredential(t *testing.T) {
-	data, err := os.ReadFile("../testdata/test-cert.pem")
+func TestCredChain(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	expired, err := NewCredFromSecret("expired")
 	if err != nil {
 		t.Fatal(err)
 	}
-	certs, key, err := CertFromPEM(data, "")
+	valid, err := NewCredFromSecret("valid")
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, cred := range []Credential{
-		{},
-		NewCredFromAssertionCallback(nil),
-		NewCredFromCert(nil, nil),
-		NewCredFromCert(certs[0], nil),
-		NewCredFromCert(nil, key),
-	} {
-		t.Run("", func(t *testing.T) {
-			_, err := New("client-id", cred)
-			if err == nil {
-				t.Fatal("expected an error")
+	failingCallback := NewCredFromAssertionCallback(func(context.Context, AssertionRequestOptions) (string, error) {
+		return "", errors.New("no federated token")
+	})
+	cred, err := NewCredChain(failingCallback, expired, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	client, err := New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSecret := func(secret string) func(*http.Request) {
+		return func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
 			}
-		})
+			if actual := r.PostForm.Get("client_secret"); actual != secret {
+				t.Errorf("expected secret %q, got %q", secret, actual)
+			}
+		}
+	}
+	invalidClient := []byte(`{"error":"invalid_client","error_description":"AADSTS7000222: The provided client secret keys are expired."}`)
+
+	// the client should try each credential in order until the authority accepts one
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(invalidClient), mock.WithHTTPStatus(http.StatusUnauthorized), mock.WithCallback(expectSecret("expired")))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)), mock.WithCallback(expectSecret("valid")))
+	if _, err := client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...then begin with the one it accepted
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)), mock.WithCallback(expectSecret("valid")))
+	if _, err := client.AcquireTokenByCredential(context.Background(), []string{"https://other/.default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// errors unrelated to the credential shouldn't cause the client to try another
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"error":"invalid_scope"}`)), mock.WithHTTPStatus(http.StatusBadRequest), mock.WithCallback(expectSecret("valid")))
+	if _, err := client.AcquireTokenByCredential(context.Background(), []string{"https://invalid/.default"}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// nor should errors the client returns before sending a request
+	client, err = New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithAllowedScopes(tokenScope...), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policyErr msalerrors.PolicyError
+	if _, err := client.AcquireTokenByCredential(context.Background(), []string{"https://not-allowed/.default"}); !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PolicyError, got %v", err)
+	}
+	if policyErr.Scope != "https://not-allowed/.default" {
+		t.Fatalf(`expected scope "https://not-allowed/.default", got %q`, policyErr.Scope)
+	}
+
+	if _, err := NewCredChain(); err == nil {
+		t.Fatal("expected an error for an empty chain")
+	}
+	if _, err := NewCredChain(valid, cred); err == nil {
+		t.Fatal("expected an error for a nested chain")
 	}
 }
 
-func TestNewCredFromCertChain(t *testing.T) {
-	for _, file := range []struct {
-		path     string
-		numCerts int
+func TestErrorCodes(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	for _, test := range []struct {
+		code      int
+		predicate func(error) bool
 	}{
-		{"../testdata/test-cert.pem", 1},
-		{"../testdata/test-cert-chain.pem", 2},
-		{"../testdata/test-cert-chain-reverse.pem", 2},
+		{msalerrors.CodeInvalidClientSecret, msalerrors.IsInvalidClientSecret},
+		{msalerrors.CodeExpiredClientSecret, msalerrors.IsExpiredSecret},
+		{msalerrors.CodeConditionalAccessBlocked, msalerrors.IsConditionalAccessBlocked},
+		{msalerrors.CodeMFARequired, msalerrors.IsMFARequired},
 	} {
-		f, err := os.Open(filepath.Clean(file.path))
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer f.Close()
-		pemData, err := io.ReadAll(f)
-		if err != nil {
-			t.Fatal(err)
-		}
-		certs, key, err := CertFromPEM(pemData, "")
-		if err != nil {
-			t.Fatal(err)
-		}
-		if len(certs) != file.numCerts {
-			t.Fatalf("expected %d certs, got %d", file.numCerts, len(certs))
+		body := fmt.Sprintf(`{"error":"invalid_grant","error_description":"AADSTS%d: description","error_codes":[%d]}`, test.code, test.code)
+		mockClient.AppendResponse(mock.WithBody([]byte(body)), mock.WithHTTPStatus(http.StatusBadRequest))
+		_, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
+		if err == nil {
+			t.Fatal("expected an error")
 		}
-		expectedCerts := make(map[string]struct{}, len(certs))
-		for _, cert := range certs {
-			expectedCerts[base64.StdEncoding.EncodeToString(cert.Raw)] = struct{}{}
+		if !test.predicate(err) {
+			t.Errorf("predicate for %d returned false", test.code)
 		}
-		k, ok := key.(*rsa.PrivateKey)
-		if !ok {
-			t.Fatal("expected an RSA private key")
+		if codes := msalerrors.ErrorCodes(fmt.Errorf("wrapped: %w", err)); len(codes) != 1 || codes[0] != test.code {
+			t.Errorf("expected error codes [%d], got %v", test.code, codes)
 		}
-		verifyingKey := &k.PublicKey
-		cred, err := NewCredFromCertChain(certs, key)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, sendX5c := range []bool{false, true} {
-			opts := []Option{}
-			if sendX5c {
-				opts = append(opts, WithX5C())
-			}
-			t.Run(fmt.Sprintf("%s/%v", filepath.Base(file.path), sendX5c), func(t *testing.T) {
-				client, err := fakeClient(accesstokens.TokenResponse{
-					AccessToken:   token,
-					ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
-					GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
-				}, cred, opts...)
-				if err != nil {
-					t.Fatal(err)
-				}
-				// the test fake passes assertions generated by the credential to this function
-				validated := false
-				client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
-					validated = true
-					tk, err := jwt.Parse(s, func(tk *jwt.Token) (interface{}, error) {
-						if signingMethod, ok := tk.Method.(*jwt.SigningMethodRSA); !ok {
-							t.Fatalf("unexpected signing method %T", signingMethod)
-						}
-						return verifyingKey, nil
-					})
-					if err != nil {
-						t.Fatal(err)
-					}
-					if err = tk.Claims.Valid(); err != nil {
-						t.Fatal(err)
-					}
-					// x5c header should be set iff the sendX5c is true
-					if x5c, ok := tk.Header["x5c"]; ok != sendX5c {
-						t.Fatal("x5c should be set only when application passed WithX5C option")
-					} else if ok {
-						if x := len(x5c.([]interface{})); x > file.numCerts {
-							t.Fatalf("x5c contains %d certs; expected %d", x, file.numCerts)
-						}
-						// x5c must contain all the file's certs, signing cert first
-						for i, cert := range x5c.([]interface{}) {
-							s := cert.(string)
-							if _, ok := expectedCerts[s]; ok {
-								delete(expectedCerts, s)
-							} else {
-								t.Fatal("x5c contains an unexpected cert")
-							}
-							if i == 0 {
-								decoded, err := base64.StdEncoding.DecodeString(s)
-								if err != nil {
-									t.Fatal(err)
-								}
-								parsed, err := x509.ParseCertificate(decoded)
-								if err != nil {
-									t.Fatal(err)
-								}
-								if !verifyingKey.Equal(parsed.PublicKey) {
-									t.Fatal("signing cert must appear first in x5c")
-								}
-							}
-						}
-						if len(expectedCerts) > 0 {
-							t.Fatal("x5c header is missing a cert")
-						}
-					}
-				}
-				tk, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
-				if err != nil {
-					t.Fatal(err)
-				}
-				if tk.AccessToken != token {
-					t.Fatalf("unexpected access token %s", tk.AccessToken)
-				}
-				if !validated {
-					t.Fatal("assertion validation function wasn't called")
-				}
-			})
+		// calling a predicate shouldn't consume the response body
+		if !test.predicate(err) {
+			t.Errorf("predicate for %d returned false on the second call", test.code)
 		}
 	}
+	if msalerrors.IsMFARequired(errors.New("AADSTS50076")) {
+		t.Error("predicate matched an error that isn't from the authority")
+	}
 }
 
-func TestNewCredFromCertChainError(t *testing.T) {
-	data, err := os.ReadFile("../testdata/test-cert.pem")
+func TestIDTokenPolicy(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
 	if err != nil {
 		t.Fatal(err)
 	}
-	certs, key, err := CertFromPEM(data, "")
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+
+	// the client credentials grant returns no ID token
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", "", "", "", 3600)))
+	if _, err := client.AcquireTokenByCredential(ctx, tokenScope, WithIDTokenPolicy(IDTokenForbidden)); err != nil {
+		t.Fatal(err)
+	}
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", "", "", "", 3600)))
+	if _, err := client.AcquireTokenByCredential(ctx, []string{"https://other/.default"}, WithIDTokenPolicy(IDTokenRequired)); err == nil {
+		t.Fatal("expected an error for a response without an ID token")
+	}
+	// the client shouldn't cache the rejected response's token
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	if _, err := client.AcquireTokenSilent(ctx, []string{"https://other/.default"}); err == nil {
+		t.Fatal("expected no cached token for the rejected response")
+	}
+
+	idToken := mock.GetIDToken(tenant, "issuer")
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", idToken, "rt", "", 3600)))
+	if _, err := client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", []string{"user"}, WithIDTokenPolicy(IDTokenForbidden)); err == nil {
+		t.Fatal("expected an error for a response having an ID token")
+	}
+	// a response having an ID token but no client info identifies no account, so the client shouldn't cache one
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", idToken, "rt", "", 3600)))
+	ar, err := client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", []string{"user"}, WithIDTokenPolicy(IDTokenRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.IDToken.IsZero() {
+		t.Fatal("expected the result to have the ID token")
+	}
+	if accounts := client.CacheSnapshot(ctx).Accounts; len(accounts) != 0 {
+		t.Fatalf("expected no cached accounts, got %v", accounts)
+	}
+}
+
+// popScheme is an AuthenticationScheme for proof-of-possession tokens
+type popScheme struct{}
+
+func (popScheme) TokenRequestParams() map[string]string {
+	return map[string]string{"token_type": "pop", "req_cnf": "key"}
+}
+
+func (popScheme) KeyID() string {
+	return "key"
+}
+
+func (popScheme) FormatAccessToken(accessToken string) (string, error) {
+	return "signed " + accessToken, nil
+}
+
+func (popScheme) AccessTokenType() string {
+	return "pop"
+}
+
+func TestAuthenticationScheme(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	popBody := func(at, tokenType string) []byte {
+		return []byte(fmt.Sprintf(`{"access_token":%q,"expires_in":3600,"token_type":%q}`, at, tokenType))
+	}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("bearer", "", "", "", 3600)))
+	ar, err := client.AcquireTokenByCredential(ctx, tokenScope)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if ar.TokenType != "Bearer" {
+		t.Fatalf(`expected token type "Bearer", got %q`, ar.TokenType)
+	}
+	mockClient.AppendResponse(mock.WithBody(popBody("pop", "pop")))
+	ar, err = client.AcquireTokenByCredential(ctx, tokenScope, WithAuthenticationScheme(popScheme{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "signed pop" || ar.TokenType != "pop" {
+		t.Fatalf(`expected "signed pop" of type "pop", got %q of type %q`, ar.AccessToken, ar.TokenType)
+	}
+
+	// the cache should return only tokens of the requested type
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
 	for _, test := range []struct {
-		certs []*x509.Certificate
-		key   crypto.PrivateKey
+		opts              []AcquireSilentOption
+		expected, tokType string
 	}{
-		{nil, nil},
-		{certs, nil},
-		{nil, key},
-		{[]*x509.Certificate{}, nil},
-		{[]*x509.Certificate{}, key},
-		{[]*x509.Certificate{nil}, nil},
-		{[]*x509.Certificate{nil}, key},
+		{nil, "bearer", "Bearer"},
+		{[]AcquireSilentOption{WithAuthenticationScheme(popScheme{})}, "signed pop", "pop"},
 	} {
-		t.Run("", func(t *testing.T) {
-			_, err := NewCredFromCertChain(test.certs, test.key)
-			if err == nil {
-				t.Fatal("expected an error")
-			}
-		})
+		ar, err := client.AcquireTokenSilent(ctx, tokenScope, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ar.AccessToken != test.expected || ar.TokenType != test.tokType {
+			t.Fatalf("expected %q of type %q, got %q of type %q", test.expected, test.tokType, ar.AccessToken, ar.TokenType)
+		}
+	}
+
+	// a response having a token of another type is an error
+	mockClient.AppendResponse(mock.WithBody(popBody("bearer", "Bearer")))
+	if _, err := client.AcquireTokenByCredential(ctx, []string{"https://other/.default"}, WithAuthenticationScheme(popScheme{})); err == nil {
+		t.Fatal("expected an error for a Bearer token")
 	}
 }
 
-func TestNewCredFromTokenProvider(t *testing.T) {
-	expectedToken := "expected token"
-	called := false
-	expiresIn := 4200
-	key := struct{}{}
-	ctx := context.WithValue(context.Background(), key, true)
-	cred := NewCredFromTokenProvider(func(c context.Context, tp exported.TokenProviderParameters) (exported.TokenProviderResult, error) {
-		if called {
-			t.Fatal("expected exactly one token provider invocation")
+func TestDPoP(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheme, err := NewDPoP(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofClaims := func(proof string) map[string]interface{} {
+		parts := strings.Split(proof, ".")
+		if len(parts) != 3 {
+			t.Fatalf("expected a JWT, got %q", proof)
 		}
-		called = true
-		if v := c.Value(key); v == nil || !v.(bool) {
-			t.Fatal("callback received unexpected context")
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Fatal(err)
 		}
-		if tp.CorrelationID == "" {
-			t.Fatal("expected CorrelationID")
+		claims := map[string]interface{}{}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			t.Fatal(err)
 		}
-		if v := fmt.Sprint(tp.Scopes); v != fmt.Sprint(tokenScope) {
-			t.Fatalf(`unexpected scopes "%v"`, v)
+		return claims
+	}
+	var nonces []interface{}
+	recordNonce := mock.WithCallback(func(r *http.Request) {
+		claims := proofClaims(r.Header.Get("DPoP"))
+		if claims["htm"] != http.MethodPost || claims["htu"] != r.URL.String() {
+			t.Errorf("unexpected htm %v or htu %v", claims["htm"], claims["htu"])
 		}
-		return exported.TokenProviderResult{
-			AccessToken:      expectedToken,
-			ExpiresInSeconds: expiresIn,
-		}, nil
+		nonces = append(nonces, claims["nonce"])
 	})
-	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	nonceHeader := http.Header{}
+	nonceHeader.Set("DPoP-Nonce", "nonce")
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	// the authority requires a nonce the first proof lacks
+	mockClient.AppendResponse(
+		mock.WithHTTPStatus(http.StatusBadRequest),
+		mock.WithHTTPHeader(nonceHeader),
+		mock.WithBody([]byte(`{"error":"use_dpop_nonce"}`)),
+		recordNonce,
+	)
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"access_token":"at","expires_in":3600,"token_type":"DPoP"}`)), recordNonce)
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
 	if err != nil {
 		t.Fatal(err)
 	}
-	ar, err := client.AcquireTokenByCredential(ctx, tokenScope)
+	ar, err := client.AcquireTokenByCredential(context.Background(), tokenScope, WithAuthenticationScheme(scheme))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !called {
-		t.Fatal("token provider wasn't invoked")
+	if ar.AccessToken != "at" || ar.TokenType != "DPoP" {
+		t.Fatalf(`expected "at" of type "DPoP", got %q of type %q`, ar.AccessToken, ar.TokenType)
 	}
-	if v := int(time.Until(ar.ExpiresOn).Seconds()); v < expiresIn-2 || v > expiresIn {
-		t.Fatalf("expected ExpiresOn ~= %d seconds, got %d", expiresIn, v)
+	if len(nonces) != 2 || nonces[0] != nil || nonces[1] != "nonce" {
+		t.Fatalf("expected a proof without a nonce and then one with the authority's nonce, got %v", nonces)
 	}
-	if ar.AccessToken != expectedToken {
-		t.Fatalf(`unexpected token "%s"`, ar.AccessToken)
+
+	proof, err := scheme.Proof(http.MethodGet, "https://resource/api?q=1", ar.AccessToken)
+	if err != nil {
+		t.Fatal(err)
 	}
-	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope)
+	claims := proofClaims(proof)
+	h := sha256.Sum256([]byte(ar.AccessToken))
+	if claims["htu"] != "https://resource/api" || claims["ath"] != base64.RawURLEncoding.EncodeToString(h[:]) || claims["nonce"] != nil {
+		t.Fatalf("unexpected resource proof claims %v", claims)
+	}
+}
+
+func TestAcquireTokenByDeviceCode(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	cred, err := NewCredFromSecret("secret")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if ar.AccessToken != expectedToken {
-		t.Fatalf(`unexpected token "%s"`, ar.AccessToken)
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"device_code":"device code","user_code":"user code","expires_in":600}`)))
+	dc, err := client.AcquireTokenByDeviceCode(ctx, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Result.UserCode != "user code" {
+		t.Fatalf(`expected user code "user code", got %q`, dc.Result.UserCode)
+	}
+	// the client should authenticate its token request with its credential
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody(token, mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if actual := r.FormValue("device_code"); actual != "device code" {
+				t.Errorf(`expected device code "device code", got %q`, actual)
+			}
+			if actual := r.FormValue("client_secret"); actual != "secret" {
+				t.Errorf(`expected client secret "secret", got %q`, actual)
+			}
+		}),
+	)
+	ar, err := dc.AuthenticationResult(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != token {
+		t.Fatalf("expected %q, got %q", token, ar.AccessToken)
+	}
+	// silent authentication begins with instance discovery
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	if ar, err = client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(ar.Account)); err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != token {
+		t.Fatalf("expected the cached access token %q, got %q", token, ar.AccessToken)
 	}
 }
 
-func TestNewCredFromTokenProviderError(t *testing.T) {
-	expectedError := "something went wrong"
-	cred := NewCredFromTokenProvider(func(ctx context.Context, tpp exported.TokenProviderParameters) (exported.TokenProviderResult, error) {
-		return exported.TokenProviderResult{}, errors.New(expectedError)
-	})
-	client, err := New("client-id", cred)
+func TestAcquireTokenByDeviceCodeCredChain(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	data, err := os.ReadFile("../testdata/test-cert.pem")
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = client.AcquireTokenByCredential(context.Background(), tokenScope)
-	if err == nil || !strings.Contains(err.Error(), expectedError) {
-		t.Fatalf(`unexpected error "%v"`, err)
+	certs, key, err := CertFromPEM(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := NewCredFromSecret("expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := NewCredChain(secret, NewCredFromCert(certs[0], key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"device_code":"device code","user_code":"user code","expires_in":600,"interval":1}`)))
+	dc, err := client.AcquireTokenByDeviceCode(ctx, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the authority rejects the secret, so the client should try the certificate
+	mockClient.AppendResponse(
+		mock.WithBody([]byte(`{"error":"invalid_client","error_description":"AADSTS7000222: The provided client secret keys are expired."}`)),
+		mock.WithHTTPStatus(http.StatusUnauthorized),
+	)
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if r.FormValue("client_assertion") == "" {
+				t.Error("expected a client assertion")
+			}
+		}),
+	)
+	ar, err := dc.AuthenticationResult(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != token {
+		t.Fatalf("expected %q, got %q", token, ar.AccessToken)
 	}
 }
 
-func TestWithTenantID(t *testing.T) {
-	accessToken := "*"
-	uuid1 := "00000000-0000-0000-0000-000000000000"
-	uuid2 := strings.ReplaceAll(uuid1, "0", "1")
-	lmo := "login.microsoftonline.com"
-	host := fmt.Sprintf("https://%s/", lmo)
-	for _, test := range []struct {
-		authority, expectedAuthority, tenant string
-		expectError                          bool
-	}{
-		{authority: host + "common", tenant: uuid1, expectedAuthority: host + uuid1},
-		{authority: host + "organizations", tenant: uuid1, expectedAuthority: host + uuid1},
-		{authority: host + uuid1, tenant: uuid2, expectedAuthority: host + uuid2},
-		{authority: host + uuid1, tenant: "common", expectError: true},
-		{authority: host + uuid1, tenant: "organizations", expectError: true},
-		{authority: host + "consumers", tenant: uuid1, expectError: true},
-	} {
-		for _, method := range []string{"authcode", "authcodeURL", "credential", "obo"} {
-			t.Run(method, func(t *testing.T) {
-				cred, err := NewCredFromSecret("secret")
-				if err != nil {
-					t.Fatal(err)
-				}
-				idToken, refreshToken, URL := "", "", ""
-				mockClient := mock.Client{}
-				if method == "obo" {
-					idToken = mock.GetIDToken(test.tenant, test.authority)
-					refreshToken = "refresh-token"
-					// TODO: OBO does instance discovery twice before first token request https://github.com/AzureAD/microsoft-authentication-library-for-go/issues/351
-					mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, test.tenant)))
-				}
-				mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, test.tenant)))
-				mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, test.tenant)))
+func TestAutoRefreshingToken(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("first", "", "", "", 3600)))
+	art, err := client.AutoRefreshingToken(ctx, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	art.now = func() time.Time { return now }
+	expectToken := func(ctx context.Context, expected string) {
+		t.Helper()
+		ar, err := art.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ar.AccessToken != expected {
+			t.Fatalf("expected %q, got %q", expected, ar.AccessToken)
+		}
+	}
+
+	// the first token is valid long enough, unless ctx's deadline is after it expires
+	expectToken(ctx, "first")
+	deadline, cancel := context.WithDeadline(ctx, now.Add(2*time.Hour))
+	defer cancel()
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("second", "", "", "", 3600)))
+	expectToken(deadline, "second")
+
+	// the handle should acquire a new token shortly before the current one expires...
+	now = now.Add(58 * time.Minute)
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("third", "", "", "", 3600)))
+	expectToken(ctx, "third")
+
+	// ...and return the current token when it can't, provided that token hasn't expired. All
+	// tokens expire an hour after the test began because the fake clock doesn't affect them.
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"error":"temporarily_unavailable"}`)), mock.WithHTTPStatus(http.StatusBadRequest))
+	expectToken(ctx, "third")
+	now = now.Add(3 * time.Minute)
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"error":"temporarily_unavailable"}`)), mock.WithHTTPStatus(http.StatusBadRequest))
+	if _, err := art.Token(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAcquireTokenByAssertionCallback(t *testing.T) {
+	calls := 0
+	key := struct{}{}
+	ctx := context.WithValue(context.Background(), key, true)
+	getAssertion := func(c context.Context, o AssertionRequestOptions) (string, error) {
+		if v := c.Value(key); v == nil || !v.(bool) {
+			t.Fatal("callback received unexpected context")
+		}
+		if o.ClientID != fakeClientID {
+			t.Fatalf(`unexpected client ID "%s"`, o.ClientID)
+		}
+		if o.TokenEndpoint != fakeTokenEndpoint {
+			t.Fatalf(`unexpected token endpoint "%s"`, o.TokenEndpoint)
+		}
+		calls++
+		if calls < 4 {
+			return "assertion", nil
+		}
+		return "", errors.New("expected error")
+	}
+	cred := NewCredFromAssertionCallback(getAssertion)
+	client, err := fakeClient(accesstokens.TokenResponse{}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if calls != i {
+			t.Fatalf("expected %d calls, got %d", i, calls)
+		}
+		_, err = client.AcquireTokenByCredential(ctx, tokenScope)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = client.AcquireTokenByCredential(ctx, tokenScope)
+	if err == nil || err.Error() != "expected error" {
+		t.Fatalf("expected an error from the callback, got %v", err)
+	}
+}
+
+func TestAcquireTokenByAuthCodeFlow(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	idToken := func(nonce string) string {
+		payload := fmt.Sprintf(`{"aud":"%s","iss":"issuer","nonce":"%s","oid":"uid","tid":"utid"}`, fakeClientID, nonce)
+		return "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	flow, err := NewFlowState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := client.AuthCodeURL(context.Background(), fakeClientID, "https://localhost", tokenScope, WithFlowState(flow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256([]byte(flow.CodeVerifier))
+	for k, v := range map[string]string{
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(h[:]),
+		"code_challenge_method": "S256",
+		"nonce":                 flow.Nonce,
+		"state":                 flow.State,
+	} {
+		if actual := parsed.Query().Get(k); actual != v {
+			t.Errorf("expected %s %q, got %q", k, v, actual)
+		}
+	}
+
+	// the client shouldn't send a token request for a redirect whose state doesn't match
+	// the flow's or that reports an error; the mock panics if it does
+	for _, redirect := range []url.Values{
+		{"code": {"code"}},
+		{"code": {"code"}, "state": {"forged"}},
+		{"error": {"access_denied"}, "state": {flow.State}},
+		{"state": {flow.State}},
+	} {
+		if _, err = client.AcquireTokenByAuthCodeFlow(context.Background(), flow, redirect, "https://localhost", tokenScope); err == nil {
+			t.Errorf("expected an error for redirect %v", redirect)
+		}
+	}
+
+	redirect := url.Values{"code": {"code"}, "state": {flow.State}}
+	// the client shouldn't cache a token whose ID token doesn't have the flow's nonce
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", idToken("other"), "rt", clientInfo, 3600)))
+	if _, err = client.AcquireTokenByAuthCodeFlow(context.Background(), flow, redirect, "https://localhost", tokenScope); err == nil {
+		t.Fatal("expected an error for a mismatched nonce")
+	}
+	if snapshot := client.CacheSnapshot(context.Background()); len(snapshot.Tokens) != 0 {
+		t.Fatalf("expected no cached tokens, got %+v", snapshot.Tokens)
+	}
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("at", idToken(flow.Nonce), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			if actual := r.PostForm.Get("code_verifier"); actual != flow.CodeVerifier {
+				t.Errorf("expected code_verifier %q, got %q", flow.CodeVerifier, actual)
+			}
+		}),
+	)
+	ar, err := client.AcquireTokenByAuthCodeFlow(context.Background(), flow, redirect, "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" || ar.IDToken.Nonce != flow.Nonce {
+		t.Fatalf("unexpected result %+v", ar)
+	}
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	parEndpoint := fmt.Sprintf("https://%s/%s/oauth2/v2.0/par", lmo, tenant)
+	requestURI := "urn:ietf:params:oauth:request_uri:abc"
+	for _, advertised := range []bool{true, false} {
+		t.Run(fmt.Sprint(advertised), func(t *testing.T) {
+			discovery := mock.GetTenantDiscoveryBody(lmo, tenant)
+			if advertised {
+				discovery = []byte(strings.Replace(string(discovery), "{", fmt.Sprintf(`{"pushed_authorization_request_endpoint": %q,`, parEndpoint), 1))
+			}
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(discovery))
+			if advertised {
 				mockClient.AppendResponse(
-					mock.WithBody(mock.GetAccessTokenBody(accessToken, idToken, refreshToken, "", 3600)),
-					mock.WithCallback(func(r *http.Request) { URL = r.URL.String() }),
+					mock.WithHTTPStatus(http.StatusCreated),
+					mock.WithBody([]byte(fmt.Sprintf(`{"request_uri":%q,"expires_in":60}`, requestURI))),
+					mock.WithCallback(func(r *http.Request) {
+						if r.URL.String() != parEndpoint {
+							t.Errorf("expected a request to %s, got %s", parEndpoint, r.URL)
+						}
+						if err := r.ParseForm(); err != nil {
+							t.Fatal(err)
+						}
+						for k, v := range map[string]string{
+							"client_secret": "secret",
+							"redirect_uri":  "https://localhost",
+							"login_hint":    "user",
+							"response_type": "code",
+						} {
+							if actual := r.PostForm.Get(k); actual != v {
+								t.Errorf("expected %s %q, got %q", k, v, actual)
+							}
+						}
+					}),
 				)
-				client, err := New("client-id", cred, WithAuthority(test.authority), WithHTTPClient(&mockClient))
-				if err != nil {
-					t.Fatal(err)
-				}
-				ctx := context.Background()
-				if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(test.tenant)); err == nil {
-					t.Fatal("silent auth should fail because the cache is empty")
-				}
-				var ar AuthResult
-				switch method {
-				case "authcode":
-					ar, err = client.AcquireTokenByAuthCode(ctx, "auth code", "https://localhost", tokenScope, WithTenantID(test.tenant))
-				case "authcodeURL":
-					URL, err = client.AuthCodeURL(ctx, "client-id", "https://localhost", tokenScope, WithTenantID(test.tenant))
-				case "credential":
-					ar, err = client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID(test.tenant))
-				case "obo":
-					ar, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID(test.tenant))
-				default:
-					t.Fatalf("no test for " + method)
-				}
-				if err != nil {
-					if test.expectError {
-						return
-					}
-					t.Fatal(err)
-				} else if test.expectError {
-					t.Fatal("expected an error")
-				}
-				if !strings.HasPrefix(URL, test.expectedAuthority) {
-					t.Fatalf(`expected "%s", got "%s"`, test.expectedAuthority, URL)
-				}
-				if method == "authcodeURL" {
-					// didn't acquire a token, no need to test silent auth
-					return
-				}
-				if ar.AccessToken != accessToken {
-					t.Fatalf(`unexpected access token "%s"`, ar.AccessToken)
+			}
+			client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := client.AuthCodeURL(context.Background(), fakeClientID, "https://localhost", tokenScope, WithLoginHint("user"), WithPushedAuthorizationRequest())
+			if !advertised {
+				if err == nil {
+					t.Fatal("expected an error because the authority doesn't advertise a pushed authorization request endpoint")
 				}
-				// silent authentication should now succeed for the given tenant...
-				if method == "obo" {
-					if ar, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID(test.tenant)); err != nil {
-						t.Fatal(err)
-					}
-				} else if ar, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(test.tenant)); err != nil {
-					t.Fatal(err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := url.Parse(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := url.Values{"client_id": {fakeClientID}, "request_uri": {requestURI}}
+			if q := parsed.Query(); q.Encode() != expected.Encode() {
+				t.Fatalf("expected query %q, got %q", expected.Encode(), q.Encode())
+			}
+		})
+	}
+}
+
+func TestAcquireTokenByAuthCode(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		RefreshToken:  refresh,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(1 * time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(1 * time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+		IDToken: accesstokens.IDToken{
+			PreferredUsername: "fakeuser@fakeplace.fake",
+			Name:              "fake person",
+			Oid:               "123-456",
+			TenantID:          "fake",
+			Subject:           "nothing",
+			Issuer:            "https://fake_authority/fake",
+			Audience:          "abc-123",
+			ExpirationTime:    time.Now().Add(time.Hour).Unix(),
+			IssuedAt:          time.Now().Add(-5 * time.Minute).Unix(),
+			NotBefore:         time.Now().Add(-5 * time.Minute).Unix(),
+			// NOTE: this is an invalid JWT however this doesn't cause a failure.
+			// it simply falls back to calling Token.Refresh() which will obviously succeed.
+			RawToken: "fake.raw.token",
+		},
+		ClientInfo: accesstokens.ClientInfo{
+			UID:  "123-456",
+			UTID: "fake",
+		},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.AcquireTokenSilent(context.Background(), tokenScope)
+	// first attempt should fail
+	if err == nil {
+		t.Fatal("unexpected nil error from AcquireTokenSilent")
+	}
+	tk, err := client.AcquireTokenByAuthCode(context.Background(), "fake_auth_code", "fake_redirect_uri", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.AccessToken != token {
+		t.Fatalf("unexpected access token %s", tk.AccessToken)
+	}
+	account := client.Account(tk.Account.HomeAccountID)
+	// second attempt should return the cached token
+	tk, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(account))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.AccessToken != token {
+		t.Fatalf("unexpected access token %s", tk.AccessToken)
+	}
+}
+
+func TestReturnRefreshToken(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			client, err := fakeClient(accesstokens.TokenResponse{
+				AccessToken:   token,
+				RefreshToken:  refresh,
+				ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+				ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+				GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+				IDToken:       accesstokens.IDToken{Oid: "123-456", TenantID: "fake", RawToken: "x.e30"},
+				ClientInfo:    accesstokens.ClientInfo{UID: "123-456", UTID: "fake"},
+			}, cred, WithReturnRefreshToken(enabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := ""
+			if enabled {
+				expected = refresh
+			}
+			ctx := context.Background()
+			ar, err := client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.RefreshToken != expected {
+				t.Errorf("expected refresh token %q, got %q", expected, ar.RefreshToken)
+			}
+			ar, err = client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(ar.Account))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.RefreshToken != expected {
+				t.Errorf("expected cached refresh token %q, got %q", expected, ar.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestAcquireTokenSilentTenants(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := []string{"a", "b"}
+	lmo := "login.microsoftonline.com"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenants[0])))
+	client, err := New("client-id", cred, WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// cache an access token for each tenant. To simplify determining their provenance below, the value of each token is the ID of the tenant that provided it.
+	for _, tenant := range tenants {
+		if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(tenant)); err == nil {
+			t.Fatal("silent auth should fail because the cache is empty")
+		}
+		mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+		mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody(tenant, "", "", "", 3600)))
+		if _, err := client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID(tenant)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cache should return the correct access token for each tenant
+	for _, tenant := range tenants {
+		ar, err := client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(tenant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ar.AccessToken != tenant {
+			t.Fatalf(`expected "%s", got "%s"`, tenant, ar.AccessToken)
+		}
+	}
+}
+
+func TestInvalidCredential(t *testing.T) {
+	data, err := os.ReadFile("../testdata/test-cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cred := range []Credential{
+		{},
+		NewCredFromAssertionCallback(nil),
+		NewCredFromCert(nil, nil),
+		NewCredFromCert(certs[0], nil),
+		NewCredFromCert(nil, key),
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := New("client-id", cred)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewCredFromCertChain(t *testing.T) {
+	for _, file := range []struct {
+		path     string
+		numCerts int
+	}{
+		{"../testdata/test-cert.pem", 1},
+		{"../testdata/test-cert-chain.pem", 2},
+		{"../testdata/test-cert-chain-reverse.pem", 2},
+	} {
+		f, err := os.Open(filepath.Clean(file.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		pemData, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, key, err := CertFromPEM(pemData, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != file.numCerts {
+			t.Fatalf("expected %d certs, got %d", file.numCerts, len(certs))
+		}
+		expectedCerts := make(map[string]struct{}, len(certs))
+		for _, cert := range certs {
+			expectedCerts[base64.StdEncoding.EncodeToString(cert.Raw)] = struct{}{}
+		}
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			t.Fatal("expected an RSA private key")
+		}
+		verifyingKey := &k.PublicKey
+		cred, err := NewCredFromCertChain(certs, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sendX5c := range []bool{false, true} {
+			opts := []Option{}
+			if sendX5c {
+				opts = append(opts, WithX5C())
+			}
+			t.Run(fmt.Sprintf("%s/%v", filepath.Base(file.path), sendX5c), func(t *testing.T) {
+				client, err := fakeClient(accesstokens.TokenResponse{
+					AccessToken:   token,
+					ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+					GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+				}, cred, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the test fake passes assertions generated by the credential to this function
+				validated := false
+				client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
+					validated = true
+					tk, err := jwt.Parse(s, func(tk *jwt.Token) (interface{}, error) {
+						if signingMethod, ok := tk.Method.(*jwt.SigningMethodRSA); !ok {
+							t.Fatalf("unexpected signing method %T", signingMethod)
+						}
+						return verifyingKey, nil
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err = tk.Claims.Valid(); err != nil {
+						t.Fatal(err)
+					}
+					// x5c header should be set iff the sendX5c is true
+					if x5c, ok := tk.Header["x5c"]; ok != sendX5c {
+						t.Fatal("x5c should be set only when application passed WithX5C option")
+					} else if ok {
+						if x := len(x5c.([]interface{})); x > file.numCerts {
+							t.Fatalf("x5c contains %d certs; expected %d", x, file.numCerts)
+						}
+						// x5c must contain all the file's certs, signing cert first
+						for i, cert := range x5c.([]interface{}) {
+							s := cert.(string)
+							if _, ok := expectedCerts[s]; ok {
+								delete(expectedCerts, s)
+							} else {
+								t.Fatal("x5c contains an unexpected cert")
+							}
+							if i == 0 {
+								decoded, err := base64.StdEncoding.DecodeString(s)
+								if err != nil {
+									t.Fatal(err)
+								}
+								parsed, err := x509.ParseCertificate(decoded)
+								if err != nil {
+									t.Fatal(err)
+								}
+								if !verifyingKey.Equal(parsed.PublicKey) {
+									t.Fatal("signing cert must appear first in x5c")
+								}
+							}
+						}
+						if len(expectedCerts) > 0 {
+							t.Fatal("x5c header is missing a cert")
+						}
+					}
+				}
+				tk, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tk.AccessToken != token {
+					t.Fatalf("unexpected access token %s", tk.AccessToken)
+				}
+				if !validated {
+					t.Fatal("assertion validation function wasn't called")
+				}
+			})
+		}
+	}
+}
+
+func TestAssertionOptions(t *testing.T) {
+	pemData, err := os.ReadFile(filepath.Clean("../testdata/test-cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(pemData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audience := "https://login.microsoftonline.com/tenant/oauth2/token"
+	lifetime := time.Hour
+	cred := NewCredFromCert(certs[0], key,
+		WithAssertionAudience(audience),
+		WithAssertionClaims(map[string]interface{}{"xms_az_claim": "value", "sub": "not the client ID"}),
+		WithAssertionLifetime(lifetime),
+	)
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validated := false
+	client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
+		validated = true
+		claims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(s, claims); err != nil {
+			t.Fatal(err)
+		}
+		if aud := claims["aud"]; aud != audience {
+			t.Errorf("unexpected audience %v", aud)
+		}
+		if v := claims["xms_az_claim"]; v != "value" {
+			t.Errorf("unexpected xms_az_claim %v", v)
+		}
+		if sub := claims["sub"]; sub != "fake_client_id" {
+			t.Errorf("additional claims shouldn't override sub, got %v", sub)
+		}
+		exp, nbf := claims["exp"].(float64), claims["nbf"].(float64)
+		if actual := time.Duration(exp-nbf) * time.Second; actual != lifetime {
+			t.Errorf("expected lifetime %v, got %v", lifetime, actual)
+		}
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if !validated {
+		t.Fatal("client didn't send an assertion")
+	}
+}
+
+func TestAssertionReuse(t *testing.T) {
+	pemData, err := os.ReadFile(filepath.Clean("../testdata/test-cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(pemData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, NewCredFromCert(certs[0], key), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertions := []string{}
+	client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
+		assertions = append(assertions, s)
+	}
+	acquire := func() {
+		t.Helper()
+		if _, err := client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acquire()
+	acquire()
+	if len(assertions) != 2 || assertions[0] != assertions[1] {
+		t.Fatal("expected the client to reuse its assertion")
+	}
+	// the client should sign a new assertion when the old one is about to expire
+	now = now.Add(8 * time.Minute)
+	acquire()
+	if len(assertions) != 3 || assertions[2] == assertions[1] {
+		t.Fatal("expected a new assertion")
+	}
+}
+
+func TestAssertionIDs(t *testing.T) {
+	pemData, err := os.ReadFile(filepath.Clean("../testdata/test-cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(pemData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := NewCredFromCert(certs[0], key, WithAssertionIDs(AssertionIDCounter), WithAssertionReuse(false))
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
+		claims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(s, claims); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, claims["jti"].(string))
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// without reuse, each request should have a new assertion whose ID has the next sequence number
+	if len(ids) != 2 || !strings.HasSuffix(ids[0], ".1") || ids[1] != strings.TrimSuffix(ids[0], "1")+"2" {
+		t.Fatalf("unexpected assertion IDs %v", ids)
+	}
+}
+
+func TestAssertionClaims(t *testing.T) {
+	for _, test := range []struct {
+		authority, aud string
+		opts           []CredentialOption
+	}{
+		{authority: "https://login.microsoftonline.com/tenant", aud: "https://login.microsoftonline.com/tenant/oauth2/v2.0/token"},
+		{authority: "https://login.microsoftonline.us/tenant/", aud: "https://login.microsoftonline.us/tenant/oauth2/v2.0/token"},
+		{authority: "https://fake_adfs/adfs", aud: "https://fake_adfs/adfs/oauth2/token"},
+		{authority: "https://login.microsoftonline.com/tenant", aud: "aud", opts: []CredentialOption{WithAssertionAudience("aud")}},
+	} {
+		t.Run(test.authority, func(t *testing.T) {
+			claims, err := AssertionClaims(test.authority, fakeClientID, append(test.opts,
+				WithAssertionClaims(map[string]interface{}{"xms_az_claim": "value", "iss": "not the client ID"}),
+			)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aud := claims["aud"]; aud != test.aud {
+				t.Errorf("expected aud %q, got %v", test.aud, aud)
+			}
+			for _, k := range []string{"iss", "sub"} {
+				if v := claims[k]; v != fakeClientID {
+					t.Errorf("expected %s %q, got %v", k, fakeClientID, v)
+				}
+			}
+			if v := claims["xms_az_claim"]; v != "value" {
+				t.Errorf("unexpected xms_az_claim %v", v)
+			}
+			exp, err := claims["exp"].(json.Number).Int64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nbf, err := claims["nbf"].(json.Number).Int64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := time.Duration(exp-nbf) * time.Second; actual != 10*time.Minute {
+				t.Errorf("expected the default lifetime, got %v", actual)
+			}
+			if claims["jti"] == "" {
+				t.Error("expected a jti claim")
+			}
+		})
+	}
+	if _, err := AssertionClaims("http://login.microsoftonline.com/tenant", fakeClientID); err == nil {
+		t.Error("expected an error for an invalid authority")
+	}
+}
+
+func TestNewCredFromCertChainError(t *testing.T) {
+	data, err := os.ReadFile("../testdata/test-cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		certs []*x509.Certificate
+		key   crypto.PrivateKey
+	}{
+		{nil, nil},
+		{certs, nil},
+		{nil, key},
+		{[]*x509.Certificate{}, nil},
+		{[]*x509.Certificate{}, key},
+		{[]*x509.Certificate{nil}, nil},
+		{[]*x509.Certificate{nil}, key},
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := NewCredFromCertChain(test.certs, test.key)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewCredFromTokenProvider(t *testing.T) {
+	expectedToken := "expected token"
+	called := false
+	expiresIn := 4200
+	key := struct{}{}
+	ctx := context.WithValue(context.Background(), key, true)
+	cred := NewCredFromTokenProvider(func(c context.Context, tp exported.TokenProviderParameters) (exported.TokenProviderResult, error) {
+		if called {
+			t.Fatal("expected exactly one token provider invocation")
+		}
+		called = true
+		if v := c.Value(key); v == nil || !v.(bool) {
+			t.Fatal("callback received unexpected context")
+		}
+		if tp.CorrelationID == "" {
+			t.Fatal("expected CorrelationID")
+		}
+		if v := fmt.Sprint(tp.Scopes); v != fmt.Sprint(tokenScope) {
+			t.Fatalf(`unexpected scopes "%v"`, v)
+		}
+		return exported.TokenProviderResult{
+			AccessToken:      expectedToken,
+			ExpiresInSeconds: expiresIn,
+		}, nil
+	})
+	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByCredential(ctx, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("token provider wasn't invoked")
+	}
+	if v := int(time.Until(ar.ExpiresOn).Seconds()); v < expiresIn-2 || v > expiresIn {
+		t.Fatalf("expected ExpiresOn ~= %d seconds, got %d", expiresIn, v)
+	}
+	if ar.AccessToken != expectedToken {
+		t.Fatalf(`unexpected token "%s"`, ar.AccessToken)
+	}
+	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != expectedToken {
+		t.Fatalf(`unexpected token "%s"`, ar.AccessToken)
+	}
+}
+
+func TestNewCredFromTokenProviderError(t *testing.T) {
+	expectedError := "something went wrong"
+	cred := NewCredFromTokenProvider(func(ctx context.Context, tpp exported.TokenProviderParameters) (exported.TokenProviderResult, error) {
+		return exported.TokenProviderResult{}, errors.New(expectedError)
+	})
+	client, err := New("client-id", cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.AcquireTokenByCredential(context.Background(), tokenScope)
+	if err == nil || !strings.Contains(err.Error(), expectedError) {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+}
+
+func TestWithTenantID(t *testing.T) {
+	accessToken := "*"
+	uuid1 := "00000000-0000-0000-0000-000000000000"
+	uuid2 := strings.ReplaceAll(uuid1, "0", "1")
+	lmo := "login.microsoftonline.com"
+	host := fmt.Sprintf("https://%s/", lmo)
+	for _, test := range []struct {
+		authority, expectedAuthority, tenant string
+		expectError                          bool
+	}{
+		{authority: host + "common", tenant: uuid1, expectedAuthority: host + uuid1},
+		{authority: host + "organizations", tenant: uuid1, expectedAuthority: host + uuid1},
+		{authority: host + uuid1, tenant: uuid2, expectedAuthority: host + uuid2},
+		{authority: host + uuid1, tenant: "common", expectError: true},
+		{authority: host + uuid1, tenant: "organizations", expectError: true},
+		{authority: host + "consumers", tenant: uuid1, expectError: true},
+	} {
+		for _, method := range []string{"authcode", "authcodeURL", "credential", "obo"} {
+			t.Run(method, func(t *testing.T) {
+				cred, err := NewCredFromSecret("secret")
+				if err != nil {
+					t.Fatal(err)
+				}
+				idToken, refreshToken, URL := "", "", ""
+				mockClient := mock.Client{}
+				if method == "obo" {
+					idToken = mock.GetIDToken(test.tenant, test.authority)
+					refreshToken = "refresh-token"
+					// TODO: OBO does instance discovery twice before first token request https://github.com/AzureAD/microsoft-authentication-library-for-go/issues/351
+					mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, test.tenant)))
+				}
+				mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, test.tenant)))
+				mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, test.tenant)))
+				mockClient.AppendResponse(
+					mock.WithBody(mock.GetAccessTokenBody(accessToken, idToken, refreshToken, "", 3600)),
+					mock.WithCallback(func(r *http.Request) { URL = r.URL.String() }),
+				)
+				client, err := New("client-id", cred, WithAuthority(test.authority), WithHTTPClient(&mockClient))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx := context.Background()
+				if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(test.tenant)); err == nil {
+					t.Fatal("silent auth should fail because the cache is empty")
+				}
+				var ar AuthResult
+				switch method {
+				case "authcode":
+					ar, err = client.AcquireTokenByAuthCode(ctx, "auth code", "https://localhost", tokenScope, WithTenantID(test.tenant))
+				case "authcodeURL":
+					URL, err = client.AuthCodeURL(ctx, "client-id", "https://localhost", tokenScope, WithTenantID(test.tenant))
+				case "credential":
+					ar, err = client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID(test.tenant))
+				case "obo":
+					ar, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID(test.tenant))
+				default:
+					t.Fatalf("no test for " + method)
+				}
+				if err != nil {
+					if test.expectError {
+						return
+					}
+					t.Fatal(err)
+				} else if test.expectError {
+					t.Fatal("expected an error")
+				}
+				if !strings.HasPrefix(URL, test.expectedAuthority) {
+					t.Fatalf(`expected "%s", got "%s"`, test.expectedAuthority, URL)
+				}
+				if method == "authcodeURL" {
+					// didn't acquire a token, no need to test silent auth
+					return
+				}
+				if ar.AccessToken != accessToken {
+					t.Fatalf(`unexpected access token "%s"`, ar.AccessToken)
+				}
+				// silent authentication should now succeed for the given tenant...
+				if method == "obo" {
+					if ar, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID(test.tenant)); err != nil {
+						t.Fatal(err)
+					}
+				} else if ar, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID(test.tenant)); err != nil {
+					t.Fatal(err)
+				}
+				if ar.AccessToken != accessToken {
+					t.Fatal("cached access token should match the one returned by AcquireToken...")
+				}
+				// ...but fail for another tenant
+				if method == "obo" {
+					// OBO sends a token request after silent auth fails
+					mockClient.AppendResponse()
+					if _, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID("not-"+test.tenant)); err == nil {
+						t.Fatal("expected an error")
+					}
+				} else if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID("not-"+test.tenant)); err == nil {
+					t.Fatal("expected an error")
+				}
+			})
+		}
+	}
+}
+
+func TestCreateLogoutURL(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	redirect := "https://localhost/signed-out"
+	for _, withTenant := range []bool{false, true} {
+		t.Run(fmt.Sprint("WithTenantID=", withTenant), func(t *testing.T) {
+			cred, err := NewCredFromSecret("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			authority, expectedTenant := fmt.Sprintf("https://%s/%s", lmo, tenant), tenant
+			opts := []LogoutURLOption{}
+			if withTenant {
+				authority, expectedTenant = fmt.Sprintf("https://%s/common", lmo), "other-tenant"
+				opts = append(opts, WithTenantID(expectedTenant))
+			}
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, expectedTenant)))
+			client, err := New("client-id", cred, WithAuthority(authority), WithHTTPClient(&mockClient))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := client.CreateLogoutURL(context.Background(), redirect, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != lmo || u.Path != fmt.Sprintf("/%s/oauth2/v2.0/logout", expectedTenant) {
+				t.Fatalf("unexpected logout URL %q", actual)
+			}
+			if v := u.Query().Get("post_logout_redirect_uri"); v != redirect {
+				t.Fatalf("expected post_logout_redirect_uri %q, got %q", redirect, v)
+			}
+		})
+	}
+}
+
+func TestWithLoginHint(t *testing.T) {
+	upn := "user@localhost"
+	cred, err := NewCredFromSecret("...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	for _, expectHint := range []bool{true, false} {
+		t.Run(fmt.Sprint(expectHint), func(t *testing.T) {
+			opts := []AuthCodeURLOption{}
+			if expectHint {
+				opts = append(opts, WithLoginHint(upn))
+			}
+			u, err := client.AuthCodeURL(context.Background(), "id", "https://localhost", tokenScope, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := url.Parse(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parsed.Query().Has("login_hint") {
+				if !expectHint {
+					return
+				}
+				t.Fatal("expected a login hint")
+			} else if !expectHint {
+				t.Fatal("expected no login hint")
+			}
+			if actual := parsed.Query()["login_hint"]; len(actual) != 1 || actual[0] != upn {
+				t.Fatalf(`unexpected login_hint "%v"`, actual)
+			}
+		})
+	}
+}
+
+func TestUserFlowsCachePartition(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	for _, method := range []string{"authcode", "devicecode"} {
+		t.Run(method, func(t *testing.T) {
+			cred, err := NewCredFromSecret("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			accessor := &recordingAccessor{}
+			mockClient := mock.Client{}
+			client, err := New(fakeClientID, cred,
+				WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+				WithAccessor(accessor),
+				WithHTTPClient(&mockClient),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			body := mock.GetAccessTokenBody(token, mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)
+			var ar AuthResult
+			switch method {
+			case "authcode":
+				mockClient.AppendResponse(
+					mock.WithBody(body),
+					mock.WithCallback(func(r *http.Request) {
+						if err := r.ParseForm(); err != nil {
+							t.Fatal(err)
+						}
+						if actual := r.FormValue("redirect_uri"); actual != "https://localhost/other" {
+							t.Errorf(`expected redirect_uri "https://localhost/other", got %q`, actual)
+						}
+					}),
+				)
+				ar, err = client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", tokenScope, WithRedirectURI("https://localhost/other"))
+			case "devicecode":
+				mockClient.AppendResponse(mock.WithBody([]byte(`{"device_code":"...","expires_in":600}`)))
+				var dc DeviceCode
+				if dc, err = client.AcquireTokenByDeviceCode(ctx, tokenScope); err != nil {
+					t.Fatal(err)
+				}
+				mockClient.AppendResponse(mock.WithBody(body))
+				ar, err = dc.AuthenticationResult(ctx)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.Account.HomeAccountID != "uid.utid" {
+				t.Fatalf(`expected home account ID "uid.utid", got %q`, ar.Account.HomeAccountID)
+			}
+			if ar.IDToken.Issuer != "issuer" {
+				t.Fatalf(`expected ID token issuer "issuer", got %q`, ar.IDToken.Issuer)
+			}
+			// the client should export the user's tokens to the user's partition
+			if accessor.key != ar.Account.HomeAccountID {
+				t.Fatalf("expected cache key %q, got %q", ar.Account.HomeAccountID, accessor.key)
+			}
+		})
+	}
+}
+
+func TestWithRedirectURI(t *testing.T) {
+	cred, err := NewCredFromSecret("...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	u, err := client.AuthCodeURL(context.Background(), "id", "https://localhost", tokenScope, WithRedirectURI("https://localhost/other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := parsed.Query().Get("redirect_uri"); actual != "https://localhost/other" {
+		t.Fatalf(`expected redirect_uri "https://localhost/other", got %q`, actual)
+	}
+}
+
+func TestRedirectURIs(t *testing.T) {
+	cred, err := NewCredFromSecret("...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		uri   string
+		valid bool
+	}{
+		{uri: "https://localhost/cb", valid: true},
+		{uri: "http://localhost:8080/cb", valid: true},
+		{uri: "http://127.0.0.1/cb", valid: true},
+		{uri: "http://[::1]/cb", valid: true},
+		{uri: "myapp://auth", valid: true},
+		{uri: "relative/path"},
+		{uri: "https://localhost/cb#fragment"},
+		{uri: "http://example.com/cb"},
+		{uri: "https:///cb"},
+	} {
+		_, err := New("client-id", cred, WithHTTPClient(&errorClient{}), WithRedirectURIs(test.uri))
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.uri, err)
+			}
+			continue
+		}
+		var uriErr msalerrors.RedirectURIError
+		if !errors.As(err, &uriErr) || uriErr.URI != test.uri {
+			t.Errorf("%s: expected a RedirectURIError, got %v", test.uri, err)
+		}
+	}
+
+	a, b := "https://localhost/a", "https://localhost/b"
+	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}), WithRedirectURIs(a, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	for _, test := range []struct {
+		desc, redirectURI, expected string
+		opts                        []AuthCodeURLOption
+	}{
+		{desc: "default", expected: a},
+		{desc: "argument", redirectURI: b, expected: b},
+		{desc: "option", redirectURI: a, expected: b, opts: []AuthCodeURLOption{WithRedirectURI(b)}},
+		{desc: "unregistered argument", redirectURI: "https://localhost/c"},
+		{desc: "unregistered option", opts: []AuthCodeURLOption{WithRedirectURI("https://localhost/c")}},
+		{desc: "malformed option", opts: []AuthCodeURLOption{WithRedirectURI("http://example.com")}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			u, err := client.AuthCodeURL(context.Background(), "id", test.redirectURI, tokenScope, test.opts...)
+			if test.expected == "" {
+				var uriErr msalerrors.RedirectURIError
+				if !errors.As(err, &uriErr) {
+					t.Fatalf("expected a RedirectURIError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := url.Parse(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := parsed.Query().Get("redirect_uri"); actual != test.expected {
+				t.Fatalf("expected redirect_uri %q, got %q", test.expected, actual)
+			}
+		})
+	}
+	// authorization code redemption should fail before sending a request, which errorClient would fail
+	_, err = client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost/c", tokenScope)
+	var uriErr msalerrors.RedirectURIError
+	if !errors.As(err, &uriErr) {
+		t.Fatalf("expected a RedirectURIError, got %v", err)
+	}
+}
+
+// recordingAccessor is a cache.ExportReplace that keeps the last data exported
+type recordingAccessor struct {
+	key  string
+	data []byte
+}
+
+func (r *recordingAccessor) Replace(cache cache.Unmarshaler, key string) {
+	if r.data != nil {
+		_ = cache.Unmarshal(r.data)
+	}
+}
+
+func (r *recordingAccessor) Export(cache cache.Marshaler, key string) {
+	r.key = key
+	r.data, _ = cache.Marshal()
+}
+
+func TestCacheNamespace(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprint("compressed=", compressed), func(t *testing.T) {
+			ctx := context.Background()
+			accessor := &recordingAccessor{}
+			newClient := func(namespace string) Client {
+				opts := []Option{WithAccessor(accessor), WithCacheNamespace(namespace)}
+				if compressed {
+					opts = append(opts, WithCacheCompression())
+				}
+				client, err := fakeClient(tr, cred, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return client
+			}
+			a := newClient("a")
+			if _, err := a.AcquireTokenByCredential(ctx, tokenScope); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(accessor.key, "a-") {
+				t.Fatalf("expected a key having the namespace prefix, got %q", accessor.key)
+			}
+			// clients having another namespace or none shouldn't read the token, and shouldn't
+			// remove it when they write the cache
+			for _, namespace := range []string{"b", ""} {
+				if _, err := newClient(namespace).AcquireTokenSilent(ctx, tokenScope); err == nil {
+					t.Fatalf("namespace %q read namespace a's token", namespace)
+				}
+			}
+			ar, err := newClient("a").AcquireTokenSilent(ctx, tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.AccessToken != token {
+				t.Fatalf("expected %q, got %q", token, ar.AccessToken)
+			}
+			// and namespace a shouldn't remove the data of clients having no namespace
+			if _, err := newClient("").AcquireTokenByCredential(ctx, tokenScope); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newClient("a").AcquireTokenSilent(ctx, tokenScope); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newClient("").AcquireTokenSilent(ctx, tokenScope); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReadOnlyCache(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}
+	ctx := context.Background()
+	accessor := &recordingAccessor{}
+	writer, err := fakeClient(tr, cred, WithAccessor(accessor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.AcquireTokenByCredential(ctx, tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	data := string(accessor.data)
+
+	var needed [][]string
+	refreshNeeded := func(account Account, scopes []string) {
+		if !account.IsZero() {
+			t.Errorf("expected no account, got %v", account)
+		}
+		needed = append(needed, scopes)
+	}
+	reader, err := fakeClient(tr, cred, WithAccessor(accessor), WithReadOnlyCache(refreshNeeded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := reader.AcquireTokenSilent(ctx, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != token {
+		t.Fatalf("expected %q, got %q", token, ar.AccessToken)
+	}
+	// the reader shouldn't change the shared cache, even when it acquires a token
+	other := []string{"https://other/.default"}
+	if _, err := reader.AcquireTokenByCredential(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if string(accessor.data) != data {
+		t.Fatal("read-only client changed the cache")
+	}
+	if _, err := reader.AcquireTokenSilent(ctx, other); err == nil {
+		t.Fatal("expected an error for a token the cache doesn't have")
+	}
+	if len(needed) != 1 || strings.Join(needed[0], " ") != strings.Join(other, " ") {
+		t.Fatalf("expected one refresh needed for %v, got %v", other, needed)
+	}
+
+	if _, err := fakeClient(tr, cred, WithReadOnlyCache(nil)); err == nil {
+		t.Fatal("expected an error for a read-only cache without an accessor")
+	}
+}
+
+// lockedAccessor is a cache.ExportReplace that clients can share concurrently
+type lockedAccessor struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (l *lockedAccessor) Replace(cache cache.Unmarshaler, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.data != nil {
+		_ = cache.Unmarshal(l.data)
+	}
+}
+
+func (l *lockedAccessor) Export(cache cache.Marshaler, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data, _ = cache.Marshal()
+}
+
+// noRequestsClient fails the test when a client sends any request
+type noRequestsClient struct {
+	t *testing.T
+}
+
+func (n noRequestsClient) Do(r *http.Request) (*http.Response, error) {
+	n.t.Errorf("unexpected request to %s", r.URL)
+	return nil, errors.New("no requests allowed")
+}
+
+func (noRequestsClient) CloseIdleConnections() {}
+
+// TestConcurrentClients constructs clients and acquires tokens concurrently, as a web app constructing
+// a client per request would. Run it with -race.
+func TestConcurrentClients(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessor := &lockedAccessor{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// constructing a client mustn't send any request
+			if _, err := New(fakeClientID, cred, WithAccessor(accessor), WithHTTPClient(noRequestsClient{t})); err != nil {
+				t.Error(err)
+				return
+			}
+			client, err := fakeClient(accesstokens.TokenResponse{
+				AccessToken:   token,
+				ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+				ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+				GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+			}, cred, WithAccessor(accessor))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := client.AcquireTokenSilent(context.Background(), tokenScope); err != nil {
+				if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			ar, err := client.AcquireTokenSilent(context.Background(), tokenScope)
+			if err != nil {
+				t.Error(err)
+			} else if ar.AccessToken != token {
+				t.Errorf("unexpected access token %q", ar.AccessToken)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCacheKeys(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessor := &recordingAccessor{}
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred, WithAccessor(accessor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := client.CacheKeys(Account{}, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Partition != accessor.key {
+		t.Errorf("expected partition %q, got %q", accessor.key, keys.Partition)
+	}
+	if keys.AccessToken == "" || !strings.Contains(string(accessor.data), fmt.Sprintf("%q", keys.AccessToken)) {
+		t.Errorf("cached data doesn't contain access token key %q", keys.AccessToken)
+	}
+
+	keys, err = client.CacheKeys(Account{}, tokenScope, WithTenantID("tenant"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(accessor.data), fmt.Sprintf("%q", keys.AccessToken)) {
+		t.Errorf("access token key %q for another tenant shouldn't be in the cache", keys.AccessToken)
+	}
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := client.CacheSnapshot(context.Background()); len(s.Accounts)+len(s.Tokens) > 0 {
+		t.Fatalf("expected an empty snapshot, got %+v", s)
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	s := client.CacheSnapshot(context.Background())
+	if len(s.Tokens) != 1 {
+		t.Fatalf("expected 1 token, got %+v", s.Tokens)
+	}
+	at := s.Tokens[0]
+	if at.Type != "AccessToken" || at.ClientID != "fake_client_id" || at.Realm != "fake" {
+		t.Errorf("unexpected token %+v", at)
+	}
+	if strings.Join(at.Scopes, " ") != strings.Join(tokenScope, " ") {
+		t.Errorf("expected scopes %v, got %v", tokenScope, at.Scopes)
+	}
+	if at.ExpiresOn.IsZero() {
+		t.Error("expected an expiration time")
+	}
+	if strings.Contains(fmt.Sprintf("%+v", s), token) {
+		t.Error("snapshot contains the access token")
+	}
+}
+
+func TestRemoveAccountByLogoutToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const issuer = "https://fake_authority/fake/v2.0"
+	idToken := "header." + base64.RawURLEncoding.EncodeToString([]byte(
+		`{"iss":"`+issuer+`","sub":"subject","sid":"session","oid":"123-456","tid":"fake"}`,
+	)) + ".signature"
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		RefreshToken:  refresh,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+		IDToken:       accesstokens.IDToken{Oid: "123-456", TenantID: "fake", RawToken: idToken},
+		ClientInfo:    accesstokens.ClientInfo{UID: "123-456", UTID: "fake"},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := authority.NewEndpoints("https://fake_authority/fake/auth", fakeTokenEndpoint, issuer, "fake_authority")
+	endpoints.JWKSURI = "https://fake_authority/fake/keys"
+	client.base.Token.Resolver = &fake.ResolveEndpoints{Endpoints: endpoints}
+	client.base.Token.Authority = &fake.Authority{Keys: authority.JSONWebKeySet{Keys: []authority.JSONWebKey{{
+		KeyType: "RSA",
+		KeyID:   "kid",
+		N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}}
+
+	sign := func(k *rsa.PrivateKey, kid string, edit func(jwt.MapClaims)) string {
+		claims := jwt.MapClaims{
+			"iss":    issuer,
+			"aud":    "fake_client_id",
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Minute).Unix(),
+			"jti":    "id",
+			"sid":    "session",
+			"events": map[string]interface{}{"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{}},
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		tk := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tk.Header["kid"] = kid
+		s, err := tk.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	ctx := context.Background()
+	for _, test := range []struct{ desc, token string }{
+		{desc: "not a JWT", token: "token"},
+		{desc: "unknown key", token: sign(key, "other", nil)},
+		{desc: "wrong key", token: sign(otherKey, "kid", nil)},
+		{desc: "wrong audience", token: sign(key, "kid", func(c jwt.MapClaims) { c["aud"] = "other" })},
+		{desc: "wrong issuer", token: sign(key, "kid", func(c jwt.MapClaims) { c["iss"] = "https://other" })},
+		{desc: "expired", token: sign(key, "kid", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })},
+		{desc: "no iat", token: sign(key, "kid", func(c jwt.MapClaims) { delete(c, "iat") })},
+		{desc: "no exp", token: sign(key, "kid", func(c jwt.MapClaims) { delete(c, "exp") })},
+		{desc: "no sub or sid", token: sign(key, "kid", func(c jwt.MapClaims) { delete(c, "sid") })},
+		{desc: "no logout event", token: sign(key, "kid", func(c jwt.MapClaims) { c["events"] = map[string]interface{}{} })},
+		{desc: "nonce", token: sign(key, "kid", func(c jwt.MapClaims) { c["nonce"] = "nonce" })},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := client.ValidateLogoutToken(ctx, test.token); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	ar, err := client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Account(ar.Account.HomeAccountID).IsZero() {
+		t.Fatal("expected a cached account")
+	}
+	lt, err := client.RemoveAccountByLogoutToken(ctx, sign(key, "kid", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt.SessionID != "session" || lt.ID != "id" || lt.Issuer != issuer {
+		t.Errorf("unexpected logout token %+v", lt)
+	}
+	if !client.Account(ar.Account.HomeAccountID).IsZero() {
+		t.Fatal("expected the account to be removed")
+	}
+	if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(ar.Account)); err == nil {
+		t.Fatal("expected the account's tokens to be removed")
+	}
+
+	// validation should use the client's clock
+	valid := sign(key, "kid", nil)
+	client.base.AuthParams.Clock = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := client.ValidateLogoutToken(ctx, valid); err == nil {
+		t.Fatal("expected an error for a token that expired by the client's clock")
+	}
+	client.base.AuthParams.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if _, err := client.ValidateLogoutToken(ctx, valid); err == nil {
+		t.Fatal("expected an error for a token issued in the future by the client's clock")
+	}
+}
+
+func TestTokenRequestRateLimit(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New("client-id", cred, WithTokenRequestRateLimit(1, 0, 0)); err == nil {
+		t.Fatal("expected an error for a zero burst")
+	}
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred, WithTokenRequestRateLimit(0.001, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = client.AcquireTokenByCredential(ctx, tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	// cached tokens don't count against the limit
+	if _, err = client.AcquireTokenSilent(ctx, tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID("tenant"))
+	var rle msalerrors.RateLimitError
+	if !errors.As(err, &rle) || rle.Host != "fake_authority" {
+		t.Fatalf("expected a RateLimitError, got %v", err)
+	}
+	if s := client.RateLimitStats(); s.Allowed != 1 || s.Dropped != 1 || s.Delayed != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresOn := time.Now().Add(time.Hour)
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: expiresOn},
+		ExtExpiresOn:  internalTime.DurationTime{T: expiresOn},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if s := client.CacheStats(ctx); len(s.Partitions) != 0 || s.ApproximateBytes != 0 {
+		t.Fatalf("expected no partitions, got %+v", s)
+	}
+	for _, tenant := range []string{"a", "b"} {
+		if _, err = client.AcquireTokenByCredential(ctx, tokenScope, WithTenantID(tenant)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	s := client.CacheStats(ctx)
+	if len(s.Partitions) != 3 {
+		t.Fatalf("expected 3 partitions, got %+v", s.Partitions)
+	}
+	total := 0
+	for i, p := range s.Partitions {
+		if i > 0 && s.Partitions[i-1].Key > p.Key {
+			t.Errorf("partitions aren't ordered by key: %+v", s.Partitions)
+		}
+		if p.AccessTokens != 1 || p.RefreshTokens+p.IDTokens+p.Accounts != 0 {
+			t.Errorf("expected 1 access token, got %+v", p)
+		}
+		if !p.OldestExpiry.Equal(p.NewestExpiry) || p.OldestExpiry.Unix() != expiresOn.Unix() {
+			t.Errorf("expected expiry %v, got %+v", expiresOn, p)
+		}
+		if p.ApproximateBytes <= len(token) {
+			t.Errorf("expected the partition's size to include its token, got %d", p.ApproximateBytes)
+		}
+		total += p.ApproximateBytes
+	}
+	if s.ApproximateBytes != total {
+		t.Errorf("expected %d total bytes, got %d", total, s.ApproximateBytes)
+	}
+	if strings.Contains(fmt.Sprintf("%+v", s), token) {
+		t.Error("stats contain the access token")
+	}
+}
+
+func TestClock(t *testing.T) {
+	cred, err := NewCredFromSecret("fake_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, test := range []struct {
+		desc    string
+		advance time.Duration
+		skew    time.Duration
+		cached  bool
+	}{
+		{desc: "token valid", cached: true},
+		{desc: "clock passed expiration", advance: 2 * time.Hour},
+		{desc: "clock near expiration", advance: 57 * time.Minute},
+		{desc: "skew exceeds remaining lifetime", advance: 30 * time.Minute, skew: 30 * time.Minute},
+		{desc: "skew within remaining lifetime", advance: 30 * time.Minute, skew: 10 * time.Minute, cached: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			clockTime := now
+			client, err := fakeClient(accesstokens.TokenResponse{
+				AccessToken:   token,
+				ExpiresOn:     internalTime.DurationTime{T: now.Add(time.Hour)},
+				ExtExpiresOn:  internalTime.DurationTime{T: now.Add(time.Hour)},
+				GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+			}, cred, WithClock(func() time.Time { return clockTime }), WithClockSkew(test.skew))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+				t.Fatal(err)
+			}
+			clockTime = clockTime.Add(test.advance)
+			_, err = client.AcquireTokenSilent(context.Background(), tokenScope)
+			if test.cached && err != nil {
+				t.Fatalf("expected a cached token, got %v", err)
+			}
+			if !test.cached && err == nil {
+				t.Fatal("expected no valid cached token")
+			}
+		})
+	}
+}
+
+func TestClockAssertion(t *testing.T) {
+	pemData, err := os.ReadFile(filepath.Clean("../testdata/test-cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := CertFromPEM(pemData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clockTime := time.Unix(1700000000, 0)
+	skew := 2 * time.Minute
+	client, err := fakeClient(accesstokens.TokenResponse{
+		AccessToken:   token,
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+	}, NewCredFromCert(certs[0], key), WithClock(func() time.Time { return clockTime }), WithClockSkew(skew))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validated := false
+	client.base.Token.AccessTokens.(*fake.AccessTokens).ValidateAssertion = func(s string) {
+		validated = true
+		claims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(s, claims); err != nil {
+			t.Fatal(err)
+		}
+		if nbf := int64(claims["nbf"].(float64)); nbf != clockTime.Add(-skew).Unix() {
+			t.Errorf("expected nbf %d, got %d", clockTime.Add(-skew).Unix(), nbf)
+		}
+		if exp := int64(claims["exp"].(float64)); exp != clockTime.Add(10*time.Minute).Unix() {
+			t.Errorf("expected exp %d, got %d", clockTime.Add(10*time.Minute).Unix(), exp)
+		}
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if !validated {
+		t.Fatal("client didn't send an assertion")
+	}
+}
+
+func TestAcquireTokens(t *testing.T) {
+	mu := sync.Mutex{}
+	active, maxActive := 0, 0
+	cred := NewCredFromTokenProvider(func(ctx context.Context, tpp TokenProviderParameters) (TokenProviderResult, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if tpp.Scopes[0] == "fail" {
+			return TokenProviderResult{}, errors.New("expected error")
+		}
+		return TokenProviderResult{AccessToken: tpp.Scopes[0], ExpiresInSeconds: 3600}, nil
+	})
+	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []TokenRequest{}
+	for i := 0; i < 10; i++ {
+		requests = append(requests, TokenRequest{Scopes: []string{fmt.Sprintf("https://resource%d/.default", i)}})
+	}
+	requests = append(requests,
+		TokenRequest{Scopes: []string{"fail"}},
+		TokenRequest{Scopes: tokenScope, Options: []AcquireByCredentialOption{WithTenantID("consumers")}},
+	)
+	results, err := client.AcquireTokens(context.Background(), requests, WithMaxConcurrency(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("expected %d results, got %d", len(requests), len(results))
+	}
+	for i, r := range results[:10] {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if expected := requests[i].Scopes[0]; r.AuthResult.AccessToken != expected {
+			t.Errorf("expected token %q, got %q", expected, r.AuthResult.AccessToken)
+		}
+	}
+	for _, r := range results[10:] {
+		if r.Err == nil {
+			t.Error("expected an error")
+		}
+	}
+	if maxActive > 3 {
+		t.Errorf("expected at most 3 concurrent requests, got %d", maxActive)
+	}
+	if _, err := client.AcquireTokens(context.Background(), requests, WithMaxConcurrency(0)); err == nil {
+		t.Error("expected an error for invalid max concurrency")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)))
+	client, err := New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Warmup(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	// the client has everything it needs to return a cached token; the mock panics if the client sends a request
+	ar, err := client.AcquireTokenSilent(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != token {
+		t.Fatalf("expected %q, got %q", token, ar.AccessToken)
+	}
+	// warming up again doesn't acquire another token
+	if err := client.Warmup(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowStaleOnError(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	now := time.Now()
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)))
+	client, err := New("client-id", cred,
+		WithAllowStaleOnError(time.Hour),
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithClock(func() time.Time { return now }),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(90 * time.Minute)
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	ar, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ar.Stale || ar.AccessToken != token {
+		t.Fatalf("expected the stale token, got %+v", ar)
+	}
+
+	// a caller canceling its request should get an error rather than the stale token
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable), mock.WithCallback(func(*http.Request) { cancel() }))
+	if _, err = client.AcquireTokenByCredential(ctx, tokenScope); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestExtendedTokenLifetime(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	now := time.Now()
+	body := mock.GetAccessTokenBody(token, "", "", "", 3600)
+	body = append(body[:len(body)-1], []byte(`, "ext_expires_in": 7200}`)...)
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(body))
+	client, err := New("client-id", cred,
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithClock(func() time.Time { return now }),
+		WithExtendedTokenLifetime(true),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByCredential(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Metadata.DegradedMode {
+		t.Fatal("a new token shouldn't be in degraded mode")
+	}
+	// the token has expired but its extended lifetime hasn't
+	now = now.Add(90 * time.Minute)
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	ar, err = client.AcquireTokenByCredential(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ar.Metadata.DegradedMode || !ar.Stale || ar.AccessToken != token {
+		t.Fatalf("expected the token in degraded mode, got %+v", ar)
+	}
+	// the token's extended lifetime has ended
+	now = now.Add(time.Hour)
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err == nil {
+		t.Fatal("expected an error after the token's extended expiry")
+	}
+}
+
+func TestAcquireTokenByTokenExchange(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	idToken := mock.GetIDToken(tenant, fmt.Sprintf("https://%s/%s/v2.0", lmo, tenant))
+	mockClient := mock.Client{}
+	client, err := New("client-id", cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AcquireTokenByTokenExchange(context.Background(), "", TokenTypeJWT, tokenScope); err == nil {
+		t.Fatal("expected an error for an empty subject token")
+	}
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("at", idToken, "", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			for k, v := range map[string]string{
+				"grant_type":         "urn:ietf:params:oauth:grant-type:token-exchange",
+				"subject_token":      "subject",
+				"subject_token_type": TokenTypeJWT,
+				"client_secret":      "secret",
+			} {
+				if actual := r.PostForm.Get(k); actual != v {
+					t.Errorf("expected %s %q, got %q", k, v, actual)
 				}
-				if ar.AccessToken != accessToken {
-					t.Fatal("cached access token should match the one returned by AcquireToken...")
+			}
+		}),
+	)
+	ar, err := client.AcquireTokenByTokenExchange(context.Background(), "subject", TokenTypeJWT, tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" {
+		t.Fatalf("unexpected access token %q", ar.AccessToken)
+	}
+
+	// the client should return the cached access token without sending a request
+	if ar, err = client.AcquireTokenByTokenExchange(context.Background(), "subject", TokenTypeJWT, tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" {
+		t.Fatalf("expected the cached token, got %q", ar.AccessToken)
+	}
+}
+
+func TestAcquireTokenByRefreshToken(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	now := time.Now()
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	idToken := mock.GetIDToken(tenant, fmt.Sprintf("https://%s/%s/v2.0", lmo, tenant))
+	redeemed := ""
+	mockClient := mock.Client{}
+	tokenResponse := func(at, rt string) {
+		mockClient.AppendResponse(
+			mock.WithBody(mock.GetAccessTokenBody(at, idToken, rt, clientInfo, 3600)),
+			mock.WithCallback(func(r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Error(err)
 				}
-				// ...but fail for another tenant
-				if method == "obo" {
-					// OBO sends a token request after silent auth fails
-					mockClient.AppendResponse()
-					if _, err = client.AcquireTokenOnBehalfOf(ctx, "assertion", tokenScope, WithTenantID("not-"+test.tenant)); err == nil {
-						t.Fatal("expected an error")
-					}
-				} else if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithTenantID("not-"+test.tenant)); err == nil {
-					t.Fatal("expected an error")
+				redeemed = r.PostForm.Get("refresh_token")
+			}),
+		)
+	}
+	client, err := New("client-id", cred,
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithClock(func() time.Time { return now }),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	tokenResponse("at", "rotated-rt")
+	ar, err := client.AcquireTokenByRefreshToken(context.Background(), "rt", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" || redeemed != "rt" {
+		t.Fatalf("expected a token for the given refresh token, got %q for %q", ar.AccessToken, redeemed)
+	}
+
+	// the client should return the cached access token without sending a request
+	if ar, err = client.AcquireTokenByRefreshToken(context.Background(), "rt", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" {
+		t.Fatalf("expected the cached token, got %q", ar.AccessToken)
+	}
+
+	// after the access token expires, the client should redeem the rotated refresh token
+	now = now.Add(2 * time.Hour)
+	tokenResponse("at2", "rotated-rt2")
+	if ar, err = client.AcquireTokenByRefreshToken(context.Background(), "rt", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at2" || redeemed != "rotated-rt" {
+		t.Fatalf("expected a token for the rotated refresh token, got %q for %q", ar.AccessToken, redeemed)
+	}
+
+	// when the authority rejects the rotated refresh token, the client should return the error instead of
+	// redeeming the stale refresh token it was given
+	now = now.Add(2 * time.Hour)
+	mockClient.AppendResponse(
+		mock.WithBody([]byte(`{"error":"invalid_grant"}`)),
+		mock.WithHTTPStatus(http.StatusBadRequest),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			redeemed = r.PostForm.Get("refresh_token")
+		}),
+	)
+	if _, err = client.AcquireTokenByRefreshToken(context.Background(), "rt", tokenScope); err == nil {
+		t.Fatal("expected an error")
+	}
+	if redeemed != "rotated-rt2" {
+		t.Fatalf(`expected the client to redeem "rotated-rt2", got %q`, redeemed)
+	}
+
+	// another refresh token's tokens are cached apart
+	tokenResponse("other-at", "")
+	if ar, err = client.AcquireTokenByRefreshToken(context.Background(), "other-rt", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "other-at" || redeemed != "other-rt" {
+		t.Fatalf("expected a token for the other refresh token, got %q for %q", ar.AccessToken, redeemed)
+	}
+
+	if _, err = client.AcquireTokenByRefreshToken(context.Background(), "", tokenScope); err == nil {
+		t.Fatal("expected an error for an empty refresh token")
+	}
+}
+
+func TestTokenEndpointOverride(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	override := "https://private.login.example/tenant/oauth2/v2.0/token"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if actual := r.URL.String(); actual != override {
+				t.Errorf("expected a request to %q, got %q", override, actual)
+			}
+		}),
+	)
+	client, err := New(fakeClientID, cred,
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithAzureRegion("westus"),
+		WithHTTPClient(&mockClient),
+		WithTokenEndpointOverride(override),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	// the cached token should be keyed by the authority's host, not the override's
+	snapshot := client.CacheSnapshot(context.Background())
+	if len(snapshot.Tokens) != 1 || snapshot.Tokens[0].Environment != lmo {
+		t.Fatalf("expected a token cached for %s, got %+v", lmo, snapshot.Tokens)
+	}
+	// the override applies to requests for other tenants too
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, "other")))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if actual := r.URL.String(); actual != override {
+				t.Errorf("expected a request to %q, got %q", override, actual)
+			}
+		}),
+	)
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope, WithTenantID("other")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New(fakeClientID, cred, WithTokenEndpointOverride("http://private.login.example")); err == nil {
+		t.Fatal("expected an error for an override that doesn't use https")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	cred, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody(token, "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			if v := r.PostForm.Get("extra"); v != "value" {
+				t.Errorf("expected the extra parameter, got %q", v)
+			}
+			if v := r.PostForm.Get("grant_type"); v != "client_credentials" {
+				t.Errorf("the interceptor shouldn't replace grant_type, got %q", v)
+			}
+		}),
+	)
+	denied := errors.New("denied")
+	var requests []TokenRequestInfo
+	var responses []TokenResponseInfo
+	client, err := New(fakeClientID, cred,
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithHTTPClient(&mockClient),
+		WithRequestInterceptor(func(ctx context.Context, info *TokenRequestInfo) error {
+			requests = append(requests, *info)
+			for _, s := range info.Scopes {
+				if s == "https://denied/.default" {
+					return denied
 				}
-			})
+			}
+			info.ExtraBodyParameters["extra"] = "value"
+			info.ExtraBodyParameters["grant_type"] = "password"
+			return nil
+		}),
+		WithResponseInterceptor(func(ctx context.Context, info *TokenResponseInfo) {
+			responses = append(responses, *info)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || len(responses) != 1 {
+		t.Fatalf("expected 1 request and 1 response, got %d and %d", len(requests), len(responses))
+	}
+	req, resp := requests[0], responses[0]
+	if req.GrantType != "client_credentials" || req.ClientID != fakeClientID || req.TenantID != tenant {
+		t.Errorf("unexpected request info %+v", req)
+	}
+	if resp.Err != nil || resp.ExpiresOn.IsZero() || resp.Endpoint != req.Endpoint || resp.CorrelationID != req.CorrelationID {
+		t.Errorf("unexpected response info %+v", resp)
+	}
+
+	// the client shouldn't send a request the interceptor denies
+	if _, err = client.AcquireTokenByCredential(context.Background(), []string{"https://denied/.default"}); !errors.Is(err, denied) {
+		t.Fatalf("expected the interceptor's error, got %v", err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("expected no response for a denied request, got %d", len(responses)-1)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert := func(notAfter time.Time) *x509.Certificate {
+		template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notAfter.Add(-24 * time.Hour), NotAfter: notAfter}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
 		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	secret, err := NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
 	}
+	expired := NewCredFromCert(newCert(time.Now().Add(-time.Hour)), key)
+	expiring := NewCredFromCert(newCert(time.Now().Add(time.Hour)), key)
+
+	t.Run("static", func(t *testing.T) {
+		cred, err := NewCredChain(expired, expiring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the client shouldn't send requests without a probe scope
+		client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mock.Client{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := client.Validate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Healthy() || len(d.Credentials) != 2 {
+			t.Fatalf("unexpected diagnostics %+v", d)
+		}
+		if c := d.Credentials[0]; c.Type != "certificate" || c.Err == nil || c.Probed {
+			t.Errorf("unexpected diagnostics for the expired certificate: %+v", c)
+		}
+		if c := d.Credentials[1]; c.Err != nil || len(c.Warnings) != 1 || c.CertificateThumbprint == "" {
+			t.Errorf("unexpected diagnostics for the expiring certificate: %+v", c)
+		}
+
+		client, err = New(fakeClientID, expired, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mock.Client{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d, err = client.Validate(context.Background()); err == nil || d.Healthy() {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		// by the client's clock, the certificate has expired
+		client, err := New(fakeClientID, expiring,
+			WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+			WithClock(func() time.Time { return time.Now().Add(2 * time.Hour) }),
+			WithHTTPClient(&mock.Client{}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d, err := client.Validate(context.Background()); err == nil || d.Healthy() {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("probe", func(t *testing.T) {
+		cred, err := NewCredChain(secret, expiring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockClient := mock.Client{}
+		client, err := New(fakeClientID, cred, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the authority rejects the secret and accepts the certificate
+		mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+		mockClient.AppendResponse(
+			mock.WithBody([]byte(`{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided."}`)),
+			mock.WithHTTPStatus(http.StatusUnauthorized),
+		)
+		mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)))
+		d, err := client.Validate(context.Background(), WithProbeScope(tokenScope[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.ProbeScope != tokenScope[0] || len(d.Credentials) != 2 {
+			t.Fatalf("unexpected diagnostics %+v", d)
+		}
+		if c := d.Credentials[0]; c.Type != "secret" || !c.Probed || c.Err == nil {
+			t.Errorf("unexpected diagnostics for the secret: %+v", c)
+		}
+		if c := d.Credentials[1]; c.Type != "certificate" || !c.Probed || c.Err != nil {
+			t.Errorf("unexpected diagnostics for the certificate: %+v", c)
+		}
+		// Validate shouldn't cache the probe's token
+		mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("token", "", "", "", 3600)))
+		if ar, err := client.AcquireTokenByCredential(context.Background(), tokenScope); err != nil {
+			t.Fatal(err)
+		} else if ar.AccessToken != "token" {
+			t.Fatalf("expected a new token, got %q", ar.AccessToken)
+		}
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		client, err := New(fakeClientID, secret, WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mock.Client{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Validate(context.Background(), WithProbeScope("scope")); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
 }
 
-func TestWithLoginHint(t *testing.T) {
-	upn := "user@localhost"
-	cred, err := NewCredFromSecret("...")
+// testLogger is a Logger recording entries
+type testLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprintf(format, args...))
+}
+
+func TestCertificateExpiryWarning(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		t.Fatal(err)
 	}
-	client, err := New("client-id", cred, WithHTTPClient(&errorClient{}))
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		t.Fatal(err)
 	}
-	client.base.Token.Resolver = &fake.ResolveEndpoints{}
-	for _, expectHint := range []bool{true, false} {
-		t.Run(fmt.Sprint(expectHint), func(t *testing.T) {
-			opts := []AuthCodeURLOption{}
-			if expectHint {
-				opts = append(opts, WithLoginHint(upn))
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := NewCredFromCert(cert, key)
+	for _, test := range []struct {
+		desc     string
+		window   time.Duration
+		callback bool
+		warnings int
+	}{
+		{desc: "callback", window: 48 * time.Hour, callback: true, warnings: 2},
+		{desc: "logger", window: 48 * time.Hour, warnings: 2},
+		{desc: "outside window", window: time.Hour, callback: true},
+		{desc: "disabled", callback: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var warned []*x509.Certificate
+			var warn func(*x509.Certificate)
+			if test.callback {
+				warn = func(c *x509.Certificate) { warned = append(warned, c) }
 			}
-			u, err := client.AuthCodeURL(context.Background(), "id", "https://localhost", tokenScope, opts...)
+			logger := &testLogger{}
+			mockClient := mock.Client{}
+			client, err := New(fakeClientID, cred,
+				WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+				WithCertificateExpiryWarning(test.window, warn),
+				WithHTTPClient(&mockClient),
+				WithLogger(logger),
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
-			parsed, err := url.Parse(u)
-			if err != nil {
-				t.Fatal(err)
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			for _, scope := range []string{tokenScope[0], "https://other/.default"} {
+				mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)))
+				if _, err := client.AcquireTokenByCredential(context.Background(), []string{scope}); err != nil {
+					t.Fatal(err)
+				}
 			}
-			if !parsed.Query().Has("login_hint") {
-				if !expectHint {
-					return
+			actual := len(logger.entries)
+			if test.callback {
+				if actual != 0 {
+					t.Fatalf("expected no log entries, got %v", logger.entries)
+				}
+				actual = len(warned)
+				for _, c := range warned {
+					if c != cert {
+						t.Fatal("callback received the wrong certificate")
+					}
+				}
+			} else {
+				for _, entry := range logger.entries {
+					if !strings.Contains(entry, cert.NotAfter.Format(time.RFC3339)) {
+						t.Fatalf("log entry %q doesn't include the expiry", entry)
+					}
 				}
-				t.Fatal("expected a login hint")
-			} else if !expectHint {
-				t.Fatal("expected no login hint")
 			}
-			if actual := parsed.Query()["login_hint"]; len(actual) != 1 || actual[0] != upn {
-				t.Fatalf(`unexpected login_hint "%v"`, actual)
+			if actual != test.warnings {
+				t.Fatalf("expected %d warnings, got %d", test.warnings, actual)
 			}
 		})
 	}
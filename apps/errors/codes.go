@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Microsoft Entra error codes (AADSTS codes) for conditions applications commonly alert on. A token request
+// error can have several codes, which ErrorCodes returns.
+const (
+	// CodeInvalidClientSecret (AADSTS7000215) indicates the client secret is wrong.
+	CodeInvalidClientSecret = 7000215
+	// CodeExpiredClientSecret (AADSTS7000222) indicates the client secret has expired.
+	CodeExpiredClientSecret = 7000222
+	// CodeConditionalAccessBlocked (AADSTS53003) indicates a Conditional Access policy blocked access.
+	CodeConditionalAccessBlocked = 53003
+	// CodeMFARequired (AADSTS50076) indicates the user must complete multi-factor authentication.
+	CodeMFARequired = 50076
+)
+
+// ErrorCodes returns the error codes of the authority's response in err's chain, or nil when err's chain
+// has no CallErr or the response has no error codes.
+func ErrorCodes(err error) []int {
+	var callErr CallErr
+	if !As(err, &callErr) || callErr.Resp == nil || callErr.Resp.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(callErr.Resp.Body)
+	// restore the body so the response remains readable
+	callErr.Resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var resp struct {
+		ErrorCodes []int `json:"error_codes"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return nil
+	}
+	return resp.ErrorCodes
+}
+
+// HasErrorCode returns true when the authority's response in err's chain has error code code.
+func HasErrorCode(err error, code int) bool {
+	for _, c := range ErrorCodes(err) {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInvalidClientSecret returns true when the authority rejected a request because its client secret is wrong.
+func IsInvalidClientSecret(err error) bool {
+	return HasErrorCode(err, CodeInvalidClientSecret)
+}
+
+// IsExpiredSecret returns true when the authority rejected a request because its client secret has expired.
+func IsExpiredSecret(err error) bool {
+	return HasErrorCode(err, CodeExpiredClientSecret)
+}
+
+// IsConditionalAccessBlocked returns true when a Conditional Access policy blocked a request.
+func IsConditionalAccessBlocked(err error) bool {
+	return HasErrorCode(err, CodeConditionalAccessBlocked)
+}
+
+// IsMFARequired returns true when the authority requires the user to complete multi-factor authentication.
+func IsMFARequired(err error) bool {
+	return HasErrorCode(err, CodeMFARequired)
+}
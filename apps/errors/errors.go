@@ -6,29 +6,12 @@ package errors
 import (
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"reflect"
+	"net/http/httputil"
 	"strings"
-
-	"github.com/kylelemons/godebug/pretty"
+	"time"
 )
 
-var prettyConf = &pretty.Config{
-	IncludeUnexported: false,
-	SkipZeroFields:    true,
-	TrackCycles:       true,
-	Formatter: map[reflect.Type]interface{}{
-		reflect.TypeOf((*io.Reader)(nil)).Elem(): func(r io.Reader) string {
-			b, err := io.ReadAll(r)
-			if err != nil {
-				return "could not read io.Reader content"
-			}
-			return string(b)
-		},
-	},
-}
-
 type verboser interface {
 	Verbose() string
 }
@@ -57,11 +40,23 @@ func New(text string) error {
 
 // CallErr represents an HTTP call error. Has a Verbose() method that allows getting the
 // http.Request and Response objects. Implements error.
+//
+// When the error response came from the STS, CallErr has the identifiers Microsoft support needs to
+// investigate the failure, so a support request doesn't require verbose HTTP logging.
 type CallErr struct {
 	Req *http.Request
 	// Resp contains response body
 	Resp *http.Response
 	Err  error
+
+	// TraceID is the STS trace ID of the failed request.
+	TraceID string
+	// CorrelationID is the correlation ID of the failed request.
+	CorrelationID string
+	// Timestamp is the time the STS reported the error, in the STS's format.
+	Timestamp string
+	// ErrorURI is the STS's link to information about the error.
+	ErrorURI string
 }
 
 // Errors implements error.Error().
@@ -71,9 +66,151 @@ func (e CallErr) Error() string {
 
 // Verbose prints a versbose error message with the request or response.
 func (e CallErr) Verbose() string {
-	e.Resp.Request = nil // This brings in a bunch of TLS crap we don't need
-	e.Resp.TLS = nil     // Same
-	return fmt.Sprintf("%s:\nRequest:\n%s\nResponse:\n%s", e.Err, prettyConf.Sprint(e.Req), prettyConf.Sprint(e.Resp))
+	return fmt.Sprintf("%s:\nRequest:\n%s\nResponse:\n%s", e.Err, dump(e.Req), dump(e.Resp))
+}
+
+// dump returns the wire representation of an *http.Request's headers or an *http.Response's headers and body.
+// It doesn't include a request's body because the client sent, and so consumed, it.
+func dump(v interface{}) string {
+	var b []byte
+	var err error
+	switch t := v.(type) {
+	case *http.Request:
+		if t == nil {
+			return "<nil>"
+		}
+		b, err = httputil.DumpRequest(t, false)
+	case *http.Response:
+		if t == nil {
+			return "<nil>"
+		}
+		b, err = httputil.DumpResponse(t, t.Body != nil)
+	}
+	if err != nil {
+		return fmt.Sprintf("couldn't dump %T: %v", v, err)
+	}
+	return string(b)
+}
+
+// PartialConsentError is returned by token acquisition methods when the authority granted only some of the
+// requested scopes, for example because the user declined consent to the others. The method also returns an
+// AuthResult whose access token is valid for the granted scopes, so the application can continue with reduced
+// functionality instead of failing when it later uses the token for a declined scope.
+type PartialConsentError struct {
+	// GrantedScopes are the scopes the access token is valid for.
+	GrantedScopes []string
+	// DeclinedScopes are the requested scopes the authority didn't grant.
+	DeclinedScopes []string
+}
+
+// Error implements error.Error().
+func (e PartialConsentError) Error() string {
+	return fmt.Sprintf("token response failed because declined scopes are present: %s", strings.Join(e.DeclinedScopes, ","))
+}
+
+// PolicyError is returned by token acquisition methods when the client's allow-lists don't allow a request's tenant
+// or scopes. The client returns this error before sending any request for the token.
+type PolicyError struct {
+	// Tenant is the requested tenant when the client doesn't allow it.
+	Tenant string
+	// Scope is the first requested scope the client doesn't allow, when the client allows the tenant.
+	Scope string
+}
+
+// Error implements error.Error().
+func (e PolicyError) Error() string {
+	if e.Tenant != "" {
+		return fmt.Sprintf("policy doesn't allow requests to tenant %q", e.Tenant)
+	}
+	return fmt.Sprintf("policy doesn't allow requests for scope %q", e.Scope)
+}
+
+// InvalidTenantError is returned by token acquisition methods when a tenant specified with WithTenantID can't be
+// used with the client's authority. A specific tenant (a tenant ID or domain) is valid when the client's
+// authority is a Microsoft Entra authority whose tenant is "common", "organizations" or another specific tenant.
+// Any other combination is invalid:
+//   - a meta-tenant ("common", "consumers" or "organizations") given to WithTenantID
+//   - any tenant given to a client whose authority doesn't support tenants, such as ADFS
+//   - any tenant given to a client whose authority is "consumers"
+type InvalidTenantError struct {
+	// Tenant is the tenant given to WithTenantID.
+	Tenant string
+	// AuthorityTenant is the tenant of the client's authority.
+	AuthorityTenant string
+	// Reason explains why the combination is invalid.
+	Reason string
+}
+
+// Error implements error.Error().
+func (e InvalidTenantError) Error() string {
+	return fmt.Sprintf("can't use tenant %q with authority tenant %q: %s", e.Tenant, e.AuthorityTenant, e.Reason)
+}
+
+// RedirectURIError is returned when a redirect URI is malformed or, for a client configured with redirect URIs,
+// isn't one of them. A redirect URI must be absolute and have no fragment. It may use https, http with a
+// loopback host such as localhost, or a custom scheme such as a mobile app's. New returns this error for a
+// malformed configured redirect URI, and methods return it before sending any request.
+type RedirectURIError struct {
+	// URI is the invalid redirect URI.
+	URI string
+	// Reason explains why the redirect URI is invalid.
+	Reason string
+}
+
+// Error implements error.Error().
+func (e RedirectURIError) Error() string {
+	return fmt.Sprintf("invalid redirect URI %q: %s", e.URI, e.Reason)
+}
+
+// RateLimitError is returned by token acquisition methods when the client's token request rate limit doesn't
+// allow a request within the configured maximum delay. The client returns this error before sending the request.
+type RateLimitError struct {
+	// Host is the authority host to which the client would have sent the request.
+	Host string
+	// RetryAfter is how long the caller would have had to wait for the rate limit to allow the request.
+	RetryAfter time.Duration
+}
+
+// Error implements error.Error().
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("token request rate limit exceeded for %s; retry after %v", e.Host, e.RetryAfter)
+}
+
+// ScopeError is returned by AcquireTokenByCredential when the requested scopes aren't valid for the client
+// credentials grant. A Microsoft Entra authority requires a client credentials request to have exactly one scope,
+// the "/.default" scope of the resource, such as "https://graph.microsoft.com/.default", and rejects any other
+// scopes with an AADSTS1002012 error. The client returns this error before sending the request.
+type ScopeError struct {
+	// Scopes are the requested scopes.
+	Scopes []string
+	// Reason explains why the scopes are invalid.
+	Reason string
+}
+
+// Error implements error.Error().
+func (e ScopeError) Error() string {
+	return fmt.Sprintf(`invalid client credentials scopes %q: %s. Request the "/.default" scope of one resource, for example "https://graph.microsoft.com/.default"`, e.Scopes, e.Reason)
+}
+
+// OptionsError is returned by the constructors of clients when the options have more than one problem. It reports
+// all of them, so an application can fix its configuration at once. A constructor returns a single problem as is.
+type OptionsError struct {
+	// Errs are the problems, in the order the constructor found them.
+	Errs []error
+}
+
+// Error implements error.Error().
+func (e OptionsError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems with the client's options:\n\t%s", len(e.Errs), strings.Join(msgs, "\n\t"))
+}
+
+// Unwrap returns the problems, so errors.Is and errors.As match any of them.
+func (e OptionsError) Unwrap() []error {
+	return e.Errs
 }
 
 // Is reports whether any error in errors chain matches target.
@@ -4,23 +4,37 @@
 package base
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"errors"
+	"crypto/subtle"
+	stdJSON "encoding/json"
 	"fmt"
+	"io"
 	"net/url"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/base/internal/storage"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
 )
 
+// gzipMagic begins gzip data
+var gzipMagic = []byte{0x1f, 0x8b}
+
 const (
+	// cacheNamespaces is the member of the serialized cache that holds the data of clients having a namespace
+	cacheNamespaces = "Namespaces"
+
 	// AuthorityPublicCloud is the default AAD authority host
 	AuthorityPublicCloud = "https://login.microsoftonline.com/common"
 	scopeSeparator       = " "
@@ -34,6 +48,10 @@ type manager interface {
 	AllAccounts() []shared.Account
 	Account(homeAccountID string) shared.Account
 	RemoveAccount(account shared.Account, clientID string)
+	AccountsByIDToken(match func(accesstokens.IDToken) bool) []shared.Account
+	Snapshot() storage.Snapshot
+	Stats() storage.Stats
+	Warmup(ctx context.Context, authParameters authority.AuthParams) error
 }
 
 // partitionedManager provides an internal cache. It is defined to allow faking the cache in tests.
@@ -41,6 +59,179 @@ type manager interface {
 type partitionedManager interface {
 	Read(ctx context.Context, authParameters authority.AuthParams) (storage.TokenResponse, error)
 	Write(authParameters authority.AuthParams, tokenResponse accesstokens.TokenResponse) (shared.Account, error)
+	Snapshot() storage.Snapshot
+	Stats() storage.Stats
+	Warmup(ctx context.Context, authParameters authority.AuthParams) error
+}
+
+// partitioner is implemented by caches that can serialize the part of their data stored under a key.
+// In all production use it's a *storage.Manager or *storage.PartitionedManager.
+type partitioner interface {
+	MarshalPartition(key string) ([]byte, error)
+	MarshalPartitionTo(key string, w io.Writer) error
+	UnmarshalPartition(key string, b []byte) error
+}
+
+// partitionView serializes the part of a cache stored under key
+type partitionView struct {
+	p   partitioner
+	key string
+}
+
+func (v partitionView) Marshal() ([]byte, error) {
+	return v.p.MarshalPartition(v.key)
+}
+
+func (v partitionView) MarshalTo(w io.Writer) error {
+	return v.p.MarshalPartitionTo(v.key, w)
+}
+
+func (v partitionView) Unmarshal(b []byte) error {
+	return v.p.UnmarshalPartition(v.key, b)
+}
+
+func (v partitionView) UnmarshalFrom(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return v.p.UnmarshalPartition(v.key, b)
+}
+
+// partitionAccessor passes an ExportReplace views of the cache partition for each key, so that each key's
+// data contains only that partition. It passes the entire cache when there's no key.
+type partitionAccessor struct {
+	cache.ExportReplace
+}
+
+func (a partitionAccessor) Replace(c cache.Unmarshaler, key string) {
+	if p, ok := c.(partitioner); ok && key != "" {
+		c = partitionView{p: p, key: key}
+	}
+	a.ExportReplace.Replace(c, key)
+}
+
+func (a partitionAccessor) Export(c cache.Marshaler, key string) {
+	if p, ok := c.(partitioner); ok && key != "" {
+		c = partitionView{p: p, key: key}
+	}
+	a.ExportReplace.Export(c, key)
+}
+
+// namespaceAccessor isolates the data of clients having different cache namespaces that share an ExportReplace. The
+// serialized cache holds each namespace's data in a member of its "Namespaces" member, which clients having another
+// namespace or none preserve, because they keep members of the cache they don't recognize. The accessor also
+// prefixes the keys it passes to the ExportReplace with the namespace.
+type namespaceAccessor struct {
+	cache.ExportReplace
+	namespace string
+
+	mu sync.Mutex
+	// shared holds the serialized cache the ExportReplace most recently replaced the client's cache with, per key.
+	// Exports write the client's data into it, preserving the data of other namespaces.
+	shared map[string]map[string]stdJSON.RawMessage
+}
+
+func newNamespaceAccessor(ca cache.ExportReplace, namespace string) *namespaceAccessor {
+	return &namespaceAccessor{ExportReplace: ca, namespace: namespace, shared: map[string]map[string]stdJSON.RawMessage{}}
+}
+
+func (a *namespaceAccessor) Replace(c cache.Unmarshaler, key string) {
+	a.ExportReplace.Replace(namespaceView{a: a, key: key, u: c}, a.key(key))
+}
+
+func (a *namespaceAccessor) Export(c cache.Marshaler, key string) {
+	a.ExportReplace.Export(namespaceView{a: a, key: key, m: c}, a.key(key))
+}
+
+func (a *namespaceAccessor) key(key string) string {
+	if key == "" {
+		return ""
+	}
+	return a.namespace + "-" + key
+}
+
+// namespaceView serializes a client's cache within the cache shared by all namespaces
+type namespaceView struct {
+	a   *namespaceAccessor
+	key string
+	m   cache.Marshaler
+	u   cache.Unmarshaler
+}
+
+func (v namespaceView) Marshal() ([]byte, error) {
+	b, err := v.m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var data stdJSON.RawMessage = b
+	if !stdJSON.Valid(b) {
+		// the client compresses its cache, so store it as a base64 string
+		if data, err = stdJSON.Marshal(b); err != nil {
+			return nil, err
+		}
+	}
+	v.a.mu.Lock()
+	defer v.a.mu.Unlock()
+	shared := v.a.shared[v.key]
+	if shared == nil {
+		shared = map[string]stdJSON.RawMessage{}
+	}
+	namespaces := map[string]stdJSON.RawMessage{}
+	if raw, ok := shared[cacheNamespaces]; ok {
+		if err := stdJSON.Unmarshal(raw, &namespaces); err != nil {
+			return nil, fmt.Errorf("couldn't decode the cache's namespaces: %w", err)
+		}
+	}
+	namespaces[v.a.namespace] = data
+	out := make(map[string]stdJSON.RawMessage, len(shared)+1)
+	for k, m := range shared {
+		out[k] = m
+	}
+	if out[cacheNamespaces], err = stdJSON.Marshal(namespaces); err != nil {
+		return nil, err
+	}
+	return stdJSON.Marshal(out)
+}
+
+func (v namespaceView) Unmarshal(b []byte) error {
+	if bytes.HasPrefix(b, gzipMagic) {
+		// a client having no namespace compresses the cache
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		if b, err = io.ReadAll(zr); err != nil {
+			return err
+		}
+	}
+	shared := map[string]stdJSON.RawMessage{}
+	if len(b) > 0 {
+		if err := stdJSON.Unmarshal(b, &shared); err != nil {
+			return err
+		}
+	}
+	data := []byte("{}")
+	if raw, ok := shared[cacheNamespaces]; ok {
+		namespaces := map[string]stdJSON.RawMessage{}
+		if err := stdJSON.Unmarshal(raw, &namespaces); err != nil {
+			return fmt.Errorf("couldn't decode the cache's namespaces: %w", err)
+		}
+		if ns, ok := namespaces[v.a.namespace]; ok {
+			data = ns
+			if bytes.HasPrefix(ns, []byte(`"`)) {
+				// compressed data is a base64 string
+				if err := stdJSON.Unmarshal(ns, &data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	v.a.mu.Lock()
+	v.a.shared[v.key] = shared
+	v.a.mu.Unlock()
+	return v.u.Unmarshal(data)
 }
 
 type noopCacheAccessor struct{}
@@ -48,6 +239,13 @@ type noopCacheAccessor struct{}
 func (n noopCacheAccessor) Replace(cache cache.Unmarshaler, key string) {}
 func (n noopCacheAccessor) Export(cache cache.Marshaler, key string)    {}
 
+// readOnlyAccessor reads from the wrapped accessor and never exports to it
+type readOnlyAccessor struct {
+	cache.ExportReplace
+}
+
+func (a readOnlyAccessor) Export(cache.Marshaler, string) {}
+
 // AcquireTokenSilentParameters contains the parameters to acquire a token silently (from cache).
 type AcquireTokenSilentParameters struct {
 	Scopes            []string
@@ -58,6 +256,11 @@ type AcquireTokenSilentParameters struct {
 	TenantID          string
 	UserAssertion     string
 	AuthorizationType authority.AuthorizeType
+	// Claims are sent in any token request. When set, AcquireTokenSilent doesn't return a cached
+	// access token, because it wasn't issued with these claims.
+	Claims string
+	// AuthnScheme, when not nil, determines the type of access token to return
+	AuthnScheme authority.AuthenticationScheme
 }
 
 // AcquireTokenAuthCodeParameters contains the parameters required to acquire an access token using the auth code flow.
@@ -72,6 +275,10 @@ type AcquireTokenAuthCodeParameters struct {
 	AppType     accesstokens.AppType
 	Credential  *accesstokens.Credential
 	TenantID    string
+	Claims      string
+	// Nonce, when not empty, must match the nonce claim of the ID token the authority returns
+	Nonce         string
+	IDTokenPolicy authority.IDTokenPolicy
 }
 
 type AcquireTokenOnBehalfOfParameters struct {
@@ -79,6 +286,34 @@ type AcquireTokenOnBehalfOfParameters struct {
 	Credential    *accesstokens.Credential
 	TenantID      string
 	UserAssertion string
+	Claims        string
+	IDTokenPolicy authority.IDTokenPolicy
+}
+
+type AcquireTokenByTokenExchangeParameters struct {
+	Scopes           []string
+	Credential       *accesstokens.Credential
+	TenantID         string
+	SubjectToken     string
+	SubjectTokenType string
+	Claims           string
+}
+
+type AcquireTokenSSHCertParameters struct {
+	Account  shared.Account
+	Scopes   []string
+	TenantID string
+	Claims   string
+	KeyID    string
+	ReqCnf   string
+}
+
+type AcquireTokenByRefreshTokenParameters struct {
+	Scopes       []string
+	Credential   *accesstokens.Credential
+	TenantID     string
+	RefreshToken string
+	Claims       string
 }
 
 // AuthResult contains the results of one token acquisition operation in PublicClientApplication
@@ -90,14 +325,60 @@ type AuthResult struct {
 	ExpiresOn      time.Time
 	GrantedScopes  []string
 	DeclinedScopes []string
+	// Stale is true when the access token has expired, or is about to. The client returns such a token only
+	// when configured to allow stale tokens and the authority is unavailable.
+	Stale bool
+	// TenantID is the tenant that issued the tokens. For a guest account, this is the tenant in which the account
+	// is a guest when the request specified that tenant, rather than the account's home tenant.
+	TenantID string
+	// TokenType is the access token's type, for example "Bearer" or "pop".
+	TokenType string
+	// RefreshTokenExpiresOn is when the account's refresh token expires. It's zero when the authority
+	// didn't say, or there is no refresh token.
+	RefreshTokenExpiresOn time.Time
+	// Metadata describes how the client got the result.
+	Metadata AuthResultMetadata
+	// RawResponse is the authority's decoded token response, including fields AuthResult doesn't model such as
+	// ext_expires_in and spa_code. It omits the refresh token, which the client manages. It's nil for a result
+	// from the cache.
+	RawResponse map[string]interface{}
+	// AuthorizationResponse holds the parameters of the authorization response that redirected the user to the
+	// application, such as session_state and cloud_instance_name, for a result of interactive authentication. It
+	// omits the authorization code, which the client redeemed. It's nil for other results.
+	AuthorizationResponse url.Values
+	// RefreshToken is the account's refresh token. It's empty unless the client is a confidential client
+	// configured to return refresh tokens, and when the result has no account.
+	RefreshToken string
+}
+
+// AuthResultMetadata describes how the client got an AuthResult.
+type AuthResultMetadata struct {
+	// DegradedMode is true when the authority is unavailable and the client returned an expired access token
+	// that's within its extended lifetime. Resources that accept the token do so only while they can't reach
+	// the authority either, so an application should retry a rejected request later.
+	DegradedMode bool
+	// ScopeWarnings describe how the client normalized the requested scopes, for example by removing a duplicate
+	// or a reserved scope such as "openid", which the client adds to requests as the flow requires. The client
+	// requested, and cached the token for, the normalized scopes.
+	ScopeWarnings []string
 }
 
 // AuthResultFromStorage creates an AuthResult from a storage token response (which is generated from the cache).
 func AuthResultFromStorage(storageTokenResponse storage.TokenResponse) (AuthResult, error) {
-	if err := storageTokenResponse.AccessToken.Validate(); err != nil {
+	return authResultFromStorage(storageTokenResponse, time.Now(), 0)
+}
+
+// authResultFromStorage is AuthResultFromStorage for a client whose clock reads now and may differ
+// from the authority's by up to skew
+func authResultFromStorage(storageTokenResponse storage.TokenResponse, now time.Time, skew time.Duration) (AuthResult, error) {
+	if err := storageTokenResponse.AccessToken.ValidateAt(now, skew); err != nil {
 		return AuthResult{}, fmt.Errorf("problem with access token in StorageTokenResponse: %w", err)
 	}
+	return storageAuthResult(storageTokenResponse)
+}
 
+// storageAuthResult creates an AuthResult from a storage token response without validating its access token
+func storageAuthResult(storageTokenResponse storage.TokenResponse) (AuthResult, error) {
 	account := storageTokenResponse.Account
 	accessToken := storageTokenResponse.AccessToken.Secret
 	grantedScopes := strings.Split(storageTokenResponse.AccessToken.Scopes, scopeSeparator)
@@ -110,21 +391,119 @@ func AuthResultFromStorage(storageTokenResponse storage.TokenResponse) (AuthResu
 			return AuthResult{}, fmt.Errorf("problem decoding JWT token: %w", err)
 		}
 	}
-	return AuthResult{account, idToken, accessToken, storageTokenResponse.AccessToken.ExpiresOn.T, grantedScopes, nil}, nil
+	return AuthResult{
+		Account:       account,
+		IDToken:       idToken,
+		AccessToken:   accessToken,
+		ExpiresOn:     storageTokenResponse.AccessToken.ExpiresOn.T,
+		GrantedScopes: grantedScopes,
+		TenantID:      issuingTenant(idToken, storageTokenResponse.AccessToken.Realm),
+		TokenType:     tokenType(storageTokenResponse.AccessToken),
+
+		RefreshTokenExpiresOn: storageTokenResponse.RefreshToken.ExpiresOn.T,
+	}, nil
+}
+
+// tokenType returns the type of a cached access token
+func tokenType(at storage.AccessToken) string {
+	if at.IsBearer() {
+		return authority.AccessTokenTypeBearer
+	}
+	return at.TokenType
 }
 
-// NewAuthResult creates an AuthResult.
-func NewAuthResult(tokenResponse accesstokens.TokenResponse, account shared.Account) (AuthResult, error) {
-	if len(tokenResponse.DeclinedScopes) > 0 {
-		return AuthResult{}, fmt.Errorf("token response failed because declined scopes are present: %s", strings.Join(tokenResponse.DeclinedScopes, ","))
+// formatResult formats the access token of ar, the result of a token request that returned err, for
+// authParams' authentication scheme. It returns err when formatting succeeds, because a PartialConsentError
+// accompanies a usable result.
+func formatResult(ar AuthResult, err error, authParams authority.AuthParams) (AuthResult, error) {
+	ar, fmtErr := formatAccessToken(ar, authParams)
+	if fmtErr != nil {
+		return AuthResult{}, fmtErr
 	}
-	return AuthResult{
+	return ar, err
+}
+
+// formatAccessToken formats the access token of ar for authParams' authentication scheme and adds any warnings
+// about the requested scopes to its metadata
+func formatAccessToken(ar AuthResult, authParams authority.AuthParams) (AuthResult, error) {
+	ar.Metadata.ScopeWarnings = authParams.ScopeWarnings()
+	if authParams.AuthnScheme == nil {
+		return ar, nil
+	}
+	at, err := authParams.AuthnScheme.FormatAccessToken(ar.AccessToken)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	ar.AccessToken = at
+	return ar, nil
+}
+
+// issuingTenant returns the tenant that issued idToken or, when idToken doesn't say, realm
+func issuingTenant(idToken accesstokens.IDToken, realm string) string {
+	if idToken.TenantID != "" {
+		return idToken.TenantID
+	}
+	return realm
+}
+
+// NewAuthResult creates an AuthResult. When the token response declines some of the requested scopes,
+// it returns the AuthResult with an errors.PartialConsentError.
+func NewAuthResult(tokenResponse accesstokens.TokenResponse, account shared.Account) (AuthResult, error) {
+	ar := AuthResult{
 		Account:       account,
 		IDToken:       tokenResponse.IDToken,
 		AccessToken:   tokenResponse.AccessToken,
 		ExpiresOn:     tokenResponse.ExpiresOn.T,
 		GrantedScopes: tokenResponse.GrantedScopes.Slice,
-	}, nil
+		TenantID:      issuingTenant(tokenResponse.IDToken, account.Realm),
+		TokenType:     tokenResponse.TokenType,
+
+		RefreshTokenExpiresOn: tokenResponse.RefreshTokenExpiresOn.T,
+		RawResponse:           tokenResponse.RawResponse,
+	}
+	if len(tokenResponse.DeclinedScopes) > 0 {
+		ar.DeclinedScopes = tokenResponse.DeclinedScopes
+		return ar, errors.PartialConsentError{GrantedScopes: ar.GrantedScopes, DeclinedScopes: ar.DeclinedScopes}
+	}
+	return ar, nil
+}
+
+// errReadOnlyCache is returned by AcquireTokenSilent when a client having a read-only cache would have to refresh a token
+var errReadOnlyCache = errors.New("the client's cache is read-only and has no valid access token for the request, so the client can't refresh one")
+
+// errCacheDisabled is returned by AcquireTokenSilent when the client's cache is disabled
+var errCacheDisabled = errors.New("the client's cache is disabled, so it has no tokens to return silently")
+
+// userInfoScopes are the scopes of access tokens for the Microsoft identity platform's userinfo endpoint,
+// which Microsoft Graph hosts. Scopes without a resource identifier are for Graph.
+var userInfoScopes = []string{"User.Read"}
+
+// AuthorityMetadata is the OpenID Connect metadata of an authority, from its openid-configuration document.
+// Fields the authority doesn't advertise are empty. AuthorizationEndpoint and TokenEndpoint are those the client
+// uses, which differ from the document's when the client overrides them.
+type AuthorityMetadata struct {
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	EndSessionEndpoint    string
+	JWKSURI               string
+	UserInfoEndpoint      string
+	Issuer                string
+	ClaimsSupported       []string
+}
+
+// ResolvedEndpoints are the endpoints a client uses with an authority, after discovery and overrides.
+// Endpoints the client doesn't use are empty. Hosts are the sorted, distinct hosts of all the endpoints.
+type ResolvedEndpoints struct {
+	AuthorizationEndpoint       string
+	TokenEndpoint               string
+	DeviceCodeEndpoint          string
+	EndSessionEndpoint          string
+	JWKSURI                     string
+	UserInfoEndpoint            string
+	InstanceDiscoveryEndpoint   string
+	OpenIDConfigurationEndpoint string
+	RegionDiscoveryEndpoint     string
+	Hosts                       []string
 }
 
 // Client is a base client that provides access to common methods and primatives that
@@ -136,6 +515,23 @@ type Client struct {
 
 	AuthParams    authority.AuthParams // DO NOT EVER MAKE THIS A POINTER! See "Note" in New().
 	cacheAccessor cache.ExportReplace
+	// partitionedExport directs New to wrap the cache accessor in a partitionAccessor
+	partitionedExport bool
+	// cacheNamespace directs New to wrap the cache accessor in a namespaceAccessor when it isn't empty
+	cacheNamespace string
+	// returnRefreshToken directs the client to include refresh tokens in results
+	returnRefreshToken bool
+	// cacheDisabled directs the client to neither store nor read tokens and accounts
+	cacheDisabled bool
+	// readOnlyCache directs the client to read its cache accessor's data but never change it
+	readOnlyCache bool
+	// refreshNeeded is called when a read-only cache has no valid access token for a silent request
+	refreshNeeded func(shared.Account, []string)
+	// normalizeUsername, when not nil, normalizes usernames before AccountsByUsername compares them
+	normalizeUsername func(string) string
+	// rtExpiryWarning is called for results whose refresh token expires within rtExpiryWindow
+	rtExpiryWarning func(shared.Account, time.Time)
+	rtExpiryWindow  time.Duration
 }
 
 // Option is an optional argument to the New constructor.
@@ -150,6 +546,81 @@ func WithCacheAccessor(ca cache.ExportReplace) Option {
 	}
 }
 
+// WithCacheEncrypter encrypts the secrets of tokens in the client's in-memory cache with e.
+func WithCacheEncrypter(e cache.Encrypter) Option {
+	return func(c *Client) {
+		if e == nil {
+			return
+		}
+		for _, m := range []interface{}{c.manager, c.pmanager} {
+			if s, ok := m.(interface{ SetEncrypter(cache.Encrypter) }); ok {
+				s.SetEncrypter(e)
+			}
+		}
+	}
+}
+
+// WithCacheCompression gzips the data the client passes to its cache accessor.
+func WithCacheCompression(enabled bool) Option {
+	return func(c *Client) {
+		for _, m := range []interface{}{c.manager, c.pmanager} {
+			if s, ok := m.(interface{ SetCompression(bool) }); ok {
+				s.SetCompression(enabled)
+			}
+		}
+	}
+}
+
+// WithPartitionedCacheExport passes the cache accessor only the part of the cache stored under the key it
+// receives, rather than the entire cache.
+func WithPartitionedCacheExport(enabled bool) Option {
+	return func(c *Client) {
+		c.partitionedExport = enabled
+	}
+}
+
+// WithCacheNamespace isolates the client's cache data from that of clients having another namespace, or none,
+// which share its cache accessor.
+func WithCacheNamespace(namespace string) Option {
+	return func(c *Client) {
+		c.cacheNamespace = namespace
+	}
+}
+
+// WithCacheDisabled directs the client to keep no tokens or accounts, so that every token acquisition except
+// AcquireTokenSilent sends a request to the authority.
+func WithCacheDisabled(disabled bool) Option {
+	return func(c *Client) {
+		c.cacheDisabled = disabled
+	}
+}
+
+// WithReadOnlyCache directs the client to read tokens from its cache accessor but never write, export or remove
+// them, as when another process maintains a cache the client shares. Instead of refreshing a token, the client
+// calls refreshNeeded, when it isn't nil, with the account and scopes of the request.
+func WithReadOnlyCache(enabled bool, refreshNeeded func(shared.Account, []string)) Option {
+	return func(c *Client) {
+		c.readOnlyCache = enabled
+		if enabled {
+			c.refreshNeeded = refreshNeeded
+		}
+	}
+}
+
+// WithReturnRefreshToken includes the account's refresh token in the client's results.
+func WithReturnRefreshToken(enabled bool) Option {
+	return func(c *Client) {
+		c.returnRefreshToken = enabled
+	}
+}
+
+// WithUsernameNormalizer sets a function AccountsByUsername applies to usernames before comparing them.
+func WithUsernameNormalizer(normalize func(string) string) Option {
+	return func(c *Client) {
+		c.normalizeUsername = normalize
+	}
+}
+
 // WithKnownAuthorityHosts specifies hosts Client shouldn't validate or request metadata for because they're known to the user
 func WithKnownAuthorityHosts(hosts []string) Option {
 	return func(c *Client) {
@@ -166,6 +637,155 @@ func WithX5C(sendX5C bool) Option {
 	}
 }
 
+// WithOfflineInstanceDiscovery specifies whether Client uses known instance metadata instead of requesting it
+func WithOfflineInstanceDiscovery(offline bool) Option {
+	return func(c *Client) {
+		c.AuthParams.AuthorityInfo.OfflineInstanceDiscovery = offline
+	}
+}
+
+// WithInstanceDiscoveryEndpoint sets the URL to which Client sends instance discovery requests. Empty means
+// the conventional endpoint.
+func WithInstanceDiscoveryEndpoint(endpoint string) Option {
+	return func(c *Client) {
+		c.AuthParams.AuthorityInfo.InstanceDiscoveryEndpoint = endpoint
+	}
+}
+
+// WithStaticEndpoints directs Client to use the specified endpoints and issuer instead of discovering the authority's
+// metadata. Client ignores them when tokenEndpoint is empty.
+func WithStaticEndpoints(authorizationEndpoint, tokenEndpoint, issuer string) Option {
+	return func(c *Client) {
+		if tokenEndpoint == "" {
+			return
+		}
+		endpoints := authority.NewEndpoints(authorizationEndpoint, tokenEndpoint, issuer, c.AuthParams.AuthorityInfo.Host)
+		c.AuthParams.AuthorityInfo.StaticEndpoints = &endpoints
+	}
+}
+
+// WithRefreshTokenExpiryWarning directs Client to call warn with the account and refresh token expiry of any
+// result whose refresh token expires within window. A nil warn or window <= 0 disables warnings.
+func WithRefreshTokenExpiryWarning(window time.Duration, warn func(shared.Account, time.Time)) Option {
+	return func(c *Client) {
+		if warn != nil && window > 0 {
+			c.rtExpiryWarning = warn
+			c.rtExpiryWindow = window
+		}
+	}
+}
+
+// warnRefreshTokenExpiry calls the client's refresh token expiry warning when ar's refresh token expires soon
+func (b Client) warnRefreshTokenExpiry(ar AuthResult, authParams authority.AuthParams) {
+	if b.rtExpiryWarning == nil || ar.RefreshTokenExpiresOn.IsZero() || ar.Account.IsZero() {
+		return
+	}
+	if ar.RefreshTokenExpiresOn.Sub(authParams.ClientNow()) <= b.rtExpiryWindow {
+		b.rtExpiryWarning(ar.Account, ar.RefreshTokenExpiresOn)
+	}
+}
+
+// WithConfidentialClient marks Client as a confidential client, which partitions its cache by user for all
+// user flows rather than only the authorization code flow
+func WithConfidentialClient() Option {
+	return func(c *Client) {
+		c.AuthParams.IsConfidentialClient = true
+	}
+}
+
+// WithClock sets the clock Client uses for token expiry decisions and client assertions
+func WithClock(clock func() time.Time) Option {
+	return func(c *Client) {
+		c.AuthParams.Clock = clock
+	}
+}
+
+// WithClockSkew sets how far Client's clock may differ from the authority's
+func WithClockSkew(skew time.Duration) Option {
+	return func(c *Client) {
+		c.AuthParams.ClockSkew = skew
+	}
+}
+
+// WithClockSkewCompensation corrects Client's clock by the offset of the authority's clock, as reported by token responses
+func WithClockSkewCompensation(enabled bool) Option {
+	return func(c *Client) {
+		if enabled {
+			c.AuthParams.AuthorityClock = &authority.AuthorityClock{}
+		}
+	}
+}
+
+// WithRateLimit limits the rate of the client's token requests to each authority host. requestsPerSecond <= 0 disables the limit.
+func WithRateLimit(requestsPerSecond float64, burst int, maxDelay time.Duration) Option {
+	return func(c *Client) {
+		if requestsPerSecond > 0 {
+			c.AuthParams.RateLimiter = authority.NewRateLimiter(requestsPerSecond, burst, maxDelay)
+		}
+	}
+}
+
+// WithAllowStaleOnError allows the client to return an access token that expired up to maxStale ago when it
+// can't get a new token because the authority is unavailable.
+func WithAllowStaleOnError(maxStale time.Duration) Option {
+	return func(c *Client) {
+		c.AuthParams.MaxStale = maxStale
+	}
+}
+
+// WithExtendedTokenLifetime allows the client to return an expired access token before its extended expiry when
+// it can't get a new token because the authority is unavailable.
+func WithExtendedTokenLifetime(enabled bool) Option {
+	return func(c *Client) {
+		c.AuthParams.ExtendedLifetime = enabled
+	}
+}
+
+// WithStrictTokenResponses makes the client reject malformed token responses instead of coercing them
+func WithStrictTokenResponses(enabled bool) Option {
+	return func(c *Client) {
+		c.AuthParams.StrictTokenResponses = enabled
+	}
+}
+
+// WithInterceptors sets functions the client calls before sending each token request and after receiving the response
+func WithInterceptors(request func(context.Context, *exported.TokenRequestInfo) error, response func(context.Context, *exported.TokenResponseInfo)) Option {
+	return func(c *Client) {
+		c.AuthParams.RequestInterceptor = request
+		c.AuthParams.ResponseInterceptor = response
+	}
+}
+
+// WithPolicy restricts the tenants and scopes for which the client requests tokens. Empty lists allow all.
+func WithPolicy(allowedTenants, allowedScopes []string) Option {
+	return func(c *Client) {
+		c.AuthParams.AllowedTenants = allowedTenants
+		c.AuthParams.AllowedScopes = allowedScopes
+	}
+}
+
+// WithTokenEndpointOverride sends token requests to endpoint instead of the token endpoint from tenant discovery.
+// The override takes precedence over a region set by WithRegionDetection.
+func WithTokenEndpointOverride(endpoint string) Option {
+	return func(c *Client) {
+		c.AuthParams.TokenEndpointOverride = endpoint
+	}
+}
+
+// WithV1TokenEndpoint directs an AAD Client to send token requests to the authority's v1 token endpoint.
+func WithV1TokenEndpoint(enabled bool) Option {
+	return func(c *Client) {
+		c.AuthParams.V1TokenEndpoint = enabled
+	}
+}
+
+// WithAuthorizationEndpointOverride directs users to endpoint instead of the authorization endpoint from tenant discovery.
+func WithAuthorizationEndpointOverride(endpoint string) Option {
+	return func(c *Client) {
+		c.AuthParams.AuthorizationEndpointOverride = endpoint
+	}
+}
+
 func WithRegionDetection(region string) Option {
 	return func(c *Client) {
 		c.AuthParams.AuthorityInfo.Region = region
@@ -189,18 +809,42 @@ func New(clientID string, authorityURI string, token *oauth.Client, options ...O
 	for _, o := range options {
 		o(&client)
 	}
+	if client.cacheDisabled {
+		client.cacheAccessor = noopCacheAccessor{}
+		return client, nil
+	}
+	// wrap the accessor after applying all options because WithCacheAccessor may follow WithPartitionedCacheExport.
+	// The namespaceAccessor wraps a partitionView when both are enabled, because the view needs the unprefixed key.
+	if client.cacheNamespace != "" {
+		client.cacheAccessor = newNamespaceAccessor(client.cacheAccessor, client.cacheNamespace)
+	}
+	if client.partitionedExport {
+		client.cacheAccessor = partitionAccessor{client.cacheAccessor}
+	}
+	if client.readOnlyCache {
+		client.cacheAccessor = readOnlyAccessor{client.cacheAccessor}
+	}
 	return client, nil
 
 }
 
-// AuthCodeURL creates a URL used to acquire an authorization code.
-func (b Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, scopes []string, authParams authority.AuthParams) (string, error) {
+// AuthCodeURL creates a URL used to acquire an authorization code. cc authenticates a confidential client's
+// pushed authorization request and is nil for a public client.
+func (b Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, scopes []string, authParams authority.AuthParams, cc *accesstokens.Credential) (string, error) {
+	authParams.Scopes = scopes
+	if err := authParams.CheckPolicy(); err != nil {
+		return "", err
+	}
 	endpoints, err := b.Token.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
 	if err != nil {
 		return "", err
 	}
 
-	baseURL, err := url.Parse(endpoints.AuthorizationEndpoint)
+	authorizationEndpoint := endpoints.AuthorizationEndpoint
+	if authParams.AuthorizationEndpointOverride != "" {
+		authorizationEndpoint = authParams.AuthorizationEndpointOverride
+	}
+	baseURL, err := url.Parse(authorizationEndpoint)
 	if err != nil {
 		return "", err
 	}
@@ -209,10 +853,22 @@ func (b Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, s
 	v.Add("client_id", clientID)
 	v.Add("response_type", "code")
 	v.Add("redirect_uri", redirectURI)
+	if authParams.AuthorityInfo.AuthorityType == authority.ADFS {
+		var resource string
+		if resource, scopes = accesstokens.ADFSScopes(scopes); resource != "" {
+			v.Add("resource", resource)
+		}
+	}
 	v.Add("scope", strings.Join(scopes, scopeSeparator))
 	if authParams.State != "" {
 		v.Add("state", authParams.State)
 	}
+	if authParams.Nonce != "" {
+		v.Add("nonce", authParams.Nonce)
+	}
+	if authParams.Claims != "" {
+		v.Add("claims", authParams.Claims)
+	}
 	if authParams.CodeChallenge != "" {
 		v.Add("code_challenge", authParams.CodeChallenge)
 	}
@@ -225,6 +881,9 @@ func (b Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, s
 	if authParams.Prompt != "" {
 		v.Add("prompt", authParams.Prompt)
 	}
+	if authParams.WebAuthn {
+		v.Add("webauthn", "1")
+	}
 	// There were left over from an implementation that didn't use any of these.  We may
 	// need to add them later, but as of now aren't needed.
 	/*
@@ -235,10 +894,143 @@ func (b Client) AuthCodeURL(ctx context.Context, clientID, redirectURI string, s
 			urlParams.Add("domain_hint", p.DomainHint)
 		}
 	*/
+	if authParams.PushedAuthorization {
+		// the URL refers to the pushed parameters, so they don't pass through the user agent
+		par, err := b.Token.PushAuthorizationRequest(ctx, authParams, cc, v)
+		if err != nil {
+			return "", err
+		}
+		v = url.Values{}
+		v.Add("client_id", clientID)
+		v.Add("request_uri", par.RequestURI)
+	}
 	baseURL.RawQuery = v.Encode()
 	return baseURL.String(), nil
 }
 
+// AuthorityMetadata returns the OpenID Connect metadata of the authority in authParams. The client caches this
+// metadata, so this method fetches it only when the client hasn't already done so.
+func (b Client) AuthorityMetadata(ctx context.Context, authParams authority.AuthParams) (AuthorityMetadata, error) {
+	endpoints, err := b.Token.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
+	if err != nil {
+		return AuthorityMetadata{}, err
+	}
+	// report the endpoints the client uses
+	if authParams.AuthorizationEndpointOverride != "" {
+		endpoints.AuthorizationEndpoint = authParams.AuthorizationEndpointOverride
+	}
+	if authParams.TokenEndpointOverride != "" {
+		endpoints.TokenEndpoint = authParams.TokenEndpointOverride
+	} else if authParams.TokenVersion() == authority.TokenVersionV1 {
+		endpoints.TokenEndpoint = authority.V1TokenEndpoint(endpoints.TokenEndpoint)
+	}
+	return AuthorityMetadata{
+		AuthorizationEndpoint: endpoints.AuthorizationEndpoint,
+		TokenEndpoint:         endpoints.TokenEndpoint,
+		EndSessionEndpoint:    endpoints.EndSessionEndpoint,
+		JWKSURI:               endpoints.JWKSURI,
+		UserInfoEndpoint:      endpoints.UserInfoEndpoint,
+		Issuer:                endpoints.Issuer(),
+		ClaimsSupported:       append([]string(nil), endpoints.ClaimsSupported...),
+	}, nil
+}
+
+// ResolvedEndpoints returns the endpoints the client uses with the authority in authParams. Like AuthorityMetadata,
+// it resolves the endpoints only when the client hasn't already done so.
+func (b Client) ResolvedEndpoints(ctx context.Context, authParams authority.AuthParams) (ResolvedEndpoints, error) {
+	endpoints, err := b.Token.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
+	if err != nil {
+		return ResolvedEndpoints{}, err
+	}
+	r := ResolvedEndpoints{
+		AuthorizationEndpoint:       endpoints.AuthorizationEndpoint,
+		TokenEndpoint:               endpoints.TokenEndpoint,
+		EndSessionEndpoint:          endpoints.EndSessionEndpoint,
+		JWKSURI:                     endpoints.JWKSURI,
+		UserInfoEndpoint:            endpoints.UserInfoEndpoint,
+		OpenIDConfigurationEndpoint: endpoints.OpenIDConfigurationEndpoint,
+		RegionDiscoveryEndpoint:     authParams.AuthorityInfo.RegionDiscoveryURL(),
+	}
+	if authParams.AuthorizationEndpointOverride != "" {
+		r.AuthorizationEndpoint = authParams.AuthorizationEndpointOverride
+	}
+	if authParams.TokenEndpointOverride != "" {
+		r.TokenEndpoint = authParams.TokenEndpointOverride
+	} else if authParams.TokenVersion() == authority.TokenVersionV1 {
+		r.TokenEndpoint = authority.V1TokenEndpoint(r.TokenEndpoint)
+	}
+	deviceParams := authParams
+	deviceParams.Endpoints = endpoints
+	deviceParams.Endpoints.TokenEndpoint = r.TokenEndpoint
+	r.DeviceCodeEndpoint = deviceParams.DeviceCodeEndpoint()
+	// the cache requests instance metadata unless the application specified known authority hosts, and tenant
+	// discovery requests it to validate an unknown host
+	info := authParams.AuthorityInfo
+	if len(authParams.KnownAuthorityHosts) == 0 || (info.ValidateAuthority && !authority.TrustedHost(info.Host)) {
+		r.InstanceDiscoveryEndpoint = info.InstanceDiscoveryURL()
+	}
+
+	hosts := map[string]bool{}
+	for _, endpoint := range []string{
+		r.AuthorizationEndpoint, r.TokenEndpoint, r.EndSessionEndpoint, r.JWKSURI, r.UserInfoEndpoint,
+		r.InstanceDiscoveryEndpoint, r.OpenIDConfigurationEndpoint, r.RegionDiscoveryEndpoint,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return ResolvedEndpoints{}, fmt.Errorf("couldn't parse endpoint %q", endpoint)
+		}
+		hosts[u.Host] = true
+	}
+	for host := range hosts {
+		r.Hosts = append(r.Hosts, host)
+	}
+	sort.Strings(r.Hosts)
+	return r, nil
+}
+
+// Warmup fetches the metadata the client needs to acquire tokens from the authority in authParams, such as
+// its endpoints and instance metadata, and caches it so that later requests needn't fetch it. It doesn't
+// resolve the endpoints of ADFS authorities because that requires a user principal name.
+func (b Client) Warmup(ctx context.Context, authParams authority.AuthParams) error {
+	if authParams.AuthorityInfo.AuthorityType != authority.ADFS {
+		if _, err := b.Token.ResolveEndpoints(ctx, authParams.AuthorityInfo, ""); err != nil {
+			return err
+		}
+	}
+	if err := b.manager.Warmup(ctx, authParams); err != nil {
+		return err
+	}
+	return b.pmanager.Warmup(ctx, authParams)
+}
+
+// LogoutURL creates a URL that signs the user out of the authority. postLogoutRedirectURI is optional.
+func (b Client) LogoutURL(ctx context.Context, authParams authority.AuthParams, postLogoutRedirectURI string) (string, error) {
+	endpoints, err := b.Token.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
+	if err != nil {
+		return "", err
+	}
+	endSession := endpoints.EndSessionEndpoint
+	if endSession == "" {
+		if authParams.AuthorityInfo.AuthorityType != authority.AAD {
+			return "", errors.New("the authority doesn't advertise an end_session_endpoint")
+		}
+		endSession = authParams.AuthorityInfo.CanonicalAuthorityURI + "oauth2/v2.0/logout"
+	}
+	u, err := url.Parse(endSession)
+	if err != nil {
+		return "", err
+	}
+	if postLogoutRedirectURI != "" {
+		v := u.Query()
+		v.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+		u.RawQuery = v.Encode()
+	}
+	return u.String(), nil
+}
+
 func (b Client) AcquireTokenSilent(ctx context.Context, silent AcquireTokenSilentParameters) (AuthResult, error) {
 	tenant := silent.TenantID
 	if tenant == "" {
@@ -252,6 +1044,14 @@ func (b Client) AcquireTokenSilent(ctx context.Context, silent AcquireTokenSilen
 	authParams.HomeAccountID = silent.Account.HomeAccountID
 	authParams.AuthorizationType = silent.AuthorizationType
 	authParams.UserAssertion = silent.UserAssertion
+	authParams.Claims = silent.Claims
+	authParams.AuthnScheme = silent.AuthnScheme
+	if err := authParams.CheckPolicy(); err != nil {
+		return AuthResult{}, err
+	}
+	if b.cacheDisabled {
+		return AuthResult{}, errCacheDisabled
+	}
 
 	var storageTokenResponse storage.TokenResponse
 	if authParams.AuthorizationType == authority.ATOnBehalfOf {
@@ -277,10 +1077,17 @@ func (b Client) AcquireTokenSilent(ctx context.Context, silent AcquireTokenSilen
 		}
 	}
 
-	result, err := AuthResultFromStorage(storageTokenResponse)
-	if err != nil {
+	result, err := authResultFromStorage(storageTokenResponse, authParams.ClientNow(), authParams.ClockSkew)
+	// a cached access token doesn't satisfy claims, so redeem the refresh token for a new one
+	if err != nil || authParams.Claims != "" {
+		if b.readOnlyCache {
+			if b.refreshNeeded != nil {
+				b.refreshNeeded(silent.Account, silent.Scopes)
+			}
+			return AuthResult{}, errReadOnlyCache
+		}
 		if reflect.ValueOf(storageTokenResponse.RefreshToken).IsZero() {
-			return AuthResult{}, errors.New("no token found")
+			return AuthResult{}, storage.NotFoundError("no token found")
 		}
 
 		var cc *accesstokens.Credential
@@ -290,12 +1097,100 @@ func (b Client) AcquireTokenSilent(ctx context.Context, silent AcquireTokenSilen
 
 		token, err := b.Token.Refresh(ctx, silent.RequestType, authParams, cc, storageTokenResponse.RefreshToken)
 		if err != nil {
+			// a caller that canceled the request wants its error, not a stale token
+			if result, ok := staleResult(storageTokenResponse, authParams, err); ok && ctx.Err() == nil {
+				return result, nil
+			}
 			return AuthResult{}, err
 		}
 
-		return b.AuthResultFromToken(ctx, authParams, token, true)
+		// this method exports the cache when it returns, so writing the token mustn't export it too
+		ar, err := b.authResultFromToken(ctx, authParams, token, true, false)
+		if err == nil && b.returnRefreshToken && ar.RefreshToken == "" {
+			// the authority didn't rotate the refresh token
+			ar.RefreshToken = storageTokenResponse.RefreshToken.Secret
+		}
+		return ar, err
+	}
+	if b.returnRefreshToken {
+		result.RefreshToken = storageTokenResponse.RefreshToken.Secret
+	}
+	b.warnRefreshTokenExpiry(result, authParams)
+	return formatAccessToken(result, authParams)
+}
+
+// StaleAppToken returns a cached app access token for authParams when the client allows stale tokens and err, the
+// error of a request for a new token, indicates the authority is unavailable. It returns none when ctx is done,
+// because then err is the caller's cancellation rather than an outage. See WithAllowStaleOnError and
+// WithExtendedTokenLifetime.
+func (b Client) StaleAppToken(ctx context.Context, authParams authority.AuthParams, err error) (AuthResult, bool) {
+	if (authParams.MaxStale <= 0 && !authParams.ExtendedLifetime) || !oauth.IsUnavailable(err) || ctx.Err() != nil {
+		return AuthResult{}, false
+	}
+	if s, ok := b.manager.(cache.Serializer); ok {
+		suggestedCacheKey := authParams.CacheKey(true)
+		b.cacheAccessor.Replace(s, suggestedCacheKey)
+		defer b.cacheAccessor.Export(s, suggestedCacheKey)
+	}
+	// the error of a cache read means there's no stale token to return; the caller returns err instead
+	tr, readErr := b.manager.Read(ctx, authParams, shared.Account{})
+	if readErr != nil {
+		return AuthResult{}, false
+	}
+	return staleResult(tr, authParams, err)
+}
+
+// staleResult returns the access token in tr when authParams allows stale tokens, err indicates the authority
+// is unavailable, and the token expired no more than authParams.MaxStale ago or, when authParams allows extended
+// lifetimes, hasn't reached its extended expiry. It never returns a token for a request with claims, because
+// those indicate a resource rejected the cached token.
+func staleResult(tr storage.TokenResponse, authParams authority.AuthParams, err error) (AuthResult, bool) {
+	if authParams.Claims != "" || tr.AccessToken.Secret == "" || !oauth.IsUnavailable(err) {
+		return AuthResult{}, false
+	}
+	now := authParams.ClientNow()
+	stale := authParams.MaxStale > 0 && now.Sub(tr.AccessToken.ExpiresOn.T) <= authParams.MaxStale
+	// a token past its expiry but not its extended expiry is valid only while the authority is unavailable
+	degraded := authParams.ExtendedLifetime && !now.Before(tr.AccessToken.ExpiresOn.T) && now.Before(tr.AccessToken.ExtendedExpiresOn.T)
+	if !stale && !degraded {
+		return AuthResult{}, false
+	}
+	result, err := storageAuthResult(tr)
+	if err != nil {
+		return AuthResult{}, false
+	}
+	result, err = formatAccessToken(result, authParams)
+	if err != nil {
+		return AuthResult{}, false
+	}
+	result.Stale = true
+	result.Metadata.DegradedMode = degraded
+	return result, true
+}
+
+// UserInfo gets claims about silent.Account from the authority's userinfo endpoint, authenticating
+// with an access token acquired silently. silent.Scopes defaults to userInfoScopes.
+func (b Client) UserInfo(ctx context.Context, silent AcquireTokenSilentParameters) (authority.UserInfo, error) {
+	if len(silent.Scopes) == 0 {
+		silent.Scopes = userInfoScopes
+	}
+	ar, err := b.AcquireTokenSilent(ctx, silent)
+	if err != nil {
+		return authority.UserInfo{}, err
+	}
+	authParams, err := b.AuthParams.WithTenant(silent.Account.Realm)
+	if err != nil {
+		return authority.UserInfo{}, err
+	}
+	info, err := b.Token.UserInfo(ctx, authParams, ar.AccessToken)
+	if err != nil {
+		return authority.UserInfo{}, err
+	}
+	// OpenID Connect Core 1.0 section 5.3.2: the sub claim must match the ID token's
+	if sub := ar.IDToken.Subject; sub != "" && sub != info.Subject {
+		return authority.UserInfo{}, fmt.Errorf("userinfo subject %q doesn't match the ID token subject %q", info.Subject, sub)
 	}
-	return result, nil
+	return info, nil
 }
 
 func (b Client) AcquireTokenByAuthCode(ctx context.Context, authCodeParams AcquireTokenAuthCodeParameters) (AuthResult, error) {
@@ -306,6 +1201,8 @@ func (b Client) AcquireTokenByAuthCode(ctx context.Context, authCodeParams Acqui
 	authParams.Scopes = authCodeParams.Scopes
 	authParams.Redirecturi = authCodeParams.RedirectURI
 	authParams.AuthorizationType = authority.ATAuthCode
+	authParams.Claims = authCodeParams.Claims
+	authParams.IDTokenPolicy = authCodeParams.IDTokenPolicy
 
 	var cc *accesstokens.Credential
 	if authCodeParams.AppType == accesstokens.ATConfidential {
@@ -322,6 +1219,10 @@ func (b Client) AcquireTokenByAuthCode(ctx context.Context, authCodeParams Acqui
 	if err != nil {
 		return AuthResult{}, err
 	}
+	// check the nonce before caching the token, because a mismatch means it may not be for this user
+	if authCodeParams.Nonce != "" && subtle.ConstantTimeCompare([]byte(token.IDToken.Nonce), []byte(authCodeParams.Nonce)) != 1 {
+		return AuthResult{}, fmt.Errorf("the ID token's nonce doesn't match the request's")
+	}
 
 	return b.AuthResultFromToken(ctx, authParams, token, true)
 }
@@ -335,6 +1236,8 @@ func (b Client) AcquireTokenOnBehalfOf(ctx context.Context, onBehalfOfParams Acq
 	authParams.Scopes = onBehalfOfParams.Scopes
 	authParams.AuthorizationType = authority.ATOnBehalfOf
 	authParams.UserAssertion = onBehalfOfParams.UserAssertion
+	authParams.Claims = onBehalfOfParams.Claims
+	authParams.IDTokenPolicy = onBehalfOfParams.IDTokenPolicy
 
 	silentParameters := AcquireTokenSilentParameters{
 		Scopes:            onBehalfOfParams.Scopes,
@@ -343,6 +1246,7 @@ func (b Client) AcquireTokenOnBehalfOf(ctx context.Context, onBehalfOfParams Acq
 		UserAssertion:     onBehalfOfParams.UserAssertion,
 		AuthorizationType: authority.ATOnBehalfOf,
 		TenantID:          onBehalfOfParams.TenantID,
+		Claims:            onBehalfOfParams.Claims,
 	}
 	token, err := b.AcquireTokenSilent(ctx, silentParameters)
 	if err != nil {
@@ -356,15 +1260,147 @@ func (b Client) AcquireTokenOnBehalfOf(ctx context.Context, onBehalfOfParams Acq
 	return token, err
 }
 
+// AcquireTokenByTokenExchange exchanges a subject token for an access token (RFC 8693). Like OBO tokens, the
+// resulting tokens are cached in a partition keyed by the subject token's hash.
+func (b Client) AcquireTokenByTokenExchange(ctx context.Context, exchangeParams AcquireTokenByTokenExchangeParameters) (AuthResult, error) {
+	authParams, err := b.AuthParams.WithTenant(exchangeParams.TenantID)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	authParams.Scopes = exchangeParams.Scopes
+	authParams.AuthorizationType = authority.ATOnBehalfOf
+	authParams.UserAssertion = exchangeParams.SubjectToken
+	authParams.SubjectTokenType = exchangeParams.SubjectTokenType
+	authParams.Claims = exchangeParams.Claims
+
+	silentParameters := AcquireTokenSilentParameters{
+		Scopes:            exchangeParams.Scopes,
+		RequestType:       accesstokens.ATConfidential,
+		Credential:        exchangeParams.Credential,
+		UserAssertion:     exchangeParams.SubjectToken,
+		AuthorizationType: authority.ATOnBehalfOf,
+		TenantID:          exchangeParams.TenantID,
+		Claims:            exchangeParams.Claims,
+	}
+	if result, err := b.AcquireTokenSilent(ctx, silentParameters); err == nil {
+		return result, nil
+	}
+	token, err := b.Token.TokenExchange(ctx, authParams, exchangeParams.Credential)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return b.AuthResultFromToken(ctx, authParams, token, true)
+}
+
+// AcquireTokenSSHCert redeems the account's cached refresh token for an SSH certificate. The client caches the
+// response's other tokens but not the certificate, so the certificate can't be mistaken for an access token.
+func (b Client) AcquireTokenSSHCert(ctx context.Context, sshParams AcquireTokenSSHCertParameters) (AuthResult, error) {
+	tenant := sshParams.TenantID
+	if tenant == "" {
+		tenant = sshParams.Account.Realm
+	}
+	authParams, err := b.AuthParams.WithTenant(tenant)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	authParams.Scopes = sshParams.Scopes
+	authParams.HomeAccountID = sshParams.Account.HomeAccountID
+	authParams.AuthorizationType = authority.ATRefreshToken
+	authParams.Claims = sshParams.Claims
+	authParams.SSHKeyID = sshParams.KeyID
+	authParams.SSHReqCnf = sshParams.ReqCnf
+
+	if s, ok := b.manager.(cache.Serializer); ok {
+		suggestedCacheKey := authParams.CacheKey(false)
+		b.cacheAccessor.Replace(s, suggestedCacheKey)
+		defer b.cacheAccessor.Export(s, suggestedCacheKey)
+	}
+	storageTokenResponse, err := b.manager.Read(ctx, authParams, sshParams.Account)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if reflect.ValueOf(storageTokenResponse.RefreshToken).IsZero() {
+		return AuthResult{}, errors.New("no refresh token found for the account")
+	}
+	token, err := b.Token.Refresh(ctx, accesstokens.ATPublic, authParams, nil, storageTokenResponse.RefreshToken)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	cert := token.AccessToken
+	token.AccessToken = ""
+	result, err := b.authResultFromToken(ctx, authParams, token, true, false)
+	result.AccessToken = cert
+	return result, err
+}
+
+// AcquireTokenByRefreshToken redeems a refresh token the application obtained elsewhere. Like OBO tokens, the
+// resulting tokens are cached in a partition keyed by the given refresh token's hash, so later calls with the
+// same refresh token return cached access tokens or redeem the cached refresh token, which replaces the given
+// one when the authority rotates it.
+func (b Client) AcquireTokenByRefreshToken(ctx context.Context, refreshParams AcquireTokenByRefreshTokenParameters) (AuthResult, error) {
+	authParams, err := b.AuthParams.WithTenant(refreshParams.TenantID)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	authParams.Scopes = refreshParams.Scopes
+	authParams.AuthorizationType = authority.ATOnBehalfOf
+	authParams.UserAssertion = refreshParams.RefreshToken
+	authParams.Claims = refreshParams.Claims
+
+	silentParameters := AcquireTokenSilentParameters{
+		Scopes:            refreshParams.Scopes,
+		RequestType:       accesstokens.ATConfidential,
+		Credential:        refreshParams.Credential,
+		UserAssertion:     refreshParams.RefreshToken,
+		AuthorizationType: authority.ATOnBehalfOf,
+		TenantID:          refreshParams.TenantID,
+		Claims:            refreshParams.Claims,
+	}
+	result, err := b.AcquireTokenSilent(ctx, silentParameters)
+	if err == nil {
+		return result, nil
+	}
+	// redeem the given refresh token only when the cache has nothing for it. Other errors, such as the
+	// authority rejecting a newer cached refresh token, would recur with the given one.
+	var notFound storage.NotFoundError
+	if !errors.As(err, &notFound) {
+		return AuthResult{}, err
+	}
+	rt := accesstokens.RefreshToken{Secret: refreshParams.RefreshToken}
+	token, err := b.Token.Refresh(ctx, accesstokens.ATConfidential, authParams, refreshParams.Credential, rt)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return b.AuthResultFromToken(ctx, authParams, token, true)
+}
+
+// AuthResultFromToken returns the result of a token response, first writing the response to the cache when cacheWrite
+// is true. The cache stores all the response's items at once and the client exports the cache once, afterward.
 func (b Client) AuthResultFromToken(ctx context.Context, authParams authority.AuthParams, token accesstokens.TokenResponse, cacheWrite bool) (AuthResult, error) {
+	return b.authResultFromToken(ctx, authParams, token, cacheWrite, true)
+}
+
+// authResultFromToken implements AuthResultFromToken. When useAccessor is false, it doesn't call the cache accessor
+// because the caller does so.
+func (b Client) authResultFromToken(ctx context.Context, authParams authority.AuthParams, token accesstokens.TokenResponse, cacheWrite, useAccessor bool) (AuthResult, error) {
+	if err := authParams.CheckIDToken(!token.IDToken.IsZero()); err != nil {
+		return AuthResult{}, err
+	}
 	if !cacheWrite {
-		return NewAuthResult(token, shared.Account{})
+		ar, consentErr := NewAuthResult(token, shared.Account{})
+		return formatResult(ar, consentErr, authParams)
 	}
 
 	var account shared.Account
 	var err error
-	if authParams.AuthorizationType == authority.ATOnBehalfOf {
-		if s, ok := b.pmanager.(cache.Serializer); ok {
+	if b.cacheDisabled || b.readOnlyCache {
+		// a throwaway cache derives the account from the token response, as the client's cache would
+		account, err = storage.New(b.Token).Write(authParams, token)
+		if err != nil {
+			return AuthResult{}, err
+		}
+	} else if authParams.AuthorizationType == authority.ATOnBehalfOf {
+		if s, ok := b.pmanager.(cache.Serializer); ok && useAccessor {
 			suggestedCacheKey := token.CacheKey(authParams)
 			b.cacheAccessor.Replace(s, suggestedCacheKey)
 			defer b.cacheAccessor.Export(s, suggestedCacheKey)
@@ -374,7 +1410,7 @@ func (b Client) AuthResultFromToken(ctx context.Context, authParams authority.Au
 			return AuthResult{}, err
 		}
 	} else {
-		if s, ok := b.manager.(cache.Serializer); ok {
+		if s, ok := b.manager.(cache.Serializer); ok && useAccessor {
 			suggestedCacheKey := token.CacheKey(authParams)
 			b.cacheAccessor.Replace(s, suggestedCacheKey)
 			defer b.cacheAccessor.Export(s, suggestedCacheKey)
@@ -384,7 +1420,12 @@ func (b Client) AuthResultFromToken(ctx context.Context, authParams authority.Au
 			return AuthResult{}, err
 		}
 	}
-	return NewAuthResult(token, account)
+	ar, consentErr := NewAuthResult(token, account)
+	if b.returnRefreshToken && !account.IsZero() {
+		ar.RefreshToken = token.RefreshToken
+	}
+	b.warnRefreshTokenExpiry(ar, authParams)
+	return formatResult(ar, consentErr, authParams)
 }
 
 func (b Client) AllAccounts() []shared.Account {
@@ -398,6 +1439,23 @@ func (b Client) AllAccounts() []shared.Account {
 	return accounts
 }
 
+// AccountsByUsername returns the cached accounts whose PreferredUsername matches username. Usernames match when
+// they're equal under Unicode case folding after normalization by the client's username normalizer, if any.
+func (b Client) AccountsByUsername(username string) []shared.Account {
+	normalize := b.normalizeUsername
+	if normalize == nil {
+		normalize = func(s string) string { return s }
+	}
+	username = normalize(username)
+	var accounts []shared.Account
+	for _, account := range b.AllAccounts() {
+		if strings.EqualFold(normalize(account.PreferredUsername), username) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
 func (b Client) Account(homeAccountID string) shared.Account {
 	authParams := b.AuthParams // This is a copy, as we dont' have a pointer receiver and .AuthParams is not a pointer.
 	authParams.AuthorizationType = authority.AccountByID
@@ -413,6 +1471,9 @@ func (b Client) Account(homeAccountID string) shared.Account {
 
 // RemoveAccount removes all the ATs, RTs and IDTs from the cache associated with this account.
 func (b Client) RemoveAccount(account shared.Account) {
+	if b.readOnlyCache {
+		return
+	}
 	if s, ok := b.manager.(cache.Serializer); ok {
 		suggestedCacheKey := b.AuthParams.CacheKey(false)
 		b.cacheAccessor.Replace(s, suggestedCacheKey)
@@ -420,3 +1481,252 @@ func (b Client) RemoveAccount(account shared.Account) {
 	}
 	b.manager.RemoveAccount(account, b.AuthParams.ClientID)
 }
+
+// backChannelLogoutEvent is the member of a logout token's events claim identifying a back-channel logout request
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenSkew is the clock skew tolerated when validating the times in a logout token, unless the client
+// has a clock skew of its own
+const logoutTokenSkew = 5 * time.Minute
+
+// LogoutToken is a validated OpenID Connect back-channel logout token. See
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken.
+type LogoutToken struct {
+	// Issuer is the token's iss claim.
+	Issuer string
+	// Subject is the token's sub claim, identifying the user. It's empty when the token has only a session ID.
+	Subject string
+	// SessionID is the token's sid claim, identifying the user's session. It's empty when the token has only a subject.
+	SessionID string
+	// Audience is the token's aud claim.
+	Audience []string
+	// IssuedAt is the time at which the authority issued the token.
+	IssuedAt time.Time
+	// ID is the token's jti claim. Applications should reject a token whose ID they've seen before.
+	ID string
+	// TenantID and ObjectID are the token's tid and oid claims. Microsoft Entra ID includes them in its logout tokens.
+	TenantID, ObjectID string
+	// Claims are all the token's claims.
+	Claims map[string]interface{}
+}
+
+// HomeAccountID returns the home account ID of the user the token logs out, or "" when the token doesn't identify one.
+func (t LogoutToken) HomeAccountID() string {
+	if t.ObjectID == "" || t.TenantID == "" {
+		return ""
+	}
+	return t.ObjectID + "." + t.TenantID
+}
+
+// ValidateLogoutToken verifies the signature and claims of a back-channel logout token sent by the client's authority.
+func (b Client) ValidateLogoutToken(ctx context.Context, logoutToken string) (LogoutToken, error) {
+	claims, endpoints, err := b.Token.VerifyJWT(ctx, b.AuthParams, logoutToken)
+	if err != nil {
+		return LogoutToken{}, fmt.Errorf("invalid logout token: %w", err)
+	}
+	t := LogoutToken{Claims: claims}
+	t.Issuer, _ = claims["iss"].(string)
+	t.Subject, _ = claims["sub"].(string)
+	t.SessionID, _ = claims["sid"].(string)
+	t.ID, _ = claims["jti"].(string)
+	t.TenantID, _ = claims["tid"].(string)
+	t.ObjectID, _ = claims["oid"].(string)
+	switch aud := claims["aud"].(type) {
+	case string:
+		t.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				t.Audience = append(t.Audience, s)
+			}
+		}
+	}
+
+	// the multi-tenant endpoints' issuer has a placeholder for the user's tenant
+	issuer := strings.Replace(endpoints.Issuer(), "{tenantid}", t.TenantID, -1)
+	if t.Issuer == "" || t.Issuer != issuer {
+		return LogoutToken{}, fmt.Errorf("invalid logout token: issuer %q doesn't match the authority's issuer %q", t.Issuer, issuer)
+	}
+	audOK := false
+	for _, a := range t.Audience {
+		audOK = audOK || a == b.AuthParams.ClientID
+	}
+	if !audOK {
+		return LogoutToken{}, fmt.Errorf("invalid logout token: audience %q doesn't include the client ID", t.Audience)
+	}
+	// the client's clock and skew apply, as they do to the tokens the client acquires
+	now, skew := b.AuthParams.ClientNow(), b.AuthParams.ClockSkew
+	if skew <= 0 {
+		skew = logoutTokenSkew
+	}
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return LogoutToken{}, errors.New("invalid logout token: it has no iat claim")
+	}
+	t.IssuedAt = time.Unix(int64(iat), 0)
+	if t.IssuedAt.After(now.Add(skew)) {
+		return LogoutToken{}, errors.New("invalid logout token: it was issued in the future")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return LogoutToken{}, errors.New("invalid logout token: it has no exp claim")
+	}
+	if time.Unix(int64(exp), 0).Add(skew).Before(now) {
+		return LogoutToken{}, errors.New("invalid logout token: it has expired")
+	}
+	if t.Subject == "" && t.SessionID == "" {
+		return LogoutToken{}, errors.New("invalid logout token: it has neither a sub nor a sid claim")
+	}
+	if events, ok := claims["events"].(map[string]interface{}); !ok || events[backChannelLogoutEvent] == nil {
+		return LogoutToken{}, fmt.Errorf("invalid logout token: its events claim doesn't contain %q", backChannelLogoutEvent)
+	}
+	// a logout token mustn't be mistaken for an ID token, which has a nonce
+	if _, ok := claims["nonce"]; ok {
+		return LogoutToken{}, errors.New("invalid logout token: it has a nonce claim")
+	}
+	return t, nil
+}
+
+// RemoveAccountByLogoutToken validates a back-channel logout token and removes from the cache the accounts
+// it logs out. It returns the validated token.
+func (b Client) RemoveAccountByLogoutToken(ctx context.Context, logoutToken string) (LogoutToken, error) {
+	t, err := b.ValidateLogoutToken(ctx, logoutToken)
+	if err != nil {
+		return LogoutToken{}, err
+	}
+	if b.readOnlyCache {
+		return t, nil
+	}
+	homeID := t.HomeAccountID()
+	if s, ok := b.manager.(cache.Serializer); ok {
+		// a web app's cache is usually partitioned by home account ID
+		b.cacheAccessor.Replace(s, homeID)
+		defer b.cacheAccessor.Export(s, homeID)
+	}
+	accounts := b.manager.AccountsByIDToken(func(idt accesstokens.IDToken) bool {
+		switch {
+		case homeID != "" && idt.Oid+"."+idt.TenantID == homeID:
+			return true
+		case t.Subject != "" && idt.Subject == t.Subject && idt.Issuer == t.Issuer:
+			return true
+		}
+		return t.SessionID != "" && idt.SessionID == t.SessionID
+	})
+	for _, account := range accounts {
+		b.manager.RemoveAccount(account, b.AuthParams.ClientID)
+	}
+	return t, nil
+}
+
+// CacheKeys are the keys under which the cache stores an account's tokens. See Client.CacheKeys.
+type CacheKeys struct {
+	// Partition is the key the client suggests to a cache.ExportReplace when it reads or writes the
+	// data containing these items.
+	Partition string
+	// AccessToken is the key of the access token for the requested scopes.
+	AccessToken string
+	// RefreshToken is the key of the account's refresh token. The cache stores the refresh token of an
+	// app in a family of client applications under a key containing the family ID instead of the client ID.
+	RefreshToken string
+	// IDToken is the key of the account's ID token.
+	IDToken string
+	// Account is the key of the account.
+	Account string
+}
+
+// CacheKeys returns the keys of the items the client caches for account in the given tenant. When tenantID is
+// empty, the tenant is account.Realm. A zero account specifies application tokens rather than user tokens.
+// The cache stores an access token under the scopes granted by the authority, in the order it returned them,
+// so scopes must be in that order to produce the same access token key.
+func (b Client) CacheKeys(account shared.Account, tenantID string, scopes []string) (CacheKeys, error) {
+	if tenantID == "" {
+		tenantID = account.Realm
+	}
+	authParams, err := b.AuthParams.WithTenant(tenantID)
+	if err != nil {
+		return CacheKeys{}, err
+	}
+	clientID, realm := authParams.ClientID, authParams.AuthorityInfo.Tenant
+	env := account.Environment
+	keys := CacheKeys{}
+	if account.IsZero() {
+		env = authParams.AuthorityInfo.Host
+		keys.Partition = authParams.AppKey()
+	} else {
+		keys.Partition = account.HomeAccountID
+		keys.RefreshToken = accesstokens.NewRefreshToken(account.HomeAccountID, env, clientID, "", "").Key()
+		keys.IDToken = storage.NewIDToken(account.HomeAccountID, env, realm, clientID, "").Key()
+		keys.Account = account.Key()
+	}
+	keys.AccessToken = storage.NewAccessToken(
+		account.HomeAccountID, env, realm, clientID, time.Time{}, time.Time{}, time.Time{}, strings.Join(scopes, scopeSeparator), "",
+	).Key()
+	return keys, nil
+}
+
+// CacheSnapshot describes the content of a client's cache. It contains no secrets.
+type CacheSnapshot = storage.Snapshot
+
+// CachedToken describes a cached token. It doesn't contain the token.
+type CachedToken = storage.CachedToken
+
+// CacheSnapshot describes the accounts and tokens in the client's cache, ordered by home account ID.
+func (b Client) CacheSnapshot(ctx context.Context) CacheSnapshot {
+	if s, ok := b.manager.(cache.Serializer); ok {
+		suggestedCacheKey := b.AuthParams.CacheKey(false)
+		b.cacheAccessor.Replace(s, suggestedCacheKey)
+		defer b.cacheAccessor.Export(s, suggestedCacheKey)
+	}
+	snapshot := b.manager.Snapshot()
+	// the partitioned cache holds tokens acquired on behalf of users
+	p := b.pmanager.Snapshot()
+	snapshot.Accounts = append(snapshot.Accounts, p.Accounts...)
+	snapshot.Tokens = append(snapshot.Tokens, p.Tokens...)
+
+	sort.Slice(snapshot.Accounts, func(i, j int) bool {
+		return snapshot.Accounts[i].Key() < snapshot.Accounts[j].Key()
+	})
+	sort.Slice(snapshot.Tokens, func(i, j int) bool {
+		x, y := snapshot.Tokens[i], snapshot.Tokens[j]
+		if x.HomeAccountID != y.HomeAccountID {
+			return x.HomeAccountID < y.HomeAccountID
+		}
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		return strings.Join(x.Scopes, scopeSeparator) < strings.Join(y.Scopes, scopeSeparator)
+	})
+	return snapshot
+}
+
+// RateLimitStats counts the token requests admitted by a client's rate limiter.
+type RateLimitStats = authority.RateLimitStats
+
+// RateLimitStats returns the counts of the client's rate limiter. They're zero when the client has no rate limit.
+func (b Client) RateLimitStats() RateLimitStats {
+	return b.AuthParams.RateLimiter.Stats()
+}
+
+// CacheStats describes the size of a client's cache partitions. It contains no secrets.
+type CacheStats = storage.Stats
+
+// PartitionStats describes the items in a cache partition.
+type PartitionStats = storage.PartitionStats
+
+// CacheStats describes the partitions of the client's cache, ordered by key.
+func (b Client) CacheStats(ctx context.Context) CacheStats {
+	if s, ok := b.manager.(cache.Serializer); ok {
+		suggestedCacheKey := b.AuthParams.CacheKey(false)
+		b.cacheAccessor.Replace(s, suggestedCacheKey)
+		defer b.cacheAccessor.Export(s, suggestedCacheKey)
+	}
+	stats := b.manager.Stats()
+	// the partitioned cache holds tokens acquired on behalf of users
+	p := b.pmanager.Stats()
+	stats.Partitions = append(stats.Partitions, p.Partitions...)
+	stats.ApproximateBytes += p.ApproximateBytes
+	sort.SliceStable(stats.Partitions, func(i, j int) bool {
+		return stats.Partitions[i].Key < stats.Partitions[j].Key
+	})
+	return stats
+}
@@ -10,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/base/internal/storage"
 	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
@@ -131,6 +133,7 @@ func TestAcquireTokenSilentScopes(t *testing.T) {
 				},
 				accesstokens.TokenResponse{
 					AccessToken:   fakeAccessToken,
+					ClientInfo:    accesstokens.ClientInfo{UID: "uid", UTID: fakeIDToken.TenantID},
 					ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(-time.Hour)},
 					GrantedScopes: accesstokens.Scopes{Slice: test.cachedTokenScopes},
 					IDToken:       fakeIDToken,
@@ -157,6 +160,47 @@ func TestAcquireTokenSilentScopes(t *testing.T) {
 	}
 }
 
+// countingAccessor counts the calls to its methods
+type countingAccessor struct {
+	exports, replaces int
+}
+
+func (a *countingAccessor) Replace(cache.Unmarshaler, string) { a.replaces++ }
+func (a *countingAccessor) Export(cache.Marshaler, string)    { a.exports++ }
+
+func TestAcquireTokenSilentExportsOnce(t *testing.T) {
+	client := fakeClient(t)
+	storage.FakeValidate = func(storage.AccessToken) error { return nil }
+	account, err := client.manager.Write(
+		authority.AuthParams{
+			AuthorityInfo: authority.Info{AuthorityType: authority.AAD, Host: fakeAuthority, Tenant: fakeIDToken.TenantID},
+			ClientID:      fakeClientID,
+			Scopes:        testScopes,
+		},
+		accesstokens.TokenResponse{
+			AccessToken:   fakeAccessToken,
+			ClientInfo:    accesstokens.ClientInfo{UID: "uid", UTID: fakeIDToken.TenantID},
+			ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(-time.Hour)},
+			GrantedScopes: accesstokens.Scopes{Slice: testScopes},
+			IDToken:       fakeIDToken,
+			RefreshToken:  fakeRefreshToken,
+		},
+	)
+	storage.FakeValidate = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessor := &countingAccessor{}
+	client.cacheAccessor = accessor
+	// refreshing the expired access token writes the cache, which the client should export only once
+	if _, err := client.AcquireTokenSilent(context.Background(), AcquireTokenSilentParameters{Account: account, Scopes: testScopes}); err != nil {
+		t.Fatal(err)
+	}
+	if accessor.replaces != 1 || accessor.exports != 1 {
+		t.Fatalf("expected 1 replace and 1 export, got %d and %d", accessor.replaces, accessor.exports)
+	}
+}
+
 func TestAcquireTokenSilentGrantedScopes(t *testing.T) {
 	client := fakeClient(t)
 	grantedScopes := []string{"scope1", "scope2"}
@@ -246,6 +290,26 @@ func TestCreateAuthenticationResult(t *testing.T) {
 	}
 }
 
+func TestPartialConsent(t *testing.T) {
+	tr := accesstokens.TokenResponse{
+		AccessToken:    "accessToken",
+		ExpiresOn:      internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+		GrantedScopes:  accesstokens.Scopes{Slice: []string{"user.read"}},
+		DeclinedScopes: []string{"mail.read"},
+	}
+	ar, err := NewAuthResult(tr, shared.Account{})
+	var pce errors.PartialConsentError
+	if !errors.As(err, &pce) {
+		t.Fatalf("expected a PartialConsentError, got %v", err)
+	}
+	if !reflect.DeepEqual(pce.DeclinedScopes, tr.DeclinedScopes) || !reflect.DeepEqual(pce.GrantedScopes, tr.GrantedScopes.Slice) {
+		t.Fatalf("unexpected scopes in %#v", pce)
+	}
+	if ar.AccessToken != tr.AccessToken || !reflect.DeepEqual(ar.DeclinedScopes, tr.DeclinedScopes) {
+		t.Fatalf("unexpected AuthResult %#v", ar)
+	}
+}
+
 func TestAuthResultFromStorage(t *testing.T) {
 	now := time.Now()
 	future := time.Now().Add(500 * time.Second)
@@ -286,6 +350,7 @@ func TestAuthResultFromStorage(t *testing.T) {
 				},
 				ExpiresOn:     future,
 				GrantedScopes: []string{"profile", "openid", "user.read"},
+				TokenType:     "Bearer",
 			},
 		},
 	}
@@ -308,3 +373,20 @@ func TestAuthResultFromStorage(t *testing.T) {
 		}
 	}
 }
+
+func TestPartitionedCacheExportOptionOrder(t *testing.T) {
+	accessor := noopCacheAccessor{}
+	for _, opts := range [][]Option{
+		{WithCacheAccessor(accessor), WithPartitionedCacheExport(true)},
+		{WithPartitionedCacheExport(true), WithCacheAccessor(accessor)},
+	} {
+		client, err := New(fakeClientID, fmt.Sprintf("https://%s/%s", fakeAuthority, fakeTenantID), &oauth.Client{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := partitionAccessor{cache.ExportReplace(accessor)}
+		if client.cacheAccessor != want {
+			t.Errorf("expected a partitionAccessor wrapping the given accessor, got %#v", client.cacheAccessor)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
+)
+
+// secrets encrypts and decrypts the secrets of cached tokens. When it has no Encrypter, secrets are
+// stored as given. Ciphertext is base64 encoded so cached items remain valid JSON strings.
+type secrets struct {
+	e cache.Encrypter
+}
+
+func (s secrets) seal(plaintext string) (string, error) {
+	if s.e == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	ciphertext, err := s.e.Encrypt([]byte(plaintext))
+	if err != nil {
+		return "", fmt.Errorf("couldn't encrypt cached token: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (s secrets) open(sealed string) (string, error) {
+	if s.e == nil || sealed == "" {
+		return sealed, nil
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decode cached token: %w", err)
+	}
+	plaintext, err := s.e.Decrypt(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decrypt cached token: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// sealResponse returns a copy of tr whose secrets are encrypted, for writing to the cache
+func (s secrets) sealResponse(tr accesstokens.TokenResponse) (accesstokens.TokenResponse, error) {
+	var err error
+	if tr.AccessToken, err = s.seal(tr.AccessToken); err != nil {
+		return tr, err
+	}
+	if tr.RefreshToken, err = s.seal(tr.RefreshToken); err != nil {
+		return tr, err
+	}
+	tr.IDToken.RawToken, err = s.seal(tr.IDToken.RawToken)
+	return tr, err
+}
+
+// openResponse returns a copy of tr whose secrets are decrypted, for returning from the cache
+func (s secrets) openResponse(tr TokenResponse) (TokenResponse, error) {
+	var err error
+	if tr.AccessToken.Secret, err = s.open(tr.AccessToken.Secret); err != nil {
+		return tr, err
+	}
+	if tr.RefreshToken.Secret, err = s.open(tr.RefreshToken.Secret); err != nil {
+		return tr, err
+	}
+	tr.IDToken.Secret, err = s.open(tr.IDToken.Secret)
+	return tr, err
+}
+
+// convertContract returns a copy of c whose secrets have been transformed by f
+func convertContract(c *Contract, f func(string) (string, error)) (*Contract, error) {
+	cp := *c
+	cp.AccessTokens = make(map[string]AccessToken, len(c.AccessTokens))
+	for k, at := range c.AccessTokens {
+		var err error
+		if at.Secret, err = f(at.Secret); err != nil {
+			return nil, err
+		}
+		cp.AccessTokens[k] = at
+	}
+	cp.RefreshTokens = make(map[string]accesstokens.RefreshToken, len(c.RefreshTokens))
+	for k, rt := range c.RefreshTokens {
+		var err error
+		if rt.Secret, err = f(rt.Secret); err != nil {
+			return nil, err
+		}
+		cp.RefreshTokens[k] = rt
+	}
+	cp.IDTokens = make(map[string]IDToken, len(c.IDTokens))
+	for k, id := range c.IDTokens {
+		var err error
+		if id.Secret, err = f(id.Secret); err != nil {
+			return nil, err
+		}
+		cp.IDTokens[k] = id
+	}
+	return &cp, nil
+}
+
+// convertInMemoryContract returns a copy of c whose secrets have been transformed by f
+func convertInMemoryContract(c *InMemoryContract, f func(string) (string, error)) (*InMemoryContract, error) {
+	cp := *c
+	cp.AccessTokensPartition = make(map[string]map[string]AccessToken, len(c.AccessTokensPartition))
+	for pk, partition := range c.AccessTokensPartition {
+		cp.AccessTokensPartition[pk] = make(map[string]AccessToken, len(partition))
+		for k, at := range partition {
+			var err error
+			if at.Secret, err = f(at.Secret); err != nil {
+				return nil, err
+			}
+			cp.AccessTokensPartition[pk][k] = at
+		}
+	}
+	cp.RefreshTokensPartition = make(map[string]map[string]accesstokens.RefreshToken, len(c.RefreshTokensPartition))
+	for pk, partition := range c.RefreshTokensPartition {
+		cp.RefreshTokensPartition[pk] = make(map[string]accesstokens.RefreshToken, len(partition))
+		for k, rt := range partition {
+			var err error
+			if rt.Secret, err = f(rt.Secret); err != nil {
+				return nil, err
+			}
+			cp.RefreshTokensPartition[pk][k] = rt
+		}
+	}
+	cp.IDTokensPartition = make(map[string]map[string]IDToken, len(c.IDTokensPartition))
+	for pk, partition := range c.IDTokensPartition {
+		cp.IDTokensPartition[pk] = make(map[string]IDToken, len(partition))
+		for k, id := range partition {
+			var err error
+			if id.Secret, err = f(id.Secret); err != nil {
+				return nil, err
+			}
+			cp.IDTokensPartition[pk][k] = id
+		}
+	}
+	return &cp, nil
+}
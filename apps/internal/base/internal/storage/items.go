@@ -12,6 +12,7 @@ import (
 
 	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
 )
 
@@ -24,6 +25,9 @@ type Contract struct {
 	IDTokens      map[string]IDToken                   `json:"IdToken,omitempty"`
 	Accounts      map[string]shared.Account            `json:"Account,omitempty"`
 	AppMetaData   map[string]AppMetaData               `json:"AppMetadata,omitempty"`
+	// SchemaVersion is the version of this package's serialization of the contract. It's 0 for
+	// caches serialized before versioning and by other SDKs. See migrateContract.
+	SchemaVersion int `json:"SchemaVersion,omitempty"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -37,6 +41,9 @@ type InMemoryContract struct {
 	IDTokensPartition      map[string]map[string]IDToken
 	AccountsPartition      map[string]map[string]shared.Account
 	AppMetaData            map[string]AppMetaData
+	SchemaVersion          int `json:"SchemaVersion,omitempty"`
+
+	AdditionalFields map[string]interface{}
 }
 
 // NewContract is the constructor for Contract.
@@ -47,6 +54,8 @@ func NewInMemoryContract() *InMemoryContract {
 		IDTokensPartition:      map[string]map[string]IDToken{},
 		AccountsPartition:      map[string]map[string]shared.Account{},
 		AppMetaData:            map[string]AppMetaData{},
+		SchemaVersion:          SchemaVersion,
+		AdditionalFields:       map[string]interface{}{},
 	}
 }
 
@@ -59,6 +68,7 @@ func NewContract() *Contract {
 		Accounts:         map[string]shared.Account{},
 		AppMetaData:      map[string]AppMetaData{},
 		AdditionalFields: map[string]interface{}{},
+		SchemaVersion:    SchemaVersion,
 	}
 }
 
@@ -75,6 +85,13 @@ type AccessToken struct {
 	ExtendedExpiresOn internalTime.Unix `json:"extended_expires_on,omitempty"`
 	CachedAt          internalTime.Unix `json:"cached_at,omitempty"`
 	UserAssertionHash string            `json:"user_assertion_hash,omitempty"`
+	// TokenType is the token's type, such as "Bearer" or "pop". Empty means "Bearer".
+	TokenType string `json:"token_type,omitempty"`
+	// AuthnSchemeKeyID identifies the key to which the token is bound, if any
+	AuthnSchemeKeyID string `json:"keyid,omitempty"`
+	// TokenVersion is the version of the token endpoint that issued the token, as from
+	// authority.AuthParams.TokenVersion. Empty means v2.0.
+	TokenVersion string `json:"token_version,omitempty"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -97,10 +114,30 @@ func NewAccessToken(homeID, env, realm, clientID string, cachedAt, expiresOn, ex
 
 // Key outputs the key that can be used to uniquely look up this entry in a map.
 func (a AccessToken) Key() string {
-	return strings.Join(
-		[]string{a.HomeAccountID, a.Environment, a.CredentialType, a.ClientID, a.Realm, a.Scopes},
-		shared.CacheKeySeparator,
-	)
+	parts := []string{a.HomeAccountID, a.Environment, a.CredentialType, a.ClientID, a.Realm, a.Scopes}
+	// Bearer tokens have no type in their key, so that the key matches the one other MSALs sharing
+	// the cache compute. Tokens of other types have their type in the key, so they don't collide.
+	if !a.IsBearer() {
+		parts = append(parts, strings.ToLower(a.TokenType))
+	}
+	// likewise, v2.0 tokens have no version in their key
+	if a.TokenVersion != "" {
+		parts = append(parts, "v"+a.TokenVersion)
+	}
+	return strings.Join(parts, shared.CacheKeySeparator)
+}
+
+// IsBearer returns true when the token is a Bearer token.
+func (a AccessToken) IsBearer() bool {
+	return a.TokenType == "" || strings.EqualFold(a.TokenType, authority.AccessTokenTypeBearer)
+}
+
+// matchesScheme returns true when the token has the type and key ID of scheme's tokens.
+func (a AccessToken) matchesScheme(scheme authority.AuthenticationScheme) bool {
+	if a.IsBearer() {
+		return strings.EqualFold(scheme.AccessTokenType(), authority.AccessTokenTypeBearer)
+	}
+	return strings.EqualFold(a.TokenType, scheme.AccessTokenType()) && a.AuthnSchemeKeyID == scheme.KeyID()
 }
 
 // FakeValidate enables tests to fake access token validation
@@ -108,13 +145,19 @@ var FakeValidate func(AccessToken) error
 
 // Validate validates that this AccessToken can be used.
 func (a AccessToken) Validate() error {
+	return a.ValidateAt(time.Now(), 0)
+}
+
+// ValidateAt validates that this AccessToken can be used at the given time by a client
+// whose clock may differ from the authority's by up to skew.
+func (a AccessToken) ValidateAt(now time.Time, skew time.Duration) error {
 	if FakeValidate != nil {
 		return FakeValidate(a)
 	}
-	if a.CachedAt.T.After(time.Now()) {
+	if a.CachedAt.T.After(now.Add(skew)) {
 		return errors.New("access token isn't valid, it was cached at a future time")
 	}
-	if a.ExpiresOn.T.Before(time.Now().Add(5 * time.Minute)) {
+	if a.ExpiresOn.T.Before(now.Add(5*time.Minute + skew)) {
 		return fmt.Errorf("access token is expired")
 	}
 	if a.CachedAt.T.IsZero() {
@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json"
+)
+
+// SchemaVersion is the version of the cache format this package serializes. Increment it when changing
+// the format, adding migrations that upgrade caches from the previous version.
+const SchemaVersion = 1
+
+// contractMigrations upgrade a Contract from the version of their index to the next version, and
+// inMemoryContractMigrations do the same for an InMemoryContract. A migration must preserve the items
+// it doesn't change, so that upgrading a cache doesn't drop accounts. It must also be idempotent,
+// because an older version of this package may load a newer cache and serialize it with its own
+// version.
+var (
+	contractMigrations = []func(*Contract) error{
+		// version 0 caches predate versioning and have the version 1 format
+		func(*Contract) error { return nil },
+	}
+	inMemoryContractMigrations = []func(*InMemoryContract) error{
+		func(*InMemoryContract) error { return nil },
+	}
+)
+
+// unmarshalContract decodes a Contract, upgrading it to SchemaVersion
+func unmarshalContract(b []byte) (*Contract, error) {
+	c := NewContract()
+	// the decoder doesn't change fields b lacks
+	c.SchemaVersion = 0
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	for v := c.SchemaVersion; v < len(contractMigrations); v++ {
+		if err := contractMigrations[v](c); err != nil {
+			return nil, fmt.Errorf("couldn't upgrade the cache from schema version %d: %w", v, err)
+		}
+	}
+	// a cache from a newer version of this package has no migration; keep its
+	// items, which the newer version upgrades again after this one writes them
+	c.SchemaVersion = SchemaVersion
+	return c, nil
+}
+
+// unmarshalInMemoryContract decodes an InMemoryContract, upgrading it to SchemaVersion
+func unmarshalInMemoryContract(b []byte) (*InMemoryContract, error) {
+	c := NewInMemoryContract()
+	c.SchemaVersion = 0
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	for v := c.SchemaVersion; v < len(inMemoryContractMigrations); v++ {
+		if err := inMemoryContractMigrations[v](c); err != nil {
+			return nil, fmt.Errorf("couldn't upgrade the cache from schema version %d: %w", v, err)
+		}
+	}
+	c.SchemaVersion = SchemaVersion
+	return c, nil
+}
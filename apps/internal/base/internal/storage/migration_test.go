@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSchemaMigration(t *testing.T) {
+	unversioned, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newForTest(nil)
+	if err := m.Unmarshal(unversioned); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.contract.Accounts) != 1 {
+		t.Fatalf("expected the unversioned cache's account, got %v", m.contract.Accounts)
+	}
+	versioned, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := []byte(fmt.Sprintf(`"SchemaVersion":%d`, SchemaVersion))
+	if !bytes.Contains(versioned, stamp) {
+		t.Fatalf("expected %s in %s", stamp, versioned)
+	}
+	buf := bytes.Buffer{}
+	if err := m.MarshalTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), stamp) {
+		t.Fatalf("expected %s in %s", stamp, buf.Bytes())
+	}
+
+	before := contractMigrations
+	defer func() { contractMigrations = before }()
+	migrated := 0
+	contractMigrations = []func(*Contract) error{
+		func(c *Contract) error {
+			migrated++
+			for k, acc := range c.Accounts {
+				acc.Name = "migrated"
+				c.Accounts[k] = acc
+			}
+			return nil
+		},
+	}
+	// only an older cache needs migration
+	for _, test := range []struct {
+		b        []byte
+		expected int
+	}{{unversioned, 1}, {versioned, 0}} {
+		migrated = 0
+		m := newForTest(nil)
+		if err := m.Unmarshal(test.b); err != nil {
+			t.Fatal(err)
+		}
+		if migrated != test.expected {
+			t.Fatalf("expected %d migrations, got %d", test.expected, migrated)
+		}
+		for _, acc := range m.contract.Accounts {
+			if (acc.Name == "migrated") != (test.expected > 0) {
+				t.Fatalf("unexpected account name %q", acc.Name)
+			}
+		}
+		if m.contract.SchemaVersion != SchemaVersion {
+			t.Fatalf("expected schema version %d, got %d", SchemaVersion, m.contract.SchemaVersion)
+		}
+	}
+
+	// a newer cache loads without migration and keeps its items
+	newer := bytes.Replace(versioned, stamp, []byte(fmt.Sprintf(`"SchemaVersion":%d`, SchemaVersion+1)), 1)
+	m = newForTest(nil)
+	if err := m.Unmarshal(newer); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.contract.Accounts) != 1 || m.contract.SchemaVersion != SchemaVersion {
+		t.Fatalf("unexpected contract %+v", m.contract)
+	}
+
+	// a failed migration fails the load instead of dropping items
+	expected := errors.New("migration failed")
+	contractMigrations = []func(*Contract) error{func(*Contract) error { return expected }}
+	if err := newForTest(nil).Unmarshal(unversioned); !errors.Is(err, expected) {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+}
+
+func TestInMemorySchemaMigration(t *testing.T) {
+	m := NewPartitionedManager(nil)
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(fmt.Sprintf(`"SchemaVersion":%d`, SchemaVersion))) {
+		t.Fatalf("expected a schema version in %s", b)
+	}
+	before := inMemoryContractMigrations
+	defer func() { inMemoryContractMigrations = before }()
+	migrated := 0
+	inMemoryContractMigrations = []func(*InMemoryContract) error{
+		func(*InMemoryContract) error { migrated++; return nil },
+	}
+	for _, test := range []struct {
+		b        []byte
+		expected int
+	}{{[]byte(`{"AppMetaData":{}}`), 1}, {b, 0}} {
+		migrated = 0
+		if err := m.Unmarshal(test.b); err != nil {
+			t.Fatal(err)
+		}
+		if migrated != test.expected {
+			t.Fatalf("expected %d migrations, got %d", test.expected, migrated)
+		}
+	}
+}
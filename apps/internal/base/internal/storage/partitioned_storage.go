@@ -6,12 +6,12 @@ package storage
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strings"
 	"sync"
-	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json"
+	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
@@ -26,6 +26,10 @@ type PartitionedManager struct {
 
 	aadCacheMu sync.RWMutex
 	aadCache   map[string]authority.InstanceDiscoveryMetadata
+
+	secrets secrets
+	// compressed is whether Marshal gzips the data it returns
+	compressed bool
 }
 
 // NewPartitionedManager is the constructor for PartitionedManager.
@@ -35,20 +39,29 @@ func NewPartitionedManager(requests *oauth.Client) *PartitionedManager {
 	return m
 }
 
+// SetEncrypter sets an Encrypter the manager uses to encrypt token secrets it holds. Read returns
+// decrypted secrets and Marshal serializes them in plaintext. Call SetEncrypter before using the manager.
+func (m *PartitionedManager) SetEncrypter(e cache.Encrypter) {
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	m.secrets = secrets{e: e}
+}
+
 // Read reads a storage token from the cache if it exists.
 func (m *PartitionedManager) Read(ctx context.Context, authParameters authority.AuthParams) (TokenResponse, error) {
 	realm := authParameters.AuthorityInfo.Tenant
 	clientID := authParameters.ClientID
-	scopes := authParameters.Scopes
+	scopes := authParameters.NormalizedScopes()
 
 	metadata, err := m.getMetadataEntry(ctx, authParameters.AuthorityInfo)
 	if err != nil {
 		return TokenResponse{}, err
 	}
+	metadata.Aliases = withKnownAliases(authParameters.AuthorityInfo.Host, metadata.Aliases)
 	userAssertionHash := authParameters.AssertionHash()
 	partitionKeyFromRequest := userAssertionHash
 
-	accessToken, err := m.readAccessToken(metadata.Aliases, realm, clientID, userAssertionHash, scopes, partitionKeyFromRequest)
+	accessToken, err := m.readAccessToken(metadata.Aliases, realm, clientID, userAssertionHash, scopes, authParameters.TokenVersion(), partitionKeyFromRequest)
 	if err != nil {
 		return TokenResponse{}, err
 	}
@@ -59,8 +72,10 @@ func (m *PartitionedManager) Read(ctx context.Context, authParameters authority.
 	}
 	familyID := AppMetaData.FamilyID
 
+	// a valid access token suffices when the authority issued no refresh token, as it may not for a token
+	// exchange or an OBO request without offline_access
 	refreshToken, err := m.readRefreshToken(metadata.Aliases, familyID, clientID, userAssertionHash, partitionKeyFromRequest)
-	if err != nil {
+	if err != nil && !isNotFound(err) {
 		return TokenResponse{}, err
 	}
 
@@ -73,39 +88,50 @@ func (m *PartitionedManager) Read(ctx context.Context, authParameters authority.
 	if err != nil {
 		return TokenResponse{}, err
 	}
-	return TokenResponse{
+	return m.secrets.openResponse(TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		IDToken:      idToken,
 		Account:      account,
-	}, nil
+	})
 }
 
 // Write writes a token response to the cache and returns the account information the token is stored with.
 func (m *PartitionedManager) Write(authParameters authority.AuthParams, tokenResponse accesstokens.TokenResponse) (shared.Account, error) {
-	authParameters.HomeAccountID = tokenResponse.ClientInfo.HomeAccountID()
+	tokenResponse, err := m.secrets.sealResponse(tokenResponse)
+	if err != nil {
+		return shared.Account{}, err
+	}
+	authParameters.HomeAccountID = tokenResponse.HomeAccountID(authParameters)
 	homeAccountID := authParameters.HomeAccountID
 	environment := authParameters.AuthorityInfo.Host
 	realm := authParameters.AuthorityInfo.Tenant
 	clientID := authParameters.ClientID
 	target := strings.Join(tokenResponse.GrantedScopes.Slice, scopeSeparator)
 	userAssertionHash := authParameters.AssertionHash()
-	cachedAt := time.Now()
+	cachedAt := authParameters.ClientNow()
 
-	var account shared.Account
+	// as in Manager.Write, build every item before adding them all at once
+	var (
+		account      shared.Account
+		refreshToken *accesstokens.RefreshToken
+		accessToken  *AccessToken
+		idToken      *IDToken
+	)
 
 	if len(tokenResponse.RefreshToken) > 0 {
-		refreshToken := accesstokens.NewRefreshToken(homeAccountID, environment, clientID, tokenResponse.RefreshToken, tokenResponse.FamilyID)
-		if authParameters.AuthorizationType == authority.ATOnBehalfOf {
-			refreshToken.UserAssertionHash = userAssertionHash
+		rt := accesstokens.NewRefreshToken(homeAccountID, environment, clientID, tokenResponse.RefreshToken, tokenResponse.FamilyID)
+		if !tokenResponse.RefreshTokenExpiresOn.T.IsZero() {
+			rt.ExpiresOn = internalTime.Unix{T: tokenResponse.RefreshTokenExpiresOn.T.UTC()}
 		}
-		if err := m.writeRefreshToken(refreshToken, getPartitionKeyRefreshToken(refreshToken)); err != nil {
-			return account, err
+		if authParameters.AuthorizationType == authority.ATOnBehalfOf {
+			rt.UserAssertionHash = userAssertionHash
 		}
+		refreshToken = &rt
 	}
 
 	if len(tokenResponse.AccessToken) > 0 {
-		accessToken := NewAccessToken(
+		at := NewAccessToken(
 			homeAccountID,
 			environment,
 			realm,
@@ -117,56 +143,55 @@ func (m *PartitionedManager) Write(authParameters authority.AuthParams, tokenRes
 			tokenResponse.AccessToken,
 		)
 		if authParameters.AuthorizationType == authority.ATOnBehalfOf {
-			accessToken.UserAssertionHash = userAssertionHash // get Hash method on this
+			at.UserAssertionHash = userAssertionHash // get Hash method on this
 		}
+		at.TokenVersion = authParameters.TokenVersion()
 
-		// Since we have a valid access token, cache it before moving on.
-		if err := accessToken.Validate(); err == nil {
-			if err := m.writeAccessToken(accessToken, getPartitionKeyAccessToken(accessToken)); err != nil {
-				return account, err
-			}
-		} else {
+		// an invalid access token fails the write before the cache changes
+		if err := at.ValidateAt(cachedAt, authParameters.ClockSkew); err != nil {
 			return shared.Account{}, err
 		}
+		accessToken = &at
 	}
 
 	idTokenJwt := tokenResponse.IDToken
 	if !idTokenJwt.IsZero() {
-		idToken := NewIDToken(homeAccountID, environment, realm, clientID, idTokenJwt.RawToken)
+		idt := NewIDToken(homeAccountID, environment, realm, clientID, idTokenJwt.RawToken)
 		if authParameters.AuthorizationType == authority.ATOnBehalfOf {
-			idToken.UserAssertionHash = userAssertionHash
+			idt.UserAssertionHash = userAssertionHash
 		}
-		if err := m.writeIDToken(idToken, getPartitionKeyIDToken(idToken)); err != nil {
-			return shared.Account{}, err
-		}
-
-		localAccountID := idTokenJwt.LocalAccountID()
-		authorityType := authParameters.AuthorityInfo.AuthorityType
+		idToken = &idt
 
-		account = shared.NewAccount(
-			homeAccountID,
-			environment,
-			realm,
-			localAccountID,
-			authorityType,
-			idTokenJwt.PreferredUsername,
-		)
+		account = newAccount(homeAccountID, environment, realm, authParameters.AuthorityInfo.AuthorityType, idTokenJwt)
 		if authParameters.AuthorizationType == authority.ATOnBehalfOf {
 			account.UserAssertionHash = userAssertionHash
 		}
-		if err := m.writeAccount(account, getPartitionKeyAccount(account)); err != nil {
-			return shared.Account{}, err
-		}
 	}
 
 	AppMetaData := NewAppMetaData(tokenResponse.FamilyID, clientID, environment)
 
-	if err := m.writeAppMetaData(AppMetaData); err != nil {
-		return shared.Account{}, err
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	if refreshToken != nil {
+		m.putRefreshToken(*refreshToken, getPartitionKeyRefreshToken(*refreshToken))
+	}
+	if accessToken != nil {
+		m.putAccessToken(*accessToken, getPartitionKeyAccessToken(*accessToken))
 	}
+	if idToken != nil {
+		m.putIDToken(*idToken, getPartitionKeyIDToken(*idToken))
+		m.putAccount(account, getPartitionKeyAccount(account))
+	}
+	m.contract.AppMetaData[AppMetaData.Key()] = AppMetaData
 	return account, nil
 }
 
+// Warmup fetches and caches the instance metadata of the authority in authParameters, so that a later Read needn't.
+func (m *PartitionedManager) Warmup(ctx context.Context, authParameters authority.AuthParams) error {
+	_, err := m.getMetadataEntry(ctx, authParameters.AuthorityInfo)
+	return err
+}
+
 func (m *PartitionedManager) getMetadataEntry(ctx context.Context, authorityInfo authority.Info) (authority.InstanceDiscoveryMetadata, error) {
 	md, err := m.aadMetadataFromCache(ctx, authorityInfo)
 	if err != nil {
@@ -209,7 +234,7 @@ func (m *PartitionedManager) aadMetadata(ctx context.Context, authorityInfo auth
 	return m.aadCache[authorityInfo.Host], nil
 }
 
-func (m *PartitionedManager) readAccessToken(envAliases []string, realm, clientID, userAssertionHash string, scopes []string, partitionKey string) (AccessToken, error) {
+func (m *PartitionedManager) readAccessToken(envAliases []string, realm, clientID, userAssertionHash string, scopes []string, tokenVersion, partitionKey string) (AccessToken, error) {
 	m.contractMu.RLock()
 	defer m.contractMu.RUnlock()
 	if accessTokens, ok := m.contract.AccessTokensPartition[partitionKey]; ok {
@@ -217,7 +242,7 @@ func (m *PartitionedManager) readAccessToken(envAliases []string, realm, clientI
 		// this shows up as the dominating node in a profile. for real-world scenarios this likely isn't
 		// an issue, however if it does become a problem then we know where to look.
 		for _, at := range accessTokens {
-			if at.Realm == realm && at.ClientID == clientID && at.UserAssertionHash == userAssertionHash {
+			if at.Realm == realm && at.ClientID == clientID && at.UserAssertionHash == userAssertionHash && at.TokenVersion == tokenVersion {
 				if checkAlias(at.Environment, envAliases) {
 					if isMatchingScopes(scopes, at.Scopes) {
 						return at, nil
@@ -226,18 +251,22 @@ func (m *PartitionedManager) readAccessToken(envAliases []string, realm, clientI
 			}
 		}
 	}
-	return AccessToken{}, fmt.Errorf("access token not found")
+	return AccessToken{}, NotFoundError("access token not found")
 }
 
 func (m *PartitionedManager) writeAccessToken(accessToken AccessToken, partitionKey string) error {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
-	key := accessToken.Key()
+	m.putAccessToken(accessToken, partitionKey)
+	return nil
+}
+
+// putAccessToken adds an access token to a partition. The caller must hold contractMu.
+func (m *PartitionedManager) putAccessToken(accessToken AccessToken, partitionKey string) {
 	if m.contract.AccessTokensPartition[partitionKey] == nil {
 		m.contract.AccessTokensPartition[partitionKey] = make(map[string]AccessToken)
 	}
-	m.contract.AccessTokensPartition[partitionKey][key] = accessToken
-	return nil
+	m.contract.AccessTokensPartition[partitionKey][accessToken.Key()] = accessToken
 }
 
 func matchFamilyRefreshTokenObo(rt accesstokens.RefreshToken, userAssertionHash string, envAliases []string) bool {
@@ -286,18 +315,22 @@ func (m *PartitionedManager) readRefreshToken(envAliases []string, familyID, cli
 		}
 	}
 
-	return accesstokens.RefreshToken{}, fmt.Errorf("refresh token not found")
+	return accesstokens.RefreshToken{}, NotFoundError("refresh token not found")
 }
 
 func (m *PartitionedManager) writeRefreshToken(refreshToken accesstokens.RefreshToken, partitionKey string) error {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
-	key := refreshToken.Key()
-	if m.contract.AccessTokensPartition[partitionKey] == nil {
+	m.putRefreshToken(refreshToken, partitionKey)
+	return nil
+}
+
+// putRefreshToken adds a refresh token to a partition. The caller must hold contractMu.
+func (m *PartitionedManager) putRefreshToken(refreshToken accesstokens.RefreshToken, partitionKey string) {
+	if m.contract.RefreshTokensPartition[partitionKey] == nil {
 		m.contract.RefreshTokensPartition[partitionKey] = make(map[string]accesstokens.RefreshToken)
 	}
-	m.contract.RefreshTokensPartition[partitionKey][key] = refreshToken
-	return nil
+	m.contract.RefreshTokensPartition[partitionKey][refreshToken.Key()] = refreshToken
 }
 
 func (m *PartitionedManager) readIDToken(envAliases []string, realm, clientID, userAssertionHash, partitionKey string) (IDToken, error) {
@@ -310,18 +343,22 @@ func (m *PartitionedManager) readIDToken(envAliases []string, realm, clientID, u
 			}
 		}
 	}
-	return IDToken{}, fmt.Errorf("token not found")
+	return IDToken{}, NotFoundError("token not found")
 }
 
 func (m *PartitionedManager) writeIDToken(idToken IDToken, partitionKey string) error {
-	key := idToken.Key()
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
+	m.putIDToken(idToken, partitionKey)
+	return nil
+}
+
+// putIDToken adds an ID token to a partition. The caller must hold contractMu.
+func (m *PartitionedManager) putIDToken(idToken IDToken, partitionKey string) {
 	if m.contract.IDTokensPartition[partitionKey] == nil {
 		m.contract.IDTokensPartition[partitionKey] = make(map[string]IDToken)
 	}
-	m.contract.IDTokensPartition[partitionKey][key] = idToken
-	return nil
+	m.contract.IDTokensPartition[partitionKey][idToken.Key()] = idToken
 }
 
 func (m *PartitionedManager) readAccount(envAliases []string, realm, UserAssertionHash, partitionKey string) (shared.Account, error) {
@@ -339,18 +376,22 @@ func (m *PartitionedManager) readAccount(envAliases []string, realm, UserAsserti
 			return acc, nil
 		}
 	}
-	return shared.Account{}, fmt.Errorf("account not found")
+	return shared.Account{}, NotFoundError("account not found")
 }
 
 func (m *PartitionedManager) writeAccount(account shared.Account, partitionKey string) error {
-	key := account.Key()
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
+	m.putAccount(account, partitionKey)
+	return nil
+}
+
+// putAccount adds an account to a partition. The caller must hold contractMu.
+func (m *PartitionedManager) putAccount(account shared.Account, partitionKey string) {
 	if m.contract.AccountsPartition[partitionKey] == nil {
 		m.contract.AccountsPartition[partitionKey] = make(map[string]shared.Account)
 	}
-	m.contract.AccountsPartition[partitionKey][key] = account
-	return nil
+	m.contract.AccountsPartition[partitionKey][account.Key()] = account
 }
 
 func (m *PartitionedManager) readAppMetaData(envAliases []string, clientID string) (AppMetaData, error) {
@@ -362,7 +403,7 @@ func (m *PartitionedManager) readAppMetaData(envAliases []string, clientID strin
 			return app, nil
 		}
 	}
-	return AppMetaData{}, fmt.Errorf("not found")
+	return AppMetaData{}, NotFoundError("not found")
 }
 
 func (m *PartitionedManager) writeAppMetaData(AppMetaData AppMetaData) error {
@@ -383,20 +424,48 @@ func (m *PartitionedManager) update(cache *InMemoryContract) {
 
 // Marshal implements cache.Marshaler.
 func (m *PartitionedManager) Marshal() ([]byte, error) {
-	return json.Marshal(m.contract)
+	m.contractMu.RLock()
+	compressed := m.compressed
+	m.contractMu.RUnlock()
+	b, err := m.marshal()
+	if err != nil || !compressed {
+		return b, err
+	}
+	return compress(b)
+}
+
+func (m *PartitionedManager) marshal() ([]byte, error) {
+	if m.secrets.e == nil {
+		return json.Marshal(m.contract)
+	}
+	m.contractMu.RLock()
+	contract, err := convertInMemoryContract(m.contract, m.secrets.open)
+	m.contractMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(contract)
 }
 
 // Unmarshal implements cache.Unmarshaler.
 func (m *PartitionedManager) Unmarshal(b []byte) error {
+	b, err := decompress(b)
+	if err != nil {
+		return err
+	}
+
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 
-	contract := NewInMemoryContract()
-
-	err := json.Unmarshal(b, contract)
+	contract, err := unmarshalInMemoryContract(b)
 	if err != nil {
 		return err
 	}
+	if m.secrets.e != nil {
+		if contract, err = convertInMemoryContract(contract, m.secrets.seal); err != nil {
+			return err
+		}
+	}
 
 	m.contract = contract
 
@@ -81,6 +81,49 @@ func TestOBOAccessTokenScopes(t *testing.T) {
 	}
 }
 
+func TestPartitionedWriteIsAtomic(t *testing.T) {
+	fakeAuthority := "fakeauthority"
+	mgr := newPartitionedManagerForTest(&fakeDiscoveryResponser{})
+	ap := authority.AuthParams{
+		AuthorityInfo: authority.Info{
+			AuthorityType: authority.AAD,
+			Host:          fakeAuthority,
+			Tenant:        "tenant",
+		},
+		AuthorizationType: authority.ATOnBehalfOf,
+		ClientID:          "client-id",
+		Scopes:            []string{"scope"},
+		UserAssertion:     "assertion",
+	}
+	// a response having an invalid access token shouldn't write any of its items
+	_, err := mgr.Write(ap, accesstokens.TokenResponse{
+		AccessToken:   "at",
+		ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(-time.Hour)},
+		GrantedScopes: accesstokens.Scopes{Slice: ap.Scopes},
+		RefreshToken:  "rt",
+	})
+	if err == nil {
+		t.Fatal("expected an error for an expired access token")
+	}
+	if n := len(mgr.contract.RefreshTokensPartition) + len(mgr.contract.AppMetaData); n != 0 {
+		t.Fatalf("expected an empty cache, found %d items", n)
+	}
+	// a refresh token should be written to a partition having only an access token
+	for _, rt := range []string{"", "rt"} {
+		if _, err := mgr.Write(ap, accesstokens.TokenResponse{
+			AccessToken:   "at",
+			ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+			GrantedScopes: accesstokens.Scopes{Slice: ap.Scopes},
+			RefreshToken:  rt,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mgr.contract.RefreshTokensPartition) != 1 {
+		t.Fatalf("expected 1 refresh token partition, got %d", len(mgr.contract.RefreshTokensPartition))
+	}
+}
+
 func TestOBOPartitioning(t *testing.T) {
 	fakeAuthority := "fakeauthority"
 	mgr := newPartitionedManagerForTest(&fakeDiscoveryResponser{
@@ -169,6 +212,7 @@ func TestReadPartitionedAccessToken(t *testing.T) {
 		"cid",
 		"user_assertion_hash",
 		[]string{"user.read", "openid"},
+		"",
 		"at_partition",
 	)
 	if err != nil {
@@ -183,6 +227,7 @@ func TestReadPartitionedAccessToken(t *testing.T) {
 		"cid",
 		"this_should_break_it",
 		[]string{"user.read", "openid"},
+		"",
 		"at_partition",
 	)
 	if err == nil {
@@ -0,0 +1,452 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	stdJSON "encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
+)
+
+// gzipMagic begins gzip data. A serialized cache is a JSON object, so it can't begin with these bytes.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// compress returns b gzipped
+func compress(b []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns b decompressed when b is gzipped, and b otherwise
+func decompress(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// streamTo calls write with a writer to w that gzips its input when compressed is true
+func streamTo(w io.Writer, compressed bool, write func(io.Writer) error) error {
+	if !compressed {
+		return write(w)
+	}
+	zw := gzip.NewWriter(w)
+	if err := write(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// objectWriter writes a JSON object one member at a time, so that serializing a large cache doesn't
+// require holding the entire serialized cache in memory. It stops writing after its first error.
+type objectWriter struct {
+	w       io.Writer
+	members int
+	err     error
+}
+
+func (o *objectWriter) write(b []byte) {
+	if o.err == nil {
+		_, o.err = o.w.Write(b)
+	}
+}
+
+// name writes the name of the next member
+func (o *objectWriter) name(name string) {
+	if o.members == 0 {
+		o.write([]byte("{"))
+	} else {
+		o.write([]byte(","))
+	}
+	o.members++
+	b, err := stdJSON.Marshal(name)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	o.write(b)
+	o.write([]byte(":"))
+}
+
+// member writes a member whose value is v. When v is a map, it writes the map's entries one at a time.
+func (o *objectWriter) member(name string, v interface{}) {
+	o.name(name)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.IsNil() {
+		marshal := json.Marshal
+		if rv.Kind() != reflect.Struct {
+			// json.Marshal accepts only structs, and other values such as an int or an
+			// additional field's json.RawMessage need no special handling anyway
+			marshal = stdJSON.Marshal
+		}
+		b, err := marshal(v)
+		if err != nil && o.err == nil {
+			o.err = err
+		}
+		o.write(b)
+		return
+	}
+	inner := objectWriter{w: o.w}
+	for iter := rv.MapRange(); iter.Next() && o.err == nil; {
+		inner.member(iter.Key().String(), iter.Value().Interface())
+		o.err = inner.err
+	}
+	o.err = inner.close()
+}
+
+// close ends the object
+func (o *objectWriter) close() error {
+	if o.members == 0 {
+		o.write([]byte("{"))
+	}
+	o.write([]byte("}"))
+	return o.err
+}
+
+// writeContract writes c as json.Marshal would
+func writeContract(w io.Writer, c *Contract) error {
+	o := objectWriter{w: w}
+	for _, m := range []struct {
+		name string
+		v    interface{}
+		n    int
+	}{
+		{"AccessToken", c.AccessTokens, len(c.AccessTokens)},
+		{"RefreshToken", c.RefreshTokens, len(c.RefreshTokens)},
+		{"IdToken", c.IDTokens, len(c.IDTokens)},
+		{"Account", c.Accounts, len(c.Accounts)},
+		{"AppMetadata", c.AppMetaData, len(c.AppMetaData)},
+	} {
+		// these members are omitempty
+		if m.n > 0 {
+			o.member(m.name, m.v)
+		}
+	}
+	if c.SchemaVersion != 0 {
+		o.member("SchemaVersion", c.SchemaVersion)
+	}
+	for k, v := range c.AdditionalFields {
+		o.member(k, v)
+	}
+	return o.close()
+}
+
+// writeInMemoryContract writes c as json.Marshal would
+func writeInMemoryContract(w io.Writer, c *InMemoryContract) error {
+	o := objectWriter{w: w}
+	o.member("AccessTokensPartition", c.AccessTokensPartition)
+	o.member("RefreshTokensPartition", c.RefreshTokensPartition)
+	o.member("IDTokensPartition", c.IDTokensPartition)
+	o.member("AccountsPartition", c.AccountsPartition)
+	o.member("AppMetaData", c.AppMetaData)
+	if c.SchemaVersion != 0 {
+		o.member("SchemaVersion", c.SchemaVersion)
+	}
+	for k, v := range c.AdditionalFields {
+		o.member(k, v)
+	}
+	return o.close()
+}
+
+// SetCompression sets whether the manager gzips the data it serializes. The manager unmarshals
+// compressed and uncompressed data regardless of this setting.
+func (m *Manager) SetCompression(compressed bool) {
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	m.compressed = compressed
+}
+
+// MarshalTo implements cache.StreamMarshaler. It writes the data Marshal returns to w, serializing one
+// cached item at a time rather than buffering the entire cache. The cache can't change while MarshalTo
+// writes, so writes to it wait for MarshalTo to return.
+func (m *Manager) MarshalTo(w io.Writer) error {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	contract := m.contract
+	if m.secrets.e != nil {
+		// the stored secrets are encrypted, so serialize a copy having the plaintext
+		var err error
+		if contract, err = convertContract(m.contract, m.secrets.open); err != nil {
+			return err
+		}
+	}
+	return streamTo(w, m.compressed, func(w io.Writer) error { return writeContract(w, contract) })
+}
+
+// UnmarshalFrom implements cache.StreamUnmarshaler.
+func (m *Manager) UnmarshalFrom(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.Unmarshal(b)
+}
+
+// accessTokenPartition returns the key under which a cache accessor stores at. This is the key AuthParams.CacheKey
+// returns for requests that read or write at: the home account ID of a user's token and the app key of an app's.
+func accessTokenPartition(at AccessToken) string {
+	if at.HomeAccountID != "" {
+		return at.HomeAccountID
+	}
+	p := authority.AuthParams{ClientID: at.ClientID, AuthorityInfo: authority.Info{Tenant: at.Realm}}
+	return p.AppKey()
+}
+
+// partition returns a copy of the part of m's contract a cache accessor stores under key, with secrets decrypted.
+// App metadata belongs to every partition. m.contractMu must be locked.
+func (m *Manager) partition(key string) (*Contract, error) {
+	c := NewContract()
+	for k, at := range m.contract.AccessTokens {
+		if accessTokenPartition(at) == key {
+			c.AccessTokens[k] = at
+		}
+	}
+	for k, rt := range m.contract.RefreshTokens {
+		if rt.HomeAccountID == key {
+			c.RefreshTokens[k] = rt
+		}
+	}
+	for k, id := range m.contract.IDTokens {
+		if id.HomeAccountID == key {
+			c.IDTokens[k] = id
+		}
+	}
+	for k, acc := range m.contract.Accounts {
+		if acc.HomeAccountID == key {
+			c.Accounts[k] = acc
+		}
+	}
+	for k, md := range m.contract.AppMetaData {
+		c.AppMetaData[k] = md
+	}
+	return convertContract(c, m.secrets.open)
+}
+
+// MarshalPartition is like Marshal, except it serializes only the items a cache accessor stores under key
+// i.e., the items of requests for which AuthParams.CacheKey returns key.
+func (m *Manager) MarshalPartition(key string) ([]byte, error) {
+	m.contractMu.RLock()
+	c, err := m.partition(key)
+	compressed := m.compressed
+	m.contractMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(c)
+	if err != nil || !compressed {
+		return b, err
+	}
+	return compress(b)
+}
+
+// MarshalPartitionTo is like MarshalTo, except it serializes only the items a cache accessor stores under key.
+func (m *Manager) MarshalPartitionTo(key string, w io.Writer) error {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	c, err := m.partition(key)
+	if err != nil {
+		return err
+	}
+	return streamTo(w, m.compressed, func(w io.Writer) error { return writeContract(w, c) })
+}
+
+// UnmarshalPartition replaces the items a cache accessor stores under key with the items in b, which
+// MarshalPartition returned. Unlike Unmarshal, it doesn't change other items.
+func (m *Manager) UnmarshalPartition(key string, b []byte) error {
+	b, err := decompress(b)
+	if err != nil {
+		return err
+	}
+	c, err := unmarshalContract(b)
+	if err != nil {
+		return err
+	}
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	if c, err = convertContract(c, m.secrets.seal); err != nil {
+		return err
+	}
+	for k, at := range m.contract.AccessTokens {
+		if accessTokenPartition(at) == key {
+			delete(m.contract.AccessTokens, k)
+		}
+	}
+	for k, rt := range m.contract.RefreshTokens {
+		if rt.HomeAccountID == key {
+			delete(m.contract.RefreshTokens, k)
+		}
+	}
+	for k, id := range m.contract.IDTokens {
+		if id.HomeAccountID == key {
+			delete(m.contract.IDTokens, k)
+		}
+	}
+	for k, acc := range m.contract.Accounts {
+		if acc.HomeAccountID == key {
+			delete(m.contract.Accounts, k)
+		}
+	}
+	for k, at := range c.AccessTokens {
+		m.contract.AccessTokens[k] = at
+	}
+	for k, rt := range c.RefreshTokens {
+		m.contract.RefreshTokens[k] = rt
+	}
+	for k, id := range c.IDTokens {
+		m.contract.IDTokens[k] = id
+	}
+	for k, acc := range c.Accounts {
+		m.contract.Accounts[k] = acc
+	}
+	for k, md := range c.AppMetaData {
+		m.contract.AppMetaData[k] = md
+	}
+	return nil
+}
+
+// SetCompression sets whether the manager gzips the data it serializes. The manager unmarshals
+// compressed and uncompressed data regardless of this setting.
+func (m *PartitionedManager) SetCompression(compressed bool) {
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	m.compressed = compressed
+}
+
+// MarshalTo implements cache.StreamMarshaler. It writes the data Marshal returns to w, serializing one
+// cached item at a time rather than buffering the entire cache. The cache can't change while MarshalTo
+// writes, so writes to it wait for MarshalTo to return.
+func (m *PartitionedManager) MarshalTo(w io.Writer) error {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	contract := m.contract
+	if m.secrets.e != nil {
+		// the stored secrets are encrypted, so serialize a copy having the plaintext
+		var err error
+		if contract, err = convertInMemoryContract(m.contract, m.secrets.open); err != nil {
+			return err
+		}
+	}
+	return streamTo(w, m.compressed, func(w io.Writer) error { return writeInMemoryContract(w, contract) })
+}
+
+// UnmarshalFrom implements cache.StreamUnmarshaler.
+func (m *PartitionedManager) UnmarshalFrom(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.Unmarshal(b)
+}
+
+// partition returns a copy of the part of m's contract a cache accessor stores under key, with secrets decrypted.
+// That's the access and refresh tokens partitioned under key, plus the ID tokens and accounts of their users.
+// App metadata belongs to every partition. m.contractMu must be locked.
+func (m *PartitionedManager) partition(key string) (*InMemoryContract, error) {
+	c := NewInMemoryContract()
+	homeIDs := map[string]bool{key: true}
+	if ats, ok := m.contract.AccessTokensPartition[key]; ok {
+		c.AccessTokensPartition[key] = ats
+		for _, at := range ats {
+			homeIDs[at.HomeAccountID] = true
+		}
+	}
+	if rts, ok := m.contract.RefreshTokensPartition[key]; ok {
+		c.RefreshTokensPartition[key] = rts
+		for _, rt := range rts {
+			homeIDs[rt.HomeAccountID] = true
+		}
+	}
+	for homeID := range homeIDs {
+		if ids, ok := m.contract.IDTokensPartition[homeID]; ok {
+			c.IDTokensPartition[homeID] = ids
+		}
+		if accs, ok := m.contract.AccountsPartition[homeID]; ok {
+			c.AccountsPartition[homeID] = accs
+		}
+	}
+	for k, md := range m.contract.AppMetaData {
+		c.AppMetaData[k] = md
+	}
+	return convertInMemoryContract(c, m.secrets.open)
+}
+
+// MarshalPartition is like Marshal, except it serializes only the items a cache accessor stores under key.
+func (m *PartitionedManager) MarshalPartition(key string) ([]byte, error) {
+	m.contractMu.RLock()
+	c, err := m.partition(key)
+	compressed := m.compressed
+	m.contractMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(c)
+	if err != nil || !compressed {
+		return b, err
+	}
+	return compress(b)
+}
+
+// MarshalPartitionTo is like MarshalTo, except it serializes only the items a cache accessor stores under key.
+func (m *PartitionedManager) MarshalPartitionTo(key string, w io.Writer) error {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	c, err := m.partition(key)
+	if err != nil {
+		return err
+	}
+	return streamTo(w, m.compressed, func(w io.Writer) error { return writeInMemoryContract(w, c) })
+}
+
+// UnmarshalPartition replaces the items a cache accessor stores under key with the items in b, which
+// MarshalPartition returned. Unlike Unmarshal, it doesn't change other partitions. It replaces the ID
+// tokens and accounts of users whose tokens b contains.
+func (m *PartitionedManager) UnmarshalPartition(key string, b []byte) error {
+	b, err := decompress(b)
+	if err != nil {
+		return err
+	}
+	c, err := unmarshalInMemoryContract(b)
+	if err != nil {
+		return err
+	}
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	if c, err = convertInMemoryContract(c, m.secrets.seal); err != nil {
+		return err
+	}
+	delete(m.contract.AccessTokensPartition, key)
+	delete(m.contract.RefreshTokensPartition, key)
+	for k, ats := range c.AccessTokensPartition {
+		m.contract.AccessTokensPartition[k] = ats
+	}
+	for k, rts := range c.RefreshTokensPartition {
+		m.contract.RefreshTokensPartition[k] = rts
+	}
+	for k, ids := range c.IDTokensPartition {
+		m.contract.IDTokensPartition[k] = ids
+	}
+	for k, accs := range c.AccountsPartition {
+		m.contract.AccountsPartition[k] = accs
+	}
+	for k, md := range c.AppMetaData {
+		m.contract.AppMetaData[k] = md
+	}
+	return nil
+}
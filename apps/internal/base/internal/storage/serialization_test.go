@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
+)
+
+func TestSerialization(t *testing.T) {
+	now := time.Now()
+	users := []string{"a", "b"}
+	accounts := map[string]shared.Account{}
+	params := map[string]authority.AuthParams{}
+	m := newForTest(nil)
+	for _, uid := range users {
+		tr := accesstokens.TokenResponse{
+			AccessToken:   uid + accessTokenSecret,
+			RefreshToken:  uid + rtSecret,
+			IDToken:       accesstokens.IDToken{RawToken: idSecret, Oid: uid, PreferredUsername: accUser},
+			ClientInfo:    accesstokens.ClientInfo{UID: uid, UTID: "utid"},
+			GrantedScopes: accesstokens.Scopes{Slice: []string{"scope"}},
+			ExpiresOn:     internalTime.DurationTime{T: now.Add(time.Hour)},
+			ExtExpiresOn:  internalTime.DurationTime{T: now.Add(time.Hour)},
+		}
+		params[uid] = authority.AuthParams{
+			AuthorityInfo:       authority.Info{Host: defaultEnvironment, Tenant: defaultRealm, AuthorityType: accAuth},
+			ClientID:            defaultClientID,
+			HomeAccountID:       uid + ".utid",
+			KnownAuthorityHosts: []string{defaultEnvironment},
+			Scopes:              []string{"scope"},
+		}
+		account, err := m.Write(params[uid], tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts[uid] = account
+	}
+	// expectTokens checks that m has the given users' tokens and not the others'
+	expectTokens := func(m *Manager, expected ...string) {
+		t.Helper()
+		for _, uid := range users {
+			tr, err := m.Read(context.Background(), params[uid], accounts[uid])
+			found := err == nil && tr.AccessToken.Secret == uid+accessTokenSecret
+			want := false
+			for _, e := range expected {
+				want = want || e == uid
+			}
+			if found != want {
+				t.Errorf("expected the cache to have %v tokens, got %q", expected, tr.AccessToken.Secret)
+			}
+		}
+	}
+
+	for _, compressed := range []bool{false, true} {
+		m.SetCompression(compressed)
+		b, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(b, gzipMagic) != compressed {
+			t.Fatalf("unexpected compression of %q", b)
+		}
+		buf := bytes.Buffer{}
+		if err = m.MarshalTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(buf.Bytes(), gzipMagic) != compressed {
+			t.Fatalf("unexpected compression of %q", buf.Bytes())
+		}
+		fromBytes, fromStream := newForTest(nil), newForTest(nil)
+		if err = fromBytes.Unmarshal(b); err != nil {
+			t.Fatal(err)
+		}
+		if err = fromStream.UnmarshalFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		expectTokens(fromBytes, users...)
+		expectTokens(fromStream, users...)
+
+		// a partition contains only its user's tokens
+		b, err = m.MarshalPartition(params["a"].HomeAccountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partial := newForTest(nil)
+		if err = partial.UnmarshalPartition(params["a"].HomeAccountID, b); err != nil {
+			t.Fatal(err)
+		}
+		expectTokens(partial, "a")
+
+		// unmarshaling a partition doesn't affect other partitions
+		b, err = m.MarshalPartition(params["b"].HomeAccountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = partial.UnmarshalPartition(params["b"].HomeAccountID, b); err != nil {
+			t.Fatal(err)
+		}
+		expectTokens(partial, users...)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
+)
+
+// Snapshot describes the content of a cache. It contains no secrets.
+type Snapshot struct {
+	Accounts []shared.Account
+	Tokens   []CachedToken
+}
+
+// CachedToken describes a cached token. It doesn't contain the token.
+type CachedToken struct {
+	// Type is "AccessToken", "RefreshToken" or "IDToken".
+	Type          string
+	HomeAccountID string
+	Environment   string
+	ClientID      string
+	// Realm is the token's tenant. Refresh tokens usually have none.
+	Realm string
+	// FamilyID identifies the family of client applications sharing a refresh token. It's empty for other tokens.
+	FamilyID string
+	// Scopes are the scopes of an access token. They're empty for other tokens.
+	Scopes []string
+	// CachedAt, ExpiresOn and ExtendedExpiresOn are set for access tokens only.
+	CachedAt          time.Time
+	ExpiresOn         time.Time
+	ExtendedExpiresOn time.Time
+}
+
+func describeAccessToken(at AccessToken) CachedToken {
+	return CachedToken{
+		Type:              at.CredentialType,
+		HomeAccountID:     at.HomeAccountID,
+		Environment:       at.Environment,
+		ClientID:          at.ClientID,
+		Realm:             at.Realm,
+		Scopes:            strings.Fields(at.Scopes),
+		CachedAt:          at.CachedAt.T,
+		ExpiresOn:         at.ExpiresOn.T,
+		ExtendedExpiresOn: at.ExtendedExpiresOn.T,
+	}
+}
+
+func describeRefreshToken(rt accesstokens.RefreshToken) CachedToken {
+	return CachedToken{
+		Type:          rt.CredentialType,
+		HomeAccountID: rt.HomeAccountID,
+		Environment:   rt.Environment,
+		ClientID:      rt.ClientID,
+		Realm:         rt.Realm,
+		FamilyID:      rt.FamilyID,
+	}
+}
+
+func describeIDToken(id IDToken) CachedToken {
+	return CachedToken{
+		Type:          id.CredentialType,
+		HomeAccountID: id.HomeAccountID,
+		Environment:   id.Environment,
+		ClientID:      id.ClientID,
+		Realm:         id.Realm,
+	}
+}
+
+// Snapshot describes the content of the cache.
+func (m *Manager) Snapshot() Snapshot {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	s := Snapshot{}
+	for _, acc := range m.contract.Accounts {
+		s.Accounts = append(s.Accounts, acc)
+	}
+	for _, at := range m.contract.AccessTokens {
+		s.Tokens = append(s.Tokens, describeAccessToken(at))
+	}
+	for _, rt := range m.contract.RefreshTokens {
+		s.Tokens = append(s.Tokens, describeRefreshToken(rt))
+	}
+	for _, id := range m.contract.IDTokens {
+		s.Tokens = append(s.Tokens, describeIDToken(id))
+	}
+	return s
+}
+
+// Snapshot describes the content of the cache.
+func (m *PartitionedManager) Snapshot() Snapshot {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	s := Snapshot{}
+	for _, partition := range m.contract.AccountsPartition {
+		for _, acc := range partition {
+			s.Accounts = append(s.Accounts, acc)
+		}
+	}
+	for _, partition := range m.contract.AccessTokensPartition {
+		for _, at := range partition {
+			s.Tokens = append(s.Tokens, describeAccessToken(at))
+		}
+	}
+	for _, partition := range m.contract.RefreshTokensPartition {
+		for _, rt := range partition {
+			s.Tokens = append(s.Tokens, describeRefreshToken(rt))
+		}
+	}
+	for _, partition := range m.contract.IDTokensPartition {
+		for _, id := range partition {
+			s.Tokens = append(s.Tokens, describeIDToken(id))
+		}
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json"
+)
+
+// Stats describes the size of a cache's partitions. It contains no secrets.
+type Stats struct {
+	// Partitions describes each partition, ordered by key.
+	Partitions []PartitionStats
+	// ApproximateBytes is the sum of the partitions' ApproximateBytes.
+	ApproximateBytes int
+}
+
+// PartitionStats describes the items in a cache partition.
+type PartitionStats struct {
+	// Key identifies the partition. It's the key AuthParams.CacheKey returns for requests that read or write
+	// the partition: a home account ID, an app key or the hash of an on-behalf-of assertion.
+	Key string
+
+	Accounts, AccessTokens, RefreshTokens, IDTokens int
+
+	// ApproximateBytes is the approximate memory footprint of the partition's items, estimated from the
+	// size of their serialization.
+	ApproximateBytes int
+
+	// OldestExpiry and NewestExpiry are the earliest and latest expiration times of the partition's access
+	// tokens. They're zero when the partition has no access tokens.
+	OldestExpiry, NewestExpiry time.Time
+}
+
+// statsBuilder accumulates PartitionStats by key
+type statsBuilder map[string]*PartitionStats
+
+func (s statsBuilder) partition(key string) *PartitionStats {
+	p, ok := s[key]
+	if !ok {
+		p = &PartitionStats{Key: key}
+		s[key] = p
+	}
+	return p
+}
+
+// add adds the size of an item and its key to the partition
+func (s statsBuilder) add(partition, key string, item interface{}) *PartitionStats {
+	p := s.partition(partition)
+	p.ApproximateBytes += len(key)
+	if b, err := json.Marshal(item); err == nil {
+		p.ApproximateBytes += len(b)
+	}
+	return p
+}
+
+func (s statsBuilder) addAccessToken(partition, key string, at AccessToken) {
+	p := s.add(partition, key, at)
+	p.AccessTokens++
+	if exp := at.ExpiresOn.T; !exp.IsZero() {
+		if p.OldestExpiry.IsZero() || exp.Before(p.OldestExpiry) {
+			p.OldestExpiry = exp
+		}
+		if exp.After(p.NewestExpiry) {
+			p.NewestExpiry = exp
+		}
+	}
+}
+
+func (s statsBuilder) stats() Stats {
+	st := Stats{Partitions: make([]PartitionStats, 0, len(s))}
+	for _, p := range s {
+		st.Partitions = append(st.Partitions, *p)
+		st.ApproximateBytes += p.ApproximateBytes
+	}
+	sort.Slice(st.Partitions, func(i, j int) bool { return st.Partitions[i].Key < st.Partitions[j].Key })
+	return st
+}
+
+// Stats describes the partitions of the cache, which are the parts a cache accessor stores under different keys.
+// App metadata belongs to every partition, so Stats doesn't count it.
+func (m *Manager) Stats() Stats {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	s := statsBuilder{}
+	for k, at := range m.contract.AccessTokens {
+		s.addAccessToken(accessTokenPartition(at), k, at)
+	}
+	for k, rt := range m.contract.RefreshTokens {
+		s.add(rt.HomeAccountID, k, rt).RefreshTokens++
+	}
+	for k, id := range m.contract.IDTokens {
+		s.add(id.HomeAccountID, k, id).IDTokens++
+	}
+	for k, acc := range m.contract.Accounts {
+		s.add(acc.HomeAccountID, k, acc).Accounts++
+	}
+	return s.stats()
+}
+
+// Stats describes the partitions of the cache. Access and refresh tokens are partitioned by the hash of the
+// assertion they were acquired on behalf of, and ID tokens and accounts by home account ID.
+func (m *PartitionedManager) Stats() Stats {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+	s := statsBuilder{}
+	for pk, partition := range m.contract.AccessTokensPartition {
+		for k, at := range partition {
+			s.addAccessToken(pk, k, at)
+		}
+	}
+	for pk, partition := range m.contract.RefreshTokensPartition {
+		for k, rt := range partition {
+			s.add(pk, k, rt).RefreshTokens++
+		}
+	}
+	for pk, partition := range m.contract.IDTokensPartition {
+		for k, id := range partition {
+			s.add(pk, k, id).IDTokens++
+		}
+	}
+	for pk, partition := range m.contract.AccountsPartition {
+		for k, acc := range partition {
+			s.add(pk, k, acc).Accounts++
+		}
+	}
+	return s.stats()
+}
@@ -14,12 +14,12 @@ package storage
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strings"
 	"sync"
-	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json"
+	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
@@ -50,6 +50,10 @@ type Manager struct {
 
 	aadCacheMu sync.RWMutex
 	aadCache   map[string]authority.InstanceDiscoveryMetadata
+
+	secrets secrets
+	// compressed is whether Marshal gzips the data it returns
+	compressed bool
 }
 
 // New is the constructor for Manager.
@@ -59,15 +63,37 @@ func New(requests *oauth.Client) *Manager {
 	return m
 }
 
+// SetEncrypter sets an Encrypter the manager uses to encrypt token secrets it holds. Read returns
+// decrypted secrets and Marshal serializes them in plaintext. Call SetEncrypter before using the manager.
+func (m *Manager) SetEncrypter(e cache.Encrypter) {
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	m.secrets = secrets{e: e}
+}
+
+// checkAlias returns true when alias is one of aliases. Hosts are case insensitive, and other MSAL libraries
+// sharing the cache don't all normalize them.
 func checkAlias(alias string, aliases []string) bool {
 	for _, v := range aliases {
-		if alias == v {
+		if strings.EqualFold(alias, v) {
 			return true
 		}
 	}
 	return false
 }
 
+// withKnownAliases returns aliases plus host and host's known aliases. Instance discovery may not return all the
+// aliases under which other MSAL libraries cache tokens, for example login.windows.net, which MSAL.js uses.
+func withKnownAliases(host string, aliases []string) []string {
+	all := append([]string{host}, aliases...)
+	for _, alias := range authority.KnownAliases(host) {
+		if !checkAlias(alias, all) {
+			all = append(all, alias)
+		}
+	}
+	return all
+}
+
 func isMatchingScopes(scopesOne []string, scopesTwo string) bool {
 	newScopesTwo := strings.Split(scopesTwo, scopeSeparator)
 	scopeCounter := 0
@@ -87,7 +113,7 @@ func (m *Manager) Read(ctx context.Context, authParameters authority.AuthParams,
 	homeAccountID := authParameters.HomeAccountID
 	realm := authParameters.AuthorityInfo.Tenant
 	clientID := authParameters.ClientID
-	scopes := authParameters.Scopes
+	scopes := authParameters.NormalizedScopes()
 
 	// fetch metadata if and only if the authority isn't explicitly trusted
 	aliases := authParameters.KnownAuthorityHosts
@@ -98,19 +124,22 @@ func (m *Manager) Read(ctx context.Context, authParameters authority.AuthParams,
 		}
 		aliases = metadata.Aliases
 	}
+	aliases = withKnownAliases(authParameters.AuthorityInfo.Host, aliases)
 
-	accessToken := m.readAccessToken(homeAccountID, aliases, realm, clientID, scopes)
+	accessToken := m.readAccessToken(homeAccountID, aliases, realm, clientID, scopes, authParameters.Scheme(), authParameters.TokenVersion())
 
 	if account.IsZero() {
-		return TokenResponse{
+		return m.secrets.openResponse(TokenResponse{
 			AccessToken:  accessToken,
 			RefreshToken: accesstokens.RefreshToken{},
 			IDToken:      IDToken{},
 			Account:      shared.Account{},
-		}, nil
+		})
 	}
+	// A guest account may have no ID token or account in realm, if the client hasn't yet acquired a token
+	// from it. The refresh token isn't specific to a tenant, so it can get one.
 	idToken, err := m.readIDToken(homeAccountID, aliases, realm, clientID)
-	if err != nil {
+	if err != nil && !isNotFound(err) {
 		return TokenResponse{}, err
 	}
 
@@ -124,42 +153,67 @@ func (m *Manager) Read(ctx context.Context, authParameters authority.AuthParams,
 	if err != nil {
 		return TokenResponse{}, err
 	}
-	account, err = m.readAccount(homeAccountID, aliases, realm)
-	if err != nil {
+	if cached, err := m.readAccount(homeAccountID, aliases, realm); err == nil {
+		account = cached
+	} else if !isNotFound(err) || account.HomeAccountID != homeAccountID {
 		return TokenResponse{}, err
 	}
-	return TokenResponse{
+	return m.secrets.openResponse(TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		IDToken:      idToken,
 		Account:      account,
-	}, nil
+	})
 }
 
 const scopeSeparator = " "
 
+// NotFoundError is returned by reads that find no matching item in the cache.
+type NotFoundError string
+
+func (e NotFoundError) Error() string {
+	return string(e)
+}
+
+func isNotFound(err error) bool {
+	var nf NotFoundError
+	return errors.As(err, &nf)
+}
+
 // Write writes a token response to the cache and returns the account information the token is stored with.
 func (m *Manager) Write(authParameters authority.AuthParams, tokenResponse accesstokens.TokenResponse) (shared.Account, error) {
-	authParameters.HomeAccountID = tokenResponse.ClientInfo.HomeAccountID()
+	tokenResponse, err := m.secrets.sealResponse(tokenResponse)
+	if err != nil {
+		return shared.Account{}, err
+	}
+	authParameters.HomeAccountID = tokenResponse.HomeAccountID(authParameters)
 	homeAccountID := authParameters.HomeAccountID
 	environment := authParameters.AuthorityInfo.Host
 	realm := authParameters.AuthorityInfo.Tenant
 	clientID := authParameters.ClientID
 	target := strings.Join(tokenResponse.GrantedScopes.Slice, scopeSeparator)
 
-	cachedAt := time.Now()
+	cachedAt := authParameters.ClientNow()
 
-	var account shared.Account
+	// build every item before changing the cache, then add them all at once, so that a concurrent reader
+	// or Marshal never sees some of a response's items without the others
+	var (
+		account      shared.Account
+		refreshToken *accesstokens.RefreshToken
+		accessToken  *AccessToken
+		idToken      *IDToken
+	)
 
 	if len(tokenResponse.RefreshToken) > 0 {
-		refreshToken := accesstokens.NewRefreshToken(homeAccountID, environment, clientID, tokenResponse.RefreshToken, tokenResponse.FamilyID)
-		if err := m.writeRefreshToken(refreshToken); err != nil {
-			return account, err
+		rt := accesstokens.NewRefreshToken(homeAccountID, environment, clientID, tokenResponse.RefreshToken, tokenResponse.FamilyID)
+		if !tokenResponse.RefreshTokenExpiresOn.T.IsZero() {
+			rt.ExpiresOn = internalTime.Unix{T: tokenResponse.RefreshTokenExpiresOn.T.UTC()}
 		}
+		refreshToken = &rt
 	}
 
 	if len(tokenResponse.AccessToken) > 0 {
-		accessToken := NewAccessToken(
+		at := NewAccessToken(
 			homeAccountID,
 			environment,
 			realm,
@@ -170,46 +224,56 @@ func (m *Manager) Write(authParameters authority.AuthParams, tokenResponse acces
 			target,
 			tokenResponse.AccessToken,
 		)
+		if scheme := authParameters.Scheme(); !strings.EqualFold(scheme.AccessTokenType(), authority.AccessTokenTypeBearer) {
+			at.TokenType = scheme.AccessTokenType()
+			at.AuthnSchemeKeyID = scheme.KeyID()
+		}
+		at.TokenVersion = authParameters.TokenVersion()
 
-		// Since we have a valid access token, cache it before moving on.
-		if err := accessToken.Validate(); err == nil {
-			if err := m.writeAccessToken(accessToken); err != nil {
-				return account, err
-			}
+		// cache the access token only when it's valid
+		if err := at.ValidateAt(cachedAt, authParameters.ClockSkew); err == nil {
+			accessToken = &at
 		}
 	}
 
+	// without a home account ID, as when the response has no client info, the ID token identifies no account
+	// the client could find again, so caching it and an account would only create an orphaned, bogus account
 	idTokenJwt := tokenResponse.IDToken
-	if !idTokenJwt.IsZero() {
-		idToken := NewIDToken(homeAccountID, environment, realm, clientID, idTokenJwt.RawToken)
-		if err := m.writeIDToken(idToken); err != nil {
-			return shared.Account{}, err
-		}
-
-		localAccountID := idTokenJwt.LocalAccountID()
-		authorityType := authParameters.AuthorityInfo.AuthorityType
+	if !idTokenJwt.IsZero() && homeAccountID != "" {
+		idt := NewIDToken(homeAccountID, environment, realm, clientID, idTokenJwt.RawToken)
+		idToken = &idt
 
-		account = shared.NewAccount(
-			homeAccountID,
-			environment,
-			realm,
-			localAccountID,
-			authorityType,
-			idTokenJwt.PreferredUsername,
-		)
-		if err := m.writeAccount(account); err != nil {
-			return shared.Account{}, err
-		}
+		account = newAccount(homeAccountID, environment, realm, authParameters.AuthorityInfo.AuthorityType, idTokenJwt)
 	}
 
 	AppMetaData := NewAppMetaData(tokenResponse.FamilyID, clientID, environment)
 
-	if err := m.writeAppMetaData(AppMetaData); err != nil {
-		return shared.Account{}, err
+	m.contractMu.Lock()
+	defer m.contractMu.Unlock()
+	if refreshToken != nil {
+		m.contract.RefreshTokens[refreshToken.Key()] = *refreshToken
 	}
+	if accessToken != nil {
+		m.contract.AccessTokens[accessToken.Key()] = *accessToken
+	}
+	if idToken != nil {
+		m.contract.IDTokens[idToken.Key()] = *idToken
+		m.contract.Accounts[account.Key()] = account
+	}
+	m.contract.AppMetaData[AppMetaData.Key()] = AppMetaData
 	return account, nil
 }
 
+// Warmup fetches and caches the instance metadata of the authority in authParameters, unless
+// authParameters specifies known authority hosts, so that a later Read needn't.
+func (m *Manager) Warmup(ctx context.Context, authParameters authority.AuthParams) error {
+	if len(authParameters.KnownAuthorityHosts) > 0 {
+		return nil
+	}
+	_, err := m.getMetadataEntry(ctx, authParameters.AuthorityInfo)
+	return err
+}
+
 func (m *Manager) getMetadataEntry(ctx context.Context, authorityInfo authority.Info) (authority.InstanceDiscoveryMetadata, error) {
 	md, err := m.aadMetadataFromCache(ctx, authorityInfo)
 	if err != nil {
@@ -219,6 +283,22 @@ func (m *Manager) getMetadataEntry(ctx context.Context, authorityInfo authority.
 	return md, err
 }
 
+// newAccount returns the account an ID token identifies, with the profile claims an application needs to
+// display it, so the application doesn't have to get them from another service
+func newAccount(homeAccountID, environment, realm, authorityType string, idt accesstokens.IDToken) shared.Account {
+	account := shared.NewAccount(homeAccountID, environment, realm, idt.LocalAccountID(), authorityType, idt.PreferredUsername)
+	account.GivenName = idt.GivenName
+	account.FamilyName = idt.FamilyName
+	account.MiddleName = idt.MiddleName
+	account.Name = idt.Name
+	account.AlternativeID = idt.AlternativeID
+	account.IdentityProvider = idt.IdentityProvider
+	if idt.AlternativeSecurityID != "" {
+		account.AlternativeSecurityIDs = []string{idt.AlternativeSecurityID}
+	}
+	return account
+}
+
 func (m *Manager) aadMetadataFromCache(ctx context.Context, authorityInfo authority.Info) (authority.InstanceDiscoveryMetadata, error) {
 	m.aadCacheMu.RLock()
 	defer m.aadCacheMu.RUnlock()
@@ -251,14 +331,14 @@ func (m *Manager) aadMetadata(ctx context.Context, authorityInfo authority.Info)
 	return m.aadCache[authorityInfo.Host], nil
 }
 
-func (m *Manager) readAccessToken(homeID string, envAliases []string, realm, clientID string, scopes []string) AccessToken {
+func (m *Manager) readAccessToken(homeID string, envAliases []string, realm, clientID string, scopes []string, scheme authority.AuthenticationScheme, tokenVersion string) AccessToken {
 	m.contractMu.RLock()
 	defer m.contractMu.RUnlock()
 	// TODO: linear search (over a map no less) is slow for a large number (thousands) of tokens.
 	// this shows up as the dominating node in a profile. for real-world scenarios this likely isn't
 	// an issue, however if it does become a problem then we know where to look.
 	for _, at := range m.contract.AccessTokens {
-		if at.HomeAccountID == homeID && at.Realm == realm && at.ClientID == clientID {
+		if at.HomeAccountID == homeID && at.Realm == realm && at.ClientID == clientID && at.matchesScheme(scheme) && at.TokenVersion == tokenVersion {
 			if checkAlias(at.Environment, envAliases) {
 				if isMatchingScopes(scopes, at.Scopes) {
 					return at
@@ -315,7 +395,7 @@ func (m *Manager) readRefreshToken(homeID string, envAliases []string, familyID,
 		}
 	}
 
-	return accesstokens.RefreshToken{}, fmt.Errorf("refresh token not found")
+	return accesstokens.RefreshToken{}, NotFoundError("refresh token not found")
 }
 
 func matchFamilyRefreshToken(rt accesstokens.RefreshToken, homeID string, envAliases []string) bool {
@@ -344,7 +424,7 @@ func (m *Manager) readIDToken(homeID string, envAliases []string, realm, clientI
 			}
 		}
 	}
-	return IDToken{}, fmt.Errorf("token not found")
+	return IDToken{}, NotFoundError("token not found")
 }
 
 func (m *Manager) writeIDToken(idToken IDToken) error {
@@ -395,7 +475,7 @@ func (m *Manager) readAccount(homeAccountID string, envAliases []string, realm s
 			return acc, nil
 		}
 	}
-	return shared.Account{}, fmt.Errorf("account not found")
+	return shared.Account{}, NotFoundError("account not found")
 }
 
 func (m *Manager) writeAccount(account shared.Account) error {
@@ -415,7 +495,7 @@ func (m *Manager) readAppMetaData(envAliases []string, clientID string) (AppMeta
 			return app, nil
 		}
 	}
-	return AppMetaData{}, fmt.Errorf("not found")
+	return AppMetaData{}, NotFoundError("not found")
 }
 
 func (m *Manager) writeAppMetaData(AppMetaData AppMetaData) error {
@@ -428,18 +508,48 @@ func (m *Manager) writeAppMetaData(AppMetaData AppMetaData) error {
 
 // RemoveAccount removes all the associated ATs, RTs and IDTs from the cache associated with this account.
 func (m *Manager) RemoveAccount(account shared.Account, clientID string) {
-	m.removeRefreshTokens(account.HomeAccountID, account.Environment, clientID)
-	m.removeAccessTokens(account.HomeAccountID, account.Environment)
-	m.removeIDTokens(account.HomeAccountID, account.Environment)
-	m.removeAccounts(account.HomeAccountID, account.Environment)
+	// remove the account's items under every alias of its environment, including those other MSAL libraries cached
+	aliases := []string{account.Environment}
+	if md, err := m.aadMetadataFromCache(context.Background(), authority.Info{Host: account.Environment}); err == nil {
+		aliases = append(aliases, md.Aliases...)
+	}
+	aliases = withKnownAliases(account.Environment, aliases)
+	m.removeRefreshTokens(account.HomeAccountID, aliases, clientID)
+	m.removeAccessTokens(account.HomeAccountID, aliases)
+	m.removeIDTokens(account.HomeAccountID, aliases)
+	m.removeAccounts(account.HomeAccountID, aliases)
+}
+
+// AccountsByIDToken returns the accounts whose cached ID tokens satisfy match. It skips ID tokens it can't decode.
+func (m *Manager) AccountsByIDToken(match func(accesstokens.IDToken) bool) []shared.Account {
+	m.contractMu.RLock()
+	defer m.contractMu.RUnlock()
+
+	var accounts []shared.Account
+	for _, idt := range m.contract.IDTokens {
+		secret, err := m.secrets.open(idt.Secret)
+		if err != nil {
+			continue
+		}
+		decoded := accesstokens.IDToken{}
+		if err = decoded.UnmarshalJSON([]byte(secret)); err != nil || !match(decoded) {
+			continue
+		}
+		for _, acc := range m.contract.Accounts {
+			if acc.HomeAccountID == idt.HomeAccountID && acc.Environment == idt.Environment {
+				accounts = append(accounts, acc)
+			}
+		}
+	}
+	return accounts
 }
 
-func (m *Manager) removeRefreshTokens(homeID string, env string, clientID string) {
+func (m *Manager) removeRefreshTokens(homeID string, envAliases []string, clientID string) {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 	for key, rt := range m.contract.RefreshTokens {
 		// Check for RTs associated with the account.
-		if rt.HomeAccountID == homeID && rt.Environment == env {
+		if rt.HomeAccountID == homeID && checkAlias(rt.Environment, envAliases) {
 			// Do RT's app ownership check as a precaution, in case family apps
 			// and 3rd-party apps share same token cache, although they should not.
 			if rt.ClientID == clientID || rt.FamilyID != "" {
@@ -449,12 +559,12 @@ func (m *Manager) removeRefreshTokens(homeID string, env string, clientID string
 	}
 }
 
-func (m *Manager) removeAccessTokens(homeID string, env string) {
+func (m *Manager) removeAccessTokens(homeID string, envAliases []string) {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 	for key, at := range m.contract.AccessTokens {
 		// Remove AT's associated with the account
-		if at.HomeAccountID == homeID && at.Environment == env {
+		if at.HomeAccountID == homeID && checkAlias(at.Environment, envAliases) {
 			// # To avoid the complexity of locating sibling family app's AT, we skip AT's app ownership check.
 			// It means ATs for other apps will also be removed, it is OK because:
 			// non-family apps are not supposed to share token cache to begin with;
@@ -464,23 +574,23 @@ func (m *Manager) removeAccessTokens(homeID string, env string) {
 	}
 }
 
-func (m *Manager) removeIDTokens(homeID string, env string) {
+func (m *Manager) removeIDTokens(homeID string, envAliases []string) {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 	for key, idt := range m.contract.IDTokens {
 		// Remove ID tokens associated with the account.
-		if idt.HomeAccountID == homeID && idt.Environment == env {
+		if idt.HomeAccountID == homeID && checkAlias(idt.Environment, envAliases) {
 			delete(m.contract.IDTokens, key)
 		}
 	}
 }
 
-func (m *Manager) removeAccounts(homeID string, env string) {
+func (m *Manager) removeAccounts(homeID string, envAliases []string) {
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 	for key, acc := range m.contract.Accounts {
 		// Remove the specified account.
-		if acc.HomeAccountID == homeID && acc.Environment == env {
+		if acc.HomeAccountID == homeID && checkAlias(acc.Environment, envAliases) {
 			delete(m.contract.Accounts, key)
 		}
 	}
@@ -496,20 +606,48 @@ func (m *Manager) update(cache *Contract) {
 
 // Marshal implements cache.Marshaler.
 func (m *Manager) Marshal() ([]byte, error) {
-	return json.Marshal(m.contract)
+	m.contractMu.RLock()
+	compressed := m.compressed
+	m.contractMu.RUnlock()
+	b, err := m.marshal()
+	if err != nil || !compressed {
+		return b, err
+	}
+	return compress(b)
+}
+
+func (m *Manager) marshal() ([]byte, error) {
+	if m.secrets.e == nil {
+		return json.Marshal(m.contract)
+	}
+	m.contractMu.RLock()
+	contract, err := convertContract(m.contract, m.secrets.open)
+	m.contractMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(contract)
 }
 
 // Unmarshal implements cache.Unmarshaler.
 func (m *Manager) Unmarshal(b []byte) error {
+	b, err := decompress(b)
+	if err != nil {
+		return err
+	}
+
 	m.contractMu.Lock()
 	defer m.contractMu.Unlock()
 
-	contract := NewContract()
-
-	err := json.Unmarshal(b, contract)
+	contract, err := unmarshalContract(b)
 	if err != nil {
 		return err
 	}
+	if m.secrets.e != nil {
+		if contract, err = convertContract(contract, m.secrets.seal); err != nil {
+			return err
+		}
+	}
 
 	m.contract = contract
 
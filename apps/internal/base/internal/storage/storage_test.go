@@ -142,6 +142,8 @@ func TestReadAccessToken(t *testing.T) {
 		"realm",
 		"cid",
 		[]string{"user.read", "openid"},
+		authority.BearerAuthenticationScheme{},
+		"",
 	)
 	if diff := pretty.Compare(testAccessToken, retAccessToken); diff != "" {
 		t.Fatalf("Returned access token is not the same as expected access token: -want/+got:\n%s", diff)
@@ -152,10 +154,75 @@ func TestReadAccessToken(t *testing.T) {
 		"realm",
 		"cid",
 		[]string{"user.read", "openid"},
+		authority.BearerAuthenticationScheme{},
+		"",
 	)
 	if !reflect.ValueOf(retAccessToken).IsZero() {
 		t.Fatal("expected to find no access token")
 	}
+
+	// a token of another type, or bound to another key, shouldn't satisfy a request
+	popToken := testAccessToken
+	popToken.Secret = "pop secret"
+	popToken.TokenType = "pop"
+	popToken.AuthnSchemeKeyID = "key"
+	if popToken.Key() == testAccessToken.Key() {
+		t.Fatal("tokens of different types should have different keys")
+	}
+	if err := storageManager.writeAccessToken(popToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		scheme   authority.AuthenticationScheme
+		expected AccessToken
+	}{
+		{authority.BearerAuthenticationScheme{}, testAccessToken},
+		{fakeScheme{keyID: "key"}, popToken},
+		{fakeScheme{keyID: "other key"}, AccessToken{}},
+	} {
+		actual := storageManager.readAccessToken("hid", []string{"env"}, "realm", "cid", []string{"user.read", "openid"}, test.scheme, "")
+		if diff := pretty.Compare(test.expected, actual); diff != "" {
+			t.Errorf("%s: unexpected access token: -want/+got:\n%s", test.scheme.KeyID(), diff)
+		}
+	}
+
+	// nor should a token from another version of the token endpoint
+	v1Token := testAccessToken
+	v1Token.Secret = "v1 secret"
+	v1Token.TokenVersion = authority.TokenVersionV1
+	if v1Token.Key() == testAccessToken.Key() {
+		t.Fatal("tokens of different versions should have different keys")
+	}
+	if err := storageManager.writeAccessToken(v1Token); err != nil {
+		t.Fatal(err)
+	}
+	for version, expected := range map[string]AccessToken{"": testAccessToken, authority.TokenVersionV1: v1Token} {
+		actual := storageManager.readAccessToken("hid", []string{"env"}, "realm", "cid", []string{"user.read", "openid"}, authority.BearerAuthenticationScheme{}, version)
+		if diff := pretty.Compare(expected, actual); diff != "" {
+			t.Errorf("version %q: unexpected access token: -want/+got:\n%s", version, diff)
+		}
+	}
+}
+
+// fakeScheme is an AuthenticationScheme for proof-of-possession tokens
+type fakeScheme struct {
+	keyID string
+}
+
+func (f fakeScheme) TokenRequestParams() map[string]string {
+	return map[string]string{"token_type": "pop", "req_cnf": f.keyID}
+}
+
+func (f fakeScheme) KeyID() string {
+	return f.keyID
+}
+
+func (fakeScheme) FormatAccessToken(accessToken string) (string, error) {
+	return "signed " + accessToken, nil
+}
+
+func (fakeScheme) AccessTokenType() string {
+	return "pop"
 }
 
 func TestWriteAccessToken(t *testing.T) {
@@ -815,6 +882,60 @@ func removeSubSeconds(t time.Time) time.Time {
 	return t
 }
 
+func TestReadAliasedEnvironment(t *testing.T) {
+	// MSAL.js caches items under login.windows.net, and instance discovery through a proxy
+	// may not return that alias. Some libraries don't normalize the environment's case.
+	const env = "Login.Windows.Net"
+	now := time.Now()
+	at := NewAccessToken("hid", env, "realm", "cid", now, now.Add(time.Hour), now.Add(time.Hour), "openid profile", "secret")
+	idt := NewIDToken("hid", env, "realm", "cid", "secret")
+	appMeta := NewAppMetaData("", "cid", env)
+	rt := accesstokens.NewRefreshToken("hid", env, "cid", "secret", "")
+	account := shared.NewAccount("hid", env, "realm", "lid", accAuth, "username")
+	authParams := authority.AuthParams{
+		AuthorityInfo: authority.Info{Host: "login.microsoftonline.com", Tenant: "realm"},
+		ClientID:      "cid",
+		HomeAccountID: "hid",
+		Scopes:        []string{"openid", "profile"},
+	}
+	for _, test := range []struct {
+		desc       string
+		knownHosts []string
+	}{
+		{desc: "instance discovery"},
+		{desc: "known authority hosts", knownHosts: []string{"login.microsoftonline.com"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			manager := newForTest(&fakeDiscoveryResponser{ret: authority.InstanceDiscoveryResponse{
+				Metadata: []authority.InstanceDiscoveryMetadata{{Aliases: []string{"login.microsoftonline.com"}}},
+			}})
+			manager.update(&Contract{
+				AccessTokens:  map[string]AccessToken{at.Key(): at},
+				Accounts:      map[string]shared.Account{account.Key(): account},
+				AppMetaData:   map[string]AppMetaData{appMeta.Key(): appMeta},
+				IDTokens:      map[string]IDToken{idt.Key(): idt},
+				RefreshTokens: map[string]accesstokens.RefreshToken{rt.Key(): rt},
+			})
+			authParams.KnownAuthorityHosts = test.knownHosts
+			tr, err := manager.Read(context.Background(), authParams, shared.Account{HomeAccountID: "hid"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tr.AccessToken.Secret != "secret" || tr.RefreshToken.Secret != "secret" || tr.IDToken.Secret != "secret" {
+				t.Fatalf("expected the aliased tokens, got %+v", tr)
+			}
+			if tr.Account.Environment != env {
+				t.Fatalf("expected the aliased account, got %+v", tr.Account)
+			}
+			manager.RemoveAccount(shared.Account{HomeAccountID: "hid", Environment: "login.microsoftonline.com"}, "cid")
+			c := manager.contract
+			if n := len(c.AccessTokens) + len(c.Accounts) + len(c.IDTokens) + len(c.RefreshTokens); n != 0 {
+				t.Fatalf("expected RemoveAccount to remove the aliased items, %d remain", n)
+			}
+		})
+	}
+}
+
 func TestWrite(t *testing.T) {
 	now := removeSubSeconds(time.Now().UTC())
 
@@ -824,9 +945,14 @@ func TestWrite(t *testing.T) {
 		UTID: "testUtid",
 	}
 	idToken := accesstokens.IDToken{
-		RawToken:          "idToken",
-		Oid:               "lid",
-		PreferredUsername: "username",
+		RawToken:              "idToken",
+		Oid:                   "lid",
+		PreferredUsername:     "username",
+		Name:                  "name",
+		GivenName:             "given",
+		FamilyName:            "family",
+		IdentityProvider:      "https://sts.windows.net/home-tenant/",
+		AlternativeSecurityID: "altsecid",
 	}
 	expiresOn := internalTime.DurationTime{T: now.Add(1000 * time.Second)}
 	tokenResponse := accesstokens.TokenResponse{
@@ -873,6 +999,12 @@ func TestWrite(t *testing.T) {
 	)
 
 	testAccount := shared.NewAccount("testUID.testUtid", "env", "realm", "lid", accAuth, "username")
+	// the account has the ID token's profile claims
+	testAccount.Name = "name"
+	testAccount.GivenName = "given"
+	testAccount.FamilyName = "family"
+	testAccount.IdentityProvider = "https://sts.windows.net/home-tenant/"
+	testAccount.AlternativeSecurityIDs = []string{"altsecid"}
 	testAppMeta := NewAppMetaData("fid", "cid", "env")
 
 	actualAccount, err := cacheManager.Write(authParams, tokenResponse)
@@ -943,7 +1075,7 @@ func TestRemoveRefreshTokens(t *testing.T) {
 		},
 	}
 	storageManager.update(contract)
-	storageManager.removeRefreshTokens("hid", "env", "cid")
+	storageManager.removeRefreshTokens("hid", []string{"env"}, "cid")
 
 	if val, ok := storageManager.contract.RefreshTokens[key]; ok {
 		t.Fatalf("TestRemoveRefreshTokens: got refreshToken == %s, want refreshToken == empty", val)
@@ -961,7 +1093,7 @@ func TestRemoveAccessTokens(t *testing.T) {
 		},
 	}
 	storageManager.update(contract)
-	storageManager.removeAccessTokens("hid", "env")
+	storageManager.removeAccessTokens("hid", []string{"env"})
 
 	if val, ok := storageManager.contract.AccessTokens[key]; ok {
 		t.Fatalf("TestRemoveAccessTokens: got accessToken == %s, want accessToken == empty", val)
@@ -978,7 +1110,7 @@ func TestRemoveIDTokens(t *testing.T) {
 		},
 	}
 	storageManager.update(contract)
-	storageManager.removeIDTokens("hid", "env")
+	storageManager.removeIDTokens("hid", []string{"env"})
 
 	if val, ok := storageManager.contract.IDTokens[key]; ok {
 		t.Fatalf("TestRemoveIDTokens: got IDToken == %s, want IDToken == empty", val)
@@ -995,7 +1127,7 @@ func TestRemoveAccountObject(t *testing.T) {
 		},
 	}
 	storageManager.update(contract)
-	storageManager.removeAccounts("hid", "env")
+	storageManager.removeAccounts("hid", []string{"env"})
 
 	if val, ok := storageManager.contract.Accounts[key]; ok {
 		t.Fatalf("TestRemoveAccountObject: got Account == %s, want Account == empty", val)
@@ -1085,3 +1217,97 @@ func TestRemoveEmptyAccount(t *testing.T) {
 		t.Fatalf("TestRemoveEmptyAccount: got Account == empty, want Account == %s", testAccount)
 	}
 }
+
+// xorEncrypter is a trivial cache.Encrypter for tests
+type xorEncrypter struct{}
+
+func (xorEncrypter) Encrypt(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x5a
+	}
+	return out, nil
+}
+
+func (x xorEncrypter) Decrypt(b []byte) ([]byte, error) {
+	return x.Encrypt(b)
+}
+
+func TestEncryption(t *testing.T) {
+	now := time.Now()
+	tokenResponse := accesstokens.TokenResponse{
+		AccessToken:   accessTokenSecret,
+		RefreshToken:  rtSecret,
+		IDToken:       accesstokens.IDToken{RawToken: idSecret, Oid: accLID, PreferredUsername: accUser},
+		ClientInfo:    accesstokens.ClientInfo{UID: "uid", UTID: "utid"},
+		GrantedScopes: accesstokens.Scopes{Slice: []string{"scope"}},
+		ExpiresOn:     internalTime.DurationTime{T: now.Add(time.Hour)},
+		ExtExpiresOn:  internalTime.DurationTime{T: now.Add(time.Hour)},
+	}
+	authParams := authority.AuthParams{
+		AuthorityInfo:       authority.Info{Host: defaultEnvironment, Tenant: defaultRealm, AuthorityType: accAuth},
+		ClientID:            defaultClientID,
+		HomeAccountID:       "uid.utid",
+		KnownAuthorityHosts: []string{defaultEnvironment},
+		Scopes:              []string{"scope"},
+	}
+	m := newForTest(nil)
+	m.SetEncrypter(xorEncrypter{})
+	account, err := m.Write(authParams, tokenResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range m.contract.AccessTokens {
+		if at.Secret == accessTokenSecret {
+			t.Fatal("cached access token isn't encrypted")
+		}
+	}
+	for _, rt := range m.contract.RefreshTokens {
+		if rt.Secret == rtSecret {
+			t.Fatal("cached refresh token isn't encrypted")
+		}
+	}
+	for _, id := range m.contract.IDTokens {
+		if id.Secret == idSecret {
+			t.Fatal("cached ID token isn't encrypted")
+		}
+	}
+
+	check := func(m *Manager) {
+		t.Helper()
+		tr, err := m.Read(context.Background(), authParams, account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.AccessToken.Secret != accessTokenSecret {
+			t.Errorf("expected access token %q, got %q", accessTokenSecret, tr.AccessToken.Secret)
+		}
+		if tr.RefreshToken.Secret != rtSecret {
+			t.Errorf("expected refresh token %q, got %q", rtSecret, tr.RefreshToken.Secret)
+		}
+		if tr.IDToken.Secret != idSecret {
+			t.Errorf("expected ID token %q, got %q", idSecret, tr.IDToken.Secret)
+		}
+	}
+	check(m)
+
+	// serialized data should be plaintext, and encrypted again when deserialized
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := newForTest(nil)
+	if err := plain.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	check(plain)
+	encrypted := newForTest(nil)
+	encrypted.SetEncrypter(xorEncrypter{})
+	if err := encrypted.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if diff := pretty.Compare(m.contract.RefreshTokens, encrypted.contract.RefreshTokens); diff != "" {
+		t.Fatalf("deserialized refresh tokens differ: -want/+got\n%s", diff)
+	}
+	check(encrypted)
+}
@@ -4,6 +4,8 @@
 // package exported contains internal types that are re-exported from a public package
 package exported
 
+import "time"
+
 // AssertionRequestOptions has information required to generate a client assertion
 type AssertionRequestOptions struct {
 	// ClientID identifies the application for which an assertion is requested. Used as the assertion's "iss" and "sub" claims.
@@ -32,3 +34,62 @@ type TokenProviderResult struct {
 	// ExpiresInSeconds is the lifetime of the token in seconds
 	ExpiresInSeconds int
 }
+
+// TokenRequestInfo describes a token request the client is about to send to the authority
+type TokenRequestInfo struct {
+	// ClientID identifies the application requesting a token
+	ClientID string
+	// CorrelationID of the authentication request
+	CorrelationID string
+	// Endpoint is the URL to which the client will send the request
+	Endpoint string
+	// ExtraBodyParameters are added to the request's body. They can't replace parameters the client sets,
+	// such as "grant_type" and "scope".
+	ExtraBodyParameters map[string]string
+	// GrantType is the request's OAuth2 grant type, for example "client_credentials"
+	GrantType string
+	// Scopes requested for the token
+	Scopes []string
+	// TenantID identifies the tenant in which to authenticate
+	TenantID string
+}
+
+// TokenResponseInfo describes the outcome of a token request
+type TokenResponseInfo struct {
+	// ClientID identifies the application that requested a token
+	ClientID string
+	// CorrelationID of the authentication request
+	CorrelationID string
+	// Duration is how long the authority took to respond
+	Duration time.Duration
+	// Endpoint is the URL to which the client sent the request
+	Endpoint string
+	// Err is the request's error, if any
+	Err error
+	// ExpiresOn is when the access token expires. It's zero when the request failed.
+	ExpiresOn time.Time
+	// GrantType is the request's OAuth2 grant type, for example "client_credentials"
+	GrantType string
+	// Scopes requested for the token
+	Scopes []string
+	// TenantID identifies the tenant in which the client authenticated
+	TenantID string
+}
+
+// PIILevel controls what a client's wire log includes
+type PIILevel int
+
+const (
+	// WireLogOff disables the wire log
+	WireLogOff PIILevel = iota
+	// WireLogRedacted logs HTTP requests and responses, redacting secrets, tokens and personal data
+	WireLogRedacted
+	// WireLogPII logs HTTP requests and responses, redacting secrets and tokens but not personal data
+	WireLogPII
+)
+
+// Logger receives a client's log output. *log.Logger implements it, so do adapters for most logging libraries.
+type Logger interface {
+	// Printf writes one log entry, formatted as by fmt.Sprintf
+	Printf(format string, args ...interface{})
+}
@@ -59,11 +59,20 @@ func (d DurationTime) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("%d", int64(dt*time.Second))), nil
 }
 
-// UnmarshalJSON implements encoding/json.UnmarshalJSON().
+// UnmarshalJSON implements encoding/json.UnmarshalJSON(). It's lenient because some token services return
+// nonstandard durations: it truncates fractional seconds and leaves T zero for null or an empty string.
 func (d *DurationTime) UnmarshalJSON(b []byte) error {
-	i, err := strconv.Atoi(strings.Trim(string(b), `"`))
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		return fmt.Errorf("unix time(%s) could not be converted from string to int: %w", string(b), err)
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return fmt.Errorf("unix time(%s) could not be converted from string to int: %w", string(b), err)
+		}
+		i = int(f)
 	}
 	d.T = time.Now().Add(time.Duration(i) * time.Second)
 	return nil
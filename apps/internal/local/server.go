@@ -9,11 +9,21 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// maxHeaderBytes limits the size of a request's headers, including its URL. A redirect carries only
+	// a code, state and perhaps an error description, so this is generous.
+	maxHeaderBytes = 32 << 10
+	// serverTimeout bounds the time the server spends reading a request or writing a response
+	serverTimeout = 10 * time.Second
+)
+
 var okPage = []byte(`
 <!DOCTYPE html>
 <html>
@@ -45,59 +55,107 @@ const failPage = `
 type Result struct {
 	// Code is the code sent by the authority server.
 	Code string
+	// Params are the parameters of the authority's redirect, including the code.
+	Params url.Values
 	// Err is set if there was an error.
 	Err error
 }
 
-// Server is an HTTP server.
+// Server is an HTTP server. It accepts only the first GET request having the expected state, so that other
+// local processes can't end the flow or substitute an authorization code by sending it requests.
 type Server struct {
 	// Addr is the address the server is listening on.
 	Addr     string
 	resultCh chan Result
 	s        *http.Server
 	reqState string
+
+	// mu protects handled, which is true after the server accepts a request
+	mu      sync.Mutex
+	handled bool
 }
 
-// New creates a local HTTP server and starts it.
-func New(reqState string, port int) (*Server, error) {
-	var l net.Listener
+// New creates a local HTTP server and starts it. The server listens on each of hosts, which defaults to
+// "localhost". When there are several hosts, the server listens on the same port on all of them and its
+// Addr has the host "localhost". When port is 0, the server listens on a free port.
+func New(reqState string, port int, hosts ...string) (*Server, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+	var ls []net.Listener
 	var err error
-	var portStr string
 	if port > 0 {
 		// use port provided by caller
-		l, err = net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
-		portStr = strconv.FormatInt(int64(port), 10)
+		ls, err = listen(hosts, port)
 	} else {
-		// find a free port
+		// find a free port. Another process may be using the first host's free port on another host.
 		for i := 0; i < 10; i++ {
-			l, err = net.Listen("tcp", "localhost:0")
-			if err != nil {
-				continue
+			ls, err = listen(hosts, 0)
+			if err == nil {
+				break
 			}
-			addr := l.Addr().String()
-			portStr = addr[strings.LastIndex(addr, ":")+1:]
-			break
 		}
 	}
 	if err != nil {
 		return nil, err
 	}
+	addr := ls[0].Addr().String()
+	portStr := addr[strings.LastIndex(addr, ":")+1:]
+	host := hosts[0]
+	if len(hosts) > 1 {
+		host = "localhost"
+	}
 
 	serv := &Server{
-		Addr:     fmt.Sprintf("http://localhost:%s", portStr),
-		s:        &http.Server{Addr: "localhost:0", ReadHeaderTimeout: time.Second},
+		Addr: "http://" + net.JoinHostPort(host, portStr),
+		s: &http.Server{
+			Addr:              "localhost:0",
+			IdleTimeout:       time.Second,
+			MaxHeaderBytes:    maxHeaderBytes,
+			ReadHeaderTimeout: time.Second,
+			ReadTimeout:       serverTimeout,
+			WriteTimeout:      serverTimeout,
+		},
 		reqState: reqState,
 		resultCh: make(chan Result, 1),
 	}
 	serv.s.Handler = http.HandlerFunc(serv.handler)
+	serv.s.SetKeepAlivesEnabled(false)
 
-	if err := serv.start(l); err != nil {
-		return nil, err
+	for _, l := range ls {
+		if err := serv.start(l); err != nil {
+			// close every listener, including those the server isn't serving yet
+			_ = serv.s.Close()
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
 	}
 
 	return serv, nil
 }
 
+// listen listens on port on each host. When port is 0, it listens on the port
+// the system chooses for the first host.
+func listen(hosts []string, port int) ([]net.Listener, error) {
+	ls := make([]net.Listener, 0, len(hosts))
+	for _, host := range hosts {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		if port == 0 {
+			port = l.Addr().(*net.TCPAddr).Port
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
 func (s *Server) start(l net.Listener) error {
 	go func() {
 		err := s.s.Serve(l)
@@ -117,6 +175,9 @@ func (s *Server) start(l net.Listener) error {
 func (s *Server) Result(ctx context.Context) Result {
 	select {
 	case <-ctx.Done():
+		// close the listener now rather than waiting for a graceful shutdown, so a
+		// canceled sign-in releases the port immediately
+		_ = s.s.Close()
 		return Result{Err: ctx.Err()}
 	case r := <-s.resultCh:
 		return r
@@ -136,9 +197,40 @@ func (s *Server) putResult(r Result) {
 	}
 }
 
+// accept returns true for the first request it's called for and false thereafter
+func (s *Server) accept() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handled {
+		return false
+	}
+	s.handled = true
+	return true
+}
+
 func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	q := r.URL.Query()
 
+	// ignore requests that don't have the expected state instead of failing the flow
+	switch q.Get("state") {
+	case s.reqState:
+	case "":
+		http.Error(w, "server didn't send OAuth state", http.StatusBadRequest)
+		return
+	default:
+		// don't echo the expected state, which would enable the sender to make a request the server accepts
+		http.Error(w, "mismatched OAuth state", http.StatusBadRequest)
+		return
+	}
+	if !s.accept() {
+		http.Error(w, "the server already received a redirect", http.StatusGone)
+		return
+	}
+
 	headerErr := q.Get("error")
 	if headerErr != "" {
 		desc := q.Get("error_description")
@@ -149,17 +241,6 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respState := q.Get("state")
-	switch respState {
-	case s.reqState:
-	case "":
-		s.error(w, http.StatusInternalServerError, "server didn't send OAuth state")
-		return
-	default:
-		s.error(w, http.StatusInternalServerError, "mismatched OAuth state, req(%s), resp(%s)", s.reqState, respState)
-		return
-	}
-
 	code := q.Get("code")
 	if code == "" {
 		s.error(w, http.StatusInternalServerError, "authorization code missing in query string")
@@ -167,7 +248,7 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, _ = w.Write(okPage)
-	s.putResult(Result{Code: code})
+	s.putResult(Result{Code: code, Params: q})
 }
 
 func (s *Server) error(w http.ResponseWriter, code int, str string, i ...interface{}) {
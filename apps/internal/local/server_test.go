@@ -5,9 +5,12 @@ package local
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -26,6 +29,8 @@ func TestServer(t *testing.T) {
 		q          url.Values
 		failPage   bool
 		statusCode int
+		// ignored indicates the server should reject the request without ending the flow
+		ignored bool
 	}{
 		{
 			desc:       "Error: Query Values has 'error' key",
@@ -40,14 +45,16 @@ func TestServer(t *testing.T) {
 			reqState:   "state",
 			port:       0,
 			q:          url.Values{"code": []string{"code"}},
-			statusCode: http.StatusInternalServerError,
+			statusCode: http.StatusBadRequest,
+			ignored:    true,
 		},
 		{
 			desc:       "Error: Query Values missing had 'state' key value that was different that requested",
 			reqState:   "state",
 			port:       0,
 			q:          url.Values{"state": []string{"etats"}, "code": []string{"code"}},
-			statusCode: http.StatusInternalServerError,
+			statusCode: http.StatusBadRequest,
+			ignored:    true,
 		},
 		{
 			desc:       "Error: Query Values missing 'code' key",
@@ -102,13 +109,22 @@ func TestServer(t *testing.T) {
 				continue
 			}
 			t.Errorf("TestServer(%s): got StatusCode == %d, want StatusCode == %d", test.desc, resp.StatusCode, test.statusCode)
-			res := serv.Result(ctx)
-			if res.Err == nil {
-				t.Errorf("TestServer(%s): Result.Err == nil, want Result.Err != nil", test.desc)
+			continue
+		}
+		if test.ignored {
+			c, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			res := serv.Result(c)
+			cancel()
+			if res.Err != context.DeadlineExceeded {
+				t.Errorf("TestServer(%s): expected no result, got %+v", test.desc, res)
 			}
 			continue
 		}
 		if resp.StatusCode != 200 {
+			res := serv.Result(ctx)
+			if res.Err == nil {
+				t.Errorf("TestServer(%s): Result.Err == nil, want Result.Err != nil", test.desc)
+			}
 			continue
 		}
 
@@ -134,8 +150,146 @@ func TestServer(t *testing.T) {
 		}
 
 		res := serv.Result(ctx)
-		if diff := pretty.Compare(Result{Code: "code"}, res); diff != "" {
+		if diff := pretty.Compare(Result{Code: "code", Params: test.q}, res); diff != "" {
 			t.Errorf("TestServer(%s): -want/+got:\n%s", test.desc, diff)
 		}
 	}
 }
+
+func TestServerHosts(t *testing.T) {
+	ipv6 := true
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+	} else {
+		ipv6 = false
+	}
+	for _, test := range []struct {
+		hosts              []string
+		expectedAddrPrefix string
+	}{
+		{hosts: []string{"127.0.0.1"}, expectedAddrPrefix: "http://127.0.0.1:"},
+		{hosts: []string{"::1"}, expectedAddrPrefix: "http://[::1]:"},
+		{hosts: []string{"127.0.0.1", "::1"}, expectedAddrPrefix: "http://localhost:"},
+	} {
+		t.Run(strings.Join(test.hosts, ","), func(t *testing.T) {
+			for _, h := range test.hosts {
+				if h == "::1" && !ipv6 {
+					t.Skip("IPv6 loopback isn't available")
+				}
+			}
+			serv, err := New("state", 0, test.hosts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer serv.Shutdown()
+			if !strings.HasPrefix(serv.Addr, test.expectedAddrPrefix) {
+				t.Fatalf("expected an address beginning with %q, got %q", test.expectedAddrPrefix, serv.Addr)
+			}
+			u, err := url.Parse(serv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the server should listen on the same port on every host
+			for _, h := range test.hosts {
+				c, err := net.Dial("tcp", net.JoinHostPort(h, u.Port()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestServerHostFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	// 192.0.2.1 is reserved for documentation, so no host has it and listening on it fails
+	if serv, err := New("state", port, "127.0.0.1", "192.0.2.1"); err == nil {
+		serv.Shutdown()
+		t.Fatal("expected an error")
+	}
+	// the server should have closed its listener on the first host
+	l, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("expected the port to be free: %s", err)
+	}
+	l.Close()
+}
+
+func TestServerAcceptsOneRequest(t *testing.T) {
+	serv, err := New("state", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serv.Shutdown()
+	u := serv.Addr + "?" + url.Values{"state": {"state"}, "code": {"code"}}.Encode()
+
+	for _, test := range []struct {
+		desc, method, url string
+		header            http.Header
+		expected          int
+	}{
+		{desc: "POST", method: http.MethodPost, url: u, expected: http.StatusMethodNotAllowed},
+		{desc: "huge header", method: http.MethodGet, url: u, header: http.Header{"X-Huge": {strings.Repeat("*", 2*maxHeaderBytes)}}, expected: http.StatusRequestHeaderFieldsTooLarge},
+		{desc: "redirect", method: http.MethodGet, url: u, expected: http.StatusOK},
+		{desc: "second redirect", method: http.MethodGet, url: strings.Replace(u, "code=code", "code=other", 1), expected: http.StatusGone},
+	} {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range test.header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.desc, test.expected, resp.StatusCode)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if res := serv.Result(ctx); res.Err != nil || res.Code != "code" {
+		t.Fatalf("expected the first redirect's code, got %+v", res)
+	}
+}
+
+func TestResultCanceledReleasesPort(t *testing.T) {
+	serv, err := New("state", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serv.Shutdown()
+	u, err := url.Parse(serv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an incomplete request keeps a connection active, which would delay a graceful shutdown
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := serv.Result(ctx); !errors.Is(res.Err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", res.Err)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", u.Port()))
+	if err != nil {
+		t.Fatalf("expected the port to be free: %s", err)
+	}
+	l.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
@@ -51,6 +52,9 @@ type AccessTokens struct {
 
 	// ValidateAssertion is an optional callback for validating an assertion generated by confidential.Client
 	ValidateAssertion func(string)
+
+	// fake result to return
+	PushedAuthorization accesstokens.PushedAuthorizationResponse
 }
 
 func (f *AccessTokens) FromUsernamePassword(ctx context.Context, authParameters authority.AuthParams) (accesstokens.TokenResponse, error) {
@@ -107,7 +111,7 @@ func (f *AccessTokens) DeviceCodeResult(ctx context.Context, authParameters auth
 	}
 	return f.DeviceCode, nil
 }
-func (f *AccessTokens) FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, deviceCodeResult accesstokens.DeviceCodeResult) (accesstokens.TokenResponse, error) {
+func (f *AccessTokens) FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, cc *accesstokens.Credential, deviceCodeResult accesstokens.DeviceCodeResult) (accesstokens.TokenResponse, error) {
 	if f.Next < len(f.Result) {
 		defer func() { f.Next++ }()
 		v := f.Result[f.Next]
@@ -124,6 +128,18 @@ func (f *AccessTokens) FromSamlGrant(ctx context.Context, authParameters authori
 	}
 	return f.AccessToken, nil
 }
+func (f *AccessTokens) PushAuthorizationRequest(ctx context.Context, authParams authority.AuthParams, cc *accesstokens.Credential, params url.Values) (accesstokens.PushedAuthorizationResponse, error) {
+	if f.Err {
+		return accesstokens.PushedAuthorizationResponse{}, fmt.Errorf("error")
+	}
+	return f.PushedAuthorization, nil
+}
+func (f *AccessTokens) FromTokenExchange(ctx context.Context, authParams authority.AuthParams, cc *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+	if f.Err {
+		return accesstokens.TokenResponse{}, fmt.Errorf("error")
+	}
+	return f.AccessToken, nil
+}
 
 // Authority is a fake implementation of the oauth.fetchAuthority interface.
 type Authority struct {
@@ -135,6 +151,12 @@ type Authority struct {
 
 	// fake result to return
 	InstanceResp authority.InstanceDiscoveryResponse
+
+	// fake result to return from the UserInfo() API
+	Info authority.UserInfo
+
+	// fake result to return from the JWKS() API
+	Keys authority.JSONWebKeySet
 }
 
 func (f Authority) UserRealm(ctx context.Context, params authority.AuthParams) (authority.UserRealm, error) {
@@ -151,6 +173,20 @@ func (f Authority) AADInstanceDiscovery(ctx context.Context, info authority.Info
 	return f.InstanceResp, nil
 }
 
+func (f Authority) UserInfo(ctx context.Context, userInfoEndpoint, accessToken string) (authority.UserInfo, error) {
+	if f.Err {
+		return authority.UserInfo{}, errors.New("error")
+	}
+	return f.Info, nil
+}
+
+func (f Authority) JWKS(ctx context.Context, jwksURI string) (authority.JSONWebKeySet, error) {
+	if f.Err {
+		return authority.JSONWebKeySet{}, errors.New("error")
+	}
+	return f.Keys, nil
+}
+
 // WSTrust is a fake implementation of the oauth.fetchWSTrust interface.
 type WSTrust struct {
 	// Set these to true to have their respective APIs return an error.
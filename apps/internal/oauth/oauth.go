@@ -4,10 +4,15 @@
 package oauth
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
@@ -36,14 +41,18 @@ type AccessTokens interface {
 	FromAssertion(ctx context.Context, authParameters authority.AuthParams, assertion string) (accesstokens.TokenResponse, error)
 	FromUserAssertionClientSecret(ctx context.Context, authParameters authority.AuthParams, userAssertion string, clientSecret string) (accesstokens.TokenResponse, error)
 	FromUserAssertionClientCertificate(ctx context.Context, authParameters authority.AuthParams, userAssertion string, assertion string) (accesstokens.TokenResponse, error)
-	FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, deviceCodeResult accesstokens.DeviceCodeResult) (accesstokens.TokenResponse, error)
+	FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, cc *accesstokens.Credential, deviceCodeResult accesstokens.DeviceCodeResult) (accesstokens.TokenResponse, error)
 	FromSamlGrant(ctx context.Context, authParameters authority.AuthParams, samlGrant wstrust.SamlTokenInfo) (accesstokens.TokenResponse, error)
+	PushAuthorizationRequest(ctx context.Context, authParams authority.AuthParams, cc *accesstokens.Credential, params url.Values) (accesstokens.PushedAuthorizationResponse, error)
+	FromTokenExchange(ctx context.Context, authParams authority.AuthParams, cc *accesstokens.Credential) (accesstokens.TokenResponse, error)
 }
 
 // FetchAuthority will be implemented by authority.Authority.
 type FetchAuthority interface {
 	UserRealm(context.Context, authority.AuthParams) (authority.UserRealm, error)
 	AADInstanceDiscovery(context.Context, authority.Info) (authority.InstanceDiscoveryResponse, error)
+	UserInfo(ctx context.Context, userInfoEndpoint, accessToken string) (authority.UserInfo, error)
+	JWKS(ctx context.Context, jwksURI string) (authority.JSONWebKeySet, error)
 }
 
 // FetchWSTrust contains the methods for interacting with WSTrust endpoints.
@@ -58,6 +67,30 @@ type Client struct {
 	AccessTokens AccessTokens
 	Authority    FetchAuthority
 	WSTrust      FetchWSTrust
+
+	// regionMu protects regionRetryAt, the time at which the client may again send requests to its regional endpoint
+	regionMu      sync.Mutex
+	regionRetryAt time.Time
+
+	// mexMu protects mexDocs, which caches MEX documents by federation metadata URL
+	mexMu   sync.Mutex
+	mexDocs map[string]cachedMex
+
+	// jwksMu protects jwks, which caches authority signing keys by jwks_uri
+	jwksMu sync.Mutex
+	jwks   map[string]cachedJWKS
+}
+
+// cachedJWKS is a key set and the time at which the client must fetch it again
+type cachedJWKS struct {
+	keys    authority.JSONWebKeySet
+	expires time.Time
+}
+
+// cachedMex is a MEX document and the time at which the client must fetch it again
+type cachedMex struct {
+	doc     defs.MexDocument
+	expires time.Time
 }
 
 // New is the constructor for Token.
@@ -80,8 +113,81 @@ func (t *Client) AADInstanceDiscovery(ctx context.Context, authorityInfo authori
 	return t.Authority.AADInstanceDiscovery(ctx, authorityInfo)
 }
 
+// UserInfo gets claims about the user from the authority's userinfo endpoint. accessToken must be valid for that endpoint.
+func (t *Client) UserInfo(ctx context.Context, authParams authority.AuthParams, accessToken string) (authority.UserInfo, error) {
+	endpoints, err := t.Resolver.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
+	if err != nil {
+		return authority.UserInfo{}, err
+	}
+	if endpoints.UserInfoEndpoint == "" {
+		return authority.UserInfo{}, errors.New("the authority doesn't advertise a userinfo_endpoint")
+	}
+	return t.Authority.UserInfo(ctx, endpoints.UserInfoEndpoint, accessToken)
+}
+
+// VerifyJWT verifies the signature of a JWT issued by the authority and returns the token's claims along
+// with the authority's endpoints, which the caller needs to validate the token's issuer. It doesn't validate
+// any claim.
+func (t *Client) VerifyJWT(ctx context.Context, authParams authority.AuthParams, token string) (map[string]interface{}, authority.Endpoints, error) {
+	endpoints, err := t.Resolver.ResolveEndpoints(ctx, authParams.AuthorityInfo, "")
+	if err != nil {
+		return nil, authority.Endpoints{}, err
+	}
+	if endpoints.JWKSURI == "" {
+		return nil, authority.Endpoints{}, errors.New("the authority doesn't advertise a jwks_uri")
+	}
+	keys, err := t.signingKeys(ctx, endpoints.JWKSURI, false)
+	if err != nil {
+		return nil, authority.Endpoints{}, err
+	}
+	claims, err := authority.VerifyJWT(token, keys)
+	if errors.Is(err, authority.ErrUnknownSigningKey) {
+		// the authority may have rotated its keys since the client cached them
+		if keys, err = t.signingKeys(ctx, endpoints.JWKSURI, true); err != nil {
+			return nil, authority.Endpoints{}, err
+		}
+		claims, err = authority.VerifyJWT(token, keys)
+	}
+	if err != nil {
+		return nil, authority.Endpoints{}, err
+	}
+	return claims, endpoints, nil
+}
+
+// jwksTTL is how long the client caches an authority's signing keys
+const jwksTTL = 24 * time.Hour
+
+// signingKeys returns the key set at jwksURI, from the client's cache unless refresh is true.
+func (t *Client) signingKeys(ctx context.Context, jwksURI string, refresh bool) (authority.JSONWebKeySet, error) {
+	if !refresh {
+		t.jwksMu.Lock()
+		cached, ok := t.jwks[jwksURI]
+		t.jwksMu.Unlock()
+		if ok && now().Before(cached.expires) {
+			return cached.keys, nil
+		}
+	}
+	keys, err := t.Authority.JWKS(ctx, jwksURI)
+	if err != nil {
+		return authority.JSONWebKeySet{}, fmt.Errorf("couldn't get the authority's signing keys: %w", err)
+	}
+	t.jwksMu.Lock()
+	defer t.jwksMu.Unlock()
+	if t.jwks == nil {
+		t.jwks = map[string]cachedJWKS{}
+	}
+	t.jwks[jwksURI] = cachedJWKS{keys: keys, expires: now().Add(jwksTTL)}
+	return keys, nil
+}
+
 // AuthCode returns a token based on an authorization code.
 func (t *Client) AuthCode(ctx context.Context, req accesstokens.AuthCodeRequest) (accesstokens.TokenResponse, error) {
+	if req.Credential != nil && req.Credential.Chain != nil {
+		return tryChain(ctx, req.Credential.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			req.Credential = cred
+			return t.AuthCode(ctx, req)
+		})
+	}
 	if err := t.resolveEndpoint(ctx, &req.AuthParams, ""); err != nil {
 		return accesstokens.TokenResponse{}, err
 	}
@@ -95,8 +201,16 @@ func (t *Client) AuthCode(ctx context.Context, req accesstokens.AuthCodeRequest)
 
 // Credential acquires a token from the authority using a client credentials grant.
 func (t *Client) Credential(ctx context.Context, authParams authority.AuthParams, cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+	if cred.Chain != nil {
+		return tryChain(ctx, cred.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			return t.Credential(ctx, authParams, cred)
+		})
+	}
 	if cred.TokenProvider != nil {
-		now := time.Now()
+		if err := authParams.CheckPolicy(); err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
+		now := authParams.Now()
 		scopes := make([]string, len(authParams.Scopes))
 		copy(scopes, authParams.Scopes)
 		params := exported.TokenProviderParameters{
@@ -105,7 +219,7 @@ func (t *Client) Credential(ctx context.Context, authParams authority.AuthParams
 		}
 		tr, err := cred.TokenProvider(ctx, params)
 		if err != nil {
-			return accesstokens.TokenResponse{}, err
+			return accesstokens.TokenResponse{}, accesstokens.CredentialError{Err: err}
 		}
 		return accesstokens.TokenResponse{
 			AccessToken: tr.AccessToken,
@@ -116,38 +230,182 @@ func (t *Client) Credential(ctx context.Context, authParams authority.AuthParams
 		}, nil
 	}
 
-	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
-		return accesstokens.TokenResponse{}, err
-	}
+	return t.withRegionalFailover(ctx, authParams, func(ctx context.Context, authParams authority.AuthParams) (accesstokens.TokenResponse, error) {
+		if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
+
+		if cred.Secret != "" {
+			return t.AccessTokens.FromClientSecret(ctx, authParams, cred.Secret)
+		}
+		jwt, err := cred.JWT(ctx, authParams)
+		if err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
+		return t.AccessTokens.FromAssertion(ctx, authParams, jwt)
+	})
+}
 
-	if cred.Secret != "" {
-		return t.AccessTokens.FromClientSecret(ctx, authParams, cred.Secret)
+// PushAuthorizationRequest pushes params, the parameters of an authorization request, to the authority's pushed
+// authorization request endpoint. cred authenticates a confidential client and is nil for a public client.
+func (t *Client) PushAuthorizationRequest(ctx context.Context, authParams authority.AuthParams, cred *accesstokens.Credential, params url.Values) (accesstokens.PushedAuthorizationResponse, error) {
+	if cred != nil && cred.Chain != nil {
+		var resp accesstokens.PushedAuthorizationResponse
+		_, err := tryChain(ctx, cred.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			var err error
+			resp, err = t.PushAuthorizationRequest(ctx, authParams, cred, params)
+			return accesstokens.TokenResponse{}, err
+		})
+		return resp, err
 	}
-	jwt, err := cred.JWT(ctx, authParams)
-	if err != nil {
-		return accesstokens.TokenResponse{}, err
+	if cred != nil && cred.TokenProvider != nil {
+		return accesstokens.PushedAuthorizationResponse{}, errors.New("a credential from a token provider can't authenticate a pushed authorization request")
+	}
+	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
+		return accesstokens.PushedAuthorizationResponse{}, err
+	}
+	if authParams.Endpoints.PushedAuthorizationRequestEndpoint == "" {
+		return accesstokens.PushedAuthorizationResponse{}, errors.New("the authority doesn't advertise a pushed_authorization_request_endpoint")
 	}
-	return t.AccessTokens.FromAssertion(ctx, authParams, jwt)
+	return t.AccessTokens.PushAuthorizationRequest(ctx, authParams, cred, params)
 }
 
 // Credential acquires a token from the authority using a client credentials grant.
 func (t *Client) OnBehalfOf(ctx context.Context, authParams authority.AuthParams, cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
-	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
-		return accesstokens.TokenResponse{}, err
+	if cred.Chain != nil {
+		return tryChain(ctx, cred.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			return t.OnBehalfOf(ctx, authParams, cred)
+		})
 	}
+	return t.withRegionalFailover(ctx, authParams, func(ctx context.Context, authParams authority.AuthParams) (accesstokens.TokenResponse, error) {
+		if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
 
-	if cred.Secret != "" {
-		return t.AccessTokens.FromUserAssertionClientSecret(ctx, authParams, authParams.UserAssertion, cred.Secret)
+		if cred.Secret != "" {
+			return t.AccessTokens.FromUserAssertionClientSecret(ctx, authParams, authParams.UserAssertion, cred.Secret)
 
+		}
+		jwt, err := cred.JWT(ctx, authParams)
+		if err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
+		return t.AccessTokens.FromUserAssertionClientCertificate(ctx, authParams, authParams.UserAssertion, jwt)
+	})
+}
+
+// TokenExchange exchanges authParams.UserAssertion, a token of type authParams.SubjectTokenType, for an access token
+// using the token exchange grant (RFC 8693).
+func (t *Client) TokenExchange(ctx context.Context, authParams authority.AuthParams, cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+	if cred.Chain != nil {
+		return tryChain(ctx, cred.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			return t.TokenExchange(ctx, authParams, cred)
+		})
 	}
-	jwt, err := cred.JWT(ctx, authParams)
-	if err != nil {
-		return accesstokens.TokenResponse{}, err
+	if cred.TokenProvider != nil {
+		return accesstokens.TokenResponse{}, errors.New("a credential from a token provider can't authenticate a token exchange")
+	}
+	return t.withRegionalFailover(ctx, authParams, func(ctx context.Context, authParams authority.AuthParams) (accesstokens.TokenResponse, error) {
+		if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
+			return accesstokens.TokenResponse{}, err
+		}
+		return t.AccessTokens.FromTokenExchange(ctx, authParams, cred)
+	})
+}
+
+// tryChain sends request with each credential in chain until the authority accepts one. It stops at the first
+// error that isn't about the credential, for example because the authority rejected the request's scopes.
+func tryChain(ctx context.Context, chain *accesstokens.CredentialChain, request func(*accesstokens.Credential) (accesstokens.TokenResponse, error)) (accesstokens.TokenResponse, error) {
+	var errs []string
+	for _, cred := range chain.Credentials() {
+		tr, err := request(cred)
+		if err == nil {
+			chain.Succeeded(cred)
+			return tr, nil
+		}
+		if ctx.Err() != nil || !isCredentialErr(err) {
+			return tr, err
+		}
+		errs = append(errs, err.Error())
+	}
+	return accesstokens.TokenResponse{}, fmt.Errorf("the authority rejected every credential in the chain:\n%s", strings.Join(errs, "\n"))
+}
+
+// isCredentialErr returns true when err indicates the client couldn't authenticate with a credential. That's the
+// case when the authority returns invalid_client or unauthorized_client, or when the credential couldn't produce
+// an assertion or token, for example because a federated token file is missing. Other errors, such as a policy
+// violation or a network failure, would recur with any credential.
+func isCredentialErr(err error) bool {
+	var credErr accesstokens.CredentialError
+	if errors.As(err, &credErr) {
+		return true
+	}
+	var callErr errors.CallErr
+	if errors.As(err, &callErr) {
+		switch errorCode(callErr) {
+		case "invalid_client", "unauthorized_client":
+			return true
+		}
+	}
+	return false
+}
+
+// regionalCoolDown is how long a client sends requests to the global endpoint after its regional endpoint fails
+const regionalCoolDown = 5 * time.Minute
+
+// now provides a test hook for the regional cool down and MEX cache
+var now = time.Now
+
+// withRegionalFailover sends a token request. When authParams specifies a region and the regional endpoint
+// is unavailable, it retries the request on the global endpoint and then uses the global endpoint for all
+// requests until regionalCoolDown elapses.
+func (t *Client) withRegionalFailover(ctx context.Context, authParams authority.AuthParams, request func(context.Context, authority.AuthParams) (accesstokens.TokenResponse, error)) (accesstokens.TokenResponse, error) {
+	region := authParams.AuthorityInfo.Region
+	if authParams.TokenEndpointOverride != "" {
+		// the override replaces the regional endpoint, so there's no regional endpoint to fail over from
+		region = ""
+	}
+	if region != "" && t.regionAvailable() {
+		tr, err := request(ctx, authParams)
+		if err == nil || !IsUnavailable(err) || ctx.Err() != nil {
+			return tr, err
+		}
+		t.regionMu.Lock()
+		t.regionRetryAt = now().Add(regionalCoolDown)
+		t.regionMu.Unlock()
+	}
+	authParams.AuthorityInfo.Region = ""
+	return request(ctx, authParams)
+}
+
+// regionAvailable returns false while the regional endpoint is cooling down after a failure
+func (t *Client) regionAvailable() bool {
+	t.regionMu.Lock()
+	defer t.regionMu.Unlock()
+	return !now().Before(t.regionRetryAt)
+}
+
+// IsUnavailable returns true when err indicates the endpoint is unavailable, as opposed to the
+// authority rejecting the request. Retrying the request on another endpoint can succeed only in this case.
+// A canceled or expired context isn't an outage; it's the caller giving up.
+func IsUnavailable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var callErr errors.CallErr
+	if errors.As(err, &callErr) {
+		return callErr.Resp != nil && callErr.Resp.StatusCode >= http.StatusInternalServerError
 	}
-	return t.AccessTokens.FromUserAssertionClientCertificate(ctx, authParams, authParams.UserAssertion, jwt)
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
 }
 
 func (t *Client) Refresh(ctx context.Context, reqType accesstokens.AppType, authParams authority.AuthParams, cc *accesstokens.Credential, refreshToken accesstokens.RefreshToken) (accesstokens.TokenResponse, error) {
+	if cc != nil && cc.Chain != nil {
+		return tryChain(ctx, cc.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			return t.Refresh(ctx, reqType, authParams, cred, refreshToken)
+		})
+	}
 	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
 		return accesstokens.TokenResponse{}, err
 	}
@@ -164,23 +422,35 @@ func (t *Client) UsernamePassword(ctx context.Context, authParams authority.Auth
 		}
 		return t.AccessTokens.FromUsernamePassword(ctx, authParams)
 	}
-	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
+	// the user realm and MEX requests don't depend on the authority's endpoints, so the client sends
+	// them while it resolves the endpoints. That halves the latency of a first acquisition. The policy
+	// check comes first so the client sends nothing to a host it may not use.
+	if err := authParams.CheckPolicy(); err != nil {
 		return accesstokens.TokenResponse{}, err
 	}
-
-	userRealm, err := t.Authority.UserRealm(ctx, authParams)
-	if err != nil {
-		return accesstokens.TokenResponse{}, fmt.Errorf("problem getting user realm(user: %s) from authority: %w", authParams.Username, err)
+	var (
+		resolveErr error
+		wg         sync.WaitGroup
+	)
+	resolved := authParams
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resolveErr = t.resolveEndpoint(ctx, &resolved, "")
+	}()
+	realm, realmErr := t.userRealm(ctx, authParams)
+	wg.Wait()
+	if resolveErr != nil {
+		return accesstokens.TokenResponse{}, resolveErr
 	}
+	if realmErr != nil {
+		return accesstokens.TokenResponse{}, realmErr
+	}
+	authParams = resolved
 
-	switch userRealm.AccountType {
+	switch realm.AccountType {
 	case authority.Federated:
-		mexDoc, err := t.WSTrust.Mex(ctx, userRealm.FederationMetadataURL)
-		if err != nil {
-			return accesstokens.TokenResponse{}, fmt.Errorf("problem getting mex doc from federated url(%s): %w", userRealm.FederationMetadataURL, err)
-		}
-
-		saml, err := t.WSTrust.SAMLTokenInfo(ctx, authParams, userRealm.CloudAudienceURN, mexDoc.UsernamePasswordEndpoint)
+		saml, err := t.WSTrust.SAMLTokenInfo(ctx, authParams, realm.CloudAudienceURN, realm.endpoint)
 		if err != nil {
 			return accesstokens.TokenResponse{}, fmt.Errorf("problem getting SAML token info: %w", err)
 		}
@@ -191,12 +461,68 @@ func (t *Client) UsernamePassword(ctx context.Context, authParams authority.Auth
 	return accesstokens.TokenResponse{}, errors.New("unknown account type")
 }
 
+// federatedRealm is a user realm and, for a federated account, the WS-Trust endpoint to which the
+// client sends the user's credentials
+type federatedRealm struct {
+	authority.UserRealm
+	endpoint defs.Endpoint
+}
+
+// userRealm gets the user realm of authParams.Username and, when the account is federated, the
+// WS-Trust endpoint from the realm's MEX document. It needs only the authority's host.
+func (t *Client) userRealm(ctx context.Context, authParams authority.AuthParams) (federatedRealm, error) {
+	authParams.Endpoints = authority.NewEndpoints("", "", "", authParams.AuthorityInfo.Host)
+	userRealm, err := t.Authority.UserRealm(ctx, authParams)
+	if err != nil {
+		return federatedRealm{}, fmt.Errorf("problem getting user realm(user: %s) from authority: %w", authParams.Username, err)
+	}
+	realm := federatedRealm{UserRealm: userRealm}
+	if userRealm.AccountType == authority.Federated {
+		realm.endpoint = defs.Endpoint{Version: defs.Trust13, URL: authParams.WSTrustEndpoint}
+		if realm.endpoint.URL == "" {
+			mexDoc, err := t.mex(ctx, userRealm.FederationMetadataURL)
+			if err != nil {
+				return federatedRealm{}, fmt.Errorf("problem getting mex doc from federated url(%s): %w", userRealm.FederationMetadataURL, err)
+			}
+			realm.endpoint = mexDoc.UsernamePasswordEndpoint
+		}
+	}
+	return realm, nil
+}
+
+// mexTTL is how long the client caches a MEX document
+const mexTTL = 24 * time.Hour
+
+// mex returns the MEX document at federationMetadataURL. It caches documents for mexTTL because fetching
+// them dominates the latency of federated username/password authentication.
+func (t *Client) mex(ctx context.Context, federationMetadataURL string) (defs.MexDocument, error) {
+	t.mexMu.Lock()
+	cached, ok := t.mexDocs[federationMetadataURL]
+	t.mexMu.Unlock()
+	if ok && now().Before(cached.expires) {
+		return cached.doc, nil
+	}
+	doc, err := t.WSTrust.Mex(ctx, federationMetadataURL)
+	if err != nil {
+		return defs.MexDocument{}, err
+	}
+	t.mexMu.Lock()
+	defer t.mexMu.Unlock()
+	if t.mexDocs == nil {
+		t.mexDocs = map[string]cachedMex{}
+	}
+	t.mexDocs[federationMetadataURL] = cachedMex{doc: doc, expires: now().Add(mexTTL)}
+	return doc, nil
+}
+
 // DeviceCode is the result of a call to Token.DeviceCode().
 type DeviceCode struct {
 	// Result is the device code result from the first call in the device code flow. This allows
 	// the caller to retrieve the displayed code that is used to authorize on the second device.
 	Result     accesstokens.DeviceCodeResult
 	authParams authority.AuthParams
+	// credential authenticates a confidential client's token requests. It's nil for a public client.
+	credential *accesstokens.Credential
 
 	accessTokens AccessTokens
 }
@@ -219,7 +545,12 @@ func (d DeviceCode) Token(ctx context.Context) (accesstokens.TokenResponse, erro
 	}
 	defer cancel()
 
-	var interval = 50 * time.Millisecond
+	// RFC 8628 requires waiting the interval the authority specified between requests. A result having no
+	// interval didn't come from an authority, so the client backs off from a short interval instead.
+	interval, backoff := time.Duration(d.Result.Interval)*time.Second, false
+	if interval <= 0 {
+		interval, backoff = 50*time.Millisecond, true
+	}
 	timer := time.NewTimer(interval)
 	defer timer.Stop()
 
@@ -229,51 +560,97 @@ func (d DeviceCode) Token(ctx context.Context) (accesstokens.TokenResponse, erro
 		case <-ctx.Done():
 			return accesstokens.TokenResponse{}, ctx.Err()
 		case <-timer.C:
-			interval += interval * 2
-			if interval > 5*time.Second {
-				interval = 5 * time.Second
+			if backoff {
+				interval += interval * 2
+				if interval > 5*time.Second {
+					interval = 5 * time.Second
+				}
 			}
 		}
 
-		token, err := d.accessTokens.FromDeviceCodeResult(ctx, d.authParams, d.Result)
-		if err != nil && isWaitDeviceCodeErr(err) {
-			continue
+		token, err := d.redeem(ctx)
+		if err != nil {
+			switch code := waitDeviceCodeErr(err); {
+			case code == "slow_down" && !backoff:
+				// RFC 8628 section 3.5
+				interval += 5 * time.Second
+				continue
+			case code != "":
+				continue
+			}
 		}
 		return token, err // This handles if it was a non-wait error or success
 	}
 }
 
+// redeem requests tokens for the device code, trying each credential of a confidential client's credential chain
+func (d DeviceCode) redeem(ctx context.Context) (accesstokens.TokenResponse, error) {
+	if d.credential != nil && d.credential.Chain != nil {
+		return tryChain(ctx, d.credential.Chain, func(cred *accesstokens.Credential) (accesstokens.TokenResponse, error) {
+			return d.accessTokens.FromDeviceCodeResult(ctx, d.authParams, cred, d.Result)
+		})
+	}
+	return d.accessTokens.FromDeviceCodeResult(ctx, d.authParams, d.credential, d.Result)
+}
+
 type deviceCodeError struct {
 	Error string `json:"error"`
 }
 
-func isWaitDeviceCodeErr(err error) bool {
+// waitDeviceCodeErr returns the error code of err when it's one of the RFC 8628 codes asking the client to keep
+// polling, authorization_pending or slow_down, and an empty string otherwise
+func waitDeviceCodeErr(err error) string {
 	var c errors.CallErr
 	if !errors.As(err, &c) {
-		return false
+		return ""
 	}
 	if c.Resp.StatusCode != 400 {
-		return false
+		return ""
 	}
-	var dCErr deviceCodeError
-	defer c.Resp.Body.Close()
-	body, err := io.ReadAll(c.Resp.Body)
-	if err != nil {
-		return false
+	switch code := errorCode(c); code {
+	case "authorization_pending", "slow_down":
+		return code
 	}
-	err = json.Unmarshal(body, &dCErr)
-	if err != nil {
+	return ""
+}
+
+// IsInteractionRequired reports whether err is an error response from the authority indicating the
+// user must authenticate interactively, for example to consent to a scope or satisfy an MFA policy.
+func IsInteractionRequired(err error) bool {
+	var c errors.CallErr
+	if !errors.As(err, &c) || c.Resp == nil {
 		return false
 	}
-	if dCErr.Error == "authorization_pending" || dCErr.Error == "slow_down" {
+	switch errorCode(c) {
+	case "interaction_required", "consent_required", "login_required", "invalid_grant":
 		return true
 	}
 	return false
 }
 
+// errorCode returns the OAuth error code from the body of an error response. It restores
+// the body afterward so callers of CallErr.Verbose() can still read it.
+func errorCode(c errors.CallErr) string {
+	if c.Resp == nil || c.Resp.Body == nil {
+		return ""
+	}
+	defer c.Resp.Body.Close()
+	body, err := io.ReadAll(c.Resp.Body)
+	if err != nil {
+		return ""
+	}
+	c.Resp.Body = io.NopCloser(bytes.NewReader(body))
+	var dCErr deviceCodeError
+	if err = json.Unmarshal(body, &dCErr); err != nil {
+		return ""
+	}
+	return dCErr.Error
+}
+
 // DeviceCode returns a DeviceCode object that can be used to get the code that must be entered on the second
-// device and optionally the token once the code has been entered on the second device.
-func (t *Client) DeviceCode(ctx context.Context, authParams authority.AuthParams) (DeviceCode, error) {
+// device and optionally the token once the code has been entered on the second device. A confidential client
+// passes its credential, which authenticates the token requests; a public client passes nil.
+func (t *Client) DeviceCode(ctx context.Context, authParams authority.AuthParams, cc *accesstokens.Credential) (DeviceCode, error) {
 	if err := t.resolveEndpoint(ctx, &authParams, ""); err != nil {
 		return DeviceCode{}, err
 	}
@@ -283,13 +660,29 @@ func (t *Client) DeviceCode(ctx context.Context, authParams authority.AuthParams
 		return DeviceCode{}, err
 	}
 
-	return DeviceCode{Result: dcr, authParams: authParams, accessTokens: t.AccessTokens}, nil
+	return DeviceCode{Result: dcr, authParams: authParams, credential: cc, accessTokens: t.AccessTokens}, nil
 }
 
 func (t *Client) resolveEndpoint(ctx context.Context, authParams *authority.AuthParams, userPrincipalName string) error {
+	// this precedes every token request, so it's where the client enforces its allow-lists
+	if err := authParams.CheckPolicy(); err != nil {
+		return err
+	}
+	if err := authParams.RateLimiter.Wait(ctx, authParams.AuthorityInfo.Host); err != nil {
+		return err
+	}
+	if authParams.TokenEndpointOverride != "" {
+		// the override takes precedence over a region
+		authParams.AuthorityInfo.Region = ""
+	}
 	endpoints, err := t.Resolver.ResolveEndpoints(ctx, authParams.AuthorityInfo, userPrincipalName)
 	if err != nil {
-		return fmt.Errorf("unable to resolve an endpoint: %s", err)
+		return fmt.Errorf("unable to resolve an endpoint: %w", err)
+	}
+	if authParams.TokenEndpointOverride != "" {
+		endpoints.TokenEndpoint = authParams.TokenEndpointOverride
+	} else if authParams.TokenVersion() == authority.TokenVersionV1 {
+		endpoints.TokenEndpoint = authority.V1TokenEndpoint(endpoints.TokenEndpoint)
 	}
 	authParams.Endpoints = endpoints
 	return nil
@@ -13,16 +13,25 @@ import (
 	"bytes"
 	"context"
 	"crypto/x509"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/mock"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/fake"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/wstrust"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/wstrust/defs"
 )
 
 func TestAuthCode(t *testing.T) {
@@ -149,6 +158,104 @@ func TestCredential(t *testing.T) {
 	}
 }
 
+func TestRegionalFailover(t *testing.T) {
+	realNow := now
+	defer func() { now = realNow }()
+	current := time.Now()
+	now = func() time.Time { return current }
+
+	lmo, region, tenant := "login.microsoftonline.com", "westus", "tenant"
+	regionalHost := region + ".r." + lmo
+	info, err := authority.NewInfoFromAuthorityURI(fmt.Sprintf("https://%s/%s", lmo, tenant), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Region = region
+	authParams := authority.NewAuthParams("client-id", info)
+	authParams.Scopes = []string{"scope"}
+	authParams.AuthorizationType = authority.ATClientCredentials
+	cred := &accesstokens.Credential{Secret: "secret"}
+
+	mockClient := mock.Client{}
+	client := New(&mockClient)
+	// expectToken adds a token response, which must be from host
+	expectToken := func(host string) {
+		mockClient.AppendResponse(
+			mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)),
+			mock.WithCallback(func(r *http.Request) {
+				if r.URL.Host != host {
+					t.Errorf("expected a request to %s, got %s", host, r.URL.Host)
+				}
+			}),
+		)
+	}
+
+	// the regional endpoint is unavailable, so the client should fall back to the global endpoint
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	expectToken(lmo)
+	if _, err := client.Credential(context.Background(), authParams, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client should use the global endpoint until the cool down elapses
+	current = current.Add(regionalCoolDown / 2)
+	expectToken(lmo)
+	if _, err := client.Credential(context.Background(), authParams, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...and then try the regional endpoint again
+	current = current.Add(regionalCoolDown)
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(regionalHost, tenant)))
+	expectToken(regionalHost)
+	if _, err := client.Credential(context.Background(), authParams, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client shouldn't fall back when the regional endpoint rejects a request; the
+	// mock client would panic if the client sent another request
+	mockClient.AppendResponse(mock.WithBody([]byte(`{"error":"invalid_client"}`)), mock.WithHTTPStatus(http.StatusUnauthorized))
+	if _, err := client.Credential(context.Background(), authParams, cred); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestTokenEndpointOverrideRegion(t *testing.T) {
+	lmo, override, tenant := "login.microsoftonline.com", "https://private.login.example/tenant/oauth2/v2.0/token", "tenant"
+	info, err := authority.NewInfoFromAuthorityURI(fmt.Sprintf("https://%s/%s", lmo, tenant), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Region = "westus"
+	authParams := authority.NewAuthParams("client-id", info)
+	authParams.Scopes = []string{"scope"}
+	authParams.AuthorizationType = authority.ATClientCredentials
+	authParams.TokenEndpointOverride = override
+
+	mockClient := mock.Client{}
+	// the override takes precedence over the region, so the client should discover the global endpoints
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)),
+		mock.WithCallback(func(r *http.Request) {
+			if r.URL.Host != lmo {
+				t.Errorf("expected tenant discovery from %s, got %s", lmo, r.URL.Host)
+			}
+		}),
+	)
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("*", "", "", "", 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if actual := r.URL.String(); actual != override {
+				t.Errorf("expected a request to %s, got %s", override, actual)
+			}
+		}),
+	)
+	if _, err := New(&mockClient).Credential(context.Background(), authParams, &accesstokens.Credential{Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRefresh(t *testing.T) {
 	tests := []struct {
 		desc string
@@ -273,6 +380,153 @@ func TestUsernamePassword(t *testing.T) {
 	}
 }
 
+// recordingWSTrust records the MEX documents it fetches and the endpoints to which it sends credentials
+type recordingWSTrust struct {
+	fake.WSTrust
+	mexURLs   []string
+	endpoints []string
+}
+
+func (r *recordingWSTrust) Mex(ctx context.Context, federationMetadataURL string) (defs.MexDocument, error) {
+	r.mexURLs = append(r.mexURLs, federationMetadataURL)
+	return r.WSTrust.Mex(ctx, federationMetadataURL)
+}
+
+func (r *recordingWSTrust) SAMLTokenInfo(ctx context.Context, authParams authority.AuthParams, cloudAudienceURN string, endpoint defs.Endpoint) (wstrust.SamlTokenInfo, error) {
+	r.endpoints = append(r.endpoints, endpoint.URL)
+	return r.WSTrust.SAMLTokenInfo(ctx, authParams, cloudAudienceURN, endpoint)
+}
+
+func TestUsernamePasswordMex(t *testing.T) {
+	realNow := now
+	defer func() { now = realNow }()
+	current := time.Now()
+	now = func() time.Time { return current }
+
+	mexURL, mexEndpoint, override := "https://idp/mex", "https://idp/trust/13/usernamemixed", "https://private.idp/trust/13/usernamemixed"
+	ws := &recordingWSTrust{WSTrust: fake.WSTrust{
+		MexDocument: defs.MexDocument{UsernamePasswordEndpoint: defs.Endpoint{Version: defs.Trust13, URL: mexEndpoint}},
+	}}
+	client := &Client{
+		AccessTokens: &fake.AccessTokens{},
+		Authority:    fake.Authority{Realm: authority.UserRealm{AccountType: authority.Federated, FederationMetadataURL: mexURL}},
+		Resolver:     fake.ResolveEndpoints{},
+		WSTrust:      ws,
+	}
+	authParams := authority.AuthParams{}
+	// the client should fetch the MEX document once and then use its cached copy...
+	for i := 0; i < 2; i++ {
+		if _, err := client.UsernamePassword(context.Background(), authParams); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ...until it expires
+	current = current.Add(mexTTL)
+	if _, err := client.UsernamePassword(context.Background(), authParams); err != nil {
+		t.Fatal(err)
+	}
+	// the client shouldn't fetch the MEX document when the caller specifies an endpoint
+	authParams.WSTrustEndpoint = override
+	if _, err := client.UsernamePassword(context.Background(), authParams); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{mexURL, mexURL}; !reflect.DeepEqual(ws.mexURLs, expected) {
+		t.Errorf("expected MEX requests %v, got %v", expected, ws.mexURLs)
+	}
+	if expected := []string{mexEndpoint, mexEndpoint, mexEndpoint, override}; !reflect.DeepEqual(ws.endpoints, expected) {
+		t.Errorf("expected WS-Trust requests %v, got %v", expected, ws.endpoints)
+	}
+}
+
+// blockingResolver doesn't resolve endpoints until unblock closes
+type blockingResolver struct {
+	unblock chan struct{}
+}
+
+func (b blockingResolver) ResolveEndpoints(ctx context.Context, info authority.Info, upn string) (authority.Endpoints, error) {
+	select {
+	case <-b.unblock:
+	case <-ctx.Done():
+		return authority.Endpoints{}, ctx.Err()
+	}
+	return fake.ResolveEndpoints{}.ResolveEndpoints(ctx, info, upn)
+}
+
+// signalingAuthority closes realmRequested when the client requests a user realm
+type signalingAuthority struct {
+	fake.Authority
+	realmRequested chan struct{}
+}
+
+func (s signalingAuthority) UserRealm(ctx context.Context, authParams authority.AuthParams) (authority.UserRealm, error) {
+	close(s.realmRequested)
+	return s.Authority.UserRealm(ctx, authParams)
+}
+
+func TestUsernamePasswordPipelinesRealm(t *testing.T) {
+	realmRequested := make(chan struct{})
+	client := &Client{
+		AccessTokens: &fake.AccessTokens{},
+		Authority:    signalingAuthority{fake.Authority{Realm: authority.UserRealm{AccountType: authority.Managed}}, realmRequested},
+		// the resolver waits for the user realm request, so the test times out when the client sends them serially
+		Resolver: blockingResolver{unblock: realmRequested},
+		WSTrust:  fake.WSTrust{},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.UsernamePassword(ctx, authority.AuthParams{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gatedHTTPClient responds to tenant discovery requests after release closes, counting them
+type gatedHTTPClient struct {
+	release  chan struct{}
+	requests int32
+}
+
+func (g *gatedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&g.requests, 1)
+	<-g.release
+	body := mock.GetTenantDiscoveryBody(req.URL.Host, "tenant")
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (*gatedHTTPClient) CloseIdleConnections() {}
+
+func TestResolveEndpointsShared(t *testing.T) {
+	info, err := authority.NewInfoFromAuthorityURI("https://login.microsoftonline.com/tenant", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &gatedHTTPClient{release: make(chan struct{})}
+	resolver := newAuthorityEndpoint(ops.New(httpClient))
+	const n = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := resolver.ResolveEndpoints(context.Background(), info, "")
+			errs <- err
+		}()
+	}
+	// give the goroutines time to find the pending resolution
+	time.Sleep(50 * time.Millisecond)
+	close(httpClient.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual := atomic.LoadInt32(&httpClient.requests); actual != 1 {
+		t.Fatalf("expected 1 tenant discovery request, got %d", actual)
+	}
+}
+
 func TestDeviceCode(t *testing.T) {
 	tests := []struct {
 		desc string
@@ -285,7 +539,7 @@ func TestDeviceCode(t *testing.T) {
 			err:  true,
 		},
 		{
-			desc: "Error: FromDeviceCodeResult() returned a !isWaitDeviceCodeErr",
+			desc: "Error: FromDeviceCodeResult() returned an error other than authorization_pending or slow_down",
 			dc: DeviceCode{
 				accessTokens: &fake.AccessTokens{
 					Result: []error{
@@ -382,7 +636,7 @@ func TestDeviceCodeToken(t *testing.T) {
 		token.AccessTokens = test.at
 		token.Resolver = test.re
 
-		dc, err := token.DeviceCode(context.Background(), authority.AuthParams{})
+		dc, err := token.DeviceCode(context.Background(), authority.AuthParams{}, nil)
 		switch {
 		case err == nil && test.err:
 			t.Errorf("TestDeviceCodeToken(%s): got err == nil, want err != nil", test.desc)
@@ -399,3 +653,20 @@ func TestDeviceCodeToken(t *testing.T) {
 		}
 	}
 }
+
+func TestIsUnavailable(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		expected bool
+	}{
+		{err: &url.Error{Op: "Post", URL: "https://localhost", Err: fmt.Errorf("connection refused")}, expected: true},
+		{err: &url.Error{Op: "Post", URL: "https://localhost", Err: context.Canceled}},
+		{err: &url.Error{Op: "Post", URL: "https://localhost", Err: context.DeadlineExceeded}},
+		{err: errors.CallErr{Resp: &http.Response{StatusCode: http.StatusServiceUnavailable}}, expected: true},
+		{err: errors.CallErr{Resp: &http.Response{StatusCode: http.StatusBadRequest}}},
+	} {
+		if actual := IsUnavailable(test.err); actual != test.expected {
+			t.Errorf("IsUnavailable(%v): expected %t, got %t", test.err, test.expected, actual)
+		}
+	}
+}
@@ -12,6 +12,7 @@ return for the requests.  The request definition is defined in https://tools.iet
 package accesstokens
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 
@@ -21,13 +22,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/internal/comm"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/internal/grant"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/wstrust"
 	"github.com/golang-jwt/jwt/v4"
@@ -72,6 +79,21 @@ type DeviceCodeResponse struct {
 	ExpiresIn       int    `json:"expires_in"`
 	Interval        int    `json:"interval"`
 	Message         string `json:"message"`
+	// VerificationURI and VerificationURIComplete are the RFC 8628 names. Older Microsoft Entra ID
+	// endpoints return VerificationURL instead.
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+
+	AdditionalFields map[string]interface{}
+}
+
+// PushedAuthorizationResponse represents the HTTP response received from the pushed authorization request
+// endpoint. RequestURI refers to the pushed parameters in an authorization URL until they expire.
+type PushedAuthorizationResponse struct {
+	authority.OAuthResponseBase
+
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -79,7 +101,18 @@ type DeviceCodeResponse struct {
 // Convert converts the DeviceCodeResponse to a DeviceCodeResult
 func (dcr DeviceCodeResponse) Convert(clientID string, scopes []string) DeviceCodeResult {
 	expiresOn := time.Now().UTC().Add(time.Duration(dcr.ExpiresIn) * time.Second)
-	return NewDeviceCodeResult(dcr.UserCode, dcr.DeviceCode, dcr.VerificationURL, expiresOn, dcr.Interval, dcr.Message, clientID, scopes)
+	verificationURL := dcr.VerificationURL
+	if verificationURL == "" {
+		verificationURL = dcr.VerificationURI
+	}
+	interval := dcr.Interval
+	if interval <= 0 {
+		// RFC 8628 section 3.2
+		interval = 5
+	}
+	result := NewDeviceCodeResult(dcr.UserCode, dcr.DeviceCode, verificationURL, expiresOn, interval, dcr.Message, clientID, scopes)
+	result.VerificationURIComplete = dcr.VerificationURIComplete
+	return result
 }
 
 // Credential represents the credential used in confidential client flows. This can be either
@@ -94,6 +127,20 @@ type Credential struct {
 	Key crypto.PrivateKey
 	// X5c is the JWT assertion's x5c header value, required for SN/I authentication.
 	X5c []string
+	// AssertionAudience overrides the JWT assertion's "aud" claim, which is by default the token endpoint.
+	AssertionAudience string
+	// AssertionClaims are additional claims for the JWT assertion. They can't override the standard claims.
+	AssertionClaims map[string]interface{}
+	// AssertionLifetime is the JWT assertion's validity period. Zero means the default, 10 minutes.
+	AssertionLifetime time.Duration
+	// AssertionIDs generates the JWT assertion's "jti" claim. Nil means random UUIDs.
+	AssertionIDs func() string
+	// DisableAssertionReuse directs JWT to sign a new assertion for every request.
+	DisableAssertionReuse bool
+	// CertExpiryWarning is called by JWT when Cert expires within CertExpiryWindow. Nil disables warnings.
+	CertExpiryWarning func(cert *x509.Certificate)
+	// CertExpiryWindow is how long before Cert expires JWT calls CertExpiryWarning.
+	CertExpiryWindow time.Duration
 
 	// AssertionCallback is a function provided by the application, if we're authenticating by assertion.
 	AssertionCallback func(context.Context, exported.AssertionRequestOptions) (string, error)
@@ -101,26 +148,149 @@ type Credential struct {
 	// TokenProvider is a function provided by the application that implements custom authentication
 	// logic for a confidential client
 	TokenProvider func(context.Context, exported.TokenProviderParameters) (exported.TokenProviderResult, error)
+
+	// Chain is set when the credential is a list of alternatives. The other fields are then empty.
+	Chain *CredentialChain
+
+	// mu protects assertions, the signed JWT assertions JWT reuses, keyed by assertionKey
+	mu         sync.Mutex
+	assertions map[string]signedAssertion
+}
+
+// signedAssertion is a JWT assertion the credential signed and the time after which JWT signs a new one
+type signedAssertion struct {
+	jwt     string
+	renewAt time.Time
+}
+
+// assertionKey identifies the claims and headers of the JWT assertions JWT signs for authParams, apart from
+// their lifetimes and IDs
+func (c *Credential) assertionKey(authParams authority.AuthParams) string {
+	aud := authParams.Endpoints.TokenEndpoint
+	if c.AssertionAudience != "" {
+		aud = c.AssertionAudience
+	}
+	return strings.Join([]string{authParams.ClientID, aud, strconv.FormatBool(authParams.SendX5C)}, " ")
+}
+
+// CredentialChain is an ordered list of credentials for one application, such as a secret and the
+// certificate replacing it. Token requests should try the credentials in the order returned by
+// Credentials, and call Succeeded with the one the authority accepts.
+type CredentialChain struct {
+	creds []*Credential
+
+	mu        sync.Mutex
+	preferred int
+}
+
+// NewCredentialChain is the constructor for CredentialChain.
+func NewCredentialChain(creds ...*Credential) *CredentialChain {
+	return &CredentialChain{creds: creds}
+}
+
+// Credentials returns the chain's credentials, beginning with the one the authority last accepted.
+func (c *CredentialChain) Credentials() []*Credential {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ordered := make([]*Credential, 0, len(c.creds))
+	ordered = append(ordered, c.creds[c.preferred])
+	for i, cred := range c.creds {
+		if i != c.preferred {
+			ordered = append(ordered, cred)
+		}
+	}
+	return ordered
+}
+
+// Succeeded records that the authority accepted cred, so that later requests try it first.
+func (c *CredentialChain) Succeeded(cred *Credential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, cc := range c.creds {
+		if cc == cred {
+			c.preferred = i
+			return
+		}
+	}
+}
+
+// Claims returns the claims of the JWT assertion the credential signs for the given AuthParams.
+func (c *Credential) Claims(authParams authority.AuthParams) jwt.MapClaims {
+	aud := authParams.Endpoints.TokenEndpoint
+	if c.AssertionAudience != "" {
+		aud = c.AssertionAudience
+	}
+	lifetime := c.assertionLifetime()
+	claims := jwt.MapClaims{}
+	for k, v := range c.AssertionClaims {
+		claims[k] = v
+	}
+	claims["aud"] = aud
+	now := authParams.Now()
+	claims["exp"] = json.Number(strconv.FormatInt(now.Add(lifetime).Unix(), 10))
+	claims["iss"] = authParams.ClientID
+	if c.AssertionIDs != nil {
+		claims["jti"] = c.AssertionIDs()
+	} else {
+		claims["jti"] = uuid.New().String()
+	}
+	claims["nbf"] = json.Number(strconv.FormatInt(now.Add(-authParams.ClockSkew).Unix(), 10))
+	claims["sub"] = authParams.ClientID
+	return claims
+}
+
+// CounterAssertionIDs returns a generator of JWT assertion IDs having a random prefix and a sequence number, such
+// as "0f8fad5b-d9cb-469f-a165-70867728950e.1". The prefix makes the IDs unique to the generator.
+func CounterAssertionIDs() func() string {
+	prefix := uuid.New().String()
+	var n uint64
+	return func() string {
+		return prefix + "." + strconv.FormatUint(atomic.AddUint64(&n, 1), 10)
+	}
 }
 
-// JWT gets the jwt assertion when the credential is not using a secret.
+// assertionLifetime returns the validity period of the credential's JWT assertions
+func (c *Credential) assertionLifetime() time.Duration {
+	if c.AssertionLifetime > 0 {
+		return c.AssertionLifetime
+	}
+	return 10 * time.Minute
+}
+
+// JWT gets the jwt assertion when the credential is not using a secret. It reuses a JWT it signed for the same
+// client and audience until three quarters of the JWT's lifetime has passed, because signing can be slow, for
+// example when a hardware security module holds the key.
 func (c *Credential) JWT(ctx context.Context, authParams authority.AuthParams) (string, error) {
 	if c.AssertionCallback != nil {
 		options := exported.AssertionRequestOptions{
 			ClientID:      authParams.ClientID,
 			TokenEndpoint: authParams.Endpoints.TokenEndpoint,
 		}
-		return c.AssertionCallback(ctx, options)
+		assertion, err := c.AssertionCallback(ctx, options)
+		if err != nil {
+			return "", CredentialError{Err: err}
+		}
+		return assertion, nil
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"aud": authParams.Endpoints.TokenEndpoint,
-		"exp": json.Number(strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)),
-		"iss": authParams.ClientID,
-		"jti": uuid.New().String(),
-		"nbf": json.Number(strconv.FormatInt(time.Now().Unix(), 10)),
-		"sub": authParams.ClientID,
-	})
+	if c.Chain != nil {
+		return "", fmt.Errorf("a credential chain can't sign an assertion; try each of its credentials instead")
+	}
+	if c.Cert == nil || c.Key == nil {
+		return "", CredentialError{Err: fmt.Errorf("the credential has no certificate and private key with which to sign an assertion")}
+	}
+	key, now := c.assertionKey(authParams), authParams.Now()
+	// warn on every request, including those reusing an assertion, so the warning recurs until the cert is replaced
+	if c.CertExpiryWarning != nil && c.Cert.NotAfter.Sub(now) <= c.CertExpiryWindow {
+		c.CertExpiryWarning(c.Cert)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a, ok := c.assertions[key]; ok && now.Before(a.renewAt) && !c.DisableAssertionReuse {
+		return a.jwt, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, c.Claims(authParams))
 	token.Header = map[string]interface{}{
 		"alg": "RS256",
 		"typ": "JWT",
@@ -133,11 +303,29 @@ func (c *Credential) JWT(ctx context.Context, authParams authority.AuthParams) (
 
 	assertion, err := token.SignedString(c.Key)
 	if err != nil {
-		return "", fmt.Errorf("unable to sign a JWT token using private key: %w", err)
+		return "", CredentialError{Err: fmt.Errorf("unable to sign a JWT token using private key: %w", err)}
 	}
+	if c.assertions == nil {
+		c.assertions = map[string]signedAssertion{}
+	}
+	c.assertions[key] = signedAssertion{jwt: assertion, renewAt: now.Add(c.assertionLifetime() * 3 / 4)}
 	return assertion, nil
 }
 
+// CredentialError is returned when a credential can't produce an assertion or token, for example because a
+// federated token file is missing.
+type CredentialError struct {
+	Err error
+}
+
+func (e CredentialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e CredentialError) Unwrap() error {
+	return e.Err
+}
+
 // thumbprint runs the asn1.Der bytes through sha1 for use in the x5t parameter of JWT.
 // https://tools.ietf.org/html/rfc7517#section-4.8
 func thumbprint(cert *x509.Certificate) []byte {
@@ -300,15 +488,31 @@ func (c Client) FromUserAssertionClientCertificate(ctx context.Context, authPara
 	return c.doTokenResp(ctx, authParameters, qv)
 }
 
+// FromTokenExchange exchanges authParams.UserAssertion, a token of type authParams.SubjectTokenType, for an access
+// token (RFC 8693). cc authenticates the client.
+func (c Client) FromTokenExchange(ctx context.Context, authParams authority.AuthParams, cc *Credential) (TokenResponse, error) {
+	qv, err := prepURLVals(ctx, cc, authParams)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	qv.Set(grantType, grant.TokenExchange)
+	qv.Set(clientID, authParams.ClientID)
+	qv.Set(clientInfo, clientInfoVal)
+	qv.Set("subject_token", authParams.UserAssertion)
+	qv.Set("subject_token_type", authParams.SubjectTokenType)
+	qv.Set("requested_token_type", "urn:ietf:params:oauth:token-type:access_token")
+	addScopeQueryParam(qv, authParams)
+
+	return c.doTokenResp(ctx, authParams, qv)
+}
+
 func (c Client) DeviceCodeResult(ctx context.Context, authParameters authority.AuthParams) (DeviceCodeResult, error) {
 	qv := url.Values{}
 	qv.Set(clientID, authParameters.ClientID)
 	addScopeQueryParam(qv, authParameters)
 
-	endpoint := strings.Replace(authParameters.Endpoints.TokenEndpoint, "token", "devicecode", -1)
-
 	resp := DeviceCodeResponse{}
-	err := c.Comm.URLFormCall(ctx, endpoint, qv, &resp)
+	err := c.Comm.URLFormCall(ctx, authParameters.DeviceCodeEndpoint(), qv, &resp)
 	if err != nil {
 		return DeviceCodeResult{}, err
 	}
@@ -316,9 +520,48 @@ func (c Client) DeviceCodeResult(ctx context.Context, authParameters authority.A
 	return resp.Convert(authParameters.ClientID, authParameters.Scopes), nil
 }
 
-func (c Client) FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, deviceCodeResult DeviceCodeResult) (TokenResponse, error) {
+// PushAuthorizationRequest pushes params, the parameters of an authorization request, to the authority's
+// pushed authorization request endpoint. cc authenticates a confidential client and is nil for a public client.
+func (c Client) PushAuthorizationRequest(ctx context.Context, authParams authority.AuthParams, cc *Credential, params url.Values) (PushedAuthorizationResponse, error) {
+	qv := url.Values{}
+	if cc != nil {
+		var err error
+		qv, err = prepURLVals(ctx, cc, authParams)
+		if err != nil {
+			return PushedAuthorizationResponse{}, err
+		}
+	}
+	for k, v := range params {
+		qv[k] = v
+	}
+
+	resp := PushedAuthorizationResponse{}
+	if err := c.Comm.URLFormCall(ctx, authParams.Endpoints.PushedAuthorizationRequestEndpoint, qv, &resp); err != nil {
+		return PushedAuthorizationResponse{}, err
+	}
+	if resp.RequestURI == "" {
+		return PushedAuthorizationResponse{}, fmt.Errorf("the pushed authorization response had no request_uri")
+	}
+	return resp, nil
+}
+
+// FromDeviceCodeResult redeems a device code for tokens. cc authenticates a confidential client and is nil for
+// a public client.
+func (c Client) FromDeviceCodeResult(ctx context.Context, authParameters authority.AuthParams, cc *Credential, deviceCodeResult DeviceCodeResult) (TokenResponse, error) {
 	qv := url.Values{}
-	qv.Set(grantType, grant.DeviceCode)
+	if cc != nil {
+		var err error
+		qv, err = prepURLVals(ctx, cc, authParameters)
+		if err != nil {
+			return TokenResponse{}, err
+		}
+	}
+	// Microsoft Entra ID accepts a short form of the grant type, which other authorities don't (RFC 8628)
+	if authParameters.AuthorityInfo.AuthorityType == authority.AAD {
+		qv.Set(grantType, grant.DeviceCode)
+	} else {
+		qv.Set(grantType, grant.DeviceCodeURN)
+	}
 	qv.Set(deviceCode, deviceCodeResult.DeviceCode)
 	qv.Set(clientID, authParameters.ClientID)
 	qv.Set(clientInfo, clientInfoVal)
@@ -348,23 +591,169 @@ func (c Client) FromSamlGrant(ctx context.Context, authParameters authority.Auth
 	return c.doTokenResp(ctx, authParameters, qv)
 }
 
-func (c Client) doTokenResp(ctx context.Context, authParams authority.AuthParams, qv url.Values) (TokenResponse, error) {
-	resp := TokenResponse{}
-	err := c.Comm.URLFormCall(ctx, authParams.Endpoints.TokenEndpoint, qv, &resp)
+func (c Client) doTokenResp(ctx context.Context, authParams authority.AuthParams, qv url.Values) (resp TokenResponse, err error) {
+	if authParams.Claims != "" {
+		qv.Set("claims", authParams.Claims)
+	}
+	if authParams.AuthnScheme != nil {
+		for k, v := range authParams.AuthnScheme.TokenRequestParams() {
+			qv.Set(k, v)
+		}
+	}
+	if authParams.SSHKeyID != "" {
+		qv.Set("token_type", "ssh-cert")
+		qv.Set("key_id", authParams.SSHKeyID)
+		qv.Set("req_cnf", authParams.SSHReqCnf)
+	}
+	if authParams.AuthorityClock != nil {
+		ctx = comm.WithResponseHeaders(ctx, func(h http.Header) {
+			if t, err := http.ParseTime(h.Get("Date")); err == nil {
+				authParams.ObserveAuthorityTime(t)
+			}
+		})
+	}
+	if authParams.RequestInterceptor != nil {
+		if err = interceptRequest(ctx, authParams, qv); err != nil {
+			return resp, err
+		}
+	}
+	if authParams.ResponseInterceptor != nil {
+		start := time.Now()
+		defer func() {
+			interceptResponse(ctx, authParams, qv, time.Since(start), resp, err)
+		}()
+	}
+	var body []byte
+	ctx = comm.WithResponseBody(ctx, func(b []byte) { body = b })
+	err = c.tokenCall(ctx, authParams, qv, &resp)
 	if err != nil {
 		return resp, err
 	}
+	if authParams.StrictTokenResponses {
+		if err = validateStrict(body); err != nil {
+			return resp, err
+		}
+	}
+	resp.RawResponse = rawResponse(body)
 	resp.ComputeScope(authParams)
+	if resp.TokenType == "" {
+		// the authority omits token_type from some responses, such as ADFS's, whose tokens are Bearer tokens
+		resp.TokenType = authority.AccessTokenTypeBearer
+	}
+	if authParams.AuthnScheme != nil && !strings.EqualFold(resp.TokenType, authParams.AuthnScheme.AccessTokenType()) {
+		return resp, fmt.Errorf("requested a %q access token but the authority returned a %q token", authParams.AuthnScheme.AccessTokenType(), resp.TokenType)
+	}
+	if authParams.Clock != nil {
+		// the response's lifetimes were converted to times by the system clock
+		offset := authParams.Clock().Sub(time.Now())
+		resp.ExpiresOn.T = resp.ExpiresOn.T.Add(offset)
+		resp.ExtExpiresOn.T = resp.ExtExpiresOn.T.Add(offset)
+	}
 	if c.testing {
 		return resp, nil
 	}
 	return resp, resp.Validate()
 }
 
+// interceptRequest passes a description of the token request in qv to the request interceptor, adding to qv the
+// extra parameters the interceptor specifies
+func interceptRequest(ctx context.Context, authParams authority.AuthParams, qv url.Values) error {
+	info := exported.TokenRequestInfo{
+		ClientID:            authParams.ClientID,
+		CorrelationID:       authParams.CorrelationID,
+		Endpoint:            authParams.Endpoints.TokenEndpoint,
+		ExtraBodyParameters: map[string]string{},
+		GrantType:           qv.Get(grantType),
+		Scopes:              authParams.Scopes,
+		TenantID:            authParams.AuthorityInfo.Tenant,
+	}
+	if err := authParams.RequestInterceptor(ctx, &info); err != nil {
+		return fmt.Errorf("the request interceptor rejected the token request: %w", err)
+	}
+	for k, v := range info.ExtraBodyParameters {
+		if _, ok := qv[k]; !ok {
+			qv.Set(k, v)
+		}
+	}
+	return nil
+}
+
+// rawResponse decodes the body of a token response, omitting the refresh token because the client
+// manages refresh tokens. It returns nil when the body isn't a JSON object.
+func rawResponse(body []byte) map[string]interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	delete(raw, "refresh_token")
+	return raw
+}
+
+// tokenCall sends a token request. When authParams' scheme is DPoP, it adds a proof to the request, and retries
+// once with a new proof when the authority requires a nonce the proof lacked (RFC 9449 section 8).
+func (c Client) tokenCall(ctx context.Context, authParams authority.AuthParams, qv url.Values, resp *TokenResponse) error {
+	endpoint := authParams.Endpoints.TokenEndpoint
+	dpop, ok := authParams.AuthnScheme.(*authority.DPoP)
+	if !ok {
+		return c.Comm.URLFormCall(ctx, endpoint, qv, resp)
+	}
+	ctx = comm.WithResponseHeaders(ctx, func(h http.Header) {
+		dpop.ObserveNonce(endpoint, h.Get(authority.DPoPNonceHeader))
+	})
+	for retried := false; ; retried = true {
+		headers, err := dpop.TokenRequestHeaders(endpoint)
+		if err != nil {
+			return err
+		}
+		err = c.Comm.URLFormCall(comm.WithHeaders(ctx, headers), endpoint, qv, resp)
+		if retried || !isNonceRequired(err) {
+			return err
+		}
+	}
+}
+
+// isNonceRequired returns true when err is the authority's demand for a DPoP proof having its nonce
+func isNonceRequired(err error) bool {
+	var callErr errors.CallErr
+	if !errors.As(err, &callErr) || callErr.Resp == nil || callErr.Resp.Body == nil || callErr.Resp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	body, readErr := io.ReadAll(callErr.Resp.Body)
+	callErr.Resp.Body = io.NopCloser(bytes.NewReader(body))
+	var e struct {
+		Error string `json:"error"`
+	}
+	return readErr == nil && json.Unmarshal(body, &e) == nil && e.Error == "use_dpop_nonce"
+}
+
+// interceptResponse passes a description of a token request's outcome to the response interceptor
+func interceptResponse(ctx context.Context, authParams authority.AuthParams, qv url.Values, d time.Duration, resp TokenResponse, err error) {
+	info := exported.TokenResponseInfo{
+		ClientID:      authParams.ClientID,
+		CorrelationID: authParams.CorrelationID,
+		Duration:      d,
+		Endpoint:      authParams.Endpoints.TokenEndpoint,
+		Err:           err,
+		GrantType:     qv.Get(grantType),
+		Scopes:        authParams.Scopes,
+		TenantID:      authParams.AuthorityInfo.Tenant,
+	}
+	if err == nil {
+		info.ExpiresOn = resp.ExpiresOn.T
+	}
+	authParams.ResponseInterceptor(ctx, &info)
+}
+
 // prepURLVals returns an url.Values that sets various key/values if we are doing secrets
 // or JWT assertions.
 func prepURLVals(ctx context.Context, cc *Credential, authParams authority.AuthParams) (url.Values, error) {
 	params := url.Values{}
+	if cc.Chain != nil {
+		return nil, fmt.Errorf("a credential chain must be resolved to one of its credentials before a request")
+	}
 	if cc.Secret != "" {
 		params.Set("client_secret", cc.Secret)
 		return params, nil
@@ -379,34 +768,68 @@ func prepURLVals(ctx context.Context, cc *Credential, authParams authority.AuthP
 	return params, nil
 }
 
-// openid required to get an id token
-// offline_access required to get a refresh token
-// profile required to get the client_info field back
-var detectDefaultScopes = map[string]bool{
-	"openid":         true,
-	"offline_access": true,
-	"profile":        true,
-}
-
-var defaultScopes = []string{"openid", "offline_access", "profile"}
-
+// AppendDefaultScopes returns the normalized scopes of authParameters with the reserved scopes its flow requires.
+// Flows that authenticate a user require all of them. The client credentials flow requires none, because it
+// has no user to identify and no refresh token.
 func AppendDefaultScopes(authParameters authority.AuthParams) []string {
-	scopes := make([]string, 0, len(authParameters.Scopes)+len(defaultScopes))
-	for _, scope := range authParameters.Scopes {
-		s := strings.TrimSpace(scope)
-		if s == "" {
-			continue
-		}
-		if detectDefaultScopes[scope] {
-			continue
+	normalized := authParameters.NormalizedScopes()
+	scopes := make([]string, 0, len(normalized)+len(authority.ReservedScopes))
+	for _, scope := range normalized {
+		if !authority.IsReservedScope(scope) {
+			scopes = append(scopes, scope)
 		}
-		scopes = append(scopes, scope)
 	}
-	scopes = append(scopes, defaultScopes...)
+	if authParameters.AuthorizationType != authority.ATClientCredentials {
+		scopes = append(scopes, authority.ReservedScopes...)
+	}
 	return scopes
 }
 
 func addScopeQueryParam(queryParams url.Values, authParameters authority.AuthParams) {
 	scopes := AppendDefaultScopes(authParameters)
+	if authParameters.TokenVersion() == authority.TokenVersionV1 {
+		// the v1 endpoint identifies the API by resource and recognizes only the OpenID Connect scopes
+		resource, _ := ADFSScopes(scopes)
+		if resource != "" {
+			queryParams.Set("resource", resource)
+		}
+		oidc := make([]string, 0, len(scopes))
+		for _, scope := range scopes {
+			if !strings.Contains(scope, "://") {
+				oidc = append(oidc, scope)
+			}
+		}
+		scopes = oidc
+	} else if authParameters.AuthorityInfo.AuthorityType == authority.ADFS {
+		var resource string
+		resource, scopes = ADFSScopes(scopes)
+		if resource != "" {
+			queryParams.Set("resource", resource)
+		}
+	}
 	queryParams.Set("scope", strings.Join(scopes, " "))
 }
+
+// ADFSScopes translates scopes to the form ADFS expects. ADFS identifies the requested API by a "resource"
+// parameter and its scopes by name, so a scope such as "https://contoso.com/api/read" becomes resource
+// "https://contoso.com/api" and scope "read". ADFS doesn't recognize ".default" scopes; they contribute only
+// a resource. ADFSScopes returns the resource of the first scope that has one, and the translated scopes.
+func ADFSScopes(scopes []string) (resource string, translated []string) {
+	translated = make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		i := strings.Index(scope, "://")
+		j := strings.LastIndex(scope, "/")
+		if i < 0 || j <= i+len("://") {
+			// scope doesn't identify a resource
+			translated = append(translated, scope)
+			continue
+		}
+		if resource == "" {
+			resource = scope[:j]
+		}
+		if name := scope[j+1:]; name != "" && name != ".default" {
+			translated = append(translated, name)
+		}
+	}
+	return resource, translated
+}
@@ -514,9 +514,10 @@ func TestDeviceCodeResult(t *testing.T) {
 
 func TestFromDeviceCodeResult(t *testing.T) {
 	authParams := authority.AuthParams{
-		Endpoints:   testAuthorityEndpoints,
-		ClientID:    "clientID",
-		Redirecturi: "redirectURI",
+		AuthorityInfo: authority.Info{AuthorityType: authority.AAD},
+		Endpoints:     testAuthorityEndpoints,
+		ClientID:      "clientID",
+		Redirecturi:   "redirectURI",
 	}
 
 	tests := []struct {
@@ -524,6 +525,7 @@ func TestFromDeviceCodeResult(t *testing.T) {
 		err              bool
 		commErr          bool
 		createErr        bool
+		cc               *Credential
 		deviceCodeResult DeviceCodeResult
 		qv               url.Values
 	}{
@@ -567,6 +569,27 @@ func TestFromDeviceCodeResult(t *testing.T) {
 				clientInfo: []string{clientInfoVal},
 			},
 		},
+		{
+			desc: "Success: confidential client",
+			cc:   &Credential{Secret: "secret"},
+			deviceCodeResult: NewDeviceCodeResult(
+				"userCode",
+				"deviceCode",
+				"verificationURL",
+				time.Now(),
+				1,
+				"message",
+				"clientID",
+				nil,
+			),
+			qv: url.Values{
+				deviceCode:      []string{"deviceCode"},
+				grantType:       []string{grant.DeviceCode},
+				clientID:        []string{authParams.ClientID},
+				clientInfo:      []string{clientInfoVal},
+				"client_secret": []string{"secret"},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -580,7 +603,7 @@ func TestFromDeviceCodeResult(t *testing.T) {
 		// We don't care about the result, that is just a translation from the JSON handled
 		// automatically in the comm package.  We care only that the comm package got what
 		// it needed.
-		_, err := client.FromDeviceCodeResult(context.Background(), authParams, test.deviceCodeResult)
+		_, err := client.FromDeviceCodeResult(context.Background(), authParams, test.cc, test.deviceCodeResult)
 		switch {
 		case err == nil && test.err:
 			t.Errorf("TestFromDeviceCodeResult(%s): got err == nil , want err != nil", test.desc)
@@ -598,6 +621,39 @@ func TestFromDeviceCodeResult(t *testing.T) {
 	}
 }
 
+func TestDeviceCodeFlowRFC8628(t *testing.T) {
+	endpoints := authority.NewEndpoints("https://idp/authorize", "https://idp/token", "https://idp", "idp")
+	endpoints.DeviceAuthorizationEndpoint = "https://idp/device_authorization"
+	authParams := authority.AuthParams{
+		AuthorityInfo: authority.Info{AuthorityType: authority.ADFS},
+		Endpoints:     endpoints,
+		ClientID:      "clientID",
+	}
+	fake := &fakeURLCaller{}
+	client := Client{Comm: fake, testing: true}
+	if _, err := client.DeviceCodeResult(context.Background(), authParams); err != nil {
+		t.Fatal(err)
+	}
+	if fake.gotEndpoint != endpoints.DeviceAuthorizationEndpoint {
+		t.Errorf("expected a request to %s, got %s", endpoints.DeviceAuthorizationEndpoint, fake.gotEndpoint)
+	}
+	if _, err := client.FromDeviceCodeResult(context.Background(), authParams, nil, DeviceCodeResult{DeviceCode: "deviceCode"}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := fake.gotQV.Get(grantType); actual != grant.DeviceCodeURN {
+		t.Errorf("expected grant type %q, got %q", grant.DeviceCodeURN, actual)
+	}
+
+	resp := DeviceCodeResponse{UserCode: "code", VerificationURI: "https://idp/device", VerificationURIComplete: "https://idp/device?user_code=code"}
+	result := resp.Convert("clientID", nil)
+	if result.VerificationURL != resp.VerificationURI || result.VerificationURIComplete != resp.VerificationURIComplete {
+		t.Errorf("unexpected verification URIs in %+v", result)
+	}
+	if result.Interval != 5 {
+		t.Errorf("expected the default interval of 5 seconds, got %d", result.Interval)
+	}
+}
+
 func TestAccessTokenFromSamlGrant(t *testing.T) {
 	authParams := authority.AuthParams{
 		Username:  "username",
@@ -927,6 +983,24 @@ func TestComputeScopes(t *testing.T) {
 				scopesComputed: true,
 			},
 		},
+		{
+			desc: "ADFS grants translated scopes",
+			authParams: authority.AuthParams{
+				AuthorityInfo: authority.Info{AuthorityType: authority.ADFS},
+				Scopes:        []string{"https://contoso.com/api/read"},
+			},
+			input: TokenResponse{
+				GrantedScopes: Scopes{
+					Slice: []string{"read", "openid"},
+				},
+			},
+			want: TokenResponse{
+				GrantedScopes: Scopes{
+					Slice: []string{"https://contoso.com/api/read"},
+				},
+				scopesComputed: true,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -969,6 +1043,133 @@ func TestHomeAccountID(t *testing.T) {
 	}
 }
 
+func TestTokenResponseHomeAccountID(t *testing.T) {
+	aad := authority.AuthParams{AuthorityInfo: authority.Info{AuthorityType: authority.AAD}}
+	adfs := authority.AuthParams{AuthorityInfo: authority.Info{AuthorityType: authority.ADFS}}
+	for _, test := range []struct {
+		desc       string
+		authParams authority.AuthParams
+		tr         TokenResponse
+		want       string
+	}{
+		{
+			desc:       "AAD with client info",
+			authParams: aad,
+			tr:         TokenResponse{ClientInfo: ClientInfo{UID: "uid", UTID: "utid"}, IDToken: IDToken{Subject: "sub"}},
+			want:       "uid.utid",
+		},
+		{
+			desc:       "AAD without client info",
+			authParams: aad,
+			tr:         TokenResponse{IDToken: IDToken{Subject: "sub"}},
+		},
+		{
+			desc:       "ADFS with client info",
+			authParams: adfs,
+			tr:         TokenResponse{ClientInfo: ClientInfo{UID: "uid", UTID: "utid"}, IDToken: IDToken{Subject: "sub"}},
+			want:       "uid.utid",
+		},
+		{
+			desc:       "ADFS without client info",
+			authParams: adfs,
+			tr:         TokenResponse{IDToken: IDToken{Subject: "sub"}},
+			want:       "sub",
+		},
+	} {
+		if got := test.tr.HomeAccountID(test.authParams); got != test.want {
+			t.Errorf("TestTokenResponseHomeAccountID(%s): got %q, want %q", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestADFSScopes(t *testing.T) {
+	for _, test := range []struct {
+		desc, resource string
+		scopes, want   []string
+	}{
+		{
+			desc:   "no resource",
+			scopes: []string{"openid", "profile"},
+			want:   []string{"openid", "profile"},
+		},
+		{
+			desc:     ".default",
+			scopes:   []string{"https://contoso.com/api/.default", "openid"},
+			resource: "https://contoso.com/api",
+			want:     []string{"openid"},
+		},
+		{
+			desc:     "named scopes",
+			scopes:   []string{"https://contoso.com/api/read", "https://contoso.com/api/write"},
+			resource: "https://contoso.com/api",
+			want:     []string{"read", "write"},
+		},
+		{
+			desc:     "resource without path",
+			scopes:   []string{"https://contoso.com/read"},
+			resource: "https://contoso.com",
+			want:     []string{"read"},
+		},
+		{
+			desc:   "URI without a scope",
+			scopes: []string{"https://contoso.com"},
+			want:   []string{"https://contoso.com"},
+		},
+	} {
+		resource, got := ADFSScopes(test.scopes)
+		if resource != test.resource {
+			t.Errorf("TestADFSScopes(%s): got resource %q, want %q", test.desc, resource, test.resource)
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestADFSScopes(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestADFSResourceParam(t *testing.T) {
+	for _, authorityType := range []string{authority.AAD, authority.ADFS} {
+		authParams := authority.AuthParams{
+			AuthorityInfo: authority.Info{AuthorityType: authorityType},
+			Endpoints:     testAuthorityEndpoints,
+			ClientID:      "clientID",
+			Scopes:        []string{"https://contoso.com/api/read"},
+		}
+		fake := &fakeURLCaller{}
+		client := Client{Comm: fake, testing: true}
+		if _, err := client.FromRefreshToken(context.Background(), ATPublic, authParams, nil, "refreshToken"); err != nil {
+			t.Fatal(err)
+		}
+		wantResource, wantScope := "", "https://contoso.com/api/read openid offline_access profile"
+		if authorityType == authority.ADFS {
+			wantResource, wantScope = "https://contoso.com/api", "read openid offline_access profile"
+		}
+		if got := fake.gotQV.Get("resource"); got != wantResource {
+			t.Errorf("%s: got resource %q, want %q", authorityType, got, wantResource)
+		}
+		if got := fake.gotQV.Get("scope"); got != wantScope {
+			t.Errorf("%s: got scope %q, want %q", authorityType, got, wantScope)
+		}
+	}
+}
+
+func TestAppendDefaultScopes(t *testing.T) {
+	for _, test := range []struct {
+		authorizationType authority.AuthorizeType
+		want              []string
+	}{
+		{authority.ATUsernamePassword, []string{"read", "write", "openid", "offline_access", "profile"}},
+		{authority.ATClientCredentials, []string{"read", "write"}},
+	} {
+		authParams := authority.AuthParams{
+			AuthorizationType: test.authorizationType,
+			Scopes:            []string{"read", " write", "openid", "READ"},
+		}
+		if diff := pretty.Compare(test.want, AppendDefaultScopes(authParams)); diff != "" {
+			t.Errorf("TestAppendDefaultScopes(%v): -want/+got:\n%s", test.authorizationType, diff)
+		}
+	}
+}
+
 func TestFindDeclinedScopes(t *testing.T) {
 	requestedScopes := []string{"user.read", "openid"}
 	grantedScopes := []string{"user.read"}
@@ -978,3 +1179,18 @@ func TestFindDeclinedScopes(t *testing.T) {
 		t.Errorf("Actual declined scopes %v differ from expected declined scopes %v", actualDeclinedScopes, expectedDeclinedScopes)
 	}
 }
+
+func TestPrepURLValsIncompleteCredential(t *testing.T) {
+	authParams := authority.AuthParams{ClientID: "client-id"}
+	for _, cred := range []*Credential{
+		{},
+		{Chain: NewCredentialChain(&Credential{Secret: "secret"})},
+	} {
+		if _, err := prepURLVals(context.Background(), cred, authParams); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := cred.JWT(context.Background(), authParams); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+}
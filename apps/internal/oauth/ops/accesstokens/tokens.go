@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,7 +38,18 @@ type IDToken struct {
 	ExpirationTime    int64  `json:"exp,omitempty"`
 	IssuedAt          int64  `json:"iat,omitempty"`
 	NotBefore         int64  `json:"nbf,omitempty"`
-	RawToken          string
+	Nonce             string `json:"nonce,omitempty"`
+	// SessionID identifies the user's session with the authority. Back-channel logout tokens refer to it.
+	SessionID string `json:"sid,omitempty"`
+	// AuthenticationMethods are the methods by which the user authenticated, such as "pwd", "mfa", "fido"
+	// and "wia". The authority includes them only when the token request's scopes include "openid".
+	AuthenticationMethods []string `json:"amr,omitempty"`
+	// IdentityProvider is the issuer of the user's identity when it isn't the token's issuer, as for a guest
+	// from another tenant or a personal Microsoft account.
+	IdentityProvider string `json:"idp,omitempty"`
+	// AlternativeSecurityID identifies the user in their identity provider when that isn't the token's issuer.
+	AlternativeSecurityID string `json:"altsecid,omitempty"`
+	RawToken              string
 
 	AdditionalFields map[string]interface{}
 }
@@ -130,28 +142,44 @@ func (c *ClientInfo) UnmarshalJSON(b []byte) error {
 	// that doesn't have this method in order to use json.Unmarshal.
 	type clientInfo2 ClientInfo
 
+	// Malformed client info identifies no account, so the client doesn't cache one. Clients
+	// that parse token responses strictly reject it instead; see validateStrict.
 	raw, err := jwtDecoder(s)
 	if err != nil {
-		return fmt.Errorf("TokenResponse client_info field had JWT decode error: %w", err)
+		return nil
 	}
 
 	var c2 clientInfo2
 
-	err = json.Unmarshal(raw, &c2)
-	if err != nil {
-		return fmt.Errorf("was unable to unmarshal decoded JWT in TokenRespone to ClientInfo: %w", err)
+	if err = json.Unmarshal(raw, &c2); err != nil {
+		return nil
 	}
 
 	*c = ClientInfo(c2)
 	return nil
 }
 
+// validateClientInfo returns an error describing why client info is malformed
+func validateClientInfo(s string) error {
+	raw, err := jwtDecoder(s)
+	if err != nil {
+		return fmt.Errorf("isn't base64url encoded: %w", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("isn't a JSON object: %w", err)
+	}
+	for _, name := range []string{"uid", "utid"} {
+		if v, ok := fields[name].(string); !ok || v == "" {
+			return fmt.Errorf("has no %s", name)
+		}
+	}
+	return nil
+}
+
 // HomeAccountID creates the home account ID.
 func (c ClientInfo) HomeAccountID() string {
-	if c.UID == "" || c.UTID == "" {
-		return ""
-	}
-	return fmt.Sprintf("%s.%s", c.UID, c.UTID)
+	return shared.NewHomeAccountID(c.UID, c.UTID)
 }
 
 // Scopes represents scopes in a TokenResponse.
@@ -176,6 +204,7 @@ type TokenResponse struct {
 
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
 
 	FamilyID       string                    `json:"foci"`
 	IDToken        IDToken                   `json:"id_token"`
@@ -185,6 +214,12 @@ type TokenResponse struct {
 	GrantedScopes  Scopes                    `json:"scope"`
 	DeclinedScopes []string                  // This is derived
 
+	// RefreshTokenExpiresOn is when the refresh token expires. It's zero when the authority doesn't say.
+	RefreshTokenExpiresOn internalTime.DurationTime `json:"refresh_token_expires_in"`
+
+	// RawResponse is the decoded response, including fields this type doesn't model, except the refresh token.
+	RawResponse map[string]interface{} // This is derived
+
 	AdditionalFields map[string]interface{}
 
 	scopesComputed bool
@@ -195,10 +230,14 @@ type TokenResponse struct {
 // This behavior can be observed in client assertion flows, but can happen at any time, this check ensures we treat
 // those special responses properly Link to spec: https://tools.ietf.org/html/rfc6749#section-3.3
 func (tr *TokenResponse) ComputeScope(authParams authority.AuthParams) {
-	if len(tr.GrantedScopes.Slice) == 0 {
-		tr.GrantedScopes = Scopes{Slice: authParams.Scopes}
+	if authParams.AuthorityInfo.AuthorityType == authority.ADFS || authParams.TokenVersion() == authority.TokenVersionV1 {
+		// ADFS and the v1 endpoint grant the scopes of the resource ADFSScopes got from the requested
+		// scopes. Caching the requested scopes lets later requests for the same scopes find the token.
+		tr.GrantedScopes = Scopes{Slice: authParams.NormalizedScopes()}
+	} else if len(tr.GrantedScopes.Slice) == 0 {
+		tr.GrantedScopes = Scopes{Slice: authParams.NormalizedScopes()}
 	} else {
-		tr.DeclinedScopes = findDeclinedScopes(authParams.Scopes, tr.GrantedScopes.Slice)
+		tr.DeclinedScopes = findDeclinedScopes(authParams.NormalizedScopes(), tr.GrantedScopes.Slice)
 	}
 	tr.scopesComputed = true
 }
@@ -220,6 +259,68 @@ func (tr *TokenResponse) Validate() error {
 	return nil
 }
 
+// validateStrict returns an error describing each field of a token response body that the client would otherwise
+// coerce or ignore: durations and timestamps that aren't whole numbers of seconds and malformed client info. The
+// response must have an expires_in field.
+func validateStrict(body []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("token response isn't a JSON object: %w", err)
+	}
+	problems := []string{}
+	if _, ok := fields["expires_in"]; !ok {
+		problems = append(problems, "expires_in is missing")
+	}
+	for _, name := range []string{"expires_in", "ext_expires_in", "refresh_token_expires_in", "expires_on"} {
+		if v, ok := fields[name]; ok {
+			if err := strictSeconds(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s", name, err))
+			}
+		}
+	}
+	if v, ok := fields["client_info"]; ok {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			problems = append(problems, fmt.Sprintf("client_info isn't a string: %s", v))
+		} else if s != "" {
+			if err := validateClientInfo(s); err != nil {
+				problems = append(problems, fmt.Sprintf("client_info %s", err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("malformed token response: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// strictSeconds returns an error when v isn't a non-negative whole number of seconds. The number may be quoted.
+func strictSeconds(v json.RawMessage) error {
+	s := string(v)
+	if len(s) > 0 && s[0] == '"' {
+		if err := json.Unmarshal(v, &s); err != nil {
+			return fmt.Errorf("isn't a number: %s", v)
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("isn't a whole number of seconds: %s", v)
+	}
+	if i < 0 {
+		return fmt.Errorf("is negative: %s", v)
+	}
+	return nil
+}
+
+// HomeAccountID returns the home account ID of the response's account. ADFS doesn't return client info,
+// so the home account ID of an ADFS account is the subject of its ID token.
+func (tr *TokenResponse) HomeAccountID(authParams authority.AuthParams) string {
+	if id := tr.ClientInfo.HomeAccountID(); id != "" || authParams.AuthorityInfo.AuthorityType != authority.ADFS {
+		return id
+	}
+	return tr.IDToken.Subject
+}
+
 func (tr *TokenResponse) CacheKey(authParams authority.AuthParams) string {
 	if authParams.AuthorizationType == authority.ATOnBehalfOf {
 		return authParams.AssertionHash()
@@ -228,7 +329,7 @@ func (tr *TokenResponse) CacheKey(authParams authority.AuthParams) string {
 		return authParams.AppKey()
 	}
 	if authParams.IsConfidentialClient || authParams.AuthorizationType == authority.ATRefreshToken {
-		return tr.ClientInfo.HomeAccountID()
+		return tr.HomeAccountID(authParams)
 	}
 	return ""
 }
@@ -268,6 +369,8 @@ type RefreshToken struct {
 	Realm             string `json:"realm,omitempty"`
 	Target            string `json:"target,omitempty"`
 	UserAssertionHash string `json:"user_assertion_hash,omitempty"`
+	// ExpiresOn is when the refresh token expires. It's zero when the authority didn't say.
+	ExpiresOn internalTime.Unix `json:"expires_on,omitempty"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -319,11 +422,23 @@ type DeviceCodeResult struct {
 	ClientID string
 	// Scopes is the OpenID scopes used to request access a protected API.
 	Scopes []string
+	// VerificationURIComplete is VerificationURL including UserCode, so the user needn't type the code.
+	// Applications may show it as a QR code. It's empty when the authority doesn't provide one.
+	VerificationURIComplete string
 }
 
 // NewDeviceCodeResult creates a DeviceCodeResult instance.
 func NewDeviceCodeResult(userCode, deviceCode, verificationURL string, expiresOn time.Time, interval int, message, clientID string, scopes []string) DeviceCodeResult {
-	return DeviceCodeResult{userCode, deviceCode, verificationURL, expiresOn, interval, message, clientID, scopes}
+	return DeviceCodeResult{
+		UserCode:        userCode,
+		DeviceCode:      deviceCode,
+		VerificationURL: verificationURL,
+		ExpiresOn:       expiresOn,
+		Interval:        interval,
+		Message:         message,
+		ClientID:        clientID,
+		Scopes:          scopes,
+	}
 }
 
 func (dcr DeviceCodeResult) String() string {
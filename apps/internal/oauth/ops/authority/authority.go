@@ -15,14 +15,20 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	"github.com/google/uuid"
 )
 
 const (
 	authorizationEndpoint             = "https://%v/%v/oauth2/v2.0/authorize"
 	instanceDiscoveryEndpoint         = "https://%v/common/discovery/instance"
+	tokenEndpoint                     = "https://%v/%v/oauth2/v2.0/token"
+	adfsTokenEndpoint                 = "https://%v/adfs/oauth2/token"
+	tenantDiscoveryEndpoint           = "https://%s/%s/v2.0/.well-known/openid-configuration"
 	tenantDiscoveryEndpointWithRegion = "https://%s.%s/%s/v2.0/.well-known/openid-configuration"
 	regionName                        = "REGION_NAME"
 	defaultAPIVersion                 = "2021-10-01"
@@ -45,6 +51,55 @@ var aadTrustedHostList = map[string]bool{
 	"login.cloudgovapi.us":         true, // Microsoft Azure US Government
 }
 
+// knownMetadata is the instance discovery metadata of the public and sovereign clouds, keyed by alias.
+// Clients configured for offline instance discovery use it instead of requesting metadata.
+var knownMetadata = func() map[string]InstanceDiscoveryMetadata {
+	clouds := []InstanceDiscoveryMetadata{
+		{
+			PreferredNetwork: "login.microsoftonline.com",
+			PreferredCache:   "login.windows.net",
+			Aliases:          []string{"login.microsoftonline.com", "login.windows.net", "login.microsoft.com", "sts.windows.net"},
+		},
+		{
+			PreferredNetwork: "login.partner.microsoftonline.cn",
+			PreferredCache:   "login.partner.microsoftonline.cn",
+			Aliases:          []string{"login.partner.microsoftonline.cn", "login.chinacloudapi.cn"},
+		},
+		{
+			PreferredNetwork: "login.microsoftonline.de",
+			PreferredCache:   "login.microsoftonline.de",
+			Aliases:          []string{"login.microsoftonline.de"},
+		},
+		{
+			PreferredNetwork: "login.microsoftonline.us",
+			PreferredCache:   "login.microsoftonline.us",
+			Aliases:          []string{"login.microsoftonline.us", "login.usgovcloudapi.net"},
+		},
+		{
+			PreferredNetwork: "login-us.microsoftonline.com",
+			PreferredCache:   "login-us.microsoftonline.com",
+			Aliases:          []string{"login-us.microsoftonline.com"},
+		},
+	}
+	m := map[string]InstanceDiscoveryMetadata{}
+	for _, md := range clouds {
+		for _, alias := range md.Aliases {
+			m[alias] = md
+		}
+	}
+	return m
+}()
+
+// KnownAliases returns the aliases of host in the known metadata of the public and sovereign clouds, or nil
+// when host isn't an alias of any of them. Other MSAL libraries may have cached tokens under any alias.
+func KnownAliases(host string) []string {
+	md, ok := knownMetadata[strings.ToLower(host)]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), md.Aliases...)
+}
+
 // TrustedHost checks if an AAD host is trusted/valid.
 func TrustedHost(host string) bool {
 	if _, ok := aadTrustedHostList[host]; ok {
@@ -66,9 +121,17 @@ type OAuthResponseBase struct {
 type TenantDiscoveryResponse struct {
 	OAuthResponseBase
 
-	AuthorizationEndpoint string `json:"authorization_endpoint"`
-	TokenEndpoint         string `json:"token_endpoint"`
-	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	Issuer                string   `json:"issuer"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	ClaimsSupported       []string `json:"claims_supported"`
+	// DeviceAuthorizationEndpoint is the RFC 8628 endpoint, which ADFS and some older authorities don't advertise
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	// PushedAuthorizationRequestEndpoint is the RFC 9126 endpoint, which only some authorities advertise
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -126,6 +189,26 @@ const (
 	ADFS = "ADFS"
 )
 
+// These are the meta-tenants of Microsoft Entra authorities. Each represents a class of accounts rather
+// than a specific tenant.
+const (
+	// TenantCommon accepts work, school and personal Microsoft accounts.
+	TenantCommon = "common"
+	// TenantConsumers accepts only personal Microsoft accounts.
+	TenantConsumers = "consumers"
+	// TenantOrganizations accepts only work and school accounts.
+	TenantOrganizations = "organizations"
+)
+
+// IsMetaTenant returns true when tenant is one of the meta-tenants, rather than a specific tenant.
+func IsMetaTenant(tenant string) bool {
+	switch strings.ToLower(tenant) {
+	case TenantCommon, TenantConsumers, TenantOrganizations:
+		return true
+	}
+	return false
+}
+
 // AuthParams represents the parameters used for authorization for token acquisition.
 type AuthParams struct {
 	AuthorityInfo Info
@@ -145,6 +228,8 @@ type AuthParams struct {
 	AuthorizationType AuthorizeType
 	// State is a random value used to prevent cross-site request forgery attacks.
 	State string
+	// Nonce is a random value the authority includes in the ID token it issues, binding the token to the request
+	Nonce string
 	// CodeChallenge is derived from a code verifier and is sent in the auth request.
 	CodeChallenge string
 	// CodeChallengeMethod describes the method used to create the CodeChallenge.
@@ -157,10 +242,312 @@ type AuthParams struct {
 	SendX5C bool
 	// UserAssertion is the access token used to acquire token on behalf of user
 	UserAssertion string
+	// SubjectTokenType, when not empty, is the type of UserAssertion, which is then the subject token of a
+	// token exchange (RFC 8693) rather than an on-behalf-of assertion
+	SubjectTokenType string
 	// KnownAuthorityHosts don't require metadata discovery because they're known to the user
 	KnownAuthorityHosts []string
 	// LoginHint is a username with which to pre-populate account selection during interactive auth
 	LoginHint string
+	// WebAuthn signals that the user agent of interactive auth supports WebAuthn, so the authority may offer
+	// passwordless sign-in with passkeys, FIDO2 security keys and Windows Hello
+	WebAuthn bool
+	// PushedAuthorization signals that the client should push the parameters of an authorization request to
+	// the authority's pushed authorization request endpoint (RFC 9126) instead of putting them in the URL
+	PushedAuthorization bool
+	// Claims is a JSON object of additional claims to request, such as the claims challenge of a
+	// Continuous Access Evaluation (CAE) enabled resource
+	Claims string
+	// Clock returns the current time for token expiry decisions. When nil, the client uses the system clock.
+	Clock func() time.Time
+	// ClockSkew is how far the client's clock may differ from the authority's. The client considers tokens
+	// expired this much earlier and backdates the client assertions it creates by this much.
+	ClockSkew time.Duration
+	// AuthorityClock, when not nil, corrects the client's clock to match the authority's
+	AuthorityClock *AuthorityClock
+	// RateLimiter, when not nil, limits the rate of the client's token requests
+	RateLimiter *RateLimiter
+	// MaxStale is how long after an access token expires the client may return it when it can't get a new
+	// token because the authority is unavailable. The client doesn't return expired tokens when this is 0.
+	MaxStale time.Duration
+	// ExtendedLifetime allows the client to return an expired access token before its extended expiry, from the
+	// authority's ext_expires_in, when it can't get a new token because the authority is unavailable.
+	ExtendedLifetime bool
+	// StrictTokenResponses makes the client reject token responses having malformed durations, timestamps or
+	// client info, which it otherwise coerces or ignores.
+	StrictTokenResponses bool
+	// RequestInterceptor, when not nil, inspects token requests before the client sends them. The client
+	// doesn't send a request the interceptor returns an error for.
+	RequestInterceptor func(context.Context, *exported.TokenRequestInfo) error
+	// ResponseInterceptor, when not nil, observes the outcome of token requests
+	ResponseInterceptor func(context.Context, *exported.TokenResponseInfo)
+	// AllowedTenants, when not empty, are the only tenants to which the client may send token requests
+	AllowedTenants []string
+	// AllowedScopes, when not empty, are the only scopes for which the client may request tokens
+	AllowedScopes []string
+	// SSHKeyID and SSHReqCnf, when set, request an SSH certificate for the public key they identify instead of an
+	// access token. SSHReqCnf is the key as a JWK.
+	SSHKeyID  string
+	SSHReqCnf string
+	// WSTrustEndpoint, when not empty, is the WS-Trust 1.3 username/password endpoint of a federated user's
+	// identity provider. The client sends the user's credentials to it instead of the endpoint in the MEX document.
+	WSTrustEndpoint string
+	// TokenEndpointOverride, when not empty, replaces the token endpoint from tenant discovery, for example with
+	// a private link endpoint, and takes precedence over AuthorityInfo.Region. Cached tokens remain keyed by
+	// AuthorityInfo.Host.
+	TokenEndpointOverride string
+	// AuthorizationEndpointOverride, when not empty, replaces the authorization endpoint from tenant discovery,
+	// for example with the address of a reverse proxy
+	AuthorizationEndpointOverride string
+	// IDTokenPolicy determines whether the authority's response must, or mustn't, include an ID token
+	IDTokenPolicy IDTokenPolicy
+	// AuthnScheme, when not nil, determines the type of access token to request, such as a proof-of-possession
+	// token, and formats the access tokens the client returns. nil means Bearer tokens; see Scheme.
+	AuthnScheme AuthenticationScheme
+	// V1TokenEndpoint directs an AAD client to send token requests to the authority's v1 token endpoint, which
+	// identifies the requested API by a "resource" parameter. See TokenVersion.
+	V1TokenEndpoint bool
+}
+
+// ReservedScopes are the OpenID Connect scopes the client adds to the token requests of flows that authenticate a
+// user: openid for an ID token, offline_access for a refresh token and profile for client info.
+var ReservedScopes = []string{"openid", "offline_access", "profile"}
+
+// IsReservedScope returns true when scope is one of ReservedScopes, which the authority compares case-insensitively
+func IsReservedScope(scope string) bool {
+	for _, reserved := range ReservedScopes {
+		if strings.EqualFold(scope, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeScopes returns scopes without surrounding whitespace, empty scopes and duplicates, which it compares
+// case-insensitively as the authority does. It also removes reserved scopes, which the client adds to each request
+// as its flow requires, unless they're all scopes has. warnings describe the changes, in the order of scopes.
+func NormalizeScopes(scopes []string) (normalized, warnings []string) {
+	seen := make(map[string]bool, len(scopes))
+	var reserved []string
+	for _, scope := range scopes {
+		s := strings.TrimSpace(scope)
+		switch {
+		case s == "":
+			warnings = append(warnings, "removed an empty scope")
+		case seen[strings.ToLower(s)]:
+			warnings = append(warnings, fmt.Sprintf("removed duplicate scope %q", s))
+		case IsReservedScope(s):
+			seen[strings.ToLower(s)] = true
+			reserved = append(reserved, s)
+		default:
+			seen[strings.ToLower(s)] = true
+			normalized = append(normalized, s)
+		}
+	}
+	if len(normalized) == 0 {
+		return reserved, warnings
+	}
+	for _, s := range reserved {
+		warnings = append(warnings, fmt.Sprintf("removed reserved scope %q, which the client requests as the flow requires", s))
+	}
+	return normalized, warnings
+}
+
+// DeviceCodeEndpoint returns the endpoint beginning the device authorization flow (RFC 8628). That's the endpoint
+// the authority advertises, unless the client overrides the token endpoint or requests v1 tokens. The endpoint is
+// then a sibling of the token endpoint, as Microsoft Entra ID's is, which is also the case when the authority
+// doesn't advertise one.
+func (p AuthParams) DeviceCodeEndpoint() string {
+	if p.Endpoints.DeviceAuthorizationEndpoint != "" && p.TokenEndpointOverride == "" && p.TokenVersion() != TokenVersionV1 {
+		return p.Endpoints.DeviceAuthorizationEndpoint
+	}
+	return strings.Replace(p.Endpoints.TokenEndpoint, "token", "devicecode", -1)
+}
+
+// NormalizedScopes returns Scopes as NormalizeScopes normalizes them. The client requests, caches and looks up
+// tokens for these scopes.
+func (p AuthParams) NormalizedScopes() []string {
+	normalized, _ := NormalizeScopes(p.Scopes)
+	return normalized
+}
+
+// ScopeWarnings describes the differences between Scopes and NormalizedScopes
+func (p AuthParams) ScopeWarnings() []string {
+	_, warnings := NormalizeScopes(p.Scopes)
+	return warnings
+}
+
+// TokenVersionV1 is the TokenVersion of requests to an AAD authority's v1 token endpoint
+const TokenVersionV1 = "1.0"
+
+// TokenVersion returns TokenVersionV1 when the client sends token requests to the v1 token endpoint and
+// otherwise an empty string, meaning the v2.0 endpoint. The client caches tokens of different versions apart,
+// because the v1 endpoint issues tokens of a different audience format for the same scopes.
+func (p AuthParams) TokenVersion() string {
+	if p.V1TokenEndpoint && p.AuthorityInfo.AuthorityType == AAD {
+		return TokenVersionV1
+	}
+	return ""
+}
+
+// V1TokenEndpoint returns the v1 token endpoint corresponding to endpoint, a v2.0 token endpoint from tenant
+// discovery. It returns endpoint unchanged when that isn't a v2.0 token endpoint.
+func V1TokenEndpoint(endpoint string) string {
+	if !strings.HasSuffix(endpoint, "/oauth2/v2.0/token") {
+		return endpoint
+	}
+	return strings.TrimSuffix(endpoint, "v2.0/token") + "token"
+}
+
+// AccessTokenTypeBearer is the type of Bearer access tokens
+const AccessTokenTypeBearer = "Bearer"
+
+// AuthenticationScheme determines the type of access token a client requests and how it formats the token
+// for an Authorization header. The client caches tokens of different types apart, so that a request for one
+// type never returns a cached token of another.
+type AuthenticationScheme interface {
+	// TokenRequestParams are parameters the client adds to token requests, for example to request a
+	// proof-of-possession token bound to a key.
+	TokenRequestParams() map[string]string
+	// KeyID identifies the key to which the scheme's tokens are bound, if any. The client caches tokens
+	// bound to different keys apart.
+	KeyID() string
+	// FormatAccessToken returns the value of an Authorization header for accessToken.
+	FormatAccessToken(accessToken string) (string, error)
+	// AccessTokenType is the token_type the authority returns for the scheme's tokens, for example "pop".
+	AccessTokenType() string
+}
+
+// BearerAuthenticationScheme is the AuthenticationScheme of Bearer tokens, which clients use by default.
+type BearerAuthenticationScheme struct{}
+
+// TokenRequestParams implements AuthenticationScheme. Bearer tokens require no parameters.
+func (BearerAuthenticationScheme) TokenRequestParams() map[string]string {
+	return nil
+}
+
+// KeyID implements AuthenticationScheme. Bearer tokens aren't bound to a key.
+func (BearerAuthenticationScheme) KeyID() string {
+	return ""
+}
+
+// FormatAccessToken implements AuthenticationScheme. It returns accessToken unchanged.
+func (BearerAuthenticationScheme) FormatAccessToken(accessToken string) (string, error) {
+	return accessToken, nil
+}
+
+// AccessTokenType implements AuthenticationScheme.
+func (BearerAuthenticationScheme) AccessTokenType() string {
+	return AccessTokenTypeBearer
+}
+
+// Scheme returns AuthnScheme or, when that's nil, BearerAuthenticationScheme.
+func (p AuthParams) Scheme() AuthenticationScheme {
+	if p.AuthnScheme == nil {
+		return BearerAuthenticationScheme{}
+	}
+	return p.AuthnScheme
+}
+
+// IDTokenPolicy determines whether a token request requires the authority's response to include an ID token.
+type IDTokenPolicy int
+
+const (
+	// IDTokenOptional accepts responses with or without an ID token
+	IDTokenOptional IDTokenPolicy = iota
+	// IDTokenRequired rejects responses without an ID token
+	IDTokenRequired
+	// IDTokenForbidden rejects responses having an ID token
+	IDTokenForbidden
+)
+
+// CheckIDToken returns an error when IDTokenPolicy doesn't accept a response that has, or doesn't have, an ID token.
+func (p AuthParams) CheckIDToken(hasIDToken bool) error {
+	switch {
+	case p.IDTokenPolicy == IDTokenRequired && !hasIDToken:
+		return errors.New("the authority's response has no ID token, which the request requires")
+	case p.IDTokenPolicy == IDTokenForbidden && hasIDToken:
+		return errors.New("the authority's response has an ID token, which the request forbids")
+	}
+	return nil
+}
+
+// Now returns the current time according to the client's clock, corrected by AuthorityClock. Use it for times
+// the client sends to the authority, and ClientNow for times the client stores or compares to stored times.
+func (p AuthParams) Now() time.Time {
+	now := p.ClientNow()
+	if p.AuthorityClock != nil {
+		now = now.Add(p.AuthorityClock.Offset())
+	}
+	return now
+}
+
+// CheckPolicy returns an errors.PolicyError when AllowedTenants or AllowedScopes doesn't allow the tenant or scopes
+// of a request having these AuthParams. Tenants and scopes are compared case insensitively.
+func (p AuthParams) CheckPolicy() error {
+	if len(p.AllowedTenants) > 0 && !containsFold(p.AllowedTenants, p.AuthorityInfo.Tenant) {
+		return msalerrors.PolicyError{Tenant: p.AuthorityInfo.Tenant}
+	}
+	if len(p.AllowedScopes) > 0 {
+		for _, scope := range p.Scopes {
+			if !containsFold(p.AllowedScopes, scope) {
+				return msalerrors.PolicyError{Scope: scope}
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ObserveAuthorityTime updates the AuthorityClock, if any, with a time reported by the authority.
+func (p AuthParams) ObserveAuthorityTime(t time.Time) {
+	if p.AuthorityClock != nil {
+		p.AuthorityClock.observe(t, p.ClientNow())
+	}
+}
+
+// ClientNow returns the current time according to the client's clock, without correction by AuthorityClock.
+// The cache stores times in these terms because other applications sharing it don't know the correction.
+func (p AuthParams) ClientNow() time.Time {
+	if p.Clock != nil {
+		return p.Clock()
+	}
+	return time.Now()
+}
+
+// minAuthorityClockOffset is the smallest offset AuthorityClock applies. The Date header has a
+// resolution of one second and network latency delays it, so smaller offsets are noise.
+const minAuthorityClockOffset = time.Minute
+
+// AuthorityClock tracks the offset of an authority's clock from the client's. It's safe for concurrent use.
+type AuthorityClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+// Offset returns the amount to add to the client's clock to match the authority's.
+func (a *AuthorityClock) Offset() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.offset
+}
+
+func (a *AuthorityClock) observe(authorityTime, clientTime time.Time) {
+	offset := authorityTime.Sub(clientTime)
+	if offset > -minAuthorityClockOffset && offset < minAuthorityClockOffset {
+		offset = 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.offset = offset
 }
 
 // NewAuthParams creates an authorization parameters object.
@@ -173,8 +560,8 @@ func NewAuthParams(clientID string, authorityInfo Info) AuthParams {
 }
 
 // WithTenant returns a copy of the AuthParams having the specified tenant ID. If the given
-// ID is empty, the copy is identical to the original. This function returns an error in
-// several cases:
+// ID is empty, the copy is identical to the original. This function returns an
+// [msalerrors.InvalidTenantError] in several cases:
 //   - ID isn't specific (for example, it's "common")
 //   - ID is non-empty and the authority doesn't support tenants (for example, it's an ADFS authority)
 //   - the client is configured to authenticate only Microsoft accounts via the "consumers" endpoint
@@ -184,24 +571,43 @@ func (p AuthParams) WithTenant(ID string) (AuthParams, error) {
 	case "", p.AuthorityInfo.Tenant:
 		// keep the default tenant because the caller didn't override it
 		return p, nil
-	case "common", "consumers", "organizations":
-		if p.AuthorityInfo.AuthorityType == AAD {
-			return p, fmt.Errorf(`tenant ID must be a specific tenant, not "%s"`, ID)
-		}
-		// else we'll return a better error below
+	}
+	invalid := func(reason string) (AuthParams, error) {
+		return p, msalerrors.InvalidTenantError{Tenant: ID, AuthorityTenant: p.AuthorityInfo.Tenant, Reason: reason}
+	}
+	if IsMetaTenant(ID) && p.AuthorityInfo.AuthorityType == AAD {
+		return invalid("tenant ID must be a specific tenant, not a meta-tenant")
 	}
 	if p.AuthorityInfo.AuthorityType != AAD {
-		return p, errors.New("the authority doesn't support tenants")
+		return invalid(fmt.Sprintf("%s authorities don't support tenants", p.AuthorityInfo.AuthorityType))
 	}
-	if p.AuthorityInfo.Tenant == "consumers" {
-		return p, errors.New(`client is configured to authenticate only personal Microsoft accounts, via the "consumers" endpoint`)
+	if strings.EqualFold(p.AuthorityInfo.Tenant, TenantConsumers) {
+		return invalid(`client is configured to authenticate only personal Microsoft accounts, via the "consumers" endpoint`)
 	}
 	authority := "https://" + path.Join(p.AuthorityInfo.Host, ID)
 	info, err := NewInfoFromAuthorityURI(authority, p.AuthorityInfo.ValidateAuthority)
-	if err == nil {
-		p.AuthorityInfo = info
+	if err != nil {
+		return invalid(err.Error())
+	}
+	info.OfflineInstanceDiscovery = p.AuthorityInfo.OfflineInstanceDiscovery
+	info.InstanceDiscoveryEndpoint = p.AuthorityInfo.InstanceDiscoveryEndpoint
+	info.StaticEndpoints = p.AuthorityInfo.StaticEndpoints
+	p.AuthorityInfo = info
+	return p, nil
+}
+
+// EffectiveTenant returns the tenant a token request to authorityURI would use when overridden by tenantID, or
+// an error when the combination is invalid. An empty tenantID means no override.
+func EffectiveTenant(authorityURI, tenantID string) (string, error) {
+	info, err := NewInfoFromAuthorityURI(authorityURI, false)
+	if err != nil {
+		return "", err
+	}
+	p, err := NewAuthParams("", info).WithTenant(tenantID)
+	if err != nil {
+		return "", err
 	}
-	return p, err
+	return p.AuthorityInfo.Tenant, nil
 }
 
 // Info consists of information about the authority.
@@ -213,6 +619,69 @@ type Info struct {
 	ValidateAuthority     bool
 	Tenant                string
 	Region                string
+	// OfflineInstanceDiscovery directs AADInstanceDiscovery to return known metadata instead of sending a request
+	OfflineInstanceDiscovery bool
+	// InstanceDiscoveryEndpoint, when not empty, is the URL to which AADInstanceDiscovery sends requests
+	InstanceDiscoveryEndpoint string
+	// StaticEndpoints, when not nil, are the authority's endpoints. The client uses them instead of discovering
+	// any metadata, and AADInstanceDiscovery returns known metadata as for offline instance discovery.
+	StaticEndpoints *Endpoints
+}
+
+// InstanceDiscoveryURL returns the URL to which AADInstanceDiscovery sends requests for the authority. It's
+// empty when AADInstanceDiscovery sends none, because the authority is regional, discovery is offline or the
+// authority has static endpoints.
+func (i Info) InstanceDiscoveryURL() string {
+	if i.Region != "" || i.OfflineInstanceDiscovery || i.StaticEndpoints != nil {
+		return ""
+	}
+	if i.InstanceDiscoveryEndpoint != "" {
+		return i.InstanceDiscoveryEndpoint
+	}
+	discoveryHost := defaultHost
+	if TrustedHost(i.Host) {
+		discoveryHost = i.Host
+	}
+	return fmt.Sprintf(instanceDiscoveryEndpoint, discoveryHost)
+}
+
+// RegionDiscoveryURL returns the URL of the IMDS endpoint from which the client detects its Azure region.
+// It's empty unless the client auto-detects its region and the REGION_NAME environment variable isn't set.
+func (i Info) RegionDiscoveryURL() string {
+	if i.Region != autoDetectRegion || os.Getenv(regionName) != "" {
+		return ""
+	}
+	return imdsEndpoint
+}
+
+// TokenEndpoint returns the authority's conventional token endpoint, without tenant discovery. It's the
+// endpoint tenant discovery returns for the public and sovereign clouds and ADFS.
+func (i Info) TokenEndpoint() string {
+	if i.AuthorityType == ADFS {
+		return fmt.Sprintf(adfsTokenEndpoint, i.Host)
+	}
+	return fmt.Sprintf(tokenEndpoint, i.Host, i.Tenant)
+}
+
+// OfflineEndpoints returns the endpoints of an AAD authority following the conventions of the public and
+// sovereign clouds, for clients configured for offline instance discovery. The endpoints' host is the preferred
+// network host from the known metadata when that includes the authority's host. UserInfoEndpoint and
+// ClaimsSupported are empty because they don't follow a convention.
+func (i Info) OfflineEndpoints() Endpoints {
+	host := i.Host
+	if md, ok := knownMetadata[host]; ok {
+		host = md.PreferredNetwork
+	}
+	base := fmt.Sprintf("https://%s/%s/", host, i.Tenant)
+	endpoints := NewEndpoints(
+		fmt.Sprintf(authorizationEndpoint, host, i.Tenant),
+		fmt.Sprintf(tokenEndpoint, host, i.Tenant),
+		base+"v2.0",
+		i.Host,
+	)
+	endpoints.EndSessionEndpoint = base + "oauth2/v2.0/logout"
+	endpoints.JWKSURI = base + "discovery/v2.0/keys"
+	return endpoints
 }
 
 func firstPathSegment(u *url.URL) (string, error) {
@@ -261,13 +730,39 @@ func NewInfoFromAuthorityURI(authorityURI string, validateAuthority bool) (Info,
 type Endpoints struct {
 	AuthorizationEndpoint string
 	TokenEndpoint         string
-	selfSignedJwtAudience string
-	authorityHost         string
+	// EndSessionEndpoint is the OIDC logout endpoint. It's empty when the authority doesn't advertise one.
+	EndSessionEndpoint string
+	// JWKSURI, UserInfoEndpoint and ClaimsSupported are the remaining OIDC metadata, which
+	// clients expose to applications. Any of them may be empty.
+	JWKSURI          string
+	UserInfoEndpoint string
+	ClaimsSupported  []string
+	// DeviceAuthorizationEndpoint begins the device authorization flow (RFC 8628). It's empty when the authority
+	// doesn't advertise one.
+	DeviceAuthorizationEndpoint string
+	// PushedAuthorizationRequestEndpoint accepts the parameters of an authorization request (RFC 9126).
+	// It's empty when the authority doesn't advertise one.
+	PushedAuthorizationRequestEndpoint string
+	// OpenIDConfigurationEndpoint is the URL of the openid-configuration document the endpoints came from.
+	// It's empty when the client resolved the endpoints without a request, as in offline instance discovery.
+	OpenIDConfigurationEndpoint string
+	selfSignedJwtAudience       string
+	authorityHost               string
+}
+
+// Issuer returns the issuer from the tenant discovery response.
+func (e Endpoints) Issuer() string {
+	return e.selfSignedJwtAudience
 }
 
 // NewEndpoints creates an Endpoints object.
 func NewEndpoints(authorizationEndpoint string, tokenEndpoint string, selfSignedJwtAudience string, authorityHost string) Endpoints {
-	return Endpoints{authorizationEndpoint, tokenEndpoint, selfSignedJwtAudience, authorityHost}
+	return Endpoints{
+		AuthorizationEndpoint: authorizationEndpoint,
+		TokenEndpoint:         tokenEndpoint,
+		selfSignedJwtAudience: selfSignedJwtAudience,
+		authorityHost:         authorityHost,
+	}
 }
 
 // UserRealmAccountType refers to the type of user realm.
@@ -317,6 +812,19 @@ func (u UserRealm) validate() error {
 	return nil
 }
 
+// UserInfo contains the claims returned by an OpenID Connect userinfo endpoint.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+
+	AdditionalFields map[string]interface{}
+}
+
 // Client represents the REST calls to authority backends.
 type Client struct {
 	// Comm provides the HTTP transport client.
@@ -345,6 +853,23 @@ func (c Client) UserRealm(ctx context.Context, authParams AuthParams) (UserRealm
 	return resp, resp.validate()
 }
 
+// UserInfo gets the claims about the user authenticated by accessToken from the userinfo endpoint.
+func (c Client) UserInfo(ctx context.Context, userInfoEndpoint, accessToken string) (UserInfo, error) {
+	resp := UserInfo{}
+	err := c.Comm.JSONCall(
+		ctx,
+		userInfoEndpoint,
+		http.Header{"Authorization": []string{"Bearer " + accessToken}},
+		nil,
+		nil,
+		&resp,
+	)
+	if err == nil && resp.Subject == "" {
+		err = errors.New("userinfo response is missing the sub claim")
+	}
+	return resp, err
+}
+
 func (c Client) GetTenantDiscoveryResponse(ctx context.Context, openIDConfigurationEndpoint string) (TenantDiscoveryResponse, error) {
 	resp := TenantDiscoveryResponse{}
 	err := c.Comm.JSONCall(
@@ -381,22 +906,35 @@ func (c Client) AADInstanceDiscovery(ctx context.Context, authorityInfo Info) (I
 			Aliases:          []string{fmt.Sprintf("%v.%v", region, authorityInfo.Host), authorityInfo.Host},
 		}
 		resp.Metadata = []InstanceDiscoveryMetadata{metadata}
+	} else if authorityInfo.OfflineInstanceDiscovery || authorityInfo.StaticEndpoints != nil {
+		resp = offlineInstanceDiscovery(authorityInfo)
 	} else {
 		qv := url.Values{}
 		qv.Set("api-version", "1.1")
 		qv.Set("authorization_endpoint", fmt.Sprintf(authorizationEndpoint, authorityInfo.Host, authorityInfo.Tenant))
 
-		discoveryHost := defaultHost
-		if TrustedHost(authorityInfo.Host) {
-			discoveryHost = authorityInfo.Host
-		}
-
-		endpoint := fmt.Sprintf(instanceDiscoveryEndpoint, discoveryHost)
-		err = c.Comm.JSONCall(ctx, endpoint, http.Header{}, qv, nil, &resp)
+		err = c.Comm.JSONCall(ctx, authorityInfo.InstanceDiscoveryURL(), http.Header{}, qv, nil, &resp)
 	}
 	return resp, err
 }
 
+// offlineInstanceDiscovery returns the known metadata of the authority's host. For unknown hosts, it returns
+// metadata having no aliases other than the host, as for a host the instance discovery endpoint doesn't know.
+func offlineInstanceDiscovery(authorityInfo Info) InstanceDiscoveryResponse {
+	md, ok := knownMetadata[authorityInfo.Host]
+	if !ok {
+		md = InstanceDiscoveryMetadata{
+			PreferredNetwork: authorityInfo.Host,
+			PreferredCache:   authorityInfo.Host,
+			Aliases:          []string{authorityInfo.Host},
+		}
+	}
+	return InstanceDiscoveryResponse{
+		TenantDiscoveryEndpoint: fmt.Sprintf(tenantDiscoveryEndpoint, authorityInfo.Host, authorityInfo.Tenant),
+		Metadata:                []InstanceDiscoveryMetadata{md},
+	}
+}
+
 func detectRegion(ctx context.Context) string {
 	region := os.Getenv(regionName)
 	if region != "" {
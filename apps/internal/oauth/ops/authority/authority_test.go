@@ -5,15 +5,23 @@ package authority
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/kylelemons/godebug/pretty"
 )
 
@@ -298,6 +306,40 @@ func TestAADInstanceDiscoveryWithRegion(t *testing.T) {
 	}
 }
 
+func TestAADInstanceDiscoveryOffline(t *testing.T) {
+	// the JSON caller returns an error, so any request fails the test
+	client := Client{&fakeJSONCaller{err: true}}
+	for _, test := range []struct {
+		host, expectedCache string
+		expectedAliases     []string
+	}{
+		{"login.microsoftonline.com", "login.windows.net", []string{"login.microsoftonline.com", "login.windows.net", "login.microsoft.com", "sts.windows.net"}},
+		{"sts.windows.net", "login.windows.net", []string{"login.microsoftonline.com", "login.windows.net", "login.microsoft.com", "sts.windows.net"}},
+		{"login.chinacloudapi.cn", "login.partner.microsoftonline.cn", []string{"login.partner.microsoftonline.cn", "login.chinacloudapi.cn"}},
+		{"unknown.host", "unknown.host", []string{"unknown.host"}},
+	} {
+		t.Run(test.host, func(t *testing.T) {
+			info := Info{Host: test.host, Tenant: "tenant", OfflineInstanceDiscovery: true}
+			resp, err := client.AADInstanceDiscovery(context.Background(), info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("https://%s/tenant/v2.0/.well-known/openid-configuration", test.host); resp.TenantDiscoveryEndpoint != expected {
+				t.Errorf("expected tenant discovery endpoint %q, got %q", expected, resp.TenantDiscoveryEndpoint)
+			}
+			if len(resp.Metadata) != 1 {
+				t.Fatalf("expected 1 metadata entry, got %d", len(resp.Metadata))
+			}
+			if actual := resp.Metadata[0].PreferredCache; actual != test.expectedCache {
+				t.Errorf("expected preferred cache %q, got %q", test.expectedCache, actual)
+			}
+			if diff := pretty.Compare(test.expectedAliases, resp.Metadata[0].Aliases); diff != "" {
+				t.Errorf("unexpected aliases: -want/+got\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestCreateAuthorityInfoFromAuthorityUri(t *testing.T) {
 	const authorityURI = "https://login.microsoftonline.com/common/"
 
@@ -343,8 +385,12 @@ func TestAuthParamsWithTenant(t *testing.T) {
 			params := NewAuthParams("client-id", info)
 			p, err := params.WithTenant(test.tenant)
 			if test.expectError {
-				if err == nil {
-					t.Fatal("expected an error")
+				var tenantErr msalerrors.InvalidTenantError
+				if !errors.As(err, &tenantErr) {
+					t.Fatalf("expected an InvalidTenantError, got %v", err)
+				}
+				if tenantErr.Tenant != test.tenant || tenantErr.AuthorityTenant != info.Tenant {
+					t.Fatalf("unexpected error %v", tenantErr)
 				}
 				return
 			}
@@ -358,3 +404,180 @@ func TestAuthParamsWithTenant(t *testing.T) {
 		})
 	}
 }
+
+func TestEffectiveTenant(t *testing.T) {
+	uuid := "00000000-0000-0000-0000-000000000000"
+	host := "https://localhost/"
+	for _, test := range []struct {
+		authority, tenant, expected string
+		expectError                 bool
+	}{
+		{authority: host + TenantCommon, expected: TenantCommon},
+		{authority: host + TenantCommon, tenant: uuid, expected: uuid},
+		{authority: host + TenantOrganizations, tenant: "contoso.onmicrosoft.com", expected: "contoso.onmicrosoft.com"},
+		{authority: host + uuid, tenant: uuid, expected: uuid},
+		{authority: host + uuid, tenant: TenantConsumers, expectError: true},
+		{authority: host + TenantConsumers, tenant: uuid, expectError: true},
+		{authority: host + "adfs", tenant: uuid, expectError: true},
+		{authority: "not a URL", expectError: true},
+	} {
+		t.Run(test.authority+"+"+test.tenant, func(t *testing.T) {
+			actual, err := EffectiveTenant(test.authority, test.tenant)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIsMetaTenant(t *testing.T) {
+	for tenant, expected := range map[string]bool{
+		TenantCommon:                           true,
+		"Consumers":                            true,
+		TenantOrganizations:                    true,
+		"00000000-0000-0000-0000-000000000000": false,
+		"contoso.onmicrosoft.com":              false,
+	} {
+		if actual := IsMetaTenant(tenant); actual != expected {
+			t.Errorf("IsMetaTenant(%q) returned %t", tenant, actual)
+		}
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	for _, test := range []struct {
+		desc             string
+		scopes, expected []string
+		expectedWarnings int
+	}{
+		{desc: "unchanged", scopes: []string{"a", "b"}, expected: []string{"a", "b"}},
+		{desc: "whitespace", scopes: []string{" a ", "", "b"}, expected: []string{"a", "b"}, expectedWarnings: 1},
+		{desc: "duplicates", scopes: []string{"a", "A", "b", "a"}, expected: []string{"a", "b"}, expectedWarnings: 2},
+		{desc: "reserved", scopes: []string{"openid", "a", "Profile"}, expected: []string{"a"}, expectedWarnings: 2},
+		{desc: "only reserved", scopes: []string{"openid", "offline_access", "openid"}, expected: []string{"openid", "offline_access"}, expectedWarnings: 1},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			actual, warnings := NormalizeScopes(test.scopes)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+			if len(warnings) != test.expectedWarnings {
+				t.Fatalf("expected %d warnings, got %v", test.expectedWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestDPoPProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDPoP(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.ObserveNonce("https://localhost/other/path", "nonce")
+	proof, err := d.Proof(http.MethodPost, "https://localhost/token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %q", proof)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+		t.Fatal("invalid signature")
+	}
+	var header struct {
+		Alg, Typ string
+		JWK      map[string]string
+	}
+	var claims map[string]interface{}
+	for i, v := range []interface{}{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header.Alg != "ES256" || header.Typ != "dpop+jwt" || header.JWK["kty"] != "EC" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if claims["htm"] != http.MethodPost || claims["htu"] != "https://localhost/token" || claims["nonce"] != "nonce" || claims["jti"] == nil {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	if _, err := NewDPoP(nil); err == nil {
+		t.Fatal("expected an error for a nil signer")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := NewRateLimiter(10, 2, 150*time.Millisecond)
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+	// the burst allows two requests immediately
+	for i := 0; i < 2; i++ {
+		if err := r.Wait(ctx, "host"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the next request waits 100ms for a token
+	start := time.Now()
+	if err := r.Wait(ctx, "host"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("expected a delay of about 100ms, got %v", d)
+	}
+	// the next would wait 200ms, longer than the max delay
+	err := r.Wait(ctx, "host")
+	var rle msalerrors.RateLimitError
+	if !errors.As(err, &rle) || rle.Host != "host" || rle.RetryAfter != 200*time.Millisecond {
+		t.Fatalf("expected a RateLimitError, got %v", err)
+	}
+	// each host has its own bucket
+	if err = r.Wait(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	// a canceled request returns its reservation
+	now = now.Add(100 * time.Millisecond)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = r.Wait(canceled, "host"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if err := r.Wait(ctx, "host"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := RateLimitStats{Allowed: 5, Delayed: 2, Dropped: 1, Delay: 200 * time.Millisecond}
+	if s := r.Stats(); s != expected {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+
+	var nilLimiter *RateLimiter
+	if err = nilLimiter.Wait(ctx, "host"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/keys"
+	"github.com/google/uuid"
+)
+
+// AccessTokenTypeDPoP is the type of DPoP-bound access tokens
+const AccessTokenTypeDPoP = "DPoP"
+
+// DPoPNonceHeader is the header in which an authority or resource server requires a nonce in DPoP proofs
+const DPoPNonceHeader = "DPoP-Nonce"
+
+// DPoP is the AuthenticationScheme of access tokens bound to a key by Demonstrating Proof of Possession (RFC 9449).
+// The client adds a proof signed by the key to each token request, and an application adds one to each request
+// it sends with a token, which it gets from Proof. DPoP is safe for concurrent use.
+type DPoP struct {
+	signer crypto.Signer
+	alg    string
+	jwk    map[string]string
+	kid    string
+	now    func() time.Time
+
+	// mu protects nonces, the latest nonce each origin required, keyed by origin
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+// NewDPoP returns a DPoP scheme whose proofs signer signs. signer's key must be an ECDSA P-256 key or an RSA key.
+// signer may use a key in a keys.Store; see keys.Signer.
+func NewDPoP(signer crypto.Signer) (*DPoP, error) {
+	if signer == nil {
+		return nil, errors.New("DPoP requires a signer")
+	}
+	jwk, err := keys.JWK(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("DPoP: %w", err)
+	}
+	kid, err := keys.Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	d := &DPoP{signer: signer, jwk: jwk, kid: kid, nonces: map[string]string{}, now: time.Now}
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		d.alg = "ES256"
+	case *rsa.PublicKey:
+		d.alg = "RS256"
+	}
+	return d, nil
+}
+
+// TokenRequestParams implements AuthenticationScheme. DPoP proofs are headers rather than parameters.
+func (d *DPoP) TokenRequestParams() map[string]string {
+	return nil
+}
+
+// KeyID implements AuthenticationScheme. It's the JWK thumbprint of the scheme's key.
+func (d *DPoP) KeyID() string {
+	return d.kid
+}
+
+// FormatAccessToken implements AuthenticationScheme. It returns accessToken unchanged; a request sends it in an
+// Authorization header having the DPoP scheme, along with a proof from Proof.
+func (d *DPoP) FormatAccessToken(accessToken string) (string, error) {
+	return accessToken, nil
+}
+
+// AccessTokenType implements AuthenticationScheme.
+func (d *DPoP) AccessTokenType() string {
+	return AccessTokenTypeDPoP
+}
+
+// Proof returns the value of a DPoP header for an HTTP request with method to uri. accessToken is the token the
+// request sends, and is empty for a token request. The proof includes the latest nonce the uri's origin required.
+func (d *DPoP) Proof(method, uri, accessToken string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	// the htu claim excludes the query and fragment
+	htu := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	claims := map[string]interface{}{
+		"htm": method,
+		"htu": htu.String(),
+		"iat": d.now().Unix(),
+		"jti": uuid.New().String(),
+	}
+	if nonce := d.nonce(u); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if accessToken != "" {
+		h := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(h[:])
+	}
+	header := map[string]interface{}{"alg": d.alg, "jwk": d.jwk, "typ": "dpop+jwt"}
+	return d.sign(header, claims)
+}
+
+// ObserveNonce records nonce, the value of a DPoP-Nonce header in a response from uri. Later proofs for the
+// uri's origin include it.
+func (d *DPoP) ObserveNonce(uri, nonce string) {
+	u, err := url.Parse(uri)
+	if err != nil || nonce == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nonces[strings.ToLower(u.Scheme+"://"+u.Host)] = nonce
+}
+
+// TokenRequestHeaders returns the headers of a token request to tokenEndpoint
+func (d *DPoP) TokenRequestHeaders(tokenEndpoint string) (http.Header, error) {
+	proof, err := d.Proof(http.MethodPost, tokenEndpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{}
+	h.Set("DPoP", proof)
+	return h, nil
+}
+
+func (d *DPoP) nonce(u *url.URL) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.nonces[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// sign returns the compact serialization of a JWT having header and claims
+func (d *DPoP) sign(header, claims map[string]interface{}) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := d.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if d.alg == "ES256" {
+		// JWS requires the fixed width concatenation of r and s rather than ASN.1
+		var esig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+			return "", fmt.Errorf("couldn't decode ECDSA signature: %w", err)
+		}
+		sig = append(esig.R.FillBytes(make([]byte, 32)), esig.S.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
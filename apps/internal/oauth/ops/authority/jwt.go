@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package authority
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// JSONWebKey is a key from an authority's JSON Web Key Set. See RFC 7517.
+type JSONWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid,omitempty"`
+	Use     string `json:"use,omitempty"`
+	// N and E are the base64url encoded modulus and exponent of an RSA key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	AdditionalFields map[string]interface{}
+}
+
+// JSONWebKeySet is the content of an authority's jwks_uri.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+
+	AdditionalFields map[string]interface{}
+}
+
+// JWKS gets the authority's signing keys from jwksURI.
+func (c Client) JWKS(ctx context.Context, jwksURI string) (JSONWebKeySet, error) {
+	resp := JSONWebKeySet{}
+	err := c.Comm.JSONCall(ctx, jwksURI, http.Header{}, nil, nil, &resp)
+	return resp, err
+}
+
+// ErrUnknownSigningKey is returned by VerifyJWT when no key in the key set has the token's key ID.
+// The authority may have rotated its keys since the caller fetched them.
+var ErrUnknownSigningKey = errors.New("the token's signing key isn't in the authority's key set")
+
+// jwtHashes are the hashes of the JWT signature algorithms VerifyJWT supports
+var jwtHashes = map[string]crypto.Hash{"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512}
+
+// VerifyJWT verifies the signature of the compact serialized JWT token with a key from keys and returns the
+// token's decoded claims. It doesn't validate any claim.
+func VerifyJWT(token string, keys JSONWebKeySet) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token isn't a JWT")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode the token's header: %w", err)
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err = json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("couldn't decode the token's header: %w", err)
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", header.Alg)
+	}
+	var key *JSONWebKey
+	for i, k := range keys.Keys {
+		if k.KeyType == "RSA" && (k.KeyID == header.Kid || header.Kid == "" && len(keys.Keys) == 1) && k.Use != "enc" {
+			key = &keys.Keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, ErrUnknownSigningKey
+	}
+	pub, err := key.rsaPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode the token's signature: %w", err)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig); err != nil {
+		return nil, errors.New("the token's signature is invalid")
+	}
+	if b, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, fmt.Errorf("couldn't decode the token's claims: %w", err)
+	}
+	claims := map[string]interface{}{}
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return nil, fmt.Errorf("couldn't decode the token's claims: %w", err)
+	}
+	return claims, nil
+}
+
+func (k JSONWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %q has an invalid modulus: %w", k.KeyID, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("key %q has an invalid exponent: %w", k.KeyID, err)
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("key %q isn't a valid RSA key", k.KeyID)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
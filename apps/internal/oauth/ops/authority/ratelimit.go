@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package authority
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+)
+
+// RateLimitStats counts the token requests a RateLimiter has admitted.
+type RateLimitStats struct {
+	// Allowed is the number of requests the limiter allowed immediately.
+	Allowed uint64
+	// Delayed is the number of requests the limiter allowed after a delay.
+	Delayed uint64
+	// Dropped is the number of requests the limiter rejected because they would have waited longer than the maximum delay.
+	Dropped uint64
+	// Delay is the total time delayed requests waited.
+	Delay time.Duration
+}
+
+// RateLimiter limits the rate of token requests to each authority host with a token bucket. It's safe for concurrent use.
+type RateLimiter struct {
+	rate     float64
+	burst    float64
+	maxDelay time.Duration
+	// now provides a test hook
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+	stats   RateLimitStats
+}
+
+// rateBucket is the state of a RateLimiter's bucket for one host
+type rateBucket struct {
+	// tokens is the number of requests the bucket allows at last. It's negative when delayed requests have reserved tokens.
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing requestsPerSecond requests per second to each host, in bursts of up to
+// burst requests. It delays requests exceeding the rate by up to maxDelay and rejects those it would delay longer.
+func NewRateLimiter(requestsPerSecond float64, burst int, maxDelay time.Duration) *RateLimiter {
+	return &RateLimiter{
+		rate:     requestsPerSecond,
+		burst:    float64(burst),
+		maxDelay: maxDelay,
+		now:      time.Now,
+		buckets:  map[string]*rateBucket{},
+	}
+}
+
+// Wait returns when the limiter allows a request to host. It returns an errors.RateLimitError when the request would have
+// to wait longer than the limiter's maximum delay, and ctx's error when ctx is done before the request may proceed.
+// Wait does nothing when r is nil.
+func (r *RateLimiter) Wait(ctx context.Context, host string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	now := r.now()
+	b, ok := r.buckets[host]
+	if !ok {
+		b = &rateBucket{tokens: r.burst, last: now}
+		r.buckets[host] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * r.rate
+		if b.tokens > r.burst {
+			b.tokens = r.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.stats.Allowed++
+		r.mu.Unlock()
+		return nil
+	}
+	delay := time.Duration((1 - b.tokens) / r.rate * float64(time.Second))
+	if delay > r.maxDelay {
+		r.stats.Dropped++
+		r.mu.Unlock()
+		return msalerrors.RateLimitError{Host: host, RetryAfter: delay}
+	}
+	// reserve a token so that concurrent requests queue behind this one
+	b.tokens--
+	r.stats.Delayed++
+	r.stats.Delay += delay
+	r.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// return the reservation
+		r.mu.Lock()
+		b.tokens++
+		r.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Stats returns the limiter's counts of the requests it has admitted.
+func (r *RateLimiter) Stats() RateLimitStats {
+	if r == nil {
+		return RateLimitStats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
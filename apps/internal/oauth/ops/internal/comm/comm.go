@@ -89,6 +89,9 @@ func (c *Client) JSONCall(ctx context.Context, endpoint string, headers http.Hea
 			return fmt.Errorf("bug: conn.Call(): could not marshal the body object: %w", err)
 		}
 		req.Body = io.NopCloser(bytes.NewBuffer(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewBuffer(data)), nil
+		}
 		req.Method = http.MethodPost
 	}
 
@@ -210,6 +213,9 @@ func (c *Client) URLFormCall(ctx context.Context, endpoint string, qv url.Values
 	if err != nil {
 		return err
 	}
+	if f, ok := ctx.Value(responseBodyKey{}).(func([]byte)); ok {
+		f(data)
+	}
 
 	v := reflect.ValueOf(resp)
 	if err := c.checkResp(v); err != nil {
@@ -228,7 +234,55 @@ func (c *Client) URLFormCall(ctx context.Context, endpoint string, qv url.Values
 	return nil
 }
 
-// do makes the HTTP call to the server and returns the contents of the body.
+type headersKey struct{}
+
+// WithHeaders returns a context that adds headers to any request made with it, in addition to the
+// standard headers. This allows callers to annotate requests without changing every method signature.
+// It doesn't replace headers the request already has, such as Content-Type and the client's telemetry.
+// headers take precedence over those of an enclosing WithHeaders context.
+func WithHeaders(ctx context.Context, headers http.Header) context.Context {
+	if outer, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		merged := outer.Clone()
+		for k, v := range headers {
+			merged[http.CanonicalHeaderKey(k)] = v
+		}
+		headers = merged
+	}
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+type responseHeadersKey struct{}
+
+// WithResponseHeaders returns a context that passes the headers of every response to a request made with it,
+// including error responses, to f. It passes them to the function of an enclosing WithResponseHeaders context too.
+func WithResponseHeaders(ctx context.Context, f func(http.Header)) context.Context {
+	if outer, ok := ctx.Value(responseHeadersKey{}).(func(http.Header)); ok {
+		inner := f
+		f = func(h http.Header) {
+			outer(h)
+			inner(h)
+		}
+	}
+	return context.WithValue(ctx, responseHeadersKey{}, f)
+}
+
+type responseBodyKey struct{}
+
+// WithResponseBody returns a context that passes the body of every successful response to a form request
+// made with it to f, before the client decodes the body.
+func WithResponseBody(ctx context.Context, f func([]byte)) context.Context {
+	return context.WithValue(ctx, responseBodyKey{}, f)
+}
+
+type httpClientKey struct{}
+
+// WithHTTPClient returns a context whose HTTP requests are sent by client instead of the Client's HTTPClient.
+func WithHTTPClient(ctx context.Context, client HTTPClient) context.Context {
+	return context.WithValue(ctx, httpClientKey{}, client)
+}
+
+// do makes the HTTP call to the server and returns the contents of the body. It retries throttled
+// requests according to the priority of ctx (see WithPriority).
 func (c *Client) do(ctx context.Context, req *http.Request) ([]byte, error) {
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
@@ -236,12 +290,32 @@ func (c *Client) do(ctx context.Context, req *http.Request) ([]byte, error) {
 		defer cancel()
 	}
 	req = req.WithContext(ctx)
+	if headers, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		for k, v := range headers {
+			k = http.CanonicalHeaderKey(k)
+			if _, ok := req.Header[k]; !ok {
+				req.Header[k] = v
+			}
+		}
+	}
 
-	reply, err := c.client.Do(req)
+	return retry(ctx, req, func() ([]byte, error) { return c.send(ctx, req) })
+}
+
+// send makes a single HTTP call to the server and returns the contents of the body.
+func (c *Client) send(ctx context.Context, req *http.Request) ([]byte, error) {
+	client := c.client
+	if hc, ok := ctx.Value(httpClientKey{}).(HTTPClient); ok {
+		client = hc
+	}
+	reply, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("server response error:\n %w", err)
 	}
 	defer reply.Body.Close()
+	if f, ok := ctx.Value(responseHeadersKey{}).(func(http.Header)); ok {
+		f(reply.Header)
+	}
 
 	data, err := c.readBody(reply)
 	if err != nil {
@@ -254,25 +328,41 @@ func (c *Client) do(ctx context.Context, req *http.Request) ([]byte, error) {
 	switch reply.StatusCode {
 	case 200, 201:
 	default:
-		sd := strings.TrimSpace(string(data))
-		if sd != "" {
-			// We probably have the error in the body.
-			return nil, errors.CallErr{
-				Req:  req,
-				Resp: reply,
-				Err:  fmt.Errorf("http call(%s)(%s) error: reply status code was %d:\n%s", req.URL.String(), req.Method, reply.StatusCode, sd),
-			}
-		}
-		return nil, errors.CallErr{
+		callErr := errors.CallErr{
 			Req:  req,
 			Resp: reply,
 			Err:  fmt.Errorf("http call(%s)(%s) error: reply status code was %d", req.URL.String(), req.Method, reply.StatusCode),
 		}
+		sd := strings.TrimSpace(string(data))
+		if sd != "" {
+			// We probably have the error in the body.
+			callErr.Err = fmt.Errorf("http call(%s)(%s) error: reply status code was %d:\n%s", req.URL.String(), req.Method, reply.StatusCode, sd)
+			addSTSDetails(&callErr, data)
+		}
+		return nil, callErr
 	}
 
 	return data, nil
 }
 
+// addSTSDetails copies the identifiers of an STS error response body to err. It leaves err
+// unchanged when the body isn't an STS error response.
+func addSTSDetails(err *errors.CallErr, body []byte) {
+	var details struct {
+		TraceID       string `json:"trace_id"`
+		CorrelationID string `json:"correlation_id"`
+		Timestamp     string `json:"timestamp"`
+		ErrorURI      string `json:"error_uri"`
+	}
+	if json.Unmarshal(body, &details) != nil {
+		return
+	}
+	err.TraceID = details.TraceID
+	err.CorrelationID = details.CorrelationID
+	err.Timestamp = details.Timestamp
+	err.ErrorURI = details.ErrorURI
+}
+
 // checkResp checks a response object o make sure it is a pointer to a struct.
 func (c *Client) checkResp(v reflect.Value) error {
 	if v.Kind() != reflect.Ptr {
@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -544,3 +545,41 @@ func TestURLFormCall(t *testing.T) {
 		}
 	}
 }
+
+func TestWithHeaders(t *testing.T) {
+	rec := &recorder{statusCode: http.StatusOK, ret: &SampleData{Ok: "true"}}
+	serv := httptest.NewServer(rec)
+	defer serv.Close()
+
+	ctx := WithHeaders(context.Background(), http.Header{
+		"content-type": {"text/plain"},
+		"X-Custom":     {"value"},
+	})
+	if err := New(serv.Client()).URLFormCall(ctx, serv.URL, url.Values{"key": {"value"}}, &SampleData{}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := rec.gotHeaders.Get("Content-Type"); actual != "application/x-www-form-urlencoded; charset=utf-8" {
+		t.Errorf("expected the standard Content-Type, got %q", actual)
+	}
+	if actual := rec.gotHeaders.Get("X-Custom"); actual != "value" {
+		t.Errorf("expected the added header, got %q", actual)
+	}
+}
+
+func TestSTSDetails(t *testing.T) {
+	serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_client","trace_id":"trace","correlation_id":"correlation","timestamp":"2024-01-02 03:04:05Z","error_uri":"https://localhost/error"}`))
+	}))
+	defer serv.Close()
+
+	err := New(serv.Client()).URLFormCall(context.Background(), serv.URL, url.Values{"key": {"value"}}, &SampleData{})
+	var callErr errors.CallErr
+	if !errors.As(err, &callErr) {
+		t.Fatalf("expected a CallErr, got %v", err)
+	}
+	expected := errors.CallErr{TraceID: "trace", CorrelationID: "correlation", Timestamp: "2024-01-02 03:04:05Z", ErrorURI: "https://localhost/error"}
+	if callErr.TraceID != expected.TraceID || callErr.CorrelationID != expected.CorrelationID || callErr.Timestamp != expected.Timestamp || callErr.ErrorURI != expected.ErrorURI {
+		t.Fatalf("expected %+v, got %+v", expected, callErr)
+	}
+}
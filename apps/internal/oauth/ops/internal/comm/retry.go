@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package comm
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+)
+
+// Priority indicates how urgently a caller needs a response. It determines how the client retries
+// requests the server throttles.
+type Priority int
+
+const (
+	// PriorityNone is the default. Throttled requests aren't retried.
+	PriorityNone Priority = iota
+	// PriorityInteractive is for requests a user is waiting on. Throttled requests are retried
+	// promptly, a few times.
+	PriorityInteractive
+	// PriorityBackground is for requests that can wait, such as a proactive token refresh. Throttled
+	// requests are retried with exponential backoff, honoring the server's Retry-After header, so that
+	// background work doesn't add to a throttling storm.
+	PriorityBackground
+)
+
+// retryPolicy describes how to retry throttled requests
+type retryPolicy struct {
+	// maxRetries is the number of times to retry a request
+	maxRetries int
+	// delay is the wait before the first retry. It doubles after each retry.
+	delay time.Duration
+	// maxDelay bounds the wait before any retry, including a wait requested by a Retry-After header
+	maxDelay time.Duration
+	// jitter randomizes waits so that many clients throttled at once don't retry at once
+	jitter bool
+}
+
+var retryPolicies = map[Priority]retryPolicy{
+	PriorityInteractive: {maxRetries: 3, delay: 50 * time.Millisecond, maxDelay: time.Second},
+	PriorityBackground:  {maxRetries: 4, delay: 2 * time.Second, maxDelay: 20 * time.Second, jitter: true},
+}
+
+// wait returns how long to wait before the given retry (0 for the first). retryAfter is the wait
+// requested by the server, if any.
+func (p retryPolicy) wait(retry int, retryAfter time.Duration) time.Duration {
+	d := p.delay << retry
+	if p.jitter {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests are retried according to p when the server throttles them.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// sleep provides a test hook for retry delays. It returns ctx's error when ctx is done before d elapses.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retry calls send until it succeeds, fails for a reason other than throttling, or exhausts the retry
+// policy for ctx's priority.
+func retry(ctx context.Context, req *http.Request, send func() ([]byte, error)) ([]byte, error) {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	policy, ok := retryPolicies[p]
+	for i := 0; ; i++ {
+		data, err := send()
+		if !ok || i == policy.maxRetries || !retryable(req) {
+			return data, err
+		}
+		retryAfter, throttled := throttling(err)
+		if !throttled {
+			return data, err
+		}
+		if sleep(ctx, policy.wait(i, retryAfter)) != nil {
+			return data, err
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return data, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// retryable returns true when req can be sent again i.e., it has no body or can reproduce its body
+func retryable(req *http.Request) bool {
+	return req.Body == nil || req.GetBody != nil
+}
+
+// throttling returns true when err is a throttling (429) response, and the wait requested by that
+// response's Retry-After header, if any
+func throttling(err error) (time.Duration, bool) {
+	var callErr errors.CallErr
+	if !errors.As(err, &callErr) || callErr.Resp == nil || callErr.Resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	v := callErr.Resp.Header.Get("Retry-After")
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, true
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package comm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// throttlingClient responds 429 to the first throttled requests, then 200
+type throttlingClient struct {
+	throttled  int
+	retryAfter string
+	bodies     []string
+}
+
+func (c *throttlingClient) Do(req *http.Request) (*http.Response, error) {
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.bodies = append(c.bodies, string(b))
+	if len(c.bodies) <= c.throttled {
+		h := http.Header{}
+		if c.retryAfter != "" {
+			h.Set("Retry-After", c.retryAfter)
+		}
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: h, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"Ok":"ok"}`))}, nil
+}
+
+func (*throttlingClient) CloseIdleConnections() {}
+
+func TestRetryThrottled(t *testing.T) {
+	realSleep := sleep
+	defer func() { sleep = realSleep }()
+
+	for _, test := range []struct {
+		desc             string
+		priority         Priority
+		throttled        int
+		retryAfter       string
+		expectedRequests int
+		expectedErr      bool
+		minWait, maxWait time.Duration
+	}{
+		{desc: "no priority", priority: PriorityNone, throttled: 1, expectedRequests: 1, expectedErr: true},
+		{desc: "interactive", priority: PriorityInteractive, throttled: 2, expectedRequests: 3, maxWait: time.Second},
+		{desc: "interactive exhausts retries", priority: PriorityInteractive, throttled: 10, expectedRequests: 4, expectedErr: true, maxWait: time.Second},
+		{desc: "interactive caps Retry-After", priority: PriorityInteractive, throttled: 1, retryAfter: "60", expectedRequests: 2, minWait: time.Second, maxWait: time.Second},
+		{desc: "background", priority: PriorityBackground, throttled: 3, expectedRequests: 4, minWait: time.Second, maxWait: 20 * time.Second},
+		{desc: "background honors Retry-After", priority: PriorityBackground, throttled: 1, retryAfter: "15", expectedRequests: 2, minWait: 15 * time.Second, maxWait: 20 * time.Second},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var waits []time.Duration
+			sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			client := &throttlingClient{throttled: test.throttled, retryAfter: test.retryAfter}
+			c := New(client)
+			ctx := WithPriority(context.Background(), test.priority)
+			resp := SampleData{}
+			err := c.URLFormCall(ctx, "https://localhost/token", url.Values{"key": {"value"}}, &resp)
+			if test.expectedErr != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(client.bodies) != test.expectedRequests {
+				t.Fatalf("expected %d requests, got %d", test.expectedRequests, len(client.bodies))
+			}
+			for _, b := range client.bodies {
+				if b != "key=value" {
+					t.Fatalf("unexpected request body %q", b)
+				}
+			}
+			if len(waits) != test.expectedRequests-1 {
+				t.Fatalf("expected %d waits, got %d", test.expectedRequests-1, len(waits))
+			}
+			for i, w := range waits {
+				if w < test.minWait || w > test.maxWait {
+					t.Errorf("wait %d was %v, expected between %v and %v", i, w, test.minWait, test.maxWait)
+				}
+				if i > 0 && test.priority == PriorityBackground && w < waits[i-1]/2 {
+					t.Errorf("wait %d (%v) should back off from %v", i, w, waits[i-1])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	client := &throttlingClient{throttled: 10}
+	c := New(client)
+	ctx, cancel := context.WithCancel(WithPriority(context.Background(), PriorityBackground))
+	cancel()
+	resp := SampleData{}
+	if err := c.URLFormCall(ctx, "https://localhost/token", url.Values{"key": {"value"}}, &resp); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(client.bodies) > 1 {
+		t.Fatalf("expected no retries after cancellation, got %d requests", len(client.bodies))
+	}
+}
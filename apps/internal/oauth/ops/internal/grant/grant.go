@@ -10,8 +10,10 @@ const (
 	SAMLV1           = "urn:ietf:params:oauth:grant-type:saml1_1-bearer"
 	SAMLV2           = "urn:ietf:params:oauth:grant-type:saml2-bearer"
 	DeviceCode       = "device_code"
+	DeviceCodeURN    = "urn:ietf:params:oauth:grant-type:device_code"
 	AuthCode         = "authorization_code"
 	RefreshToken     = "refresh_token"
 	ClientCredential = "client_credentials"
+	TokenExchange    = "urn:ietf:params:oauth:grant-type:token-exchange"
 	ClientAssertion  = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
 )
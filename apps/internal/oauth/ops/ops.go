@@ -18,6 +18,9 @@ Usage is simple:
 package ops
 
 import (
+	"context"
+	"net/http"
+
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/internal/comm"
@@ -28,6 +31,35 @@ import (
 // It's usually an *http.Client from the standard library.
 type HTTPClient = comm.HTTPClient
 
+// WithHeaders returns a context that adds headers to the HTTP requests made with it. It doesn't replace headers
+// the requests already have.
+func WithHeaders(ctx context.Context, headers http.Header) context.Context {
+	return comm.WithHeaders(ctx, headers)
+}
+
+// Priority indicates how urgently a caller needs a response. It determines how throttled requests are retried.
+type Priority = comm.Priority
+
+const (
+	PriorityNone        = comm.PriorityNone
+	PriorityInteractive = comm.PriorityInteractive
+	PriorityBackground  = comm.PriorityBackground
+)
+
+// WithPriority returns a context whose HTTP requests are retried according to p when the server throttles them.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return comm.WithPriority(ctx, p)
+}
+
+// WithHTTPClient returns a context whose HTTP requests are sent by client instead of the REST client's
+// HTTPClient. It returns ctx when client is nil.
+func WithHTTPClient(ctx context.Context, client HTTPClient) context.Context {
+	if client == nil {
+		return ctx
+	}
+	return comm.WithHTTPClient(ctx, client)
+}
+
 // REST provides REST clients for communicating with various backends used by MSAL.
 type REST struct {
 	client *comm.Client
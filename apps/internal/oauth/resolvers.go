@@ -36,11 +36,20 @@ type authorityEndpoint struct {
 
 	mu    sync.Mutex
 	cache map[string]cacheEntry
+	// pending holds the resolutions in flight, by cache key, so concurrent first requests share one
+	pending map[string]*pendingResolution
+}
+
+// pendingResolution is a resolution in flight. done closes when endpoints and err are set.
+type pendingResolution struct {
+	done      chan struct{}
+	endpoints authority.Endpoints
+	err       error
 }
 
 // newAuthorityEndpoint is the constructor for AuthorityEndpoint.
 func newAuthorityEndpoint(rest *ops.REST) *authorityEndpoint {
-	m := &authorityEndpoint{rest: rest, cache: map[string]cacheEntry{}}
+	m := &authorityEndpoint{rest: rest, cache: map[string]cacheEntry{}, pending: map[string]*pendingResolution{}}
 	return m
 }
 
@@ -50,10 +59,55 @@ func (m *authorityEndpoint) ResolveEndpoints(ctx context.Context, authorityInfo
 		return authority.Endpoints{}, errors.New("UPN required for authority validation for ADFS")
 	}
 
+	if authorityInfo.StaticEndpoints != nil {
+		return *authorityInfo.StaticEndpoints, nil
+	}
+
 	if endpoints, found := m.cachedEndpoints(authorityInfo, userPrincipalName); found {
 		return endpoints, nil
 	}
 
+	// a client configured for offline instance discovery sends no metadata requests. A regional
+	// authority is the exception because its endpoints don't follow the convention.
+	if authorityInfo.OfflineInstanceDiscovery && authorityInfo.AuthorityType == authority.AAD && authorityInfo.Region == "" {
+		endpoints := authorityInfo.OfflineEndpoints()
+		m.addCachedEndpoints(authorityInfo, userPrincipalName, endpoints)
+		return endpoints, nil
+	}
+
+	// ADFS endpoints are valid only for the domains the client has seen, so ADFS resolutions aren't shared
+	if authorityInfo.AuthorityType == ADFS {
+		return m.resolve(ctx, authorityInfo, userPrincipalName)
+	}
+	key := endpointsCacheKey(authorityInfo)
+	m.mu.Lock()
+	if p, ok := m.pending[key]; ok {
+		m.mu.Unlock()
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return authority.Endpoints{}, ctx.Err()
+		}
+		if p.err == nil {
+			return p.endpoints, nil
+		}
+		// the failure may be specific to the other request, for example because its context expired
+		return m.resolve(ctx, authorityInfo, userPrincipalName)
+	}
+	p := &pendingResolution{done: make(chan struct{})}
+	m.pending[key] = p
+	m.mu.Unlock()
+
+	p.endpoints, p.err = m.resolve(ctx, authorityInfo, userPrincipalName)
+	m.mu.Lock()
+	delete(m.pending, key)
+	m.mu.Unlock()
+	close(p.done)
+	return p.endpoints, p.err
+}
+
+// resolve gets the authority's endpoints from its metadata and caches them
+func (m *authorityEndpoint) resolve(ctx context.Context, authorityInfo authority.Info, userPrincipalName string) (authority.Endpoints, error) {
 	endpoint, err := m.openIDConfigurationEndpoint(ctx, authorityInfo, userPrincipalName)
 	if err != nil {
 		return authority.Endpoints{}, err
@@ -74,6 +128,13 @@ func (m *authorityEndpoint) ResolveEndpoints(ctx context.Context, authorityInfo
 		strings.Replace(resp.TokenEndpoint, "{tenant}", tenant, -1),
 		strings.Replace(resp.Issuer, "{tenant}", tenant, -1),
 		authorityInfo.Host)
+	endpoints.EndSessionEndpoint = strings.Replace(resp.EndSessionEndpoint, "{tenant}", tenant, -1)
+	endpoints.JWKSURI = strings.Replace(resp.JWKSURI, "{tenant}", tenant, -1)
+	endpoints.UserInfoEndpoint = resp.UserInfoEndpoint
+	endpoints.ClaimsSupported = resp.ClaimsSupported
+	endpoints.DeviceAuthorizationEndpoint = strings.Replace(resp.DeviceAuthorizationEndpoint, "{tenant}", tenant, -1)
+	endpoints.PushedAuthorizationRequestEndpoint = strings.Replace(resp.PushedAuthorizationRequestEndpoint, "{tenant}", tenant, -1)
+	endpoints.OpenIDConfigurationEndpoint = endpoint
 
 	m.addCachedEndpoints(authorityInfo, userPrincipalName, endpoints)
 
@@ -85,7 +146,7 @@ func (m *authorityEndpoint) cachedEndpoints(authorityInfo authority.Info, userPr
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if cacheEntry, ok := m.cache[authorityInfo.CanonicalAuthorityURI]; ok {
+	if cacheEntry, ok := m.cache[endpointsCacheKey(authorityInfo)]; ok {
 		if authorityInfo.AuthorityType == ADFS {
 			domain, err := adfsDomainFromUpn(userPrincipalName)
 			if err == nil {
@@ -108,7 +169,7 @@ func (m *authorityEndpoint) addCachedEndpoints(authorityInfo authority.Info, use
 	if authorityInfo.AuthorityType == ADFS {
 		// Since we're here, we've made a call to the backend.  We want to ensure we're caching
 		// the latest values from the server.
-		if cacheEntry, ok := m.cache[authorityInfo.CanonicalAuthorityURI]; ok {
+		if cacheEntry, ok := m.cache[endpointsCacheKey(authorityInfo)]; ok {
 			for k := range cacheEntry.ValidForDomainsInList {
 				updatedCacheEntry.ValidForDomainsInList[k] = true
 			}
@@ -119,7 +180,16 @@ func (m *authorityEndpoint) addCachedEndpoints(authorityInfo authority.Info, use
 		}
 	}
 
-	m.cache[authorityInfo.CanonicalAuthorityURI] = updatedCacheEntry
+	m.cache[endpointsCacheKey(authorityInfo)] = updatedCacheEntry
+}
+
+// endpointsCacheKey returns the key of an authority's endpoints in the cache. Regional and global
+// endpoints have different keys because a client may use both when it falls back from a region.
+func endpointsCacheKey(authorityInfo authority.Info) string {
+	if authorityInfo.Region != "" {
+		return authorityInfo.CanonicalAuthorityURI + "?region=" + authorityInfo.Region
+	}
+	return authorityInfo.CanonicalAuthorityURI
 }
 
 func (m *authorityEndpoint) openIDConfigurationEndpoint(ctx context.Context, authorityInfo authority.Info, userPrincipalName string) (string, error) {
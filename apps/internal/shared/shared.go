@@ -28,6 +28,11 @@ type Account struct {
 	AlternativeID     string `json:"alternative_account_id,omitempty"`
 	RawClientInfo     string `json:"client_info,omitempty"`
 	UserAssertionHash string `json:"user_assertion_hash,omitempty"`
+	// IdentityProvider is the issuer of the user's identity when it isn't the account's authority, as for a
+	// guest from another tenant. It's empty otherwise.
+	IdentityProvider string `json:"identity_provider,omitempty"`
+	// AlternativeSecurityIDs identify the user in IdentityProvider.
+	AlternativeSecurityIDs []string `json:"alternative_security_ids,omitempty"`
 
 	AdditionalFields map[string]interface{}
 }
@@ -44,6 +49,26 @@ func NewAccount(homeAccountID, env, realm, localAccountID, authorityType, userna
 	}
 }
 
+// NewHomeAccountID returns the home account ID of the user having object ID oid in the tenant having ID tid.
+// It returns an empty string when either is empty.
+func NewHomeAccountID(oid, tid string) string {
+	if oid == "" || tid == "" {
+		return ""
+	}
+	return oid + "." + tid
+}
+
+// ParseHomeAccountID returns the object and tenant IDs from a home account ID created by NewHomeAccountID.
+// It returns empty strings when id isn't in that form. Azure AD B2C object IDs include the user flow
+// (for example "<oid>-b2c_1_signin"), which this function doesn't remove.
+func ParseHomeAccountID(id string) (oid, tid string) {
+	i := strings.LastIndex(id, ".")
+	if i < 1 || i == len(id)-1 {
+		return "", ""
+	}
+	return id[:i], id[i+1:]
+}
+
 // Key creates the key for storing accounts in the cache.
 func (acc Account) Key() string {
 	return strings.Join([]string{acc.HomeAccountID, acc.Environment, acc.Realm}, CacheKeySeparator)
@@ -101,3 +101,32 @@ func TestAccountMarshal(t *testing.T) {
 		t.Errorf("TestAccountMarshal: -want/+got:\n%s", diff)
 	}
 }
+
+func TestHomeAccountID(t *testing.T) {
+	for _, test := range []struct {
+		id, oid, tid string
+	}{
+		{id: "oid.tid", oid: "oid", tid: "tid"},
+		{id: "oid-b2c_1_signin.tid", oid: "oid-b2c_1_signin", tid: "tid"},
+		{id: ""},
+		{id: "oid"},
+		{id: ".tid"},
+		{id: "oid."},
+	} {
+		t.Run(test.id, func(t *testing.T) {
+			oid, tid := ParseHomeAccountID(test.id)
+			if oid != test.oid || tid != test.tid {
+				t.Fatalf("expected (%q, %q), got (%q, %q)", test.oid, test.tid, oid, tid)
+			}
+			if test.oid == "" {
+				return
+			}
+			if id := NewHomeAccountID(oid, tid); id != test.id {
+				t.Fatalf("expected %q, got %q", test.id, id)
+			}
+		})
+	}
+	if id := NewHomeAccountID("oid", ""); id != "" {
+		t.Fatalf("expected an empty ID, got %q", id)
+	}
+}
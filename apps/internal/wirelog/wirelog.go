@@ -0,0 +1,195 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package wirelog provides an HTTP client that logs the requests and responses it sends and receives,
+// redacting secrets and, optionally, personal data.
+package wirelog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
+)
+
+// secrets are the names of parameters, JSON fields and headers whose values are always redacted
+var secrets = map[string]bool{
+	"access_token":          true,
+	"assertion":             true,
+	"authorization":         true,
+	"client_assertion":      true,
+	"client_secret":         true,
+	"code":                  true,
+	"code_verifier":         true,
+	"cookie":                true,
+	"device_code":           true,
+	"id_token":              true,
+	"password":              true,
+	"refresh_token":         true,
+	"set-cookie":            true,
+	"user_code":             true,
+	"x-ms-client-assertion": true,
+}
+
+// personalData are the names of parameters and JSON fields whose values are redacted unless the level is [exported.WireLogPII]
+var personalData = map[string]bool{
+	"client_info": true,
+	"login_hint":  true,
+	"username":    true,
+}
+
+// HTTPClient is an HTTP client such as *http.Client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+	CloseIdleConnections()
+}
+
+// Client is an HTTPClient that logs the requests and responses of another HTTPClient.
+type Client struct {
+	client HTTPClient
+	level  exported.PIILevel
+	logf   func(format string, args ...interface{})
+}
+
+// New returns client when level is [exported.WireLogOff], and otherwise a Client that logs client's
+// requests and responses with logf.
+func New(client HTTPClient, level exported.PIILevel, logf func(format string, args ...interface{})) HTTPClient {
+	if level == exported.WireLogOff {
+		return client
+	}
+	return &Client{client: client, level: level, logf: logf}
+}
+
+// Do implements HTTPClient. It logs req and the response.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	u := *req.URL
+	u.RawQuery = c.redactValues(req.URL.Query()).Encode()
+	c.logf("MSAL request: %s %s\n%s%s", req.Method, u.String(), c.headers(req.Header), c.body(req.Header, body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.logf("MSAL request to %s failed: %s", u.String(), err)
+		return resp, err
+	}
+	body, err = readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.logf("MSAL response from %s: %s\n%s%s", u.String(), resp.Status, c.headers(resp.Header), c.body(resp.Header, body))
+	return resp, nil
+}
+
+// CloseIdleConnections implements HTTPClient.
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// readBody reads the body rc points to, if any, and replaces it with a reader of the same content.
+func readBody(rc *io.ReadCloser) ([]byte, error) {
+	if *rc == nil || *rc == http.NoBody {
+		return nil, nil
+	}
+	defer (*rc).Close()
+	b, err := io.ReadAll(*rc)
+	if err != nil {
+		return nil, err
+	}
+	*rc = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// redact returns a stand-in for v that identifies it without revealing it, so logs of one
+// value in different messages can be correlated.
+func redact(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return "[redacted sha256:" + hex.EncodeToString(h[:4]) + "]"
+}
+
+func (c *Client) redacts(name string) bool {
+	name = strings.ToLower(name)
+	return secrets[name] || (personalData[name] && c.level != exported.WireLogPII)
+}
+
+func (c *Client) headers(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := strings.Builder{}
+	for _, name := range names {
+		for _, v := range h[name] {
+			if c.redacts(name) {
+				v = redact(v)
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, v)
+		}
+	}
+	return b.String()
+}
+
+func (c *Client) redactValues(v url.Values) url.Values {
+	for name, values := range v {
+		if c.redacts(name) {
+			for i := range values {
+				values[i] = redact(values[i])
+			}
+		}
+	}
+	return v
+}
+
+// body returns a loggable form of body. Because the client can't redact secrets in other
+// formats, such as the XML of WS-Trust, it logs only the size of bodies that aren't JSON
+// or form data.
+func (c *Client) body(h http.Header, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if v, err := url.ParseQuery(string(body)); err == nil {
+			return "\n" + c.redactValues(v).Encode()
+		}
+	case "application/json":
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if b, err := json.Marshal(c.redactJSON(v)); err == nil {
+				return "\n" + string(b)
+			}
+		}
+	}
+	return fmt.Sprintf("\n[%d bytes of %q content omitted]", len(body), mediaType)
+}
+
+func (c *Client) redactJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if s, ok := v.(string); ok && c.redacts(k) {
+				t[k] = redact(s)
+			} else {
+				t[k] = c.redactJSON(v)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = c.redactJSON(t[i])
+		}
+	}
+	return v
+}
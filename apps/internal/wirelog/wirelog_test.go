@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package wirelog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/mock"
+)
+
+func TestWireLog(t *testing.T) {
+	mc := &mock.Client{}
+	if New(mc, exported.WireLogOff, nil) != mc {
+		t.Fatal("expected the given client when logging is off")
+	}
+	form := url.Values{"client_secret": {"secret-value"}, "username": {"user@contoso.com"}, "scope": {"scope-value"}}
+	respBody := `{"access_token":"at-value","refresh_token":"rt-value","client_info":"client-info-value","expires_in":3600}`
+	for _, level := range []exported.PIILevel{exported.WireLogRedacted, exported.WireLogPII} {
+		mc.AppendResponse(
+			mock.WithBody([]byte(respBody)),
+			mock.WithHTTPHeader(http.Header{"Content-Type": {"application/json; charset=utf-8"}}),
+			mock.WithCallback(func(r *http.Request) {
+				// the logger must leave the body for the client to send
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != form.Encode() {
+					t.Errorf("unexpected request body %q", b)
+				}
+			}),
+		)
+		log := strings.Builder{}
+		c := New(mc, level, func(format string, args ...interface{}) { fmt.Fprintf(&log, format+"\n", args...) })
+		req, err := http.NewRequest(http.MethodPost, "https://localhost/token?code=code-value", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		req.Header.Set("Authorization", "Bearer header-value")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := io.ReadAll(resp.Body); err != nil || string(b) != respBody {
+			t.Fatalf("unexpected response body %q (%v)", b, err)
+		}
+
+		logged := log.String()
+		for _, secret := range []string{"secret-value", "code-value", "header-value", "at-value", "rt-value"} {
+			if strings.Contains(logged, secret) {
+				t.Errorf("log contains %q:\n%s", secret, logged)
+			}
+		}
+		for _, pii := range []string{"user@contoso.com", "client-info-value"} {
+			if strings.Contains(logged, url.QueryEscape(pii)) || strings.Contains(logged, pii) {
+				if level != exported.WireLogPII {
+					t.Errorf("log contains %q:\n%s", pii, logged)
+				}
+			} else if level == exported.WireLogPII {
+				t.Errorf("expected %q in the log:\n%s", pii, logged)
+			}
+		}
+		for _, expected := range []string{"scope-value", `"expires_in":3600`, "[redacted sha256:"} {
+			if !strings.Contains(logged, expected) {
+				t.Errorf("expected %q in the log:\n%s", expected, logged)
+			}
+		}
+	}
+}
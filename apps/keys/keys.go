@@ -0,0 +1,322 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package keys stores the private keys of proof-of-possession features, such as the DPoP scheme of package
+confidential. Features use a key through a Store, which needn't reveal it, so an implementation may keep keys
+in a TPM, the macOS Keychain or an HSM. This package provides a Store holding keys in memory and one holding
+them in files.
+
+A feature takes a crypto.Signer, which Signer returns for a key in any Store:
+
+	store, err := keys.NewFileStore(dir)
+	// handle err
+	signer, err := keys.Signer(store, "dpop")
+	// handle err
+	scheme, err := confidential.NewDPoP(signer)
+*/
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrNotFound is returned by a Store asked for a key it doesn't have.
+var ErrNotFound = errors.New("key not found")
+
+// Store generates private keys, signs with them, and deletes them. Keys have names, which the application chooses.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Generate creates a key named name, replacing any key having that name, and returns its public key.
+	Generate(name string) (crypto.PublicKey, error)
+	// Public returns the public key of the key named name, or ErrNotFound when the store has no such key.
+	Public(name string) (crypto.PublicKey, error)
+	// Sign signs digest with the key named name, as crypto.Signer does.
+	Sign(name string, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	// Thumbprint returns the JWK thumbprint (RFC 7638) of the key named name. See [Thumbprint].
+	Thumbprint(name string) (string, error)
+	// Delete deletes the key named name. Deleting a key the store doesn't have isn't an error.
+	Delete(name string) error
+}
+
+// Signer returns a crypto.Signer signing with the key named name in store. It generates the key when the store
+// doesn't have it.
+func Signer(store Store, name string) (crypto.Signer, error) {
+	pub, err := store.Public(name)
+	if errors.Is(err, ErrNotFound) {
+		pub, err = store.Generate(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return storeSigner{store: store, name: name, pub: pub}, nil
+}
+
+// storeSigner is a crypto.Signer using a key in a Store
+type storeSigner struct {
+	store Store
+	name  string
+	pub   crypto.PublicKey
+}
+
+func (s storeSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s storeSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.store.Sign(s.name, digest, opts)
+}
+
+// JWK returns the required members of pub's JSON Web Key (RFC 7517). pub must be an ECDSA P-256 or RSA public key.
+func JWK(pub crypto.PublicKey) (map[string]string, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only the P-256 curve is supported for ECDSA keys")
+		}
+		return map[string]string{
+			"crv": "P-256",
+			"kty": "EC",
+			"x":   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	case *rsa.PublicKey:
+		return map[string]string{
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+		}, nil
+	}
+	return nil, fmt.Errorf("keys of type %T aren't supported", pub)
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638) of pub, the base64url encoded SHA-256 hash of its JWK's
+// required members. pub must be an ECDSA P-256 or RSA public key.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := JWK(pub)
+	if err != nil {
+		return "", err
+	}
+	// the thumbprint requires the members in lexicographic order, as encoding/json orders the keys of a map
+	b, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+// MemoryStore is a Store holding ECDSA P-256 keys in memory. Its keys don't outlive the process.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PrivateKey
+}
+
+// NewMemoryStore is the constructor for MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]*ecdsa.PrivateKey{}}
+}
+
+// Generate implements Store.
+func (m *MemoryStore) Generate(name string) (crypto.PublicKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[name] = key
+	return key.Public(), nil
+}
+
+// Public implements Store.
+func (m *MemoryStore) Public(name string) (crypto.PublicKey, error) {
+	key, err := m.key(name)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Sign implements Store.
+func (m *MemoryStore) Sign(name string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := m.key(name)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// Thumbprint implements Store.
+func (m *MemoryStore) Thumbprint(name string) (string, error) {
+	key, err := m.key(name)
+	if err != nil {
+		return "", err
+	}
+	return Thumbprint(key.Public())
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, name)
+	return nil
+}
+
+func (m *MemoryStore) key(name string) (*ecdsa.PrivateKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return key, nil
+}
+
+// validName matches the key names FileStore accepts, which are safe file names on every platform
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// FileStore is a Store holding ECDSA P-256 keys in PEM encoded PKCS #8 files, one per key, which only the
+// process's user can read. The keys outlive the process, so a proof-of-possession token cached in persistent
+// storage remains usable after a restart. Key names must consist of letters, digits, '.', '-' and '_'.
+type FileStore struct {
+	dir string
+	// mu serializes changes to files, so that Generate and Delete don't race
+	mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore keeping keys in dir, which it creates if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Generate implements Store.
+func (f *FileStore) Generate(name string) (crypto.PublicKey, error) {
+	p, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// write a temporary file and rename it, so that a reader never sees a partially written key
+	tmp, err := os.CreateTemp(f.dir, name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Public implements Store.
+func (f *FileStore) Public(name string) (crypto.PublicKey, error) {
+	key, err := f.key(name)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Sign implements Store.
+func (f *FileStore) Sign(name string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := f.key(name)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// Thumbprint implements Store.
+func (f *FileStore) Thumbprint(name string) (string, error) {
+	key, err := f.key(name)
+	if err != nil {
+		return "", err
+	}
+	return Thumbprint(key.Public())
+}
+
+// Delete implements Store.
+func (f *FileStore) Delete(name string) error {
+	p, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileStore) key(name string) (crypto.Signer, error) {
+	p, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("key %q isn't PEM encoded", name)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %q has unsupported type %T", name, key)
+	}
+	return signer, nil
+}
+
+func (f *FileStore) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid key name %q", name)
+	}
+	return filepath.Join(f.dir, name+".pem"), nil
+}
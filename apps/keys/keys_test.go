@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		desc  string
+		store Store
+	}{
+		{desc: "file", store: fs},
+		{desc: "memory", store: NewMemoryStore()},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			name := "test-key"
+			if _, err := test.store.Public(name); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			signer, err := Signer(test.store, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := sha256.Sum256([]byte("data"))
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig) {
+				t.Fatal("signature isn't valid")
+			}
+			expected, err := Thumbprint(signer.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual, err := test.store.Thumbprint(name); err != nil {
+				t.Fatal(err)
+			} else if actual != expected {
+				t.Fatalf("expected thumbprint %q, got %q", expected, actual)
+			}
+			// Signer should use the existing key rather than generating another
+			again, err := Signer(test.store, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !signer.Public().(*ecdsa.PublicKey).Equal(again.Public()) {
+				t.Fatal("Signer generated a new key")
+			}
+			if err := test.store.Delete(name); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := test.store.Sign(name, digest[:], crypto.SHA256); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			if err := test.store.Delete(name); err != nil {
+				t.Fatalf("deleting a missing key returned %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := fs.Generate("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filepath.Join(dir, "key.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("expected permissions 0600, got %o", perm)
+		}
+	}
+	// another store for the same directory should find the key
+	other, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := other.Public("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(actual) {
+		t.Fatal("key changed")
+	}
+	for _, name := range []string{"", ".", "..", "../key", "a/b", `a\b`} {
+		if _, err := fs.Generate(name); err == nil {
+			t.Errorf("expected an error for name %q", name)
+		}
+	}
+}
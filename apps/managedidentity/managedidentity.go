@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package managedidentity detects the managed identity environment an application runs in.
+
+DetectSource reports which managed identity source, if any, is available and the endpoint
+that issues its tokens, so that orchestration code can choose between managed identity and
+other credentials, such as workload identity, before requesting a token:
+
+	source, endpoint, err := managedidentity.DetectSource(ctx)
+	if err != nil {
+		// handle error
+	}
+	if source == managedidentity.None {
+		// use another credential
+	}
+*/
+package managedidentity
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Source is a managed identity source.
+type Source string
+
+const (
+	// None indicates no managed identity source is available.
+	None Source = ""
+	// AppService is Azure App Service and Azure Functions.
+	AppService Source = "AppService"
+	// AzureArc is an Azure Arc-enabled server.
+	AzureArc Source = "AzureArc"
+	// CloudShell is Azure Cloud Shell.
+	CloudShell Source = "CloudShell"
+	// IMDS is the Azure Instance Metadata Service, available on Azure VMs and similar hosts.
+	IMDS Source = "IMDS"
+	// ServiceFabric is Azure Service Fabric.
+	ServiceFabric Source = "ServiceFabric"
+)
+
+const (
+	arcEndpoint  = "http://127.0.0.1:40342/metadata/identity/oauth2/token"
+	imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// imdsProbeTimeout bounds the time DetectSource waits for IMDS, which doesn't respond at all off Azure
+	imdsProbeTimeout = time.Second
+)
+
+// these provide test hooks for the environment
+var (
+	getenv     = os.Getenv
+	fileExists = func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	probeClient interface {
+		Do(*http.Request) (*http.Response, error)
+	} = &http.Client{
+		// IMDS is link-local, so a proxy can't reach it and would answer for it, making IMDS appear available
+		Transport: &http.Transport{Proxy: nil},
+	}
+)
+
+// DetectSource returns the managed identity source available to the application and the endpoint from which
+// that source issues tokens. It returns None and an empty endpoint when no source is available. DetectSource
+// reads environment variables set by Azure hosting platforms and, when none are set, checks for an Azure Arc
+// agent and then sends a request to IMDS. It returns an error only when ctx is done.
+func DetectSource(ctx context.Context) (Source, string, error) {
+	identityEndpoint := getenv("IDENTITY_ENDPOINT")
+	switch {
+	case identityEndpoint != "" && getenv("IDENTITY_HEADER") != "":
+		if getenv("IDENTITY_SERVER_THUMBPRINT") != "" {
+			return ServiceFabric, identityEndpoint, nil
+		}
+		return AppService, identityEndpoint, nil
+	case identityEndpoint != "" && getenv("IMDS_ENDPOINT") != "":
+		return AzureArc, identityEndpoint, nil
+	case getenv("MSI_ENDPOINT") != "":
+		return CloudShell, getenv("MSI_ENDPOINT"), nil
+	}
+	if arcAgentInstalled() {
+		return AzureArc, arcEndpoint, nil
+	}
+	available, err := probeIMDS(ctx)
+	if err != nil || !available {
+		return None, "", err
+	}
+	return IMDS, imdsEndpoint, nil
+}
+
+// arcAgentInstalled returns true when the Azure Connected Machine agent's himds executable is at its default location
+func arcAgentInstalled() bool {
+	switch runtime.GOOS {
+	case "linux":
+		return fileExists("/opt/azcmagent/bin/himds")
+	case "windows":
+		if pf := getenv("ProgramFiles"); pf != "" {
+			return fileExists(filepath.Join(pf, "AzureConnectedMachineAgent", "himds.exe"))
+		}
+	}
+	return false
+}
+
+// probeIMDS returns true when IMDS responds to a request. The request omits the Metadata header IMDS
+// requires, so IMDS rejects it without issuing a token; any response means IMDS is available.
+func probeIMDS(ctx context.Context) (bool, error) {
+	c, cancel := context.WithTimeout(ctx, imdsProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(c, http.MethodGet, imdsEndpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		// the caller's context ending is an error; IMDS not responding within the timeout isn't
+		return false, ctx.Err()
+	}
+	resp.Body.Close()
+	return true, nil
+}
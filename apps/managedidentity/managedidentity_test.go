@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package managedidentity
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type fakeProbeClient struct {
+	err error
+}
+
+func (f fakeProbeClient) Do(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if req.URL.String() != imdsEndpoint {
+		return nil, errors.New("unexpected URL " + req.URL.String())
+	}
+	return &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestDetectSource(t *testing.T) {
+	realGetenv, realFileExists, realProbeClient := getenv, fileExists, probeClient
+	defer func() { getenv, fileExists, probeClient = realGetenv, realFileExists, realProbeClient }()
+	fileExists = func(string) bool { return false }
+
+	endpoint := "http://localhost:42/token"
+	for _, test := range []struct {
+		desc             string
+		env              map[string]string
+		imdsErr          error
+		expectedSource   Source
+		expectedEndpoint string
+	}{
+		{
+			desc:             "App Service",
+			env:              map[string]string{"IDENTITY_ENDPOINT": endpoint, "IDENTITY_HEADER": "header"},
+			expectedSource:   AppService,
+			expectedEndpoint: endpoint,
+		},
+		{
+			desc:             "Azure Arc",
+			env:              map[string]string{"IDENTITY_ENDPOINT": endpoint, "IMDS_ENDPOINT": "http://localhost"},
+			expectedSource:   AzureArc,
+			expectedEndpoint: endpoint,
+		},
+		{
+			desc:             "Cloud Shell",
+			env:              map[string]string{"MSI_ENDPOINT": endpoint},
+			expectedSource:   CloudShell,
+			expectedEndpoint: endpoint,
+		},
+		{
+			desc:             "Service Fabric",
+			env:              map[string]string{"IDENTITY_ENDPOINT": endpoint, "IDENTITY_HEADER": "header", "IDENTITY_SERVER_THUMBPRINT": "thumbprint"},
+			expectedSource:   ServiceFabric,
+			expectedEndpoint: endpoint,
+		},
+		{
+			desc:             "IMDS",
+			expectedSource:   IMDS,
+			expectedEndpoint: imdsEndpoint,
+		},
+		{
+			desc:           "none",
+			imdsErr:        errors.New("no route to host"),
+			expectedSource: None,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			getenv = func(k string) string { return test.env[k] }
+			probeClient = fakeProbeClient{err: test.imdsErr}
+			source, actual, err := DetectSource(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if source != test.expectedSource {
+				t.Errorf("expected source %q, got %q", test.expectedSource, source)
+			}
+			if actual != test.expectedEndpoint {
+				t.Errorf("expected endpoint %q, got %q", test.expectedEndpoint, actual)
+			}
+		})
+	}
+}
+
+func TestDetectSourceCanceled(t *testing.T) {
+	realGetenv, realFileExists, realProbeClient := getenv, fileExists, probeClient
+	defer func() { getenv, fileExists, probeClient = realGetenv, realFileExists, realProbeClient }()
+	getenv = func(string) string { return "" }
+	fileExists = func(string) bool { return false }
+	probeClient = fakeProbeClient{err: context.Canceled}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := DetectSource(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestProbeClientIgnoresProxy(t *testing.T) {
+	c, ok := probeClient.(*http.Client)
+	if !ok {
+		t.Fatalf("expected an *http.Client, got %T", probeClient)
+	}
+	if tr, ok := c.Transport.(*http.Transport); !ok || tr.Proxy != nil {
+		t.Fatal("expected a transport that doesn't use a proxy")
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package msaltest helps applications test code that uses MSAL without sending requests to a real authority.
+Its Client implements the HTTP client interface accepted by the WithHTTPClient options of the public and
+confidential packages. It returns a sequence of canned responses, which the Get*Body functions build in the
+form Microsoft Entra ID returns:
+
+	c := &msaltest.Client{}
+	c.AppendResponse(msaltest.WithBody(msaltest.GetTenantDiscoveryBody("login.microsoftonline.com", "tenant")))
+	c.AppendResponse(msaltest.WithBody(msaltest.GetAccessTokenBody("token", "", "", "", 3600)))
+	client, err := confidential.New(clientID, cred,
+		confidential.WithAuthority("https://login.microsoftonline.com/tenant"),
+		confidential.WithHTTPClient(c),
+	)
+
+This package follows the module's compatibility guarantee: its exported API won't change in a breaking way
+within a major version.
+*/
+package msaltest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type response struct {
+	body     []byte
+	callback func(*http.Request)
+	code     int
+	headers  http.Header
+	path     string
+}
+
+// ResponseOption configures a response appended with [Client.AppendResponse].
+type ResponseOption interface {
+	apply(*response)
+}
+
+type respOpt func(*response)
+
+func (fn respOpt) apply(r *response) {
+	fn(r)
+}
+
+// WithBody sets the HTTP response's body to the specified value.
+func WithBody(b []byte) ResponseOption {
+	return respOpt(func(r *response) {
+		r.body = b
+	})
+}
+
+// WithCallback sets a callback to invoke before returning the response.
+func WithCallback(callback func(*http.Request)) ResponseOption {
+	return respOpt(func(r *response) {
+		r.callback = callback
+	})
+}
+
+// WithHTTPStatus sets the HTTP response's status code. The default is 200.
+func WithHTTPStatus(code int) ResponseOption {
+	return respOpt(func(r *response) {
+		r.code = code
+	})
+}
+
+// WithHTTPHeader sets the HTTP response's headers.
+func WithHTTPHeader(header http.Header) ResponseOption {
+	return respOpt(func(r *response) {
+		r.headers = header
+	})
+}
+
+// WithPath restricts the response to requests whose URL path contains path. Client returns such a response, when
+// one matches, in preference to the next unrestricted response. That keeps a test deterministic when the code under
+// test sends requests concurrently, as a public client does when it gets a user realm while resolving endpoints.
+func WithPath(path string) ResponseOption {
+	return respOpt(func(r *response) {
+		r.path = path
+	})
+}
+
+// Client is a mock HTTP client that returns a sequence of responses. Use AppendResponse to specify the sequence.
+// Client is safe for concurrent use.
+type Client struct {
+	mu   sync.Mutex
+	resp []response
+}
+
+// AppendResponse adds a response to the end of the sequence. The response has status 200 and no body unless
+// opts specify otherwise.
+func (c *Client) AppendResponse(opts ...ResponseOption) {
+	r := response{code: http.StatusOK, headers: http.Header{}}
+	for _, o := range opts {
+		o.apply(&r)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resp = append(c.resp, r)
+}
+
+// Do returns the first response in the sequence restricted to the request's path or, when there's none, the first
+// unrestricted response. It panics when no response fits, because that means the code under test sent an unexpected
+// request.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	resp, ok := c.next(req)
+	if !ok {
+		panic(fmt.Sprintf(`no response for "%s"`, req.URL.String()))
+	}
+	if resp.callback != nil {
+		resp.callback(req)
+	}
+	res := http.Response{Header: resp.headers, StatusCode: resp.code}
+	res.Body = io.NopCloser(bytes.NewReader(resp.body))
+	return &res, nil
+}
+
+// next removes and returns the response for req
+func (c *Client) next(req *http.Request) (response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := -1
+	for j, r := range c.resp {
+		if r.path != "" && strings.Contains(req.URL.Path, r.path) {
+			i = j
+			break
+		}
+		if r.path == "" && i < 0 {
+			i = j
+		}
+	}
+	if i < 0 {
+		return response{}, false
+	}
+	resp := c.resp[i]
+	c.resp = append(c.resp[:i], c.resp[i+1:]...)
+	return resp, true
+}
+
+// CloseIdleConnections implements the HTTP client interface. It does nothing.
+func (*Client) CloseIdleConnections() {}
+
+// GetAccessTokenBody returns a token response body. Empty idToken, refreshToken and clientInfo values are omitted.
+func GetAccessTokenBody(accessToken, idToken, refreshToken, clientInfo string, expiresIn int) []byte {
+	body := fmt.Sprintf(
+		`{"access_token": "%s","expires_in": %d,"expires_on": %d`,
+		accessToken, expiresIn, time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(),
+	)
+	if clientInfo != "" {
+		body += fmt.Sprintf(`, "client_info": "%s"`, clientInfo)
+	}
+	if idToken != "" {
+		body += fmt.Sprintf(`, "id_token": "%s"`, idToken)
+	}
+	if refreshToken != "" {
+		body += fmt.Sprintf(`, "refresh_token": "%s"`, refreshToken)
+	}
+	body += "}"
+	return []byte(body)
+}
+
+// GetIDToken returns an unsigned ID token having audience tenant and the given issuer, which expires in an hour.
+func GetIDToken(tenant, issuer string) string {
+	now := time.Now().Unix()
+	payload := []byte(fmt.Sprintf(`{"aud": "%s","exp": %d,"iat": %d,"iss": "%s"}`, tenant, now+3600, now, issuer))
+	return fmt.Sprintf("header.%s.signature", base64.RawStdEncoding.EncodeToString(payload))
+}
+
+// GetInstanceDiscoveryBody returns an instance discovery response body for the authority https://host/tenant.
+func GetInstanceDiscoveryBody(host, tenant string) []byte {
+	authority := fmt.Sprintf("https://%s/%s", host, tenant)
+	body := fmt.Sprintf(`{"tenant_discovery_endpoint": "%s/v2.0/.well-known/openid-configuration","api-version": "1.1","metadata": [{"preferred_network": "%s","preferred_cache": "%s","aliases": ["%s"]}]}`,
+		authority, host, host, host,
+	)
+	return []byte(body)
+}
+
+// GetTenantDiscoveryBody returns an OpenID configuration response body for the authority https://host/tenant.
+func GetTenantDiscoveryBody(host, tenant string) []byte {
+	authority := fmt.Sprintf("https://%s/%s", host, tenant)
+	content := strings.ReplaceAll(`{"token_endpoint": "{authority}/oauth2/v2.0/token",
+		"token_endpoint_auth_methods_supported": [
+			"client_secret_post",
+			"private_key_jwt",
+			"client_secret_basic"
+		],
+		"jwks_uri": "{authority}/discovery/v2.0/keys",
+		"response_modes_supported": [
+			"query",
+			"fragment",
+			"form_post"
+		],
+		"subject_types_supported": [
+			"pairwise"
+		],
+		"id_token_signing_alg_values_supported": [
+			"RS256"
+		],
+		"response_types_supported": [
+			"code",
+			"id_token",
+			"code id_token",
+			"id_token token"
+		],
+		"scopes_supported": [
+			"openid",
+			"profile",
+			"email",
+			"offline_access"
+		],
+		"issuer": "{authority}/v2.0",
+		"request_uri_parameter_supported": false,
+		"userinfo_endpoint": "https://graph.microsoft.com/oidc/userinfo",
+		"authorization_endpoint": "{authority}/oauth2/v2.0/authorize",
+		"device_authorization_endpoint": "{authority}/oauth2/v2.0/devicecode",
+		"http_logout_supported": true,
+		"frontchannel_logout_supported": true,
+		"end_session_endpoint": "{authority}/oauth2/v2.0/logout",
+		"claims_supported": [
+			"sub",
+			"iss",
+			"cloud_instance_name",
+			"cloud_instance_host_name",
+			"cloud_graph_host_name",
+			"msgraph_host",
+			"aud",
+			"exp",
+			"iat",
+			"auth_time",
+			"acr",
+			"nonce",
+			"preferred_username",
+			"name",
+			"tid",
+			"ver",
+			"at_hash",
+			"c_hash",
+			"email"
+		],
+		"kerberos_endpoint": "{authority}/kerberos",
+		"tenant_region_scope": "NA",
+		"cloud_instance_name": "microsoftonline.com",
+		"cloud_graph_host_name": "graph.windows.net",
+		"msgraph_host": "graph.microsoft.com",
+		"rbac_url": "https://pas.windows.net"
+	}`, "{authority}", authority)
+	return []byte(content)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package msaltest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/msaltest"
+)
+
+func TestClient(t *testing.T) {
+	const host, tenant = "login.microsoftonline.com", "tenant"
+	cred, err := confidential.NewCredFromSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &msaltest.Client{}
+	client, err := confidential.New("client-id", cred,
+		confidential.WithAuthority("https://"+host+"/"+tenant),
+		confidential.WithHTTPClient(c),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	count := func(*http.Request) { requests++ }
+	c.AppendResponse(msaltest.WithBody(msaltest.GetTenantDiscoveryBody(host, tenant)), msaltest.WithCallback(count))
+	c.AppendResponse(msaltest.WithBody(msaltest.GetAccessTokenBody("token", "", "", "", 3600)), msaltest.WithCallback(count))
+	ar, err := client.AcquireTokenByCredential(context.Background(), []string{"https://resource/.default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "token" {
+		t.Fatalf("expected access token %q, got %q", "token", ar.AccessToken)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+
+	c.AppendResponse(msaltest.WithBody([]byte(`{"error":"invalid_client"}`)), msaltest.WithHTTPStatus(http.StatusUnauthorized))
+	if _, err := client.AcquireTokenByCredential(context.Background(), []string{"https://other/.default"}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestClientWithPath(t *testing.T) {
+	c := &msaltest.Client{}
+	c.AppendResponse(msaltest.WithHTTPStatus(http.StatusAccepted))
+	c.AppendResponse(msaltest.WithHTTPStatus(http.StatusCreated), msaltest.WithPath("/realm/"))
+	for _, test := range []struct {
+		url      string
+		expected int
+	}{
+		// the restricted response comes first for a matching request, although it's second in the sequence
+		{"https://localhost/realm/user", http.StatusCreated},
+		{"https://localhost/other", http.StatusAccepted},
+	} {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != test.expected {
+			t.Errorf("expected status %d for %s, got %d", test.expected, test.url, res.StatusCode)
+		}
+	}
+}
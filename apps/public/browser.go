@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package public
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Browser identifies a web browser for interactive authentication. See [WithBrowserPreference].
+type Browser int
+
+const (
+	// SystemDefault is the operating system's default browser.
+	SystemDefault Browser = iota
+	// Edge is Microsoft Edge.
+	Edge
+	// Chrome is Google Chrome.
+	Chrome
+)
+
+// browserPreference is the browser configuration set by WithBrowserPreference
+type browserPreference struct {
+	browser Browser
+	private bool
+}
+
+// open opens authURL in the preferred browser. It doesn't launch a browser when ctx is done.
+func (b browserPreference) open(ctx context.Context, authURL string) error {
+	if b.browser == SystemDefault {
+		return browserOpenURL(ctx, authURL)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name, args, err := browserCommand(runtime.GOOS, b, authURL)
+	if err != nil {
+		return err
+	}
+	return runBrowser(ctx, name, args...)
+}
+
+// provides a test hook to simulate launching a browser
+var runBrowser = func(ctx context.Context, name string, args ...string) error {
+	return exec.CommandContext(ctx, name, args...).Start()
+}
+
+// browserCommand returns the command line that opens authURL in the preferred browser on the given OS
+func browserCommand(goos string, b browserPreference, authURL string) (string, []string, error) {
+	var app, exe, privateFlag string
+	switch b.browser {
+	case Edge:
+		app, exe, privateFlag = "Microsoft Edge", "msedge", "--inprivate"
+		if goos == "linux" {
+			exe = "microsoft-edge"
+		}
+	case Chrome:
+		app, exe, privateFlag = "Google Chrome", "chrome", "--incognito"
+		if goos == "linux" {
+			exe = "google-chrome"
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown browser %d", b.browser)
+	}
+	var args []string
+	if b.private {
+		args = append(args, privateFlag)
+	}
+	switch goos {
+	case "windows":
+		// Launching a browser through cmd.exe would let it interpret the URL, for example expanding %NAME% in it,
+		// so the client asks the shell to open the URL instead. That selects a browser only by protocol: Edge
+		// handles "microsoft-edge:" URLs. The shell can't pass flags to a browser, or select Chrome.
+		if b.browser != Edge || b.private {
+			return "", nil, fmt.Errorf("on windows, the browser preference supports only Edge without private browsing")
+		}
+		return "rundll32", []string{"url.dll,FileProtocolHandler", "microsoft-edge:" + authURL}, nil
+	case "darwin":
+		return "open", append([]string{"-na", app, "--args"}, append(args, authURL)...), nil
+	case "linux":
+		return exe, append(args, authURL), nil
+	}
+	return "", nil, fmt.Errorf("browser preference isn't supported on %s", goos)
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+//go:build msalnobrowser
+
+package public
+
+import "errors"
+
+// openURL returns an error because the msalnobrowser build tag omits support for the system's default browser
+func openURL(string) error {
+	return errors.New("can't open the default browser because the application was built with the msalnobrowser tag")
+}
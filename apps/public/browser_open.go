@@ -0,0 +1,13 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+//go:build !msalnobrowser
+
+package public
+
+import "github.com/pkg/browser"
+
+// openURL opens url in the system's default browser
+func openURL(url string) error {
+	return browser.OpenURL(url)
+}
@@ -5,6 +5,11 @@
 Package public provides a client for authentication of "public" applications. A "public"
 application is defined as an app that runs on client devices (android, ios, windows, linux, ...).
 These devices are "untrusted" and access resources via web APIs that must authenticate.
+
+Building an application with the msalnobrowser tag omits the package's dependency on
+github.com/pkg/browser, for applications that never open the system's default browser, such as
+services using only the device code flow. In such a build, [Client.AcquireTokenInteractive] can
+open a browser only when [WithBrowserPreference] specifies one, or when it has [WithWebView].
 */
 package public
 
@@ -22,14 +27,23 @@ Base.AuthParams is a copy that is free to be manipulated here.
 import (
 	"context"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"log"
+	"math/big"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/base"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/exported"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/local"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops"
@@ -37,22 +51,70 @@ import (
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/options"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/shared"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/wirelog"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/webview"
 	"github.com/google/uuid"
-	"github.com/pkg/browser"
 )
 
 // AuthResult contains the results of one token acquisition operation.
 // For details see https://aka.ms/msal-net-authenticationresult
 type AuthResult = base.AuthResult
 
+// AuthorityMetadata is the OpenID Connect metadata of an authority.
+type AuthorityMetadata = base.AuthorityMetadata
+
+// UserInfo contains claims about a user from the authority's OpenID Connect userinfo endpoint.
+type UserInfo = authority.UserInfo
+
 type Account = shared.Account
 
+// NewHomeAccountID returns the home account ID of the user having object ID oid in the tenant having ID tid,
+// in the form of [Account].HomeAccountID. Applications can use it to find a user's cached account by the IDs
+// in the user's ID token. It returns an empty string when either ID is empty.
+func NewHomeAccountID(oid, tid string) string {
+	return shared.NewHomeAccountID(oid, tid)
+}
+
+// ParseHomeAccountID returns the object and tenant IDs of an [Account].HomeAccountID. It returns empty strings
+// when id isn't a home account ID. For Azure AD B2C accounts, the object ID includes the user flow.
+func ParseHomeAccountID(id string) (oid, tid string) {
+	return shared.ParseHomeAccountID(id)
+}
+
 // Options configures the Client's behavior.
 type Options struct {
 	// Accessor controls cache persistence. By default there is no cache persistence.
 	// This can be set with the WithCache() option.
 	Accessor cache.ExportReplace
 
+	// Encrypter encrypts the secrets of tokens in the client's in-memory cache. By default they
+	// aren't encrypted. This can be set with the WithCacheEncrypter() option.
+	Encrypter cache.Encrypter
+
+	// CacheCompression specifies whether the client gzips the data it passes to its cache accessor.
+	// This can be set with the WithCacheCompression() option.
+	CacheCompression bool
+
+	// PartitionedCacheExport specifies whether the client passes its cache accessor only the part of the cache
+	// stored under the suggested key. This can be set with the WithPartitionedCacheExport() option.
+	PartitionedCacheExport bool
+
+	// CacheDisabled specifies whether the client keeps no tokens or accounts.
+	// This can be set with the WithCacheDisabled() option.
+	CacheDisabled bool
+
+	// ReadOnlyCache specifies whether the client reads its cache accessor's data without ever changing it.
+	// This can be set with the WithReadOnlyCache() option.
+	ReadOnlyCache bool
+
+	// RefreshNeeded is called when a client having a read-only cache can't return a token silently.
+	// This can be set with the WithReadOnlyCache() option.
+	RefreshNeeded func(account Account, scopes []string)
+
+	// CacheNamespace isolates the client's cache data from that of clients having another namespace.
+	// This can be set with the WithCacheNamespace() option.
+	CacheNamespace string
+
 	// The host of the Azure Active Directory authority. The default is https://login.microsoftonline.com/common.
 	// This can be changed with the WithAuthority() option.
 	Authority string
@@ -60,22 +122,241 @@ type Options struct {
 	// The HTTP client used for making requests.
 	// It defaults to a shared http.Client.
 	HTTPClient ops.HTTPClient
+
+	// OfflineInstanceDiscovery specifies whether the client uses bundled instance metadata.
+	// This can be set with the WithOfflineInstanceDiscovery() option.
+	OfflineInstanceDiscovery bool
+
+	// InstanceDiscoveryEndpoint is the URL to which the client sends instance discovery requests.
+	// This can be set with the WithInstanceDiscoveryEndpoint() option.
+	InstanceDiscoveryEndpoint string
+
+	// V1TokenEndpoint specifies whether the client sends token requests to the authority's v1 token endpoint.
+	// This can be set with the WithV1TokenEndpoint() option.
+	V1TokenEndpoint bool
+
+	// StaticAuthorizationEndpoint, StaticTokenEndpoint and StaticIssuer are the authority's endpoints and issuer,
+	// which the client uses instead of discovering any metadata.
+	// These can be set with the WithStaticEndpoints() option.
+	StaticAuthorizationEndpoint, StaticTokenEndpoint, StaticIssuer string
+
+	// AuthorizationEndpoint is the URL of an authorization endpoint to use instead of the authority's.
+	// This can be set with the WithAuthorizationEndpoint() option.
+	AuthorizationEndpoint string
+
+	// TokenEndpoint is the URL of a token endpoint to use instead of the authority's.
+	// This can be set with the WithTokenEndpoint() option.
+	TokenEndpoint string
+
+	// Clock returns the current time. It defaults to the system clock.
+	// This can be set with the WithClock() option.
+	Clock func() time.Time
+
+	// ClockSkew is how far the client's clock may differ from the authority's.
+	// This can be set with the WithClockSkew() option.
+	ClockSkew time.Duration
+
+	// ClockSkewCompensation specifies whether the client corrects its clock to match the authority's.
+	// This can be set with the WithClockSkewCompensation() option.
+	ClockSkewCompensation bool
+
+	// MaxStale is how long after an access token expires the client may return it when the authority is unavailable.
+	// This can be set with the WithAllowStaleOnError() option.
+	MaxStale time.Duration
+
+	// ExtendedTokenLifetime specifies whether the client may return an access token before its extended expiry when
+	// the authority is unavailable. This can be set with the WithExtendedTokenLifetime() option.
+	ExtendedTokenLifetime bool
+
+	// RequestInterceptor inspects token requests before the client sends them.
+	// This can be set with the WithRequestInterceptor() option.
+	RequestInterceptor func(context.Context, *TokenRequestInfo) error
+
+	// ResponseInterceptor observes the outcome of token requests.
+	// This can be set with the WithResponseInterceptor() option.
+	ResponseInterceptor func(context.Context, *TokenResponseInfo)
+
+	// StrictTokenResponses specifies whether the client rejects malformed token responses instead of coercing them.
+	// This can be set with the WithStrictTokenResponses() option.
+	StrictTokenResponses bool
+
+	// PIILogging controls the wire log of the client's HTTP requests and responses. It's off by default.
+	// This can be set with the WithPIILogging() option.
+	PIILogging PIILevel
+
+	// Logger receives the wire log. The default is the standard library's log package.
+	// This can be set with the WithLogger() option.
+	Logger Logger
+
+	// AllowedTenants are the only tenants to which the client sends token requests. Empty allows all.
+	// This can be set with the WithAllowedTenants() option.
+	AllowedTenants []string
+
+	// AllowedScopes are the only scopes for which the client requests tokens. Empty allows all.
+	// This can be set with the WithAllowedScopes() option.
+	AllowedScopes []string
+
+	// RefreshTokenExpiryWarning is called for results whose refresh token expires within RefreshTokenExpiryWindow.
+	// This can be set with the WithRefreshTokenExpiryWarning() option.
+	RefreshTokenExpiryWarning func(account Account, expiresOn time.Time)
+
+	// RefreshTokenExpiryWindow is how long before a refresh token expires the client warns of its expiry.
+	// This can be set with the WithRefreshTokenExpiryWarning() option.
+	RefreshTokenExpiryWindow time.Duration
+
+	// TokenRequestRate is the number of token requests per second the client sends to each authority host.
+	// Zero means no limit. This can be set with the WithTokenRequestRateLimit() option.
+	TokenRequestRate float64
+
+	// TokenRequestBurst is the number of token requests the client may send to a host at once despite TokenRequestRate.
+	// This can be set with the WithTokenRequestRateLimit() option.
+	TokenRequestBurst int
+
+	// TokenRequestMaxDelay is how long the client delays a request exceeding TokenRequestRate before rejecting it.
+	// This can be set with the WithTokenRequestRateLimit() option.
+	TokenRequestMaxDelay time.Duration
+
+	// UsernameNormalizer normalizes usernames before AccountsByUsername compares them.
+	// This can be set with the WithUsernameNormalizer() option.
+	UsernameNormalizer func(string) string
+}
+
+// validate returns every problem with the options and clientID, so that a misconfigured client fails at
+// construction instead of in the middle of an authentication
+func (p *Options) validate(clientID string) []error {
+	var errs []error
+	if strings.TrimSpace(clientID) != clientID || clientID == "" {
+		errs = append(errs, fmt.Errorf("client ID(%q) is empty or has surrounding whitespace", clientID))
+	}
+	if u, err := url.Parse(p.Authority); err != nil {
+		errs = append(errs, fmt.Errorf("Authority options cannot be URL parsed: %w", err))
+	} else if u.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("Authority(%s) did not start with https://", u.String()))
+	} else if _, err := authority.NewInfoFromAuthorityURI(p.Authority, false); err != nil {
+		errs = append(errs, fmt.Errorf("Authority(%s) is invalid: %w", p.Authority, err))
+	}
+	if p.HTTPClient == nil {
+		errs = append(errs, errors.New("HTTPClient can't be nil"))
+	}
+	if p.InstanceDiscoveryEndpoint != "" {
+		if u, err := url.Parse(p.InstanceDiscoveryEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("InstanceDiscoveryEndpoint options cannot be URL parsed: %w", err))
+		} else if u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("InstanceDiscoveryEndpoint(%s) did not start with https://", u.String()))
+		}
+	}
+	if p.StaticTokenEndpoint == "" && (p.StaticAuthorizationEndpoint != "" || p.StaticIssuer != "") {
+		errs = append(errs, errors.New("static endpoints must include a token endpoint"))
+	}
+	for _, endpoint := range []struct{ name, value string }{
+		{"AuthorizationEndpoint", p.AuthorizationEndpoint},
+		{"StaticAuthorizationEndpoint", p.StaticAuthorizationEndpoint},
+		{"StaticTokenEndpoint", p.StaticTokenEndpoint},
+		{"StaticIssuer", p.StaticIssuer},
+		{"TokenEndpoint", p.TokenEndpoint},
+	} {
+		if err := validateEndpointOverride(endpoint.name, endpoint.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.CacheDisabled && p.Accessor != nil {
+		errs = append(errs, errors.New("a client can't have both a disabled cache and a cache accessor"))
+	}
+	if p.ReadOnlyCache && p.Accessor == nil {
+		errs = append(errs, errors.New("a client having a read-only cache must have a cache accessor"))
+	}
+	if p.TokenRequestRate < 0 || p.TokenRequestRate > 0 && (p.TokenRequestBurst < 1 || p.TokenRequestMaxDelay < 0) {
+		errs = append(errs, fmt.Errorf("TokenRequestRate(%v) must be zero, or positive with a positive burst and non-negative max delay", p.TokenRequestRate))
+	}
+	return errs
+}
+
+// optionsError returns nil when errs is empty, its only error when it has one and otherwise an [errors.OptionsError]
+func optionsError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errors.OptionsError{Errs: errs}
 }
 
-func (p *Options) validate() error {
-	u, err := url.Parse(p.Authority)
+// validateEndpointOverride returns an error when endpoint, the value of the named option, isn't empty or an
+// https URL without a query or fragment
+func validateEndpointOverride(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
 	if err != nil {
-		return fmt.Errorf("Authority options cannot be URL parsed: %w", err)
+		return fmt.Errorf("%s options cannot be URL parsed: %w", name, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s(%s) did not start with https://", name, endpoint)
 	}
-	if u.Scheme != "https" {
-		return fmt.Errorf("Authority(%s) did not start with https://", u.String())
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%s(%s) has a query or fragment", name, endpoint)
 	}
 	return nil
 }
 
+// TokenRequestInfo describes a token request. See [WithRequestInterceptor].
+type TokenRequestInfo = exported.TokenRequestInfo
+
+// TokenResponseInfo describes the outcome of a token request. See [WithResponseInterceptor].
+type TokenResponseInfo = exported.TokenResponseInfo
+
+// PIILevel controls what the wire log includes. See [WithPIILogging].
+type PIILevel = exported.PIILevel
+
+// Logger receives a client's log output. See [WithLogger].
+type Logger = exported.Logger
+
+const (
+	// WireLogOff disables the wire log.
+	WireLogOff = exported.WireLogOff
+	// WireLogRedacted logs HTTP requests and responses, redacting secrets, tokens and personal data such as usernames.
+	WireLogRedacted = exported.WireLogRedacted
+	// WireLogPII logs HTTP requests and responses, redacting secrets and tokens but not personal data.
+	WireLogPII = exported.WireLogPII
+)
+
 // Option is an optional argument to the New constructor.
 type Option func(o *Options)
 
+// WithAllowStaleOnError allows the client to return a cached access token that expired up to maxStale ago when
+// it can't get a new token because the authority is unavailable, that is, it responds with a 5xx status or can't
+// be reached. This keeps applications running through short authority outages. The AuthResult of such a token has
+// Stale set. The client never returns a stale token for a request with claims. By default, the client returns
+// an error instead of a stale token.
+func WithAllowStaleOnError(maxStale time.Duration) Option {
+	return func(o *Options) {
+		o.MaxStale = maxStale
+	}
+}
+
+// WithAllowedScopes restricts the scopes for which the client requests tokens to scopes. Token acquisition methods
+// return an [errors.PolicyError] for any other scope, before sending a request or reading the cache. Scopes are
+// compared case insensitively. By default, the client allows all scopes.
+func WithAllowedScopes(scopes ...string) Option {
+	return func(o *Options) {
+		o.AllowedScopes = scopes
+	}
+}
+
+// WithAllowedTenants restricts the tenants to which the client sends token requests to tenants, for example to
+// prevent shared tooling from sending credentials to consumer tenants. Token acquisition methods return an
+// [errors.PolicyError] for any other tenant, including a tenant specified by WithTenantID, before sending a
+// request or reading the cache. The list must contain the tenant of the client's authority, in the form that
+// authority specifies it, such as a tenant ID or domain. Tenants are compared case insensitively. By default, the
+// client allows all tenants.
+func WithAllowedTenants(tenants ...string) Option {
+	return func(o *Options) {
+		o.AllowedTenants = tenants
+	}
+}
+
 // WithAuthority allows for a custom authority to be set. This must be a valid https url.
 func WithAuthority(authority string) Option {
 	return func(o *Options) {
@@ -90,6 +371,77 @@ func WithCache(accessor cache.ExportReplace) Option {
 	}
 }
 
+// WithCacheCompression gzips the data the client passes to its cache accessor, which reduces the size of
+// large caches in external storage. The client reads compressed and uncompressed data regardless of this
+// option, so enabling it doesn't invalidate data already in storage. However, clients that don't enable it
+// and other MSAL libraries can't read compressed data.
+func WithCacheCompression() Option {
+	return func(o *Options) {
+		o.CacheCompression = true
+	}
+}
+
+// WithCacheNamespace isolates the client's cache data from that of clients having a different namespace, or none,
+// for example when two applications in one process share a cache file through their [WithCache] accessors. Clients
+// having different namespaces don't read each other's tokens even when they have the same client ID. The
+// serialized cache holds a namespace's data in a member named for the namespace, which clients having other
+// namespaces preserve, and the client prefixes the keys it suggests to its accessor with the namespace. Other
+// MSAL libraries don't read data having a namespace.
+func WithCacheNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.CacheNamespace = namespace
+	}
+}
+
+// WithCacheEncrypter encrypts the secrets of tokens the client holds in memory with e. See [cache.Encrypter].
+func WithCacheEncrypter(e cache.Encrypter) Option {
+	return func(o *Options) {
+		o.Encrypter = e
+	}
+}
+
+// WithClock sets the clock the client uses to decide whether tokens have expired and to set the validity period
+// of the client assertions it creates. By default the client uses the system clock. A fixed or adjustable clock
+// makes tests deterministic, and a clock corrected for a known offset compensates for a host's inaccurate clock.
+func WithClock(clock func() time.Time) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// WithClockSkew allows for the client's clock differing from the authority's by up to skew. The client considers
+// tokens expired this much earlier than it otherwise would and backdates the client assertions it creates by this
+// much, so that the authority doesn't reject them as not yet valid.
+func WithClockSkew(skew time.Duration) Option {
+	return func(o *Options) {
+		o.ClockSkew = skew
+	}
+}
+
+// WithClockSkewCompensation corrects the client's clock by its offset from the authority's clock, which the client
+// computes from the Date header of token responses. The client applies the correction to the times it sends the
+// authority, which prevents a client whose clock is several minutes off from creating client assertions the
+// authority rejects. Token expiry doesn't need the correction because the authority states token lifetimes relative
+// to the time it responds, so the client stores and checks expiry times according to its own clock, and applications
+// sharing the cache read expiry times they can use. The client ignores offsets smaller than a minute. It applies the
+// correction to the clock set by [WithClock], if any.
+func WithClockSkewCompensation() Option {
+	return func(o *Options) {
+		o.ClockSkewCompensation = true
+	}
+}
+
+// WithExtendedTokenLifetime allows the client to return an expired access token whose extended lifetime hasn't
+// ended when it can't get a new token because the authority is unavailable, that is, it responds with a 5xx status
+// or can't be reached. The authority's ext_expires_in sets a token's extended lifetime, which resources honor only
+// during an outage of the authority. The AuthResult of such a token has Stale and Metadata.DegradedMode set. The
+// client never returns such a token for a request with claims. By default, the client returns an error instead.
+func WithExtendedTokenLifetime(enabled bool) Option {
+	return func(o *Options) {
+		o.ExtendedTokenLifetime = enabled
+	}
+}
+
 // WithHTTPClient allows for a custom HTTP client to be set.
 func WithHTTPClient(httpClient ops.HTTPClient) Option {
 	return func(o *Options) {
@@ -97,6 +449,204 @@ func WithHTTPClient(httpClient ops.HTTPClient) Option {
 	}
 }
 
+// WithInstanceDiscoveryEndpoint sets the URL to which the client sends instance discovery requests, which the client
+// uses to validate its authority and learn the authority's aliases. By default, the client sends these requests to
+// the authority's host when that's a known Microsoft host, and otherwise to login.microsoftonline.com. Set this to
+// target a test environment, a national cloud this module doesn't know, or a proxy for the discovery service. The
+// URL must use https. The client doesn't send instance discovery requests when configured for offline instance
+// discovery or a region.
+func WithInstanceDiscoveryEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.InstanceDiscoveryEndpoint = endpoint
+	}
+}
+
+// WithAuthorizationEndpoint sets the URL of the authorization endpoint to which the client directs users, for example
+// the address of a reverse proxy or API gateway in front of the authority, instead of the endpoint in the authority's
+// openid-configuration document. The client still gets that document, and caches tokens under the authority's host,
+// from the authority. The URL must use https and have no query or fragment. See also [WithTokenEndpoint].
+func WithAuthorizationEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.AuthorizationEndpoint = endpoint
+	}
+}
+
+// WithTokenEndpoint sets the URL to which the client sends token requests, for example the address of a reverse
+// proxy or API gateway in front of the authority, instead of the endpoint in the authority's openid-configuration
+// document. The client still gets that document, and caches tokens under the authority's host, from the authority.
+// The override applies to requests for all tenants. The URL must use https and have no query or fragment.
+// See also [WithAuthorizationEndpoint].
+func WithTokenEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.TokenEndpoint = endpoint
+	}
+}
+
+// WithOfflineInstanceDiscovery specifies whether the client uses instance metadata bundled with this module instead
+// of requesting it from the authority, and derives the authority's endpoints from the conventions of the public and
+// sovereign clouds instead of requesting its OpenID configuration. This saves requests, which reduces the latency
+// of the first authentication and permits authenticating on networks that allow only the authority's authorization
+// and token endpoints. The bundled metadata describes the public and sovereign clouds. When the client's authority
+// isn't among them, the client doesn't validate the authority and doesn't recognize its aliases. Clients configured
+// for a region still request the regional endpoints' metadata.
+func WithOfflineInstanceDiscovery(enabled bool) Option {
+	return func(o *Options) {
+		o.OfflineInstanceDiscovery = enabled
+	}
+}
+
+// WithV1TokenEndpoint directs the client to send token requests to the v1 token endpoint of its Microsoft Entra
+// authority, for legacy APIs that accept only v1 access tokens. The client then identifies the requested API by a
+// "resource" parameter, which it gets from the first requested scope having one. For example, scopes
+// "https://legacy.contoso.com/.default" request resource "https://legacy.contoso.com". The client caches v1 tokens
+// apart from v2.0 tokens for the same scopes. Authorization URLs still use the v2.0 authorization endpoint, so this
+// doesn't suit the authorization code flow. The option has no effect on clients of other authorities, such as
+// ADFS, and a token endpoint specified by another option takes precedence.
+func WithV1TokenEndpoint(enabled bool) Option {
+	return func(o *Options) {
+		o.V1TokenEndpoint = enabled
+	}
+}
+
+// WithStaticEndpoints directs the client to use the specified authorization and token endpoints and issuer instead of
+// discovering the authority's metadata. The client then sends no instance discovery, tenant discovery or other
+// metadata requests, which permits authenticating in a disconnected environment, such as a sovereign enclave, whose
+// authority's metadata endpoints the client can't reach. It uses bundled instance metadata, as for
+// [WithOfflineInstanceDiscovery], to recognize the authority's aliases. The URLs must use https and have no query or
+// fragment. tokenEndpoint is required; the others may be empty when the client doesn't use them.
+func WithStaticEndpoints(authorizationEndpoint, tokenEndpoint, issuer string) Option {
+	return func(o *Options) {
+		o.StaticAuthorizationEndpoint = authorizationEndpoint
+		o.StaticTokenEndpoint = tokenEndpoint
+		o.StaticIssuer = issuer
+	}
+}
+
+// WithReadOnlyCache directs the client to read tokens from its cache accessor, which another process maintains,
+// without ever writing, exporting or removing them. This suits a sidecar deployment in which one process acquires
+// and refreshes tokens for others sharing its serialized cache. AcquireTokenSilent returns a cached access token
+// when it's valid. Otherwise, instead of refreshing the token, it calls refreshNeeded, when that isn't nil, with the
+// request's account and scopes and returns an error. Tokens from other methods, such as AcquireTokenByAuthCode, aren't
+// cached, and RemoveAccount does nothing. New returns an error when the client has no cache accessor.
+func WithReadOnlyCache(refreshNeeded func(account Account, scopes []string)) Option {
+	return func(o *Options) {
+		o.ReadOnlyCache = true
+		o.RefreshNeeded = refreshNeeded
+	}
+}
+
+// WithCacheDisabled specifies whether the client keeps no tokens or accounts in memory or in a cache accessor.
+// A client with a disabled cache sends a request to the authority for every call to AcquireTokenInteractive and the other acquisition methods,
+// AcquireTokenSilent returns an error, and Accounts returns no accounts. RemoveAccount does nothing. This suits an application that
+// implements its own token caching or mustn't retain tokens. New returns an error when the client also has a
+// cache accessor.
+func WithCacheDisabled(disabled bool) Option {
+	return func(o *Options) {
+		o.CacheDisabled = disabled
+	}
+}
+
+// WithPartitionedCacheExport passes the cache accessor only the part of the cache stored under the key it
+// receives, such as one user's tokens, instead of the entire cache. When the accessor replaces the cache
+// with data for a key, the client replaces only that part of its cache. This reduces the cost of persisting
+// the cache of an application that holds many users' tokens, for example a web app that persists tokens per
+// user. The cache accessor receives the entire cache for operations that don't have a key.
+func WithPartitionedCacheExport() Option {
+	return func(o *Options) {
+		o.PartitionedCacheExport = true
+	}
+}
+
+// WithUsernameNormalizer sets a function the client applies to usernames before [Client.AccountsByUsername] compares
+// them, such as the NFC normalization of golang.org/x/text/unicode/norm:
+//
+//	WithUsernameNormalizer(norm.NFC.String)
+//
+// Normalization lets a username match the cached username of the same account when they're encoded differently,
+// for example when one has "é" as a single code point and the other as "e" followed by a combining accent, as
+// can happen with usernames typed on different platforms. By default the client doesn't normalize usernames.
+func WithUsernameNormalizer(normalize func(string) string) Option {
+	return func(o *Options) {
+		o.UsernameNormalizer = normalize
+	}
+}
+
+// WithTokenRequestRateLimit limits the rate of the client's token requests to each authority host, so that a
+// misbehaving caller, such as a loop acquiring tokens without using the cache, can't provoke the authority into
+// throttling the application for every caller. The client allows requestsPerSecond requests per second in bursts of
+// up to burst requests, delays a request exceeding that rate by up to maxDelay, and fails a request it would delay
+// longer with an [errors.RateLimitError]. The limit doesn't apply to tokens the client returns from its cache.
+// Clients sharing a cache don't share a limit. See [Client.RateLimitStats] for counts of delayed and rejected requests.
+func WithTokenRequestRateLimit(requestsPerSecond float64, burst int, maxDelay time.Duration) Option {
+	return func(o *Options) {
+		o.TokenRequestRate = requestsPerSecond
+		o.TokenRequestBurst = burst
+		o.TokenRequestMaxDelay = maxDelay
+	}
+}
+
+// WithRefreshTokenExpiryWarning directs the client to call warn when it returns a result for an account whose refresh
+// token expires within window, for example so an application can prompt its user to sign in again before silent
+// authentication starts failing. The client knows a refresh token's expiry only when the authority includes it in a
+// token response, as it does for refresh tokens bound to a device or limited by a sign-in frequency policy. The
+// AuthResult of any acquisition reports the expiry, when known, in RefreshTokenExpiresOn. warn is called synchronously,
+// before the acquisition method returns.
+func WithRefreshTokenExpiryWarning(window time.Duration, warn func(account Account, expiresOn time.Time)) Option {
+	return func(o *Options) {
+		o.RefreshTokenExpiryWarning = warn
+		o.RefreshTokenExpiryWindow = window
+	}
+}
+
+// WithRequestInterceptor sets a function the client calls before sending each token request, in all flows. The
+// function can enforce policy, for example by denying requests for certain scopes, and add parameters to the
+// request's body by setting ExtraBodyParameters. When it returns an error, the client doesn't send the request
+// and returns an error wrapping that one. It must be safe for concurrent use.
+func WithRequestInterceptor(interceptor func(context.Context, *TokenRequestInfo) error) Option {
+	return func(o *Options) {
+		o.RequestInterceptor = interceptor
+	}
+}
+
+// WithResponseInterceptor sets a function the client calls after each token request completes, successfully or not,
+// for example to write an audit log. The TokenResponseInfo it receives contains no secrets. It must be safe for
+// concurrent use.
+func WithResponseInterceptor(interceptor func(context.Context, *TokenResponseInfo)) Option {
+	return func(o *Options) {
+		o.ResponseInterceptor = interceptor
+	}
+}
+
+// WithStrictTokenResponses makes the client reject a token response having an expires_in, ext_expires_in,
+// refresh_token_expires_in or expires_on value that isn't a whole number of seconds, malformed client_info or no
+// expires_in. The error describes each malformed field. By default, the client is lenient because some token
+// services, such as emulators, return nonstandard responses: it truncates fractional seconds, treats empty and null
+// durations as absent and ignores malformed client info, which then identifies no account.
+func WithStrictTokenResponses(enabled bool) Option {
+	return func(o *Options) {
+		o.StrictTokenResponses = enabled
+	}
+}
+
+// WithPIILogging enables a wire log of the client's HTTP requests and responses, for debugging. The client writes
+// the log with the standard library's log package unless it has [WithLogger]. It always replaces secrets and tokens
+// with a prefix of their SHA-256 hash, which identifies a value without revealing it, and does the same to personal
+// data such as usernames unless level is [WireLogPII]. It omits bodies it can't redact, such as WS-Trust XML. Don't
+// enable [WireLogPII] in production without considering where the log goes.
+func WithPIILogging(level PIILevel) Option {
+	return func(o *Options) {
+		o.PIILogging = level
+	}
+}
+
+// WithLogger sends the client's log output, such as the wire log enabled by [WithPIILogging], to logger instead of
+// the standard library's log package. A nil logger restores the default.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
 // Client is a representation of authentication client for public applications as defined in the
 // package doc. For more information, visit https://docs.microsoft.com/azure/active-directory/develop/msal-client-applications.
 type Client struct {
@@ -104,6 +654,8 @@ type Client struct {
 }
 
 // New is the constructor for Client.
+//
+// New sends no requests and is safe to call concurrently. A Client is safe for concurrent use.
 func New(clientID string, options ...Option) (Client, error) {
 	opts := Options{
 		Authority:  base.AuthorityPublicCloud,
@@ -113,11 +665,40 @@ func New(clientID string, options ...Option) (Client, error) {
 	for _, o := range options {
 		o(&opts)
 	}
-	if err := opts.validate(); err != nil {
+	if err := optionsError(opts.validate(clientID)); err != nil {
 		return Client{}, err
 	}
 
-	base, err := base.New(clientID, opts.Authority, oauth.New(opts.HTTPClient), base.WithCacheAccessor(opts.Accessor))
+	logf := log.Printf
+	if opts.Logger != nil {
+		logf = opts.Logger.Printf
+	}
+	base, err := base.New(clientID, opts.Authority, oauth.New(wirelog.New(opts.HTTPClient, opts.PIILogging, logf)),
+		base.WithCacheAccessor(opts.Accessor),
+		base.WithPartitionedCacheExport(opts.PartitionedCacheExport),
+		base.WithCacheDisabled(opts.CacheDisabled),
+		base.WithReadOnlyCache(opts.ReadOnlyCache, opts.RefreshNeeded),
+		base.WithCacheCompression(opts.CacheCompression),
+		base.WithCacheNamespace(opts.CacheNamespace),
+		base.WithCacheEncrypter(opts.Encrypter),
+		base.WithClock(opts.Clock),
+		base.WithClockSkew(opts.ClockSkew),
+		base.WithClockSkewCompensation(opts.ClockSkewCompensation),
+		base.WithAllowStaleOnError(opts.MaxStale),
+		base.WithExtendedTokenLifetime(opts.ExtendedTokenLifetime),
+		base.WithInterceptors(opts.RequestInterceptor, opts.ResponseInterceptor),
+		base.WithStrictTokenResponses(opts.StrictTokenResponses),
+		base.WithPolicy(opts.AllowedTenants, opts.AllowedScopes),
+		base.WithOfflineInstanceDiscovery(opts.OfflineInstanceDiscovery),
+		base.WithInstanceDiscoveryEndpoint(opts.InstanceDiscoveryEndpoint),
+		base.WithStaticEndpoints(opts.StaticAuthorizationEndpoint, opts.StaticTokenEndpoint, opts.StaticIssuer),
+		base.WithV1TokenEndpoint(opts.V1TokenEndpoint),
+		base.WithAuthorizationEndpointOverride(opts.AuthorizationEndpoint),
+		base.WithTokenEndpointOverride(opts.TokenEndpoint),
+		base.WithRefreshTokenExpiryWarning(opts.RefreshTokenExpiryWindow, opts.RefreshTokenExpiryWarning),
+		base.WithRateLimit(opts.TokenRequestRate, opts.TokenRequestBurst, opts.TokenRequestMaxDelay),
+		base.WithUsernameNormalizer(opts.UsernameNormalizer),
+	)
 	if err != nil {
 		return Client{}, err
 	}
@@ -126,7 +707,8 @@ func New(clientID string, options ...Option) (Client, error) {
 
 // createAuthCodeURLOptions contains options for CreateAuthCodeURL
 type createAuthCodeURLOptions struct {
-	loginHint, tenantID string
+	claims, loginHint, tenantID string
+	pushed                      bool
 }
 
 // CreateAuthCodeURLOption is implemented by options for CreateAuthCodeURL
@@ -137,7 +719,9 @@ type CreateAuthCodeURLOption interface {
 // CreateAuthCodeURL creates a URL used to acquire an authorization code.
 //
 // Options:
+// - [WithClaims]
 // - [WithLoginHint]
+// - [WithPushedAuthorizationRequest]
 // - [WithTenantID]
 func (pca Client) CreateAuthCodeURL(ctx context.Context, clientID, redirectURI string, scopes []string, opts ...CreateAuthCodeURLOption) (string, error) {
 	o := createAuthCodeURLOptions{}
@@ -148,16 +732,45 @@ func (pca Client) CreateAuthCodeURL(ctx context.Context, clientID, redirectURI s
 	if err != nil {
 		return "", err
 	}
+	ap.Claims = o.claims
 	ap.LoginHint = o.loginHint
-	return pca.base.AuthCodeURL(ctx, clientID, redirectURI, scopes, ap)
+	ap.PushedAuthorization = o.pushed
+	return pca.base.AuthCodeURL(ctx, clientID, redirectURI, scopes, ap, nil)
 }
 
-// WithTenantID specifies a tenant for a single authentication. It may be different than the tenant set in [New] by [WithAuthority].
-// This option is valid for any token acquisition method.
-func WithTenantID(tenantID string) interface {
+// WithPushedAuthorizationRequest has CreateAuthCodeURL push the parameters of the authorization request to the
+// authority (RFC 9126) and return a short URL referring to them, so that they don't pass through the browser,
+// where users and extensions could read or alter them. The authority's metadata must advertise a
+// pushed_authorization_request_endpoint; CreateAuthCodeURL returns an error when it doesn't.
+func WithPushedAuthorizationRequest() interface {
+	CreateAuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		CreateAuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *createAuthCodeURLOptions:
+					t.pushed = true
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// WithClaims sets additional claims to request, a JSON object such as the claims challenge a Continuous Access
+// Evaluation (CAE) enabled resource returns when it rejects an access token. AcquireTokenSilent doesn't return a
+// cached access token when claims are set, because that token may be the one the resource rejected. Instead it
+// redeems a cached refresh token for a new access token.
+func WithClaims(claims string) interface {
 	AcquireByAuthCodeOption
-	AcquireByDeviceCodeOption
-	AcquireByUsernamePasswordOption
+	AcquireBySSHCertOption
 	AcquireInteractiveOption
 	AcquireSilentOption
 	CreateAuthCodeURLOption
@@ -165,8 +778,7 @@ func WithTenantID(tenantID string) interface {
 } {
 	return struct {
 		AcquireByAuthCodeOption
-		AcquireByDeviceCodeOption
-		AcquireByUsernamePasswordOption
+		AcquireBySSHCertOption
 		AcquireInteractiveOption
 		AcquireSilentOption
 		CreateAuthCodeURLOption
@@ -176,17 +788,15 @@ func WithTenantID(tenantID string) interface {
 			func(a any) error {
 				switch t := a.(type) {
 				case *AcquireTokenByAuthCodeOptions:
-					t.tenantID = tenantID
-				case *acquireTokenByDeviceCodeOptions:
-					t.tenantID = tenantID
-				case *acquireTokenByUsernamePasswordOptions:
-					t.tenantID = tenantID
+					t.claims = claims
+				case *acquireTokenBySSHCertOptions:
+					t.claims = claims
 				case *AcquireTokenSilentOptions:
-					t.tenantID = tenantID
+					t.claims = claims
 				case *createAuthCodeURLOptions:
-					t.tenantID = tenantID
+					t.claims = claims
 				case *InteractiveAuthOptions:
-					t.tenantID = tenantID
+					t.claims = claims
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -196,39 +806,46 @@ func WithTenantID(tenantID string) interface {
 	}
 }
 
-// AcquireTokenSilentOptions are all the optional settings to an AcquireTokenSilent() call.
-// These are set by using various AcquireTokenSilentOption functions.
-type AcquireTokenSilentOptions struct {
-	// Account represents the account to use. To set, use the WithSilentAccount() option.
-	Account Account
-
-	tenantID string
-}
-
-// AcquireSilentOption is implemented by options for AcquireTokenSilent
-type AcquireSilentOption interface {
-	acquireSilentOption()
-}
-
-// AcquireTokenSilentOption changes options inside AcquireTokenSilentOptions used in .AcquireTokenSilent().
-type AcquireTokenSilentOption func(a *AcquireTokenSilentOptions)
-
-func (AcquireTokenSilentOption) acquireSilentOption() {}
+// RequestPriority indicates how urgently an application needs a token. See [WithRequestPriority].
+type RequestPriority = ops.Priority
+
+const (
+	// PriorityInteractive is for requests a user is waiting on. When the authority throttles such a request,
+	// the client retries it promptly, a few times.
+	PriorityInteractive RequestPriority = ops.PriorityInteractive
+	// PriorityBackground is for requests that can wait, such as refreshing a token before it expires. When the
+	// authority throttles such a request, the client retries it with exponential backoff, honoring the authority's
+	// Retry-After header, so that background work doesn't add to the load on a throttled authority.
+	PriorityBackground RequestPriority = ops.PriorityBackground
+)
 
-// WithSilentAccount uses the passed account during an AcquireTokenSilent() call.
-func WithSilentAccount(account Account) interface {
+// WithRequestPriority sets the priority of an acquisition, which determines how the client retries requests the
+// authority throttles (HTTP 429). By default, the client doesn't retry throttled requests.
+func WithRequestPriority(priority RequestPriority) interface {
+	AcquireByAuthCodeOption
+	AcquireByUsernamePasswordOption
+	AcquireInteractiveOption
 	AcquireSilentOption
 	options.CallOption
 } {
 	return struct {
+		AcquireByAuthCodeOption
+		AcquireByUsernamePasswordOption
+		AcquireInteractiveOption
 		AcquireSilentOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
 			func(a any) error {
 				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.priority = priority
+				case *acquireTokenByUsernamePasswordOptions:
+					t.priority = priority
 				case *AcquireTokenSilentOptions:
-					t.Account = account
+					t.priority = priority
+				case *InteractiveAuthOptions:
+					t.priority = priority
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -238,31 +855,309 @@ func WithSilentAccount(account Account) interface {
 	}
 }
 
-// AcquireTokenSilent acquires a token from either the cache or using a refresh token.
-//
-// Options:
-//   - [WithSilentAccount]
-//   - [WithTenantID]
-func (pca Client) AcquireTokenSilent(ctx context.Context, scopes []string, opts ...AcquireSilentOption) (AuthResult, error) {
-	o := AcquireTokenSilentOptions{}
-	if err := options.ApplyOptions(&o, opts); err != nil {
-		return AuthResult{}, err
-	}
-
-	silentParameters := base.AcquireTokenSilentParameters{
-		Scopes:      scopes,
-		Account:     o.Account,
-		RequestType: accesstokens.ATPublic,
-		IsAppCache:  false,
-		TenantID:    o.tenantID,
-	}
+// WithPerCallHTTPClient sets the HTTP client that sends an acquisition's requests, instead of the client set by
+// [WithHTTPClient], for example to route one tenant's requests through an egress proxy. It applies to every
+// request of the acquisition, including requests for the authority's metadata when the client hasn't cached it.
+// The client doesn't log the requests httpClient sends, even when it has [WithPIILogging].
+func WithPerCallHTTPClient(httpClient ops.HTTPClient) interface {
+	AcquireByAuthCodeOption
+	AcquireByUsernamePasswordOption
+	AcquireInteractiveOption
+	AcquireSilentOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByUsernamePasswordOption
+		AcquireInteractiveOption
+		AcquireSilentOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.httpClient = httpClient
+				case *acquireTokenByUsernamePasswordOptions:
+					t.httpClient = httpClient
+				case *AcquireTokenSilentOptions:
+					t.httpClient = httpClient
+				case *InteractiveAuthOptions:
+					t.httpClient = httpClient
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// IDTokenPolicy determines whether a token request requires the authority's response to include an ID token.
+// See [WithIDTokenPolicy].
+type IDTokenPolicy = authority.IDTokenPolicy
+
+const (
+	// IDTokenOptional accepts responses with or without an ID token. This is the default.
+	IDTokenOptional IDTokenPolicy = authority.IDTokenOptional
+	// IDTokenRequired rejects responses without an ID token, for example when an application signs users in.
+	IDTokenRequired IDTokenPolicy = authority.IDTokenRequired
+	// IDTokenForbidden rejects responses having an ID token.
+	IDTokenForbidden IDTokenPolicy = authority.IDTokenForbidden
+)
+
+// WithIDTokenPolicy determines whether an acquisition requires the authority's response to include an ID token.
+// When the response doesn't satisfy policy, the method returns an error and the client doesn't cache the response's
+// tokens. Some Azure AD B2C policies return no ID token. The client caches no account for a response that has no
+// client info, even when the response has an ID token.
+func WithIDTokenPolicy(policy IDTokenPolicy) interface {
+	AcquireByAuthCodeOption
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.idTokenPolicy = policy
+				case *InteractiveAuthOptions:
+					t.idTokenPolicy = policy
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// These are the meta-tenants of Microsoft Entra authorities. An authority such as
+// https://login.microsoftonline.com/common specifies a class of accounts rather than a specific tenant.
+const (
+	// TenantCommon accepts work, school and personal Microsoft accounts.
+	TenantCommon = authority.TenantCommon
+	// TenantConsumers accepts only personal Microsoft accounts.
+	TenantConsumers = authority.TenantConsumers
+	// TenantOrganizations accepts only work and school accounts.
+	TenantOrganizations = authority.TenantOrganizations
+)
+
+// IsMetaTenant returns true when tenant is [TenantCommon], [TenantConsumers] or [TenantOrganizations].
+func IsMetaTenant(tenant string) bool {
+	return authority.IsMetaTenant(tenant)
+}
+
+// EffectiveTenant returns the tenant a client configured with authorityURI would request tokens from when
+// given [WithTenantID](tenantID). An empty tenantID returns the authority's tenant. It returns the error
+// [WithTenantID] would cause when the combination is invalid.
+func EffectiveTenant(authorityURI, tenantID string) (string, error) {
+	return authority.EffectiveTenant(authorityURI, tenantID)
+}
+
+// WithTenantID specifies a tenant for a single authentication. It may be different than the tenant set in [New] by [WithAuthority].
+// This option is valid for any token acquisition method. tenantID must be a specific tenant, and the client's
+// authority must be a Microsoft Entra authority whose tenant isn't [TenantConsumers]. Otherwise, acquisition
+// methods return an [errors.InvalidTenantError].
+func WithTenantID(tenantID string) interface {
+	AcquireByAuthCodeOption
+	AcquireByDeviceCodeOption
+	AcquireBySSHCertOption
+	AcquireByUsernamePasswordOption
+	AcquireInteractiveOption
+	AcquireSilentOption
+	CacheKeysOption
+	CreateAuthCodeURLOption
+	ResolvedEndpointsOption
+	WarmupOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		AcquireByDeviceCodeOption
+		AcquireBySSHCertOption
+		AcquireByUsernamePasswordOption
+		AcquireInteractiveOption
+		AcquireSilentOption
+		CacheKeysOption
+		CreateAuthCodeURLOption
+		ResolvedEndpointsOption
+		WarmupOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.tenantID = tenantID
+				case *acquireTokenByDeviceCodeOptions:
+					t.tenantID = tenantID
+				case *acquireTokenBySSHCertOptions:
+					t.tenantID = tenantID
+				case *acquireTokenByUsernamePasswordOptions:
+					t.tenantID = tenantID
+				case *AcquireTokenSilentOptions:
+					t.tenantID = tenantID
+				case *cacheKeysOptions:
+					t.tenantID = tenantID
+				case *createAuthCodeURLOptions:
+					t.tenantID = tenantID
+				case *InteractiveAuthOptions:
+					t.tenantID = tenantID
+				case *resolvedEndpointsOptions:
+					t.tenantID = tenantID
+				case *warmupOptions:
+					t.tenantID = tenantID
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// AcquireTokenSilentOptions are all the optional settings to an AcquireTokenSilent() call.
+// These are set by using various AcquireTokenSilentOption functions.
+type AcquireTokenSilentOptions struct {
+	// Account represents the account to use. To set, use the WithSilentAccount() option.
+	Account Account
+
+	claims, tenantID string
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
+}
+
+// AcquireSilentOption is implemented by options for AcquireTokenSilent
+type AcquireSilentOption interface {
+	acquireSilentOption()
+}
+
+// AcquireTokenSilentOption changes options inside AcquireTokenSilentOptions used in .AcquireTokenSilent().
+type AcquireTokenSilentOption func(a *AcquireTokenSilentOptions)
+
+func (AcquireTokenSilentOption) acquireSilentOption() {}
+
+// WithSilentAccount uses the passed account during an AcquireTokenSilent() call.
+func WithSilentAccount(account Account) interface {
+	AcquireSilentOption
+	options.CallOption
+} {
+	return struct {
+		AcquireSilentOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenSilentOptions:
+					t.Account = account
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// AcquireTokenSilent acquires a token from either the cache or using a refresh token. With [WithTenantID], it gets
+// a token from that tenant for the account, which may be a guest there: the client matches the account by its
+// home account ID and redeems the account's refresh token when the cache has no token from the tenant. The
+// result's TenantID identifies the tenant that issued the token.
+//
+// Options:
+//   - [WithClaims]
+//   - [WithRequestPriority]
+//   - [WithSilentAccount]
+//   - [WithTenantID]
+func (pca Client) AcquireTokenSilent(ctx context.Context, scopes []string, opts ...AcquireSilentOption) (AuthResult, error) {
+	o := AcquireTokenSilentOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+
+	silentParameters := base.AcquireTokenSilentParameters{
+		Scopes:      scopes,
+		Account:     o.Account,
+		RequestType: accesstokens.ATPublic,
+		IsAppCache:  false,
+		TenantID:    o.tenantID,
+		Claims:      o.claims,
+	}
 
 	return pca.base.AcquireTokenSilent(ctx, silentParameters)
 }
 
+// acquireTokenBySSHCertOptions contains optional configuration for AcquireTokenBySSHCert
+type acquireTokenBySSHCertOptions struct {
+	claims, tenantID string
+}
+
+// AcquireBySSHCertOption is implemented by options for AcquireTokenBySSHCert
+type AcquireBySSHCertOption interface {
+	acquireBySSHCertOption()
+}
+
+// AcquireTokenBySSHCert acquires an SSH certificate for key, such as the certificates Azure AD SSH login requires
+// to sign in to virtual machines, whose scope is "https://pas.windows.net/CheckMyAccess/Linux/.default". It redeems
+// the refresh token cached for account, which the application gets by authenticating the user with another method
+// such as AcquireTokenInteractive. The AuthResult's AccessToken is the certificate, encoded in base64. OpenSSH reads
+// it from a file containing "ssh-rsa-cert-v01@openssh.com " followed by the certificate. The client doesn't cache
+// certificates.
+//
+// Options:
+//   - [WithClaims]
+//   - [WithTenantID]
+func (pca Client) AcquireTokenBySSHCert(ctx context.Context, account Account, scopes []string, key *rsa.PublicKey, opts ...AcquireBySSHCertOption) (AuthResult, error) {
+	o := acquireTokenBySSHCertOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	if account.IsZero() {
+		return AuthResult{}, errors.New("account is required")
+	}
+	keyID, reqCnf, err := sshKeyJWK(key)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	params := base.AcquireTokenSSHCertParameters{
+		Account:  account,
+		Scopes:   scopes,
+		TenantID: o.tenantID,
+		Claims:   o.claims,
+		KeyID:    keyID,
+		ReqCnf:   reqCnf,
+	}
+	return pca.base.AcquireTokenSSHCert(ctx, params)
+}
+
+// sshKeyJWK returns an ID for key and key as a JWK having that ID, in the form Azure AD SSH login expects
+func sshKeyJWK(key *rsa.PublicKey) (string, string, error) {
+	if key == nil {
+		return "", "", errors.New("key is required")
+	}
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	h := sha256.Sum256([]byte(n + e))
+	kid := hex.EncodeToString(h[:])
+	jwk, err := json.Marshal(map[string]string{"kty": "RSA", "n": n, "e": e, "kid": kid})
+	if err != nil {
+		return "", "", err
+	}
+	return kid, string(jwk), nil
+}
+
 // acquireTokenByUsernamePasswordOptions contains optional configuration for AcquireTokenByUsernamePassword
 type acquireTokenByUsernamePasswordOptions struct {
-	tenantID string
+	tenantID, wsTrustEndpoint string
+	priority                  RequestPriority
+	httpClient                ops.HTTPClient
 }
 
 // AcquireByUsernamePasswordOption is implemented by options for AcquireTokenByUsernamePassword
@@ -270,16 +1165,88 @@ type AcquireByUsernamePasswordOption interface {
 	acquireByUsernamePasswordOption()
 }
 
+// WithWSTrustEndpoint specifies the WS-Trust 1.3 username/password endpoint of a federated user's identity provider,
+// for example https://adfs.contoso.com/adfs/services/trust/13/usernamemixed. The client sends the user's credentials
+// to this endpoint instead of discovering one from the identity provider's metadata exchange (MEX) document, which
+// spares the latency of fetching that document and allows authentication when its URL isn't reachable from the
+// application. The client ignores this option for users whose accounts aren't federated.
+func WithWSTrustEndpoint(endpoint string) interface {
+	AcquireByUsernamePasswordOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByUsernamePasswordOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *acquireTokenByUsernamePasswordOptions:
+					u, err := url.Parse(endpoint)
+					if err != nil || u.Scheme != "https" || u.Host == "" {
+						return fmt.Errorf("WS-Trust endpoint %q isn't an https URL", endpoint)
+					}
+					t.wsTrustEndpoint = endpoint
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
 // AcquireTokenByUsernamePassword acquires a security token from the authority, via Username/Password Authentication.
+// When the user's account is federated, the client caches the identity provider's metadata exchange (MEX) document
+// for a day, so that later requests for users of the same identity provider needn't fetch it.
 // NOTE: this flow is NOT recommended.
 //
 // Options:
+//   - [WithRequestPriority]
 //   - [WithTenantID]
+//   - [WithWSTrustEndpoint]
 func (pca Client) AcquireTokenByUsernamePassword(ctx context.Context, scopes []string, username, password string, opts ...AcquireByUsernamePasswordOption) (AuthResult, error) {
 	o := acquireTokenByUsernamePasswordOptions{}
 	if err := options.ApplyOptions(&o, opts); err != nil {
 		return AuthResult{}, err
 	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+	return pca.usernamePassword(ctx, scopes, username, password, o)
+}
+
+// AcquireTokenByUsernamePasswordFunc is like [Client.AcquireTokenByUsernamePassword], except it gets the password
+// from a callback when it's about to authenticate, so the application needn't hold the password until then. The
+// callback may return a new slice each time it's called. The client zeroes the returned slice before returning,
+// however it copies the password into strings to send the token request, and it can't wipe those copies from
+// memory.
+// NOTE: this flow is NOT recommended.
+//
+// Options:
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+//   - [WithWSTrustEndpoint]
+func (pca Client) AcquireTokenByUsernamePasswordFunc(ctx context.Context, scopes []string, username string, password func() ([]byte, error), opts ...AcquireByUsernamePasswordOption) (AuthResult, error) {
+	o := acquireTokenByUsernamePasswordOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+	if password == nil {
+		return AuthResult{}, errors.New("password callback can't be nil")
+	}
+	pw, err := password()
+	if err != nil {
+		return AuthResult{}, err
+	}
+	defer func() {
+		for i := range pw {
+			pw[i] = 0
+		}
+	}()
+	return pca.usernamePassword(ctx, scopes, username, string(pw), o)
+}
+
+func (pca Client) usernamePassword(ctx context.Context, scopes []string, username, password string, o acquireTokenByUsernamePasswordOptions) (AuthResult, error) {
 	authParams, err := pca.base.AuthParams.WithTenant(o.tenantID)
 	if err != nil {
 		return AuthResult{}, err
@@ -288,6 +1255,7 @@ func (pca Client) AcquireTokenByUsernamePassword(ctx context.Context, scopes []s
 	authParams.AuthorizationType = authority.ATUsernamePassword
 	authParams.Username = username
 	authParams.Password = password
+	authParams.WSTrustEndpoint = o.wsTrustEndpoint
 
 	token, err := pca.base.Token.UsernamePassword(ctx, authParams)
 	if err != nil {
@@ -308,6 +1276,7 @@ type DeviceCode struct {
 	authParams authority.AuthParams
 	client     Client
 	dc         oauth.DeviceCode
+	progress   progress
 }
 
 // AuthenticationResult retreives the AuthenticationResult once the user enters the code
@@ -318,12 +1287,18 @@ func (d DeviceCode) AuthenticationResult(ctx context.Context) (AuthResult, error
 	if err != nil {
 		return AuthResult{}, err
 	}
-	return d.client.base.AuthResultFromToken(ctx, d.authParams, token, true)
+	d.progress.report(ctx, ProgressCodeRedeemed, "")
+	ar, err := d.client.base.AuthResultFromToken(ctx, d.authParams, token, true)
+	if err == nil {
+		d.progress.report(ctx, ProgressTokenCached, "")
+	}
+	return ar, err
 }
 
 // acquireTokenByDeviceCodeOptions contains optional configuration for AcquireTokenByDeviceCode
 type acquireTokenByDeviceCodeOptions struct {
 	tenantID string
+	progress progress
 }
 
 // AcquireByDeviceCodeOption is implemented by options for AcquireTokenByDeviceCode
@@ -335,6 +1310,7 @@ type AcquireByDeviceCodeOption interface {
 // Users need to create an AcquireTokenDeviceCodeParameters instance and pass it in.
 //
 // Options:
+//   - [WithProgress]
 //   - [WithTenantID]
 func (pca Client) AcquireTokenByDeviceCode(ctx context.Context, scopes []string, opts ...AcquireByDeviceCodeOption) (DeviceCode, error) {
 	o := acquireTokenByDeviceCodeOptions{}
@@ -348,45 +1324,537 @@ func (pca Client) AcquireTokenByDeviceCode(ctx context.Context, scopes []string,
 	authParams.Scopes = scopes
 	authParams.AuthorizationType = authority.ATDeviceCode
 
-	dc, err := pca.base.Token.DeviceCode(ctx, authParams)
+	dc, err := pca.base.Token.DeviceCode(ctx, authParams, nil)
 	if err != nil {
 		return DeviceCode{}, err
 	}
 
-	return DeviceCode{Result: dc.Result, authParams: authParams, client: pca, dc: dc}, nil
+	o.progress.report(ctx, ProgressURLGenerated, dc.Result.VerificationURL)
+	return DeviceCode{Result: dc.Result, authParams: authParams, client: pca, dc: dc, progress: o.progress}, nil
 }
 
 // AcquireTokenByAuthCodeOptions contains the optional parameters used to acquire an access token using the authorization code flow.
 type AcquireTokenByAuthCodeOptions struct {
 	Challenge string
 
+	claims, tenantID string
+	idTokenPolicy    IDTokenPolicy
+	priority         RequestPriority
+	httpClient       ops.HTTPClient
+}
+
+// AcquireByAuthCodeOption is implemented by options for AcquireTokenByAuthCode
+type AcquireByAuthCodeOption interface {
+	acquireByAuthCodeOption()
+}
+
+// AcquireTokenByAuthCodeOption changes options inside AcquireTokenByAuthCodeOptions used in .AcquireTokenByAuthCode().
+type AcquireTokenByAuthCodeOption func(a *AcquireTokenByAuthCodeOptions)
+
+func (AcquireTokenByAuthCodeOption) acquireByAuthCodeOption() {}
+
+// WithChallenge allows you to provide a code for the .AcquireTokenByAuthCode() call.
+func WithChallenge(challenge string) interface {
+	AcquireByAuthCodeOption
+	options.CallOption
+} {
+	return struct {
+		AcquireByAuthCodeOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *AcquireTokenByAuthCodeOptions:
+					t.Challenge = challenge
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// AcquireTokenByAuthCode is a request to acquire a security token from the authority, using an authorization code.
+// The specified redirect URI must be the same URI that was used when the authorization code was requested.
+//
+// Options:
+//   - [WithChallenge]
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithRequestPriority]
+//   - [WithTenantID]
+func (pca Client) AcquireTokenByAuthCode(ctx context.Context, code string, redirectURI string, scopes []string, opts ...AcquireByAuthCodeOption) (AuthResult, error) {
+	o := AcquireTokenByAuthCodeOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return AuthResult{}, err
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
+
+	params := base.AcquireTokenAuthCodeParameters{
+		Scopes:        scopes,
+		Code:          code,
+		Challenge:     o.Challenge,
+		AppType:       accesstokens.ATPublic,
+		RedirectURI:   redirectURI,
+		TenantID:      o.tenantID,
+		Claims:        o.claims,
+		IDTokenPolicy: o.idTokenPolicy,
+	}
+
+	return pca.base.AcquireTokenByAuthCode(ctx, params)
+}
+
+// warmupOptions contains optional configuration for Warmup
+type warmupOptions struct {
+	tenantID string
+}
+
+// WarmupOption is implemented by options for Warmup
+type WarmupOption interface {
+	warmupOption()
+}
+
+// Warmup fetches and caches metadata the client needs to acquire tokens, such as the authority's endpoints and
+// instance metadata. When scopes isn't empty, Warmup also ensures the cache has a token for them for each cached
+// account, as AcquireTokenSilent would, redeeming an account's refresh token when the cache has no access token.
+// It returns the first error it encounters, which may require the user to authenticate interactively. Calling
+// Warmup when an application starts spares the application's first token request the latency of fetching
+// metadata and tokens.
+//
+// Options:
+//   - [WithTenantID]
+func (pca Client) Warmup(ctx context.Context, scopes []string, opts ...WarmupOption) error {
+	o := warmupOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return err
+	}
+	authParams, err := pca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return err
+	}
+	if err := pca.base.Warmup(ctx, authParams); err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	for _, account := range pca.Accounts() {
+		if _, err := pca.AcquireTokenSilent(ctx, scopes, WithSilentAccount(account), WithTenantID(o.tenantID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RateLimitStats counts the token requests admitted by a client's rate limiter.
+type RateLimitStats = base.RateLimitStats
+
+// RateLimitStats returns the number of token requests the client's rate limiter has allowed, delayed and rejected.
+// The counts are zero when the client has no rate limit. See [WithTokenRequestRateLimit].
+func (pca Client) RateLimitStats() RateLimitStats {
+	return pca.base.RateLimitStats()
+}
+
+// AuthorityMetadata returns the OpenID Connect metadata of the client's authority, such as its end_session_endpoint
+// and jwks_uri. The client caches this metadata and shares it with token requests, so calling this method
+// doesn't fetch the openid-configuration document again.
+func (pca Client) AuthorityMetadata(ctx context.Context) (AuthorityMetadata, error) {
+	return pca.base.AuthorityMetadata(ctx, pca.base.AuthParams)
+}
+
+// ResolvedEndpoints are the endpoints a client uses with its authority and the hosts of those endpoints.
+type ResolvedEndpoints = base.ResolvedEndpoints
+
+// resolvedEndpointsOptions contains optional configuration for ResolvedEndpoints
+type resolvedEndpointsOptions struct {
+	tenantID string
+}
+
+// ResolvedEndpointsOption is implemented by options for ResolvedEndpoints
+type ResolvedEndpointsOption interface {
+	resolvedEndpointsOption()
+}
+
+// ResolvedEndpoints returns the endpoints the client uses with its authority, after instance and tenant discovery
+// and with any endpoint overrides applied. Its Hosts field lists every host the client may send requests to, or
+// direct a browser to, for that authority, which helps applications provision firewall rules and egress proxies.
+// The client caches the endpoints, so calling this method discovers them only when the client hasn't already
+// done so.
+//
+// Options:
+//   - [WithTenantID]
+func (pca Client) ResolvedEndpoints(ctx context.Context, opts ...ResolvedEndpointsOption) (ResolvedEndpoints, error) {
+	o := resolvedEndpointsOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return ResolvedEndpoints{}, err
+	}
+	authParams, err := pca.base.AuthParams.WithTenant(o.tenantID)
+	if err != nil {
+		return ResolvedEndpoints{}, err
+	}
+	return pca.base.ResolvedEndpoints(ctx, authParams)
+}
+
+// UserInfo gets claims about the account's user, such as name and email, from the authority's OpenID Connect userinfo
+// endpoint. It authenticates to that endpoint with a token acquired silently, as by AcquireTokenSilent, and so returns
+// an error when the cache has no token for the account and can't refresh one.
+func (pca Client) UserInfo(ctx context.Context, account Account) (UserInfo, error) {
+	return pca.base.UserInfo(ctx, base.AcquireTokenSilentParameters{
+		Account:     account,
+		RequestType: accesstokens.ATPublic,
+	})
+}
+
+// Accounts gets all the accounts in the token cache.
+// If there are no accounts in the cache the returned slice is empty.
+func (pca Client) Accounts() []Account {
+	return pca.base.AllAccounts()
+}
+
+// AccountsByUsername returns the cached accounts whose PreferredUsername matches username, for example the username
+// a user typed to sign in. Usernames match when they're equal under Unicode case folding, so "JOSÉ@contoso.com"
+// matches "josé@contoso.com", after normalization by the function given to [WithUsernameNormalizer], if any. A user
+// has an account in each tenant in which the client has acquired a token for them.
+func (pca Client) AccountsByUsername(username string) []Account {
+	return pca.base.AccountsByUsername(username)
+}
+
+// RequiredInteractionFor reports whether err, returned by a non-interactive method such as AcquireTokenSilent,
+// means the user must authenticate interactively, for example to consent to a scope the application hasn't
+// requested before. The application should then call AcquireTokenInteractive, optionally with [WithAdditionalScopes]
+// to request consent to other scopes it will need.
+func (pca Client) RequiredInteractionFor(err error) bool {
+	var pce errors.PartialConsentError
+	if errors.As(err, &pce) {
+		return true
+	}
+	return oauth.IsInteractionRequired(err)
+}
+
+// RemoveAccount signs the account out and forgets account from token cache.
+func (pca Client) RemoveAccount(account Account) error {
+	pca.base.RemoveAccount(account)
+	return nil
+}
+
+// CacheSnapshot describes the content of a client's cache. It contains no secrets.
+type CacheSnapshot = base.CacheSnapshot
+
+// CachedToken describes a cached token. It doesn't contain the token.
+type CachedToken = base.CachedToken
+
+// CacheSnapshot describes the accounts and tokens in the client's cache, without secrets, to help diagnose
+// why silent authentication fails, for example because the cache has no token for a tenant or scope.
+func (pca Client) CacheSnapshot(ctx context.Context) CacheSnapshot {
+	return pca.base.CacheSnapshot(ctx)
+}
+
+// CacheKeys are the keys under which the cache stores an account's tokens.
+type CacheKeys = base.CacheKeys
+
+// cacheKeysOptions contains options for CacheKeys
+type cacheKeysOptions struct {
 	tenantID string
 }
 
-// AcquireByAuthCodeOption is implemented by options for AcquireTokenByAuthCode
-type AcquireByAuthCodeOption interface {
-	acquireByAuthCodeOption()
+// CacheKeysOption is implemented by options for CacheKeys
+type CacheKeysOption interface {
+	cacheKeysOption()
+}
+
+// CacheKeys returns the keys under which the client caches tokens for account and scopes. Operators can use
+// these to delete specific items from data stored by a [cache.ExportReplace], for example to revoke access
+// during an incident. The cache stores an access token under the scopes the authority granted, in the
+// order it returned them, so scopes must match that order.
+//
+// Options:
+//   - [WithTenantID]
+func (pca Client) CacheKeys(account Account, scopes []string, opts ...CacheKeysOption) (CacheKeys, error) {
+	o := cacheKeysOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return CacheKeys{}, err
+	}
+	return pca.base.CacheKeys(account, o.tenantID, scopes)
+}
+
+// signOutOptions contains optional configuration for SignOut
+type signOutOptions struct {
+	interactive           bool
+	postLogoutRedirectURI string
+}
+
+// SignOutOption is implemented by options for SignOut
+type SignOutOption interface {
+	signOutOption()
+}
+
+// WithInteractiveSignOut opens the logout URL in the default web browser, so the user signs out of the
+// authority's browser session as well as the application.
+func WithInteractiveSignOut() interface {
+	SignOutOption
+	options.CallOption
+} {
+	return struct {
+		SignOutOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *signOutOptions:
+					t.interactive = true
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// WithPostLogoutRedirectURI specifies where the authority redirects the browser after signing the user out.
+// It must be registered for the application.
+func WithPostLogoutRedirectURI(uri string) interface {
+	SignOutOption
+	options.CallOption
+} {
+	return struct {
+		SignOutOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *signOutOptions:
+					t.postLogoutRedirectURI = uri
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// SignOut removes the account from the token cache, as RemoveAccount does, and returns the authority's logout URL,
+// which ends the user's session with the authority. Use [WithInteractiveSignOut] to open that URL in the browser.
+// SignOut removes the account even when it returns an error because it can't get the logout URL, for example
+// because the authority is unreachable.
+//
+// Options:
+//   - [WithInteractiveSignOut]
+//   - [WithPostLogoutRedirectURI]
+func (pca Client) SignOut(ctx context.Context, account Account, opts ...SignOutOption) (string, error) {
+	o := signOutOptions{}
+	if err := options.ApplyOptions(&o, opts); err != nil {
+		return "", err
+	}
+	pca.base.RemoveAccount(account)
+	authParams, err := pca.base.AuthParams.WithTenant(account.Realm)
+	if err != nil {
+		// the account may be from an authority that doesn't support tenants, such as ADFS
+		authParams = pca.base.AuthParams
+	}
+	logoutURL, err := pca.base.LogoutURL(ctx, authParams, o.postLogoutRedirectURI)
+	if err != nil {
+		return "", err
+	}
+	if o.interactive {
+		if err := browserOpenURL(ctx, logoutURL); err != nil {
+			return logoutURL, err
+		}
+	}
+	return logoutURL, nil
+}
+
+// InteractiveAuthOptions contains the optional parameters used to acquire an access token for interactive auth code flow.
+type InteractiveAuthOptions struct {
+	// Used to specify a custom port for the local server.  http://localhost:portnumber
+	// All other URI components are ignored, unless the URI has a custom scheme such as
+	// msal{clientID}://auth, in which case the application must provide a redirect receiver.
+	RedirectURI string
+
+	claims, loginHint, tenantID string
+	prompt                      Prompt
+	webAuthn                    bool
+	idTokenPolicy               IDTokenPolicy
+	priority                    RequestPriority
+	httpClient                  ops.HTTPClient
+	fallback                    InteractiveFallback
+	progress                    progress
+	webview                     webview.Interactor
+	receiver                    func(context.Context, string) (string, error)
+	browser                     browserPreference
+	additionalScopes            []string
+	loopback                    Loopback
+}
+
+// Loopback identifies the loopback addresses on which AcquireTokenInteractive listens for the authority's redirect.
+// See [WithLoopback].
+type Loopback int
+
+const (
+	// LoopbackLocalhost listens on the address "localhost" resolves to. This is the default.
+	LoopbackLocalhost Loopback = iota
+	// LoopbackIPv4 listens on 127.0.0.1.
+	LoopbackIPv4
+	// LoopbackIPv6 listens on ::1.
+	LoopbackIPv6
+	// LoopbackDualStack listens on both 127.0.0.1 and ::1, so the browser can reach the listener
+	// whichever address it resolves "localhost" to.
+	LoopbackDualStack
+)
+
+// hosts returns the hosts the redirect server listens on
+func (l Loopback) hosts() ([]string, error) {
+	switch l {
+	case LoopbackLocalhost:
+		return []string{"localhost"}, nil
+	case LoopbackIPv4:
+		return []string{"127.0.0.1"}, nil
+	case LoopbackIPv6:
+		return []string{"::1"}, nil
+	case LoopbackDualStack:
+		return []string{"127.0.0.1", "::1"}, nil
+	}
+	return nil, fmt.Errorf("unknown loopback %d", l)
+}
+
+// AcquireInteractiveOption is implemented by options for AcquireTokenInteractive
+type AcquireInteractiveOption interface {
+	acquireInteractiveOption()
+}
+
+// InteractiveAuthOption changes options inside InteractiveAuthOptions used in .AcquireTokenInteractive().
+type InteractiveAuthOption func(*InteractiveAuthOptions)
+
+func (InteractiveAuthOption) acquireInteractiveOption() {}
+
+// WithLoginHint pre-populates the login prompt with a username.
+func WithLoginHint(username string) interface {
+	AcquireInteractiveOption
+	CreateAuthCodeURLOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		CreateAuthCodeURLOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *createAuthCodeURLOptions:
+					t.loginHint = username
+				case *InteractiveAuthOptions:
+					t.loginHint = username
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// Prompt specifies the sign-in experience of AcquireTokenInteractive. See [WithPrompt].
+type Prompt string
+
+const (
+	// PromptSelectAccount asks the user to choose an account, even when the browser has a session. It's the default.
+	PromptSelectAccount Prompt = "select_account"
+	// PromptLogin asks the user to enter credentials, even when the browser has a session.
+	PromptLogin Prompt = "login"
+	// PromptConsent asks the user to consent to the requested scopes, even when they have already consented.
+	PromptConsent Prompt = "consent"
+	// PromptNone doesn't interact with the user. Authentication fails when it requires interaction.
+	PromptNone Prompt = "none"
+)
+
+// WithPrompt sets the sign-in experience of interactive auth, for example to require users to enter credentials
+// instead of reusing a browser session. The default is [PromptSelectAccount].
+func WithPrompt(prompt Prompt) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.prompt = prompt
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
+
+// ProgressStage identifies a step of interactive or device code authentication. See [WithProgress].
+type ProgressStage int
+
+const (
+	// ProgressListenerStarted means the client started listening for the authority's redirect. The event's URL
+	// is the redirect URI. Only interactive auth in the system browser, with the default redirect handling,
+	// has this stage.
+	ProgressListenerStarted ProgressStage = iota + 1
+	// ProgressURLGenerated means the client created the URL at which the user authenticates. For interactive
+	// auth, that's the authorization URL the client opens in a browser or web view. For device code auth,
+	// it's the verification URL.
+	ProgressURLGenerated
+	// ProgressCodeRedeemed means the user authenticated and the client redeemed the authorization or device
+	// code for tokens.
+	ProgressCodeRedeemed
+	// ProgressTokenCached means the client cached the tokens. It's the last stage of a successful
+	// authentication.
+	ProgressTokenCached
+)
+
+// ProgressEvent describes the progress of interactive or device code authentication. See [WithProgress].
+type ProgressEvent struct {
+	Stage ProgressStage
+	// URL is the URL associated with the stage, if any
+	URL string
 }
 
-// AcquireTokenByAuthCodeOption changes options inside AcquireTokenByAuthCodeOptions used in .AcquireTokenByAuthCode().
-type AcquireTokenByAuthCodeOption func(a *AcquireTokenByAuthCodeOptions)
+// progress reports events to an application's progress callback, if it has one
+type progress func(context.Context, ProgressEvent)
 
-func (AcquireTokenByAuthCodeOption) acquireByAuthCodeOption() {}
+func (p progress) report(ctx context.Context, stage ProgressStage, url string) {
+	if p != nil {
+		p(ctx, ProgressEvent{Stage: stage, URL: url})
+	}
+}
 
-// WithChallenge allows you to provide a code for the .AcquireTokenByAuthCode() call.
-func WithChallenge(challenge string) interface {
-	AcquireByAuthCodeOption
+// WithProgress registers a callback the client calls as interactive or device code authentication progresses,
+// so applications can show users accurate progress. The client calls it synchronously, on the goroutine
+// authenticating, once per stage. Stages occur in the order of their [ProgressStage] values and some
+// authentications skip stages. For device code auth, the client reports [ProgressURLGenerated] before
+// AcquireTokenByDeviceCode returns and the remaining stages from [DeviceCode.AuthenticationResult].
+func WithProgress(callback func(context.Context, ProgressEvent)) interface {
+	AcquireByDeviceCodeOption
+	AcquireInteractiveOption
 	options.CallOption
 } {
 	return struct {
-		AcquireByAuthCodeOption
+		AcquireByDeviceCodeOption
+		AcquireInteractiveOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
 			func(a any) error {
 				switch t := a.(type) {
-				case *AcquireTokenByAuthCodeOptions:
-					t.Challenge = challenge
+				case *acquireTokenByDeviceCodeOptions:
+					t.progress = callback
+				case *InteractiveAuthOptions:
+					t.progress = callback
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -396,79 +1864,210 @@ func WithChallenge(challenge string) interface {
 	}
 }
 
-// AcquireTokenByAuthCode is a request to acquire a security token from the authority, using an authorization code.
-// The specified redirect URI must be the same URI that was used when the authorization code was requested.
-//
-// Options:
-//   - [WithChallenge]
-//   - [WithTenantID]
-func (pca Client) AcquireTokenByAuthCode(ctx context.Context, code string, redirectURI string, scopes []string, opts ...AcquireByAuthCodeOption) (AuthResult, error) {
-	o := AcquireTokenByAuthCodeOptions{}
-	if err := options.ApplyOptions(&o, opts); err != nil {
-		return AuthResult{}, err
-	}
-
-	params := base.AcquireTokenAuthCodeParameters{
-		Scopes:      scopes,
-		Code:        code,
-		Challenge:   o.Challenge,
-		AppType:     accesstokens.ATPublic,
-		RedirectURI: redirectURI,
-		TenantID:    o.tenantID,
+// WithRedirectURI uses the specified redirect URI for interactive auth.
+func WithRedirectURI(redirectURI string) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.RedirectURI = redirectURI
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
 	}
+}
 
-	return pca.base.AcquireTokenByAuthCode(ctx, params)
+// InteractiveFallback is an alternative means of authentication AcquireTokenInteractive uses when it can't open
+// a browser, for example on a headless machine or in an SSH session. Create one with [FallbackDeviceCode].
+type InteractiveFallback struct {
+	deviceCode func(context.Context, DeviceCodeResult) error
 }
 
-// Accounts gets all the accounts in the token cache.
-// If there are no accounts in the cache the returned slice is empty.
-func (pca Client) Accounts() []Account {
-	return pca.base.AllAccounts()
+// FallbackDeviceCode authenticates with the device code flow when AcquireTokenInteractive can't open a browser.
+// showCode receives the device code. The application should display its Message to the user, who completes
+// authentication on another device. Returning an error from showCode aborts authentication.
+func FallbackDeviceCode(showCode func(context.Context, DeviceCodeResult) error) InteractiveFallback {
+	return InteractiveFallback{deviceCode: showCode}
 }
 
-// RemoveAccount signs the account out and forgets account from token cache.
-func (pca Client) RemoveAccount(account Account) error {
-	pca.base.RemoveAccount(account)
-	return nil
+// WithInteractiveFallback configures how AcquireTokenInteractive authenticates when it can't open a browser.
+// By default, it returns an error in that case.
+func WithInteractiveFallback(fallback InteractiveFallback) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.fallback = fallback
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
 }
 
-// InteractiveAuthOptions contains the optional parameters used to acquire an access token for interactive auth code flow.
-type InteractiveAuthOptions struct {
-	// Used to specify a custom port for the local server.  http://localhost:portnumber
-	// All other URI components are ignored.
-	RedirectURI string
+// WithWebAuthn signals to the authority that the browser supports WebAuthn, so that it may offer passwordless
+// sign-in with passkeys, FIDO2 security keys and Windows Hello. Applications enforcing phishing-resistant MFA can
+// then check the authentication methods in the ID token of the AuthResult, IDToken.AuthenticationMethods.
+func WithWebAuthn() interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.webAuthn = true
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
 
-	loginHint, tenantID string
+// WithWebView authenticates in an embedded web view hosted by the application instead of the system browser.
+// When this option is set, AcquireTokenInteractive doesn't start a local redirect server. Unless [WithRedirectURI]
+// specifies another redirect URI, it uses https://login.microsoftonline.com/common/oauth2/nativeclient, which
+// must be registered for the application.
+func WithWebView(interactor webview.Interactor) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.webview = interactor
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
 }
 
-// AcquireInteractiveOption is implemented by options for AcquireTokenInteractive
-type AcquireInteractiveOption interface {
-	acquireInteractiveOption()
+// WithAdditionalScopes requests the user's consent to scopes the application will need later, in addition to the scopes
+// of the requested token. The access token AcquireTokenInteractive returns is valid only for the requested scopes,
+// however the application can later acquire tokens for the additional scopes silently, without prompting the user
+// for consent again.
+func WithAdditionalScopes(scopes ...string) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.additionalScopes = append(t.additionalScopes, scopes...)
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
 }
 
-// InteractiveAuthOption changes options inside InteractiveAuthOptions used in .AcquireTokenInteractive().
-type InteractiveAuthOption func(*InteractiveAuthOptions)
+// WithBrowserPreference specifies the browser AcquireTokenInteractive opens, for example when conditional access
+// policy requires a managed browser. The default is [SystemDefault]. Interactive authentication returns an error
+// when it can't launch the specified browser. On Windows, only [Edge] is supported, without [WithPrivateBrowsing].
+func WithBrowserPreference(browser Browser) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.browser.browser = browser
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
 
-func (InteractiveAuthOption) acquireInteractiveOption() {}
+// WithLoopback specifies the loopback addresses on which AcquireTokenInteractive listens for the authority's redirect.
+// The default, [LoopbackLocalhost], can fail on hosts whose "localhost" name resolution doesn't match the browser's,
+// for example IPv6-only systems. With [LoopbackIPv4] or [LoopbackIPv6] the redirect URI has an IP address host, such as
+// http://127.0.0.1:port, which must be registered for the application. This option doesn't apply to [WithWebView] or
+// [WithRedirectReceiver], which don't listen for redirects.
+func WithLoopback(loopback Loopback) interface {
+	AcquireInteractiveOption
+	options.CallOption
+} {
+	return struct {
+		AcquireInteractiveOption
+		options.CallOption
+	}{
+		CallOption: options.NewCallOption(
+			func(a any) error {
+				switch t := a.(type) {
+				case *InteractiveAuthOptions:
+					t.loopback = loopback
+				default:
+					return fmt.Errorf("unexpected options type %T", a)
+				}
+				return nil
+			},
+		),
+	}
+}
 
-// WithLoginHint pre-populates the login prompt with a username.
-func WithLoginHint(username string) interface {
+// WithPrivateBrowsing opens the browser in private mode (InPrivate for Edge, incognito for Chrome), so
+// authentication doesn't use or affect the user's browser sessions. It requires [WithBrowserPreference].
+func WithPrivateBrowsing() interface {
 	AcquireInteractiveOption
-	CreateAuthCodeURLOption
 	options.CallOption
 } {
 	return struct {
 		AcquireInteractiveOption
-		CreateAuthCodeURLOption
 		options.CallOption
 	}{
 		CallOption: options.NewCallOption(
 			func(a any) error {
 				switch t := a.(type) {
-				case *createAuthCodeURLOptions:
-					t.loginHint = username
 				case *InteractiveAuthOptions:
-					t.loginHint = username
+					t.browser.private = true
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -478,8 +2077,12 @@ func WithLoginHint(username string) interface {
 	}
 }
 
-// WithRedirectURI uses the specified redirect URI for interactive auth.
-func WithRedirectURI(redirectURI string) interface {
+// WithRedirectReceiver enables redirect URIs having a custom scheme, such as msal{clientID}://auth, for applications
+// whose registration doesn't allow a loopback redirect URI. Specify the redirect URI with [WithRedirectURI].
+// AcquireTokenInteractive opens the system browser as usual but doesn't start a local redirect server. Instead it
+// calls receive, which must block until the operating system delivers the authority's redirect to the application,
+// then return the full redirect URL including its query string. receive should return an error when ctx is done.
+func WithRedirectReceiver(receive func(ctx context.Context, redirectURI string) (redirectURL string, err error)) interface {
 	AcquireInteractiveOption
 	options.CallOption
 } {
@@ -491,7 +2094,7 @@ func WithRedirectURI(redirectURI string) interface {
 			func(a any) error {
 				switch t := a.(type) {
 				case *InteractiveAuthOptions:
-					t.RedirectURI = redirectURI
+					t.receiver = receive
 				default:
 					return fmt.Errorf("unexpected options type %T", a)
 				}
@@ -505,14 +2108,31 @@ func WithRedirectURI(redirectURI string) interface {
 // https://docs.microsoft.com/en-us/azure/active-directory/develop/msal-authentication-flows#interactive-and-non-interactive-authentication
 //
 // Options:
+//   - [WithAdditionalScopes]
+//   - [WithBrowserPreference]
+//   - [WithClaims]
+//   - [WithIDTokenPolicy]
+//   - [WithInteractiveFallback]
 //   - [WithLoginHint]
+//   - [WithLoopback]
+//   - [WithPrivateBrowsing]
+//   - [WithProgress]
+//   - [WithPrompt]
+//   - [WithRedirectReceiver]
 //   - [WithRedirectURI]
+//   - [WithRequestPriority]
 //   - [WithTenantID]
+//   - [WithWebAuthn]
+//   - [WithWebView]
 func (pca Client) AcquireTokenInteractive(ctx context.Context, scopes []string, opts ...AcquireInteractiveOption) (AuthResult, error) {
 	o := InteractiveAuthOptions{}
 	if err := options.ApplyOptions(&o, opts); err != nil {
 		return AuthResult{}, err
 	}
+	if o.browser.private && o.browser.browser == SystemDefault {
+		return AuthResult{}, errors.New("WithPrivateBrowsing requires a specific browser, set with WithBrowserPreference")
+	}
+	ctx = ops.WithHTTPClient(ops.WithPriority(ctx, o.priority), o.httpClient)
 	// the code verifier is a random 32-byte sequence that's been base-64 encoded without padding.
 	// it's used to prevent MitM attacks during auth code flow, see https://tools.ietf.org/html/rfc7636
 	cv, challenge, err := codeVerifier()
@@ -534,11 +2154,43 @@ func (pca Client) AcquireTokenInteractive(ctx context.Context, scopes []string,
 	authParams.AuthorizationType = authority.ATInteractive
 	authParams.CodeChallenge = challenge
 	authParams.CodeChallengeMethod = "S256"
+	authParams.Claims = o.claims
 	authParams.LoginHint = o.loginHint
 	authParams.State = uuid.New().String()
-	authParams.Prompt = "select_account"
-	res, err := pca.browserLogin(ctx, redirectURL, authParams)
+	authParams.Prompt = string(PromptSelectAccount)
+	if o.prompt != "" {
+		authParams.Prompt = string(o.prompt)
+	}
+	authParams.WebAuthn = o.webAuthn
+	authParams.IDTokenPolicy = o.idTokenPolicy
+	// the authorization request includes additional scopes so the user consents to them now; the
+	// token request includes only the scopes the caller requested a token for
+	loginParams := authParams
+	if len(o.additionalScopes) > 0 {
+		loginParams.Scopes = append(append([]string{}, scopes...), o.additionalScopes...)
+	}
+	var res interactiveAuthResult
+	switch {
+	case o.webview != nil && o.receiver != nil:
+		return AuthResult{}, errors.New("WithWebView and WithRedirectReceiver are mutually exclusive")
+	case o.webview != nil:
+		res, err = pca.webviewLogin(ctx, o.webview, redirectURL, loginParams, o.progress)
+	case o.receiver != nil:
+		if redirectURL == nil {
+			return AuthResult{}, errors.New("WithRedirectReceiver requires a redirect URI, specified with WithRedirectURI")
+		}
+		res, err = pca.webviewLogin(ctx, redirectReceiver{browser: o.browser, receive: o.receiver}, redirectURL, loginParams, o.progress)
+	default:
+		if redirectURL != nil && redirectURL.Scheme != "" && redirectURL.Scheme != "http" && redirectURL.Scheme != "https" {
+			return AuthResult{}, fmt.Errorf(`redirect URI scheme "%s" requires WithRedirectReceiver`, redirectURL.Scheme)
+		}
+		res, err = pca.browserLogin(ctx, redirectURL, loginParams, o.browser, o.loopback, o.progress)
+	}
 	if err != nil {
+		var be browserError
+		if o.fallback.deviceCode != nil && errors.As(err, &be) {
+			return pca.deviceCodeFallback(ctx, scopes, o)
+		}
 		return AuthResult{}, err
 	}
 	authParams.Redirecturi = res.redirectURI
@@ -552,18 +2204,61 @@ func (pca Client) AcquireTokenInteractive(ctx context.Context, scopes []string,
 	if err != nil {
 		return AuthResult{}, err
 	}
+	o.progress.report(ctx, ProgressCodeRedeemed, "")
 
-	return pca.base.AuthResultFromToken(ctx, authParams, token, true)
+	ar, err := pca.base.AuthResultFromToken(ctx, authParams, token, true)
+	if err == nil {
+		o.progress.report(ctx, ProgressTokenCached, "")
+	}
+	if ar.AccessToken != "" && res.params != nil {
+		ar.AuthorizationResponse = url.Values{}
+		for k, v := range res.params {
+			if k != "code" {
+				ar.AuthorizationResponse[k] = v
+			}
+		}
+	}
+	return ar, err
+}
+
+// deviceCodeFallback authenticates with the device code flow after AcquireTokenInteractive failed to open a browser
+func (pca Client) deviceCodeFallback(ctx context.Context, scopes []string, o InteractiveAuthOptions) (AuthResult, error) {
+	dc, err := pca.AcquireTokenByDeviceCode(ctx, scopes, WithTenantID(o.tenantID), WithProgress(o.progress))
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if err = o.fallback.deviceCode(ctx, dc.Result); err != nil {
+		return AuthResult{}, err
+	}
+	return dc.AuthenticationResult(ctx)
+}
+
+// browserError is returned by browserLogin when it can't open a browser
+type browserError struct {
+	err error
+}
+
+func (e browserError) Error() string {
+	return e.err.Error()
+}
+
+func (e browserError) Unwrap() error {
+	return e.err
 }
 
 type interactiveAuthResult struct {
-	authCode    string
+	authCode string
+	// params are the parameters of the authority's redirect
+	params      url.Values
 	redirectURI string
 }
 
 // provides a test hook to simulate opening a browser
-var browserOpenURL = func(authURL string) error {
-	return browser.OpenURL(authURL)
+var browserOpenURL = func(ctx context.Context, authURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openURL(authURL)
 }
 
 // parses the port number from the provided URL.
@@ -580,25 +2275,31 @@ func parsePort(u *url.URL) (int, error) {
 }
 
 // browserLogin launches the system browser for interactive login
-func (pca Client) browserLogin(ctx context.Context, redirectURI *url.URL, params authority.AuthParams) (interactiveAuthResult, error) {
+func (pca Client) browserLogin(ctx context.Context, redirectURI *url.URL, params authority.AuthParams, bp browserPreference, lb Loopback, p progress) (interactiveAuthResult, error) {
 	// start local redirect server so login can call us back
 	port, err := parsePort(redirectURI)
 	if err != nil {
 		return interactiveAuthResult{}, err
 	}
-	srv, err := local.New(params.State, port)
+	hosts, err := lb.hosts()
+	if err != nil {
+		return interactiveAuthResult{}, err
+	}
+	srv, err := local.New(params.State, port, hosts...)
 	if err != nil {
 		return interactiveAuthResult{}, err
 	}
 	defer srv.Shutdown()
+	p.report(ctx, ProgressListenerStarted, srv.Addr)
 	params.Scopes = accesstokens.AppendDefaultScopes(params)
-	authURL, err := pca.base.AuthCodeURL(ctx, params.ClientID, srv.Addr, params.Scopes, params)
+	authURL, err := pca.base.AuthCodeURL(ctx, params.ClientID, srv.Addr, params.Scopes, params, nil)
 	if err != nil {
 		return interactiveAuthResult{}, err
 	}
+	p.report(ctx, ProgressURLGenerated, authURL)
 	// open browser window so user can select credentials
-	if err := browserOpenURL(authURL); err != nil {
-		return interactiveAuthResult{}, err
+	if err := bp.open(ctx, authURL); err != nil {
+		return interactiveAuthResult{}, browserError{err}
 	}
 	// now wait until the logic calls us back
 	res := srv.Result(ctx)
@@ -607,10 +2308,84 @@ func (pca Client) browserLogin(ctx context.Context, redirectURI *url.URL, params
 	}
 	return interactiveAuthResult{
 		authCode:    res.Code,
+		params:      res.Params,
 		redirectURI: srv.Addr,
 	}, nil
 }
 
+// nativeClientRedirectURI is the default redirect URI for authentication in an embedded web view
+const nativeClientRedirectURI = "https://login.microsoftonline.com/common/oauth2/nativeclient"
+
+// webviewLogin directs an application-provided web view through interactive login. It also handles custom
+// redirect schemes, through a redirectReceiver.
+func (pca Client) webviewLogin(ctx context.Context, wv webview.Interactor, redirectURI *url.URL, params authority.AuthParams, p progress) (interactiveAuthResult, error) {
+	redirect := nativeClientRedirectURI
+	if redirectURI != nil {
+		redirect = redirectURI.String()
+	}
+	params.Scopes = accesstokens.AppendDefaultScopes(params)
+	authURL, err := pca.base.AuthCodeURL(ctx, params.ClientID, redirect, params.Scopes, params, nil)
+	if err != nil {
+		return interactiveAuthResult{}, err
+	}
+	p.report(ctx, ProgressURLGenerated, authURL)
+	if err := wv.Navigate(ctx, authURL); err != nil {
+		return interactiveAuthResult{}, err
+	}
+	res, err := wv.WaitForRedirect(ctx, redirect)
+	if err != nil {
+		return interactiveAuthResult{}, err
+	}
+	code, q, err := authCodeFromRedirect(res, params.State)
+	if err != nil {
+		return interactiveAuthResult{}, err
+	}
+	return interactiveAuthResult{authCode: code, params: q, redirectURI: redirect}, nil
+}
+
+// redirectReceiver adapts a redirect receiver callback to webview.Interactor. It
+// opens the system browser and lets the application receive the redirect.
+type redirectReceiver struct {
+	browser browserPreference
+	receive func(context.Context, string) (string, error)
+}
+
+func (r redirectReceiver) Navigate(ctx context.Context, authURL string) error {
+	if err := r.browser.open(ctx, authURL); err != nil {
+		return browserError{err}
+	}
+	return nil
+}
+
+func (r redirectReceiver) WaitForRedirect(ctx context.Context, prefix string) (string, error) {
+	return r.receive(ctx, prefix)
+}
+
+// authCodeFromRedirect validates the authority's redirect to redirectURL and returns the authorization code it carries
+// along with all its parameters
+func authCodeFromRedirect(redirectURL, state string) (string, url.Values, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", nil, err
+	}
+	q := u.Query()
+	if e := q.Get("error"); e != "" {
+		return "", nil, fmt.Errorf("authentication failed: error %s error_description: %s", e, q.Get("error_description"))
+	}
+	switch respState := q.Get("state"); respState {
+	case state:
+	case "":
+		return "", nil, errors.New("server didn't send OAuth state")
+	default:
+		return "", nil, fmt.Errorf("mismatched OAuth state, req(%s), resp(%s)", state, respState)
+	}
+	code := q.Get("code")
+	if code == "" {
+		return "", nil, errors.New("authorization code missing in query string")
+	}
+	return code, q, nil
+}
+
 // creates a code verifier string along with its SHA256 hash which
 // is used as the challenge when requesting an auth code.
 // used in interactive auth flow for PKCE.
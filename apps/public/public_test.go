@@ -5,21 +5,34 @@ package public
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+	internalTime "github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/json/types/time"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/mock"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/fake"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/accesstokens"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/internal/oauth/ops/authority"
 )
 
 var tokenScope = []string{"the_scope"}
 
-func fakeBrowserOpenURL(authURL string) error {
+func fakeBrowserOpenURL(ctx context.Context, authURL string) error {
 	// we will get called with the URL for requesting an auth code
 	u, err := url.Parse(authURL)
 	if err != nil {
@@ -73,6 +86,1381 @@ func TestAcquireTokenInteractive(t *testing.T) {
 	}
 }
 
+func TestAuthorizationResponse(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := url.Values{
+			"cloud_instance_name": {"microsoftonline.com"},
+			"code":                {"code"},
+			"session_state":       {"session"},
+			"state":               {u.Query().Get("state")},
+		}
+		resp, err := http.DefaultClient.Get(u.Query().Get("redirect_uri") + "/?" + q.Encode())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{
+		AccessToken: accesstokens.TokenResponse{
+			AccessToken:   "at",
+			ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+			GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+		},
+	}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	ar, err := client.AcquireTokenInteractive(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"cloud_instance_name": "microsoftonline.com", "session_state": "session"} {
+		if actual := ar.AuthorizationResponse.Get(k); actual != v {
+			t.Errorf("expected %s %q, got %q", k, v, actual)
+		}
+	}
+	if ar.AuthorizationResponse.Get("state") == "" {
+		t.Error("expected the state")
+	}
+	if _, ok := ar.AuthorizationResponse["code"]; ok {
+		t.Error("the authorization response shouldn't include the code")
+	}
+}
+
+func TestInteractiveFallback(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(context.Context, string) error { return errors.New("no browser") }
+
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprint(fallback), func(t *testing.T) {
+			client, err := New("client-id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			userCode := "user-code"
+			client.base.Token.AccessTokens = &fake.AccessTokens{
+				DeviceCode: accesstokens.DeviceCodeResult{UserCode: userCode, ExpiresOn: time.Now().Add(time.Minute)},
+				Result:     []error{nil},
+			}
+			client.base.Token.Authority = &fake.Authority{}
+			client.base.Token.Resolver = &fake.ResolveEndpoints{}
+			shown := false
+			opts := []AcquireInteractiveOption{}
+			if fallback {
+				opts = append(opts, WithInteractiveFallback(FallbackDeviceCode(func(ctx context.Context, dc DeviceCodeResult) error {
+					if dc.UserCode != userCode {
+						t.Fatalf("expected user code %q, got %q", userCode, dc.UserCode)
+					}
+					shown = true
+					return nil
+				})))
+			}
+			_, err = client.AcquireTokenInteractive(context.Background(), tokenScope, opts...)
+			if fallback {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !shown {
+					t.Fatal("fallback didn't show the device code")
+				}
+			} else if err == nil {
+				t.Fatal("expected an error because no browser could be opened")
+			}
+		})
+	}
+}
+
+// fakeWebView completes authentication by redirecting with a code or, when setting badState, a mismatched state
+type fakeWebView struct {
+	authURL  string
+	badState bool
+}
+
+func (f *fakeWebView) Navigate(ctx context.Context, authURL string) error {
+	f.authURL = authURL
+	return nil
+}
+
+func (f *fakeWebView) WaitForRedirect(ctx context.Context, prefix string) (string, error) {
+	u, err := url.Parse(f.authURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if redirect := q.Get("redirect_uri"); redirect != prefix {
+		return "", fmt.Errorf("expected prefix %q, got %q", redirect, prefix)
+	}
+	state := q.Get("state")
+	if f.badState {
+		state = "not-" + state
+	}
+	return fmt.Sprintf("%s?code=fake_auth_code&state=%s", prefix, state), nil
+}
+
+func TestWebView(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(context.Context, string) error {
+		return errors.New("AcquireTokenInteractive shouldn't open a browser")
+	}
+
+	for _, badState := range []bool{false, true} {
+		t.Run(fmt.Sprint(badState), func(t *testing.T) {
+			client, err := New("client-id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.base.Token.AccessTokens = &fake.AccessTokens{}
+			client.base.Token.Authority = &fake.Authority{}
+			client.base.Token.Resolver = &fake.ResolveEndpoints{}
+			wv := &fakeWebView{badState: badState}
+			_, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithWebView(wv))
+			if badState {
+				if err == nil {
+					t.Fatal("expected an error because of the mismatched state")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(wv.authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := u.Query().Get("redirect_uri"); actual != nativeClientRedirectURI {
+				t.Fatalf("expected redirect URI %q, got %q", nativeClientRedirectURI, actual)
+			}
+		})
+	}
+}
+
+func TestWebAuthn(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(context.Context, string) error {
+		return errors.New("AcquireTokenInteractive shouldn't open a browser")
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"amr":["fido","mfa"]}`))
+	idToken := accesstokens.IDToken{}
+	if err := idToken.UnmarshalJSON([]byte("header." + payload + ".signature")); err != nil {
+		t.Fatal(err)
+	}
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{AccessToken: accesstokens.TokenResponse{AccessToken: "at", IDToken: idToken}}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	wv := &fakeWebView{}
+	ar, err := client.AcquireTokenInteractive(context.Background(), tokenScope, WithPrompt(PromptLogin), WithWebAuthn(), WithWebView(wv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(wv.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := u.Query().Get("prompt"); actual != "login" {
+		t.Errorf(`expected prompt "login", got %q`, actual)
+	}
+	if actual := u.Query().Get("webauthn"); actual != "1" {
+		t.Errorf(`expected webauthn "1", got %q`, actual)
+	}
+	if amr := ar.IDToken.AuthenticationMethods; len(amr) != 2 || amr[0] != "fido" || amr[1] != "mfa" {
+		t.Errorf("unexpected authentication methods %v", amr)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(context.Context, string) error {
+		return errors.New("AcquireTokenInteractive shouldn't open a browser")
+	}
+
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{AccessToken: accesstokens.TokenResponse{AccessToken: "at"}}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	events := []ProgressEvent{}
+	progress := WithProgress(func(ctx context.Context, e ProgressEvent) {
+		events = append(events, e)
+	})
+	wv := &fakeWebView{}
+	if _, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithWebView(wv), progress); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProgressEvent{
+		{Stage: ProgressURLGenerated, URL: wv.authURL},
+		{Stage: ProgressCodeRedeemed},
+		{Stage: ProgressTokenCached},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+
+	events = nil
+	wv = &fakeWebView{badState: true}
+	if _, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithWebView(wv), progress); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(events) != 1 || events[0].Stage != ProgressURLGenerated {
+		t.Fatalf("expected only a ProgressURLGenerated event, got %v", events)
+	}
+}
+
+func TestADFS(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(context.Context, string) error {
+		return errors.New("AcquireTokenInteractive shouldn't open a browser")
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"subject"}`))
+	idToken := accesstokens.IDToken{}
+	if err := idToken.UnmarshalJSON([]byte("header." + payload + ".signature")); err != nil {
+		t.Fatal(err)
+	}
+	client, err := New("client-id", WithAuthority("https://fake_adfs/adfs/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{AccessToken: accesstokens.TokenResponse{AccessToken: "at", IDToken: idToken}}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{
+		Endpoints: authority.NewEndpoints("https://fake_adfs/adfs/oauth2/authorize", "https://fake_adfs/adfs/oauth2/token", "https://fake_adfs/adfs", "fake_adfs"),
+	}
+	wv := &fakeWebView{}
+	ar, err := client.AcquireTokenInteractive(context.Background(), []string{"https://contoso.com/api/read"}, WithWebView(wv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(wv.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := u.Query().Get("resource"); actual != "https://contoso.com/api" {
+		t.Errorf(`expected resource "https://contoso.com/api", got %q`, actual)
+	}
+	if actual := u.Query().Get("scope"); actual != "read openid offline_access profile" {
+		t.Errorf(`expected scope "read openid offline_access profile", got %q`, actual)
+	}
+	if actual := ar.Account.HomeAccountID; actual != "subject" {
+		t.Errorf(`expected home account ID "subject", got %q`, actual)
+	}
+}
+
+// failingHTTPClient fails the test when the client sends any request
+type failingHTTPClient struct {
+	t *testing.T
+}
+
+func (f failingHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request to %s", r.URL)
+	return nil, errors.New("no requests allowed")
+}
+
+func (failingHTTPClient) CloseIdleConnections() {}
+
+func TestOfflineInstanceDiscovery(t *testing.T) {
+	for _, test := range []struct {
+		authority, endpointHost string
+	}{
+		{authority: "https://login.microsoftonline.com/tenant", endpointHost: "login.microsoftonline.com"},
+		{authority: "https://login.windows.net/tenant", endpointHost: "login.microsoftonline.com"},
+		{authority: "https://login.microsoftonline.us/tenant", endpointHost: "login.microsoftonline.us"},
+	} {
+		t.Run(test.authority, func(t *testing.T) {
+			client, err := New("client-id", WithAuthority(test.authority), WithOfflineInstanceDiscovery(true), WithHTTPClient(failingHTTPClient{t}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = client.Warmup(context.Background(), nil); err != nil {
+				t.Fatal(err)
+			}
+			u, err := client.CreateAuthCodeURL(context.Background(), "client-id", "https://localhost", tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("https://%s/tenant/oauth2/v2.0/authorize?", test.endpointHost); !strings.HasPrefix(u, expected) {
+				t.Errorf("expected %q to begin with %q", u, expected)
+			}
+			md, err := client.AuthorityMetadata(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("https://%s/tenant/oauth2/v2.0/token", test.endpointHost); md.TokenEndpoint != expected {
+				t.Errorf("expected token endpoint %q, got %q", expected, md.TokenEndpoint)
+			}
+		})
+	}
+}
+
+func TestWithInstanceDiscoveryEndpoint(t *testing.T) {
+	host, tenant, endpoint := "login.example.com", "tenant", "https://discovery.example.com/instance"
+	mockClient := mock.Client{}
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", host, tenant)), WithInstanceDiscoveryEndpoint(endpoint), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetInstanceDiscoveryBody(host, tenant)),
+		mock.WithCallback(func(r *http.Request) {
+			if actual := r.URL.Scheme + "://" + r.URL.Host + r.URL.Path; actual != endpoint {
+				t.Errorf("expected a request to %q, got %q", endpoint, actual)
+			}
+		}),
+	)
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(host, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", "", "", "", 3600)))
+	if _, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New("client-id", WithInstanceDiscoveryEndpoint("http://discovery.example.com/instance")); err == nil {
+		t.Fatal("expected an error for an endpoint that doesn't use https")
+	}
+}
+
+func TestRedirectReceiver(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	authURL := ""
+	browserOpenURL = func(ctx context.Context, u string) error {
+		authURL = u
+		return nil
+	}
+	redirectURI := "msalclient-id://auth"
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+
+	_, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithRedirectURI(redirectURI))
+	if err == nil {
+		t.Fatal("expected an error because the custom scheme requires a redirect receiver")
+	}
+
+	receive := func(ctx context.Context, prefix string) (string, error) {
+		if prefix != redirectURI {
+			return "", fmt.Errorf("expected redirect URI %q, got %q", redirectURI, prefix)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return "", err
+		}
+		if actual := u.Query().Get("redirect_uri"); actual != redirectURI {
+			return "", fmt.Errorf("expected redirect_uri %q, got %q", redirectURI, actual)
+		}
+		return fmt.Sprintf("%s?code=fake_auth_code&state=%s", prefix, u.Query().Get("state")), nil
+	}
+	_, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithRedirectURI(redirectURI), WithRedirectReceiver(receive))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	for _, test := range []struct {
+		loopback     Loopback
+		expectedHost string
+	}{
+		{loopback: LoopbackLocalhost, expectedHost: "localhost"},
+		{loopback: LoopbackIPv4, expectedHost: "127.0.0.1"},
+		{loopback: LoopbackIPv6, expectedHost: "::1"},
+		{loopback: LoopbackDualStack, expectedHost: "localhost"},
+	} {
+		t.Run(test.expectedHost, func(t *testing.T) {
+			if test.loopback == LoopbackIPv6 || test.loopback == LoopbackDualStack {
+				l, err := net.Listen("tcp", "[::1]:0")
+				if err != nil {
+					t.Skip("IPv6 loopback isn't available")
+				}
+				l.Close()
+			}
+			browserOpenURL = func(ctx context.Context, authURL string) error {
+				u, err := url.Parse(authURL)
+				if err != nil {
+					return err
+				}
+				redirect, err := url.Parse(u.Query().Get("redirect_uri"))
+				if err != nil {
+					return err
+				}
+				if actual := redirect.Hostname(); actual != test.expectedHost {
+					t.Errorf("expected redirect URI host %q, got %q", test.expectedHost, actual)
+				}
+				return fakeBrowserOpenURL(ctx, authURL)
+			}
+			if _, err := client.AcquireTokenInteractive(context.Background(), tokenScope, WithLoopback(test.loopback)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBrowserPreference(t *testing.T) {
+	realRunBrowser := runBrowser
+	defer func() { runBrowser = realRunBrowser }()
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{}
+	client.base.Token.Authority = &fake.Authority{}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	for _, test := range []struct {
+		browser Browser
+		flag    string
+	}{
+		{browser: Edge, flag: "--inprivate"},
+		{browser: Chrome, flag: "--incognito"},
+	} {
+		for _, private := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d/%v", test.browser, private), func(t *testing.T) {
+				if _, _, err := browserCommand(runtime.GOOS, browserPreference{browser: test.browser, private: private}, ""); err != nil {
+					t.Skipf("unsupported on %s: %s", runtime.GOOS, err)
+				}
+				called := false
+				runBrowser = func(ctx context.Context, name string, args ...string) error {
+					called = true
+					expected, _, err := browserCommand(runtime.GOOS, browserPreference{browser: test.browser}, "")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if name != expected {
+						t.Fatalf("expected %q, got %q", expected, name)
+					}
+					hasFlag := false
+					for _, arg := range args {
+						hasFlag = hasFlag || arg == test.flag
+					}
+					if hasFlag != private {
+						t.Fatalf("unexpected args %v", args)
+					}
+					// on Windows, the URL has Edge's protocol prefix
+					authURL := strings.TrimPrefix(args[len(args)-1], "microsoft-edge:")
+					return fakeBrowserOpenURL(ctx, authURL)
+				}
+				opts := []AcquireInteractiveOption{WithBrowserPreference(test.browser)}
+				if private {
+					opts = append(opts, WithPrivateBrowsing())
+				}
+				if _, err := client.AcquireTokenInteractive(context.Background(), tokenScope, opts...); err != nil {
+					t.Fatal(err)
+				}
+				if !called {
+					t.Fatal("preferred browser wasn't launched")
+				}
+			})
+		}
+	}
+	// private browsing without a specific browser is a configuration error, which shouldn't trigger a fallback
+	fallback := WithInteractiveFallback(FallbackDeviceCode(func(context.Context, DeviceCodeResult) error {
+		t.Fatal("unexpected fallback")
+		return nil
+	}))
+	if _, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithPrivateBrowsing(), fallback); err == nil {
+		t.Fatal("expected an error because private browsing requires a specific browser")
+	}
+}
+
+func TestBrowserCommandWindows(t *testing.T) {
+	// cmd.exe would expand %PATH% and interpret the "&", so the URL mustn't go through it
+	authURL := "https://localhost/authorize?a=%PATH%&b=%7C^<>|"
+	name, args, err := browserCommand("windows", browserPreference{browser: Edge}, authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "rundll32" {
+		t.Fatalf(`expected "rundll32", got %q`, name)
+	}
+	if expected := []string{"url.dll,FileProtocolHandler", "microsoft-edge:" + authURL}; !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+	for _, b := range []browserPreference{{browser: Chrome}, {browser: Edge, private: true}} {
+		if _, _, err := browserCommand("windows", b, authURL); err == nil {
+			t.Errorf("expected an error for %+v", b)
+		}
+	}
+}
+
+func TestAdditionalScopes(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	additional := "additional_scope"
+	browserOpenURL = func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		if scope := u.Query().Get("scope"); !strings.Contains(scope, tokenScope[0]) || !strings.Contains(scope, additional) {
+			t.Fatalf("unexpected scope %q", scope)
+		}
+		return fakeBrowserOpenURL(ctx, authURL)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("*", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if scope := r.PostForm.Get("scope"); strings.Contains(scope, additional) {
+				t.Fatalf("token request shouldn't include additional scopes: %q", scope)
+			}
+		}),
+	)
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenInteractive(context.Background(), tokenScope, WithAdditionalScopes(additional)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	for _, opt := range []Option{
+		WithAuthorizationEndpoint("http://localhost/authorize"),
+		WithTokenEndpoint("https://proxy/token?a=b"),
+		WithTokenEndpoint("/token"),
+	} {
+		if _, err := New("client-id", opt); err == nil {
+			t.Error("expected an error for an invalid endpoint")
+		}
+	}
+
+	const authzEndpoint, tokenEndpoint = "https://proxy/authorize", "https://proxy/token"
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	browserOpenURL = func(ctx context.Context, authURL string) error {
+		if !strings.HasPrefix(authURL, authzEndpoint+"?") {
+			t.Errorf("unexpected authorization URL %q", authURL)
+		}
+		return fakeBrowserOpenURL(ctx, authURL)
+	}
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("*", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if u := r.URL.String(); u != tokenEndpoint {
+				t.Errorf("expected a token request to %q, got %q", tokenEndpoint, u)
+			}
+		}),
+	)
+	client, err := New("client-id",
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithAuthorizationEndpoint(authzEndpoint),
+		WithTokenEndpoint(tokenEndpoint),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenInteractive(context.Background(), tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client caches tokens under the authority's host
+	if ar.Account.Environment != lmo {
+		t.Errorf("expected environment %q, got %q", lmo, ar.Account.Environment)
+	}
+	md, err := client.AuthorityMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.AuthorizationEndpoint != authzEndpoint || md.TokenEndpoint != tokenEndpoint {
+		t.Errorf("metadata doesn't reflect the overrides: %+v", md)
+	}
+}
+
+func TestAuthorityMetadata(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	authority := fmt.Sprintf("https://%s/%s", lmo, tenant)
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	client, err := New("client-id", WithAuthority(authority), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := client.AuthorityMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for actual, expected := range map[string]string{
+		md.AuthorizationEndpoint: authority + "/oauth2/v2.0/authorize",
+		md.EndSessionEndpoint:    authority + "/oauth2/v2.0/logout",
+		md.Issuer:                authority + "/v2.0",
+		md.JWKSURI:               authority + "/discovery/v2.0/keys",
+		md.TokenEndpoint:         authority + "/oauth2/v2.0/token",
+		md.UserInfoEndpoint:      "https://graph.microsoft.com/oidc/userinfo",
+	} {
+		if actual != expected {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+	}
+	if len(md.ClaimsSupported) == 0 {
+		t.Error("expected supported claims")
+	}
+	// the client should use the cached metadata instead of fetching it again; the
+	// mock client would panic if it sent another request
+	if _, err = client.CreateAuthCodeURL(context.Background(), "client-id", "https://localhost", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AuthorityMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolvedEndpoints(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	authority := fmt.Sprintf("https://%s/%s", lmo, tenant)
+	tokenEndpoint := "https://proxy.contoso.com/token"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	client, err := New("client-id", WithAuthority(authority), WithHTTPClient(&mockClient), WithTokenEndpoint(tokenEndpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.ResolvedEndpoints(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for actual, expected := range map[string]string{
+		r.AuthorizationEndpoint:       authority + "/oauth2/v2.0/authorize",
+		r.DeviceCodeEndpoint:          "https://proxy.contoso.com/devicecode",
+		r.InstanceDiscoveryEndpoint:   "https://login.microsoftonline.com/common/discovery/instance",
+		r.OpenIDConfigurationEndpoint: authority + "/v2.0/.well-known/openid-configuration",
+		r.RegionDiscoveryEndpoint:     "",
+		r.TokenEndpoint:               tokenEndpoint,
+		r.UserInfoEndpoint:            "https://graph.microsoft.com/oidc/userinfo",
+	} {
+		if actual != expected {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+	}
+	if expected := []string{"graph.microsoft.com", lmo, "proxy.contoso.com"}; !reflect.DeepEqual(r.Hosts, expected) {
+		t.Errorf("expected hosts %v, got %v", expected, r.Hosts)
+	}
+}
+
+// recordingLogger is a Logger that keeps what it's given
+type recordingLogger struct {
+	entries []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.entries = append(l.entries, fmt.Sprintf(format, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	logger := &recordingLogger{}
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient),
+		WithLogger(logger), WithPIILogging(WireLogRedacted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ResolvedEndpoints(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.entries) != 2 {
+		t.Fatalf("expected a request and a response entry, got %d", len(logger.entries))
+	}
+	if !strings.HasPrefix(logger.entries[0], "MSAL request: GET https://"+lmo) {
+		t.Errorf("unexpected request entry %q", logger.entries[0])
+	}
+}
+
+// nopAccessor is a cache accessor that persists nothing
+type nopAccessor struct{}
+
+func (nopAccessor) Replace(cache.Unmarshaler, string) {}
+func (nopAccessor) Export(cache.Marshaler, string)    {}
+
+func TestCacheDisabled(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient), WithCacheDisabled(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		// every acquisition should send a token request because the client caches nothing
+		mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+		ar, err := client.AcquireTokenByAuthCode(ctx, "code", "https://localhost", tokenScope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ar.Account.IsZero() {
+			t.Fatal("expected an account")
+		}
+		if accounts := client.Accounts(); len(accounts) != 0 {
+			t.Fatalf("expected no accounts, got %v", accounts)
+		}
+		if _, err := client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(ar.Account)); err == nil {
+			t.Fatal("expected an error from AcquireTokenSilent")
+		}
+		if err := client.RemoveAccount(ar.Account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := New("client-id", WithCacheDisabled(true), WithCache(nopAccessor{})); err == nil {
+		t.Fatal("expected an error for a client having a cache accessor and a disabled cache")
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	accessToken, lmo, tenant := "*", "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody(accessToken, mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", []string{"User.Read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// silent authentication begins with instance discovery
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody([]byte(`{"sub":"subject","name":"name","email":"user@localhost","locale":"en-US"}`)),
+		mock.WithCallback(func(r *http.Request) {
+			if r.URL.String() != "https://graph.microsoft.com/oidc/userinfo" {
+				t.Errorf("unexpected URL %q", r.URL.String())
+			}
+			if actual := r.Header.Get("Authorization"); actual != "Bearer "+accessToken {
+				t.Errorf("unexpected Authorization header %q", actual)
+			}
+		}),
+	)
+	info, err := client.UserInfo(context.Background(), ar.Account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "subject" || info.Name != "name" || info.Email != "user@localhost" {
+		t.Fatalf("unexpected claims %+v", info)
+	}
+	if _, ok := info.AdditionalFields["locale"]; !ok {
+		t.Fatalf("expected additional claims, got %v", info.AdditionalFields)
+	}
+}
+
+func TestRefreshTokenExpiryWarning(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	body := mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)
+	body = append(body[:len(body)-1], []byte(`, "refresh_token_expires_in": 600}`)...)
+	for _, test := range []struct {
+		desc   string
+		window time.Duration
+		warn   bool
+	}{
+		{desc: "expires within window", window: time.Hour, warn: true},
+		{desc: "expires after window", window: time.Minute},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			mockClient.AppendResponse(mock.WithBody(body))
+			warnings := []Account{}
+			client, err := New("client-id",
+				WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+				WithHTTPClient(&mockClient),
+				WithRefreshTokenExpiryWarning(test.window, func(account Account, expiresOn time.Time) {
+					if time.Until(expiresOn) > 10*time.Minute {
+						t.Errorf("unexpected expiry %v", expiresOn)
+					}
+					warnings = append(warnings, account)
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.RefreshTokenExpiresOn.IsZero() {
+				t.Fatal("expected the result to have the refresh token's expiry")
+			}
+			// silent authentication begins with instance discovery
+			mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+			cached, err := client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cached.RefreshTokenExpiresOn.Equal(ar.RefreshTokenExpiresOn) {
+				t.Fatalf("expected cached expiry %v, got %v", ar.RefreshTokenExpiresOn, cached.RefreshTokenExpiresOn)
+			}
+			expected := 0
+			if test.warn {
+				expected = 2
+			}
+			if len(warnings) != expected {
+				t.Fatalf("expected %d warnings, got %d", expected, len(warnings))
+			}
+			for _, account := range warnings {
+				if account.HomeAccountID != ar.Account.HomeAccountID {
+					t.Fatalf("unexpected account %v", account)
+				}
+			}
+		})
+	}
+}
+
+func TestRawResponse(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	body := mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)
+	body = append(body[:len(body)-1], []byte(`, "ext_expires_in": 7200, "spa_code": "code"}`)...)
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(body))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := ar.RawResponse["spa_code"]; actual != "code" {
+		t.Fatalf(`expected spa_code "code", got %v`, actual)
+	}
+	if actual := ar.RawResponse["ext_expires_in"]; actual != float64(7200) {
+		t.Fatalf("expected ext_expires_in 7200, got %v", actual)
+	}
+	if _, ok := ar.RawResponse["refresh_token"]; ok {
+		t.Fatal("raw response shouldn't include the refresh token")
+	}
+	// silent authentication begins with instance discovery
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.RawResponse != nil {
+		t.Fatalf("expected no raw response for a cached token, got %v", ar.RawResponse)
+	}
+}
+
+func TestStrictTokenResponses(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	idToken := mock.GetIDToken(tenant, "issuer")
+	malformed := []byte(fmt.Sprintf(`{"access_token":"at","expires_in":"3599.5","ext_expires_in":null,"client_info":"*","id_token":"%s"}`, idToken))
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprint(strict), func(t *testing.T) {
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			mockClient.AppendResponse(mock.WithBody(malformed))
+			client, err := New("client-id",
+				WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+				WithHTTPClient(&mockClient),
+				WithStrictTokenResponses(strict),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+			if strict {
+				if err == nil {
+					t.Fatal("expected an error for a malformed response")
+				}
+				for _, field := range []string{"expires_in", "client_info"} {
+					if !strings.Contains(err.Error(), field) {
+						t.Errorf("expected the error to describe %s, got %q", field, err)
+					}
+				}
+				// a well-formed response is acceptable
+				mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", idToken, "rt", clientInfo, 3600)))
+				if _, err = client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := time.Until(ar.ExpiresOn); d < 3590*time.Second || d > 3600*time.Second {
+				t.Fatalf("expected the token to expire in about 3599 seconds, got %v", d)
+			}
+			if ar.Account.HomeAccountID != "" {
+				t.Fatalf("malformed client info shouldn't identify an account, got %q", ar.Account.HomeAccountID)
+			}
+		})
+	}
+}
+
+func TestClaims(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	claims := `{"access_token":{"nbf":{"essential":true,"value":"1"}}}`
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// silent authentication begins with instance discovery
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "at" {
+		t.Fatalf("expected the cached access token, got %q", ar.AccessToken)
+	}
+
+	// with claims, AcquireTokenSilent should redeem the refresh token rather than return the cached access token
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("new at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if actual := r.FormValue("grant_type"); actual != "refresh_token" {
+				t.Errorf("expected a refresh token request, got grant_type %q", actual)
+			}
+			if actual := r.FormValue("claims"); actual != claims {
+				t.Errorf("expected claims %q, got %q", claims, actual)
+			}
+		}),
+	)
+	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account), WithClaims(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "new at" {
+		t.Fatalf("expected a new access token, got %q", ar.AccessToken)
+	}
+
+	u, err := client.CreateAuthCodeURL(context.Background(), "client-id", "https://localhost", tokenScope, WithClaims(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(u, "claims="+url.QueryEscape(claims)) {
+		t.Fatalf("expected claims in %q", u)
+	}
+}
+
+func TestClockSkewCompensation(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	for _, test := range []struct {
+		desc           string
+		offset         time.Duration
+		expectedOffset time.Duration
+	}{
+		{desc: "authority ahead", offset: 10 * time.Minute, expectedOffset: 10 * time.Minute},
+		{desc: "authority behind", offset: -10 * time.Minute, expectedOffset: -10 * time.Minute},
+		{desc: "negligible offset", offset: 30 * time.Second},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			mockClient.AppendResponse(
+				mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+				mock.WithHTTPHeader(http.Header{"Date": {time.Now().Add(test.offset).UTC().Format(http.TimeFormat)}}),
+			)
+			client, err := New("client-id",
+				WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+				WithClockSkewCompensation(),
+				WithHTTPClient(&mockClient),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the client stores expiry according to its own clock, so applications sharing the cache can use it
+			expected := time.Now().Add(time.Hour)
+			if d := ar.ExpiresOn.Sub(expected); d < -5*time.Second || d > 5*time.Second {
+				t.Fatalf("expected ExpiresOn near %v, got %v", expected, ar.ExpiresOn)
+			}
+			if d := client.base.AuthParams.AuthorityClock.Offset() - test.expectedOffset; d < -5*time.Second || d > 5*time.Second {
+				t.Fatalf("expected offset near %v, got %v", test.expectedOffset, d+test.expectedOffset)
+			}
+			mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+			silent, err := client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if silent.AccessToken != "at" {
+				t.Fatalf("expected the cached token, got %q", silent.AccessToken)
+			}
+		})
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope); err != nil {
+		t.Fatal(err)
+	}
+	// the cache has no token for these scopes, so Warmup should redeem the account's refresh token
+	scopes := []string{"other"}
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("other-at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			if actual := r.Form.Get("refresh_token"); actual != "rt" {
+				t.Errorf("expected the cached refresh token, got %q", actual)
+			}
+		}),
+	)
+	if err = client.Warmup(context.Background(), scopes); err != nil {
+		t.Fatal(err)
+	}
+	// the mock panics if the client sends another request
+	ar, err := client.AcquireTokenSilent(context.Background(), scopes, WithSilentAccount(client.Accounts()[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "other-at" {
+		t.Fatalf("expected the token Warmup acquired, got %q", ar.AccessToken)
+	}
+	if err = client.Warmup(context.Background(), scopes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	for _, test := range []struct {
+		desc     string
+		opts     []AcquireByAuthCodeOption
+		expected string
+	}{
+		{desc: "default", expected: "throttled"},
+		{desc: "interactive", opts: []AcquireByAuthCodeOption{WithRequestPriority(PriorityInteractive)}, expected: "at"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusTooManyRequests), mock.WithBody([]byte(`{"error":"throttled"}`)))
+			mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+			client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope, test.opts...)
+			if test.expected == "throttled" {
+				if err == nil || !strings.Contains(err.Error(), "429") {
+					t.Fatalf("expected a throttling error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.AccessToken != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, ar.AccessToken)
+			}
+		})
+	}
+}
+
+func TestAllowStaleOnError(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	now := time.Now()
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	client, err := New("client-id",
+		WithAllowStaleOnError(time.Hour),
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithClock(func() time.Time { return now }),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Stale {
+		t.Fatal("new token shouldn't be stale")
+	}
+
+	// the token has expired and the authority is unavailable, so the client returns the stale token
+	now = now.Add(90 * time.Minute)
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	ar, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ar.Stale || ar.AccessToken != "at" {
+		t.Fatalf("expected the stale token, got %+v", ar)
+	}
+
+	// the client doesn't return a stale token when the authority rejects the request
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusBadRequest), mock.WithBody([]byte(`{"error":"invalid_grant"}`)))
+	if _, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the token expired more than maxStale ago
+	now = now.Add(time.Hour)
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusServiceUnavailable))
+	if _, err = client.AcquireTokenSilent(context.Background(), tokenScope, WithSilentAccount(ar.Account)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSignOut(t *testing.T) {
+	realBrowserOpenURL := browserOpenURL
+	defer func() { browserOpenURL = realBrowserOpenURL }()
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	for _, interactive := range []bool{false, true} {
+		t.Run(fmt.Sprint("interactive=", interactive), func(t *testing.T) {
+			mockClient := mock.Client{}
+			mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+			mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+			client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened := ""
+			browserOpenURL = func(ctx context.Context, u string) error {
+				opened = u
+				return nil
+			}
+			redirect := "https://localhost/signed-out"
+			opts := []SignOutOption{WithPostLogoutRedirectURI(redirect)}
+			if interactive {
+				opts = append(opts, WithInteractiveSignOut())
+			}
+			logoutURL, err := client.SignOut(context.Background(), ar.Account, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(logoutURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != lmo || u.Path != fmt.Sprintf("/%s/oauth2/v2.0/logout", tenant) {
+				t.Fatalf("unexpected logout URL %q", logoutURL)
+			}
+			if actual := u.Query().Get("post_logout_redirect_uri"); actual != redirect {
+				t.Fatalf("expected post_logout_redirect_uri %q, got %q", redirect, actual)
+			}
+			if interactive && opened != logoutURL {
+				t.Fatalf("expected the browser to open %q, got %q", logoutURL, opened)
+			} else if !interactive && opened != "" {
+				t.Fatalf("unexpected browser navigation to %q", opened)
+			}
+			if accounts := client.Accounts(); len(accounts) != 0 {
+				t.Fatalf("expected no cached accounts, got %d", len(accounts))
+			}
+		})
+	}
+
+	// the client should remove the account even when it can't get the logout URL
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("*", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the account's tenant differs from the client's, so the client requests the tenant's metadata
+	account := ar.Account
+	account.Realm = "other"
+	mockClient.AppendResponse(mock.WithHTTPStatus(http.StatusInternalServerError))
+	if _, err = client.SignOut(context.Background(), account); err == nil {
+		t.Fatal("expected an error")
+	}
+	if accounts := client.Accounts(); len(accounts) != 0 {
+		t.Fatalf("expected no cached accounts, got %d", len(accounts))
+	}
+}
+
+func TestRequiredInteractionFor(t *testing.T) {
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callErr := func(code string) error {
+		body := io.NopCloser(strings.NewReader(fmt.Sprintf(`{"error":%q}`, code)))
+		return fmt.Errorf("wrapped: %w", msalerrors.CallErr{Resp: &http.Response{StatusCode: http.StatusBadRequest, Body: body}, Err: errors.New(code)})
+	}
+	for _, test := range []struct {
+		err      error
+		expected bool
+	}{
+		{err: callErr("consent_required"), expected: true},
+		{err: callErr("interaction_required"), expected: true},
+		{err: callErr("invalid_grant"), expected: true},
+		{err: msalerrors.PartialConsentError{DeclinedScopes: tokenScope}, expected: true},
+		{err: callErr("invalid_client")},
+		{err: errors.New("error")},
+		{},
+	} {
+		if actual := client.RequiredInteractionFor(test.err); actual != test.expected {
+			t.Errorf("expected %v for %v", test.expected, test.err)
+		}
+	}
+}
+
+func TestUsernamePasswordFunc(t *testing.T) {
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.base.Token.AccessTokens = &fake.AccessTokens{AccessToken: accesstokens.TokenResponse{AccessToken: "*"}}
+	client.base.Token.Authority = &fake.Authority{Realm: authority.UserRealm{AccountType: authority.Managed}}
+	client.base.Token.Resolver = &fake.ResolveEndpoints{}
+	pw := []byte("password")
+	ar, err := client.AcquireTokenByUsernamePasswordFunc(context.Background(), tokenScope, "username", func() ([]byte, error) { return pw, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AccessToken != "*" {
+		t.Fatalf("unexpected access token %q", ar.AccessToken)
+	}
+	for _, b := range pw {
+		if b != 0 {
+			t.Fatal("password wasn't zeroed")
+		}
+	}
+	expected := errors.New("expected")
+	_, err = client.AcquireTokenByUsernamePasswordFunc(context.Background(), tokenScope, "username", func() ([]byte, error) { return nil, expected })
+	if !errors.Is(err, expected) {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+}
+
+func TestAccountsByUsername(t *testing.T) {
+	// a stand-in for NFC normalization, composing the only combining sequence in this test
+	nfc := strings.NewReplacer("e\u0301", "\u00e9", "E\u0301", "\u00c9").Replace
+	for _, test := range []struct {
+		desc, username string
+		normalize      func(string) string
+		match          bool
+	}{
+		{desc: "identical", username: "Jose\u0301@Contoso.com", match: true},
+		{desc: "case", username: "JOSE\u0301@contoso.COM", match: true},
+		{desc: "unnormalized", username: "JOS\u00c9@contoso.com"},
+		{desc: "normalized", username: "JOS\u00c9@contoso.com", normalize: nfc, match: true},
+		{desc: "other user", username: "jo@contoso.com", normalize: nfc},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			client, err := New("client-id", WithUsernameNormalizer(test.normalize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.base.Token.AccessTokens = &fake.AccessTokens{AccessToken: accesstokens.TokenResponse{
+				AccessToken:   "*",
+				ExpiresOn:     internalTime.DurationTime{T: time.Now().Add(time.Hour)},
+				GrantedScopes: accesstokens.Scopes{Slice: tokenScope},
+				IDToken:       accesstokens.IDToken{PreferredUsername: "Jose\u0301@Contoso.com", RawToken: "x.e30"},
+				ClientInfo:    accesstokens.ClientInfo{UID: "uid", UTID: "utid"},
+			}}
+			client.base.Token.Authority = &fake.Authority{Realm: authority.UserRealm{AccountType: authority.Managed}}
+			client.base.Token.Resolver = &fake.ResolveEndpoints{}
+			if _, err = client.AcquireTokenByUsernamePassword(context.Background(), tokenScope, test.username, "password"); err != nil {
+				t.Fatal(err)
+			}
+			accounts := client.AccountsByUsername(test.username)
+			if test.match && (len(accounts) != 1 || accounts[0].HomeAccountID != "uid.utid") {
+				t.Fatalf("expected the account, got %+v", accounts)
+			}
+			if !test.match && len(accounts) != 0 {
+				t.Fatalf("expected no accounts, got %+v", accounts)
+			}
+		})
+	}
+}
+
+func TestWithWSTrustEndpoint(t *testing.T) {
+	client, err := New("client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []string{"", "http://idp/trust/13/usernamemixed", "/trust/13/usernamemixed"} {
+		if _, err = client.AcquireTokenByUsernamePassword(context.Background(), tokenScope, "username", "password", WithWSTrustEndpoint(endpoint)); err == nil {
+			t.Errorf("expected an error for endpoint %q", endpoint)
+		}
+	}
+}
+
 func TestAcquireTokenSilentTenants(t *testing.T) {
 	tenants := []string{"a", "b"}
 	lmo := "login.microsoftonline.com"
@@ -91,7 +1479,10 @@ func TestAcquireTokenSilentTenants(t *testing.T) {
 			t.Fatal("silent auth should fail because the cache is empty")
 		}
 		mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
-		mockClient.AppendResponse(mock.WithBody([]byte(`{"account_type":"Managed","cloud_audience_urn":"urn","cloud_instance_name":"...","domain_name":"..."}`)))
+		mockClient.AppendResponse(
+			mock.WithBody([]byte(`{"account_type":"Managed","cloud_audience_urn":"urn","cloud_instance_name":"...","domain_name":"..."}`)),
+			mock.WithPath("/UserRealm/"),
+		)
 		mockClient.AppendResponse(mock.WithBody(
 			mock.GetAccessTokenBody(tenant, mock.GetIDToken(tenant, fmt.Sprintf("https://%s/%s", lmo, tenant)), "rt-"+tenant, clientInfo, 3600)),
 		)
@@ -165,7 +1556,10 @@ func TestAcquireTokenWithTenantID(t *testing.T) {
 					mockClient.AppendResponse(mock.WithBody([]byte(`{"device_code":"...","expires_in":600}`)))
 				} else if method == "password" {
 					// user realm metadata
-					mockClient.AppendResponse(mock.WithBody([]byte(`{"account_type":"Managed","cloud_audience_urn":"urn","cloud_instance_name":"...","domain_name":"..."}`)))
+					mockClient.AppendResponse(
+						mock.WithBody([]byte(`{"account_type":"Managed","cloud_audience_urn":"urn","cloud_instance_name":"...","domain_name":"..."}`)),
+						mock.WithPath("/UserRealm/"),
+					)
 				}
 				mockClient.AppendResponse(
 					mock.WithBody(mock.GetAccessTokenBody(accessToken, mock.GetIDToken(test.tenant, test.authority), "rt", clientInfo, 3600)),
@@ -225,9 +1619,22 @@ func TestAcquireTokenWithTenantID(t *testing.T) {
 				} else if ar.AccessToken != accessToken {
 					t.Fatal("cached access token should match the one returned by AcquireToken...")
 				}
-				// ...but fail for another tenant
-				if _, err = client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(ar.Account), WithTenantID("not-"+test.tenant)); err == nil {
-					t.Fatal("expected an error")
+				if ar.TenantID != test.tenant {
+					t.Fatalf("expected tenant %q, got %q", test.tenant, ar.TenantID)
+				}
+				// ...and redeem the refresh token for a token from another tenant, as for a guest account
+				other := "not-" + test.tenant
+				mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, other)))
+				mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("other-"+accessToken, mock.GetIDToken(other, test.authority), "rt", clientInfo, 3600)))
+				account := ar.Account
+				if ar, err = client.AcquireTokenSilent(ctx, tokenScope, WithSilentAccount(account), WithTenantID(other)); err != nil {
+					t.Fatal(err)
+				}
+				if ar.AccessToken != "other-"+accessToken || ar.TenantID != other {
+					t.Fatalf("expected a token from tenant %q, got %q from %q", other, ar.AccessToken, ar.TenantID)
+				}
+				if ar.Account.HomeAccountID != account.HomeAccountID {
+					t.Fatalf("expected home account ID %q, got %q", account.HomeAccountID, ar.Account.HomeAccountID)
 				}
 			})
 		}
@@ -263,7 +1670,7 @@ func TestWithLoginHint(t *testing.T) {
 				}
 				return err
 			}
-			browserOpenURL = func(authURL string) error {
+			browserOpenURL = func(ctx context.Context, authURL string) error {
 				called = true
 				parsed, err := url.Parse(authURL)
 				if err != nil {
@@ -278,7 +1685,7 @@ func TestWithLoginHint(t *testing.T) {
 					return err
 				}
 				// this helper validates the other params and completes the redirect
-				return fakeBrowserOpenURL(authURL)
+				return fakeBrowserOpenURL(ctx, authURL)
 			}
 			acquireOpts := []AcquireInteractiveOption{}
 			urlOpts := []CreateAuthCodeURLOption{}
@@ -307,3 +1714,118 @@ func TestWithLoginHint(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicy(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	// the client must not send a request the policy rejects, so the mock has responses only for allowed requests
+	mockClient := mock.Client{}
+	client, err := New("client-id",
+		WithAllowedScopes(tokenScope...),
+		WithAllowedTenants("TENANT"),
+		WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)),
+		WithHTTPClient(&mockClient),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		desc            string
+		scopes          []string
+		tenant          string
+		expectedErrType msalerrors.PolicyError
+	}{
+		{desc: "tenant", scopes: tokenScope, tenant: "other", expectedErrType: msalerrors.PolicyError{Tenant: "other"}},
+		{desc: "scope", scopes: append([]string{"denied"}, tokenScope...), expectedErrType: msalerrors.PolicyError{Scope: "denied"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var opts []AcquireByAuthCodeOption
+			if test.tenant != "" {
+				opts = append(opts, WithTenantID(test.tenant))
+			}
+			_, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", test.scopes, opts...)
+			var policyErr msalerrors.PolicyError
+			if !errors.As(err, &policyErr) || policyErr != test.expectedErrType {
+				t.Fatalf("expected %v, got %v", test.expectedErrType, err)
+			}
+			if test.tenant == "" {
+				if _, err = client.CreateAuthCodeURL(context.Background(), "client-id", "https://localhost", test.scopes); !errors.As(err, &policyErr) {
+					t.Fatalf("expected a PolicyError, got %v", err)
+				}
+			}
+		})
+	}
+
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", mock.GetIDToken(tenant, "issuer"), "rt", clientInfo, 3600)))
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the policy applies to cached tokens too
+	var policyErr msalerrors.PolicyError
+	if _, err = client.AcquireTokenSilent(context.Background(), []string{"denied"}, WithSilentAccount(ar.Account)); !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PolicyError, got %v", err)
+	}
+}
+
+func TestAcquireTokenBySSHCert(t *testing.T) {
+	lmo, tenant := "login.microsoftonline.com", "tenant"
+	clientInfo := base64.RawStdEncoding.EncodeToString([]byte(`{"uid":"uid","utid":"utid"}`))
+	idToken := mock.GetIDToken(tenant, "issuer")
+	sshScope := []string{"https://pas.windows.net/CheckMyAccess/Linux/.default"}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := mock.Client{}
+	mockClient.AppendResponse(mock.WithBody(mock.GetTenantDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(mock.WithBody(mock.GetAccessTokenBody("at", idToken, "rt", clientInfo, 3600)))
+	client, err := New("client-id", WithAuthority(fmt.Sprintf("https://%s/%s", lmo, tenant)), WithHTTPClient(&mockClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := client.AcquireTokenByAuthCode(context.Background(), "code", "https://localhost", tokenScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient.AppendResponse(mock.WithBody(mock.GetInstanceDiscoveryBody(lmo, tenant)))
+	mockClient.AppendResponse(
+		mock.WithBody(mock.GetAccessTokenBody("cert", idToken, "rt2", clientInfo, 3600)),
+		mock.WithCallback(func(r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			if v := r.PostForm.Get("token_type"); v != "ssh-cert" {
+				t.Errorf(`expected token_type "ssh-cert", got %q`, v)
+			}
+			if v := r.PostForm.Get("refresh_token"); v != "rt" {
+				t.Errorf("expected the cached refresh token, got %q", v)
+			}
+			jwk := map[string]string{}
+			if err := json.Unmarshal([]byte(r.PostForm.Get("req_cnf")), &jwk); err != nil {
+				t.Fatal(err)
+			}
+			if jwk["kty"] != "RSA" || jwk["n"] != base64.RawURLEncoding.EncodeToString(key.N.Bytes()) {
+				t.Errorf("unexpected JWK %v", jwk)
+			}
+			if kid := r.PostForm.Get("key_id"); kid == "" || kid != jwk["kid"] {
+				t.Errorf("expected key_id to match the JWK's kid %q, got %q", jwk["kid"], kid)
+			}
+		}),
+	)
+	cert, err := client.AcquireTokenBySSHCert(context.Background(), ar.Account, sshScope, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.AccessToken != "cert" {
+		t.Fatalf("expected the certificate, got %q", cert.AccessToken)
+	}
+	// the client shouldn't cache the certificate as an access token
+	for _, tk := range client.CacheSnapshot(context.Background()).Tokens {
+		if tk.Type == "AccessToken" && strings.Contains(strings.Join(tk.Scopes, " "), sshScope[0]) {
+			t.Fatalf("the client cached the certificate: %+v", tk)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+/*
+Package webview allows desktop applications to complete interactive authentication inside an
+embedded web view (for example one provided by Wails, Lorca or CEF) instead of the system browser.
+
+Applications implement Interactor and pass it to public.Client.AcquireTokenInteractive with the
+public.WithWebView() option. The client then navigates the web view to the authority's sign in page
+and waits for it to redirect to the application's redirect URI, without starting a localhost listener.
+*/
+package webview
+
+import "context"
+
+// Interactor hosts an interactive authentication in an embedded web view.
+type Interactor interface {
+	// Navigate displays url in the web view. url is the authority's authorization endpoint.
+	Navigate(ctx context.Context, url string) error
+
+	// WaitForRedirect blocks until the web view navigates to a URL beginning with prefix, which is
+	// the redirect URI of the authentication request, and returns that URL including its query string.
+	// Implementations should cancel that navigation rather than load the page. WaitForRedirect must
+	// return an error when ctx is done or the user closes the web view.
+	WaitForRedirect(ctx context.Context, prefix string) (redirectURL string, err error)
+}